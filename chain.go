@@ -0,0 +1,224 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// hostTrustRoots verifies the certificates ESXi hosts present; nil means -insecure, where any
+// certificate is accepted (the usual self-signed lab host)
+var hostTrustRoots *x509.CertPool
+
+// configureHostTrust selects how host certificates are trusted: -insecure accepts them as-is,
+// otherwise they must chain to the -ca-bundle roots
+func configureHostTrust(config Config) error {
+	hostTrustRoots = nil
+	if config.Insecure || config.CABundle == "" {
+		return nil
+	}
+
+	roots, err := loadCABundle(config.CABundle)
+	if err != nil {
+		return err
+	}
+	hostTrustRoots = roots
+	return nil
+}
+
+// hostTLSConfig returns the TLS settings for connecting to an ESXi host. Expiry is not
+// enforced even when verifying, since replacing an expired certificate is the tool's job.
+func hostTLSConfig(host string) *tls.Config {
+	if hostTrustRoots == nil {
+		return &tls.Config{InsecureSkipVerify: true}
+	}
+
+	roots := hostTrustRoots
+	return &tls.Config{
+		// Verification happens in VerifyConnection so that an expired certificate can still be read
+		InsecureSkipVerify: true,
+		VerifyConnection: func(state tls.ConnectionState) error {
+			return verifyHostCertificate(state.PeerCertificates, roots, host)
+		},
+	}
+}
+
+// verifyHostCertificate checks that a host certificate chains to the roots and matches the host,
+// evaluated at a time within the leaf's validity period
+func verifyHostCertificate(certs []*x509.Certificate, roots *x509.CertPool, host string) error {
+	if len(certs) == 0 {
+		return fmt.Errorf("no certificates presented")
+	}
+
+	leaf := certs[0]
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	verifyTime := time.Now()
+	if verifyTime.After(leaf.NotAfter) {
+		verifyTime = leaf.NotAfter.Add(-time.Second)
+	} else if verifyTime.Before(leaf.NotBefore) {
+		verifyTime = leaf.NotBefore.Add(time.Second)
+	}
+
+	_, err := leaf.Verify(x509.VerifyOptions{
+		DNSName:       host,
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   verifyTime,
+	})
+	if err != nil {
+		return fmt.Errorf("host certificate is not trusted by the CA bundle (use -insecure to accept it): %w", err)
+	}
+	return nil
+}
+
+// ChainReport summarizes the certificate chain served by a host
+type ChainReport struct {
+	Leaf      *x509.Certificate
+	Length    int
+	Verified  bool
+	VerifyErr error
+	Gaps      []string // Adjacent certificates that do not sign one another
+	Warnings  []string // Intermediates expiring before the leaf
+}
+
+// loadCABundle reads a PEM bundle of trusted root certificates
+func loadCABundle(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA bundle: %v", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("CA bundle %s contains no PEM certificates", path)
+	}
+	return pool, nil
+}
+
+// analyzeCertificateChain checks that the presented chain is in order, that no intermediate
+// expires before the leaf, and that it builds to a trusted root (the system roots when roots is nil)
+func analyzeCertificateChain(certs []*x509.Certificate, roots *x509.CertPool, now time.Time) ChainReport {
+	report := ChainReport{Length: len(certs)}
+	if len(certs) == 0 {
+		report.VerifyErr = fmt.Errorf("no certificates presented")
+		return report
+	}
+
+	leaf := certs[0]
+	report.Leaf = leaf
+	intermediates := x509.NewCertPool()
+	for i, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+
+		if err := certs[i].CheckSignatureFrom(cert); err != nil {
+			report.Gaps = append(report.Gaps, fmt.Sprintf("certificate %d (%s) is not signed by certificate %d (%s)",
+				i, certs[i].Subject.CommonName, i+1, cert.Subject.CommonName))
+		}
+		if cert.NotAfter.Before(leaf.NotAfter) {
+			report.Warnings = append(report.Warnings, fmt.Sprintf("intermediate %s expires %s, before the leaf (%s)",
+				cert.Subject.CommonName, cert.NotAfter.Format(time.RFC3339), leaf.NotAfter.Format(time.RFC3339)))
+		}
+	}
+
+	_, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+	})
+	report.Verified = err == nil
+	report.VerifyErr = err
+	return report
+}
+
+// Fetch the chain a host presents and analyze it
+func checkCertificateChainWithDialer(config Config, dialer TLSDialer) (ChainReport, error) {
+	var roots *x509.CertPool
+	if config.CABundle != "" {
+		pool, err := loadCABundle(config.CABundle)
+		if err != nil {
+			return ChainReport{}, err
+		}
+		roots = pool
+	}
+
+	address := esxiHTTPSAddress(config)
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+		port = "443"
+	}
+
+	conn, err := dialer.Dial(dialNetwork, net.JoinHostPort(host, port), &tls.Config{
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		return ChainReport{}, fmt.Errorf("failed to connect to %s: %v", config.Hostname, err)
+	}
+	defer conn.Close()
+
+	report := analyzeCertificateChain(conn.ConnectionState().PeerCertificates, roots, time.Now())
+	logChainReport(config.Hostname, report)
+	return report, nil
+}
+
+// chainCheckEnabled reports whether the full chain should be checked; a CA bundle implies it.
+// A leaf-only install never serves a chain that could verify, so it is never checked.
+func chainCheckEnabled(config Config) bool {
+	if config.ChainMode == chainModeLeafOnly {
+		return false
+	}
+	return config.CheckChain || config.CABundle != ""
+}
+
+// chainProblem describes why a chain is unacceptable, or returns "" for a sound chain.
+// Early-expiring intermediates are only warnings, as the chain still works until then.
+func chainProblem(report ChainReport) string {
+	if len(report.Gaps) > 0 {
+		return fmt.Sprintf("chain has %d gap(s): %s", len(report.Gaps), strings.Join(report.Gaps, "; "))
+	}
+	if !report.Verified {
+		return fmt.Sprintf("chain does not verify to a trusted root: %v", report.VerifyErr)
+	}
+	return ""
+}
+
+// trustProblem describes why an installed certificate is not genuinely trusted for the
+// hostname, or returns "" when it chains to a trusted root and covers the hostname
+func trustProblem(hostname string, report ChainReport) string {
+	if problem := chainProblem(report); problem != "" {
+		return problem
+	}
+
+	host, _, err := net.SplitHostPort(hostname)
+	if err != nil {
+		host = hostname
+	}
+	if err := report.Leaf.VerifyHostname(host); err != nil {
+		return fmt.Sprintf("certificate does not match hostname: %v", err)
+	}
+	return ""
+}
+
+// logChainReport logs the chain length, any gaps or early-expiring intermediates, and the verification result
+func logChainReport(hostname string, report ChainReport) {
+	logInfo("Certificate chain for %s has %d certificate(s)", hostname, report.Length)
+	for _, gap := range report.Gaps {
+		logWarn("Chain gap: %s", gap)
+	}
+	for _, warning := range report.Warnings {
+		logWarn("Chain warning: %s", warning)
+	}
+	if report.Verified {
+		logInfo("Certificate chain verifies to a trusted root")
+	} else {
+		logWarn("Certificate chain does not verify to a trusted root: %v", report.VerifyErr)
+	}
+}
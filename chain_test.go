@@ -0,0 +1,267 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testIssuer struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// issueTestCertificate creates a certificate signed by the issuer (self-signed when issuer is nil)
+func issueTestCertificate(t *testing.T, commonName string, isCA bool, notAfter time.Time, issuer *testIssuer) *testIssuer {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              notAfter,
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	if !isCA {
+		template.DNSNames = []string{commonName}
+	}
+
+	parent, signer := template, key
+	if issuer != nil {
+		parent, signer = issuer.cert, issuer.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Failed to parse certificate: %v", err)
+	}
+	return &testIssuer{cert: cert, key: key}
+}
+
+func TestAnalyzeCertificateChain(t *testing.T) {
+	now := time.Now()
+	root := issueTestCertificate(t, "Test Root", true, now.Add(10*365*24*time.Hour), nil)
+	intermediate := issueTestCertificate(t, "Test Intermediate", true, now.Add(365*24*time.Hour), root)
+	leaf := issueTestCertificate(t, "esxi01.lab.example.com", false, now.Add(90*24*time.Hour), intermediate)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(root.cert)
+
+	t.Run("complete chain", func(t *testing.T) {
+		report := analyzeCertificateChain([]*x509.Certificate{leaf.cert, intermediate.cert}, roots, now)
+		if report.Length != 2 || !report.Verified || len(report.Gaps) != 0 || len(report.Warnings) != 0 {
+			t.Errorf("Expected a clean verified chain, got %+v", report)
+		}
+		if problem := chainProblem(report); problem != "" {
+			t.Errorf("Expected no chain problem, got %s", problem)
+		}
+	})
+
+	t.Run("missing intermediate", func(t *testing.T) {
+		report := analyzeCertificateChain([]*x509.Certificate{leaf.cert}, roots, now)
+		if report.Verified {
+			t.Error("Expected leaf-only chain not to verify")
+		}
+		if problem := chainProblem(report); !strings.Contains(problem, "does not verify") {
+			t.Errorf("Expected verification problem, got %q", problem)
+		}
+	})
+
+	t.Run("out of order chain", func(t *testing.T) {
+		other := issueTestCertificate(t, "Other Intermediate", true, now.Add(365*24*time.Hour), root)
+		report := analyzeCertificateChain([]*x509.Certificate{leaf.cert, other.cert, intermediate.cert}, roots, now)
+		if len(report.Gaps) == 0 {
+			t.Fatal("Expected a gap between the leaf and the unrelated intermediate")
+		}
+		if problem := chainProblem(report); !strings.Contains(problem, "gap") {
+			t.Errorf("Expected gap problem, got %q", problem)
+		}
+	})
+
+	t.Run("intermediate expires before leaf", func(t *testing.T) {
+		shortIntermediate := issueTestCertificate(t, "Short Intermediate", true, now.Add(30*24*time.Hour), root)
+		shortLeaf := issueTestCertificate(t, "esxi02.lab.example.com", false, now.Add(20*24*time.Hour), shortIntermediate)
+		// A leaf that outlives its issuer
+		longLeaf := issueTestCertificate(t, "esxi03.lab.example.com", false, now.Add(60*24*time.Hour), shortIntermediate)
+
+		if report := analyzeCertificateChain([]*x509.Certificate{shortLeaf.cert, shortIntermediate.cert}, roots, now); len(report.Warnings) != 0 {
+			t.Errorf("Did not expect warnings, got %v", report.Warnings)
+		}
+		report := analyzeCertificateChain([]*x509.Certificate{longLeaf.cert, shortIntermediate.cert}, roots, now)
+		if len(report.Warnings) != 1 || !strings.Contains(report.Warnings[0], "Short Intermediate") {
+			t.Errorf("Expected early-expiry warning, got %v", report.Warnings)
+		}
+	})
+
+	t.Run("untrusted root", func(t *testing.T) {
+		report := analyzeCertificateChain([]*x509.Certificate{leaf.cert, intermediate.cert}, x509.NewCertPool(), now)
+		if report.Verified {
+			t.Error("Expected chain not to verify against an empty root pool")
+		}
+	})
+
+	t.Run("empty chain", func(t *testing.T) {
+		report := analyzeCertificateChain(nil, roots, now)
+		if report.Verified || report.VerifyErr == nil {
+			t.Errorf("Expected empty chain to fail, got %+v", report)
+		}
+	})
+}
+
+func TestLoadCABundle(t *testing.T) {
+	tempDir := t.TempDir()
+	root := issueTestCertificate(t, "Test Root", true, time.Now().Add(24*time.Hour), nil)
+
+	bundlePath := filepath.Join(tempDir, "bundle.pem")
+	os.WriteFile(bundlePath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: root.cert.Raw}), 0600)
+	if _, err := loadCABundle(bundlePath); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	emptyPath := filepath.Join(tempDir, "empty.pem")
+	os.WriteFile(emptyPath, []byte("no certificates here"), 0600)
+	if _, err := loadCABundle(emptyPath); err == nil || !strings.Contains(err.Error(), "contains no PEM certificates") {
+		t.Errorf("Expected empty bundle error, got %v", err)
+	}
+
+	if _, err := loadCABundle(filepath.Join(tempDir, "missing.pem")); err == nil {
+		t.Error("Expected error for missing bundle")
+	}
+}
+
+func TestChainCheckEnabled(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   Config
+		expected bool
+	}{
+		{"off by default", Config{}, false},
+		{"check-chain", Config{CheckChain: true}, true},
+		{"implied by a CA bundle", Config{CABundle: "roots.pem"}, true},
+		{"skipped for leaf-only installs", Config{CABundle: "roots.pem", ChainMode: chainModeLeafOnly}, false},
+	}
+	for _, tt := range tests {
+		if got := chainCheckEnabled(tt.config); got != tt.expected {
+			t.Errorf("%s: chainCheckEnabled() = %v, expected %v", tt.name, got, tt.expected)
+		}
+	}
+}
+
+func TestTrustProblem(t *testing.T) {
+	now := time.Now()
+	root := issueTestCertificate(t, "Test Root", true, now.Add(10*365*24*time.Hour), nil)
+	leaf := issueTestCertificate(t, "esxi01.lab.example.com", false, now.Add(90*24*time.Hour), root)
+	selfSigned := issueTestCertificate(t, "esxi01.lab.example.com", false, now.Add(90*24*time.Hour), nil)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(root.cert)
+
+	tests := []struct {
+		name      string
+		hostname  string
+		certs     []*x509.Certificate
+		wantError string
+	}{
+		{"trusted and matching", "esxi01.lab.example.com", []*x509.Certificate{leaf.cert}, ""},
+		{"trusted with port", "esxi01.lab.example.com:443", []*x509.Certificate{leaf.cert}, ""},
+		{"hostname mismatch", "esxi02.lab.example.com", []*x509.Certificate{leaf.cert}, "does not match hostname"},
+		{"self-signed", "esxi01.lab.example.com", []*x509.Certificate{selfSigned.cert}, "trusted root"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			problem := trustProblem(tt.hostname, analyzeCertificateChain(tt.certs, roots, now))
+			if tt.wantError == "" && problem != "" {
+				t.Errorf("Expected no problem, got %s", problem)
+			}
+			if tt.wantError != "" && !strings.Contains(problem, tt.wantError) {
+				t.Errorf("Expected problem containing %q, got %q", tt.wantError, problem)
+			}
+		})
+	}
+}
+
+// writeTestCABundle writes a PEM bundle with a freshly generated root and returns its path
+func writeTestCABundle(t *testing.T) string {
+	t.Helper()
+
+	root := issueTestCertificate(t, "Test Root", true, time.Now().Add(365*24*time.Hour), nil)
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: root.cert.Raw}), 0644); err != nil {
+		t.Fatalf("Failed to write CA bundle: %v", err)
+	}
+	return path
+}
+
+func TestVerifyHostCertificate(t *testing.T) {
+	now := time.Now()
+	root := issueTestCertificate(t, "Test Root", true, now.Add(10*365*24*time.Hour), nil)
+	leaf := issueTestCertificate(t, "esxi01.lab.example.com", false, now.Add(90*24*time.Hour), root)
+	expired := issueTestCertificate(t, "esxi01.lab.example.com", false, now.Add(-time.Minute), root)
+	selfSigned := issueTestCertificate(t, "esxi01.lab.example.com", false, now.Add(90*24*time.Hour), nil)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(root.cert)
+
+	tests := []struct {
+		name      string
+		cert      *x509.Certificate
+		host      string
+		wantError bool
+	}{
+		{"trusted", leaf.cert, "esxi01.lab.example.com", false},
+		{"expired but trusted", expired.cert, "esxi01.lab.example.com", false},
+		{"self-signed", selfSigned.cert, "esxi01.lab.example.com", true},
+		{"wrong host", leaf.cert, "esxi02.lab.example.com", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyHostCertificate([]*x509.Certificate{tt.cert}, roots, tt.host)
+			if tt.wantError != (err != nil) {
+				t.Errorf("verifyHostCertificate() error = %v, wantError %v", err, tt.wantError)
+			}
+		})
+	}
+}
+
+func TestConfigureHostTrust(t *testing.T) {
+	defer func() { hostTrustRoots = nil }()
+
+	if err := configureHostTrust(Config{Insecure: true, CABundle: writeTestCABundle(t)}); err != nil || hostTrustRoots != nil {
+		t.Errorf("Expected -insecure to skip verification, got roots %v, err %v", hostTrustRoots, err)
+	}
+	if cfg := hostTLSConfig("esxi01"); !cfg.InsecureSkipVerify || cfg.VerifyConnection != nil {
+		t.Error("Expected an unverified TLS config with -insecure")
+	}
+
+	if err := configureHostTrust(Config{CABundle: writeTestCABundle(t)}); err != nil || hostTrustRoots == nil {
+		t.Fatalf("Expected the CA bundle to be loaded, got err %v", err)
+	}
+	if cfg := hostTLSConfig("esxi01"); cfg.VerifyConnection == nil {
+		t.Error("Expected a verifying TLS config with a CA bundle")
+	}
+}
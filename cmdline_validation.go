@@ -4,6 +4,9 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"lab-update-esxi-cert/internal/version"
 )
@@ -18,40 +21,178 @@ func parseArgs() (Config, error) {
 
 	// Load from config file if specified
 	var configFile string
-	flag.StringVar(&configFile, "config", "", "Path to JSON configuration file")
+	flag.StringVar(&configFile, "config", "", "Path to JSON configuration file, or - to read it from stdin")
 
 	// Define command-line flags
 	var (
-		showVersion     = flag.Bool("version", false, "Show version information and exit")
-		hostname        = flag.String("hostname", "", "ESXi server hostname")
-		domain          = flag.String("domain", "", "DNS domain managed by Route53 (for DNS validation)")
-		email           = flag.String("email", "", "Email address for ACME registration")
-		threshold       = flag.Float64("threshold", 0, "Renewal threshold (e.g., 0.33 for 1/3 of remaining lifetime)")
-		logFile         = flag.String("log", "", "Path to log file (defaults to binary_name.log)")
-		logLevel        = flag.String("log-level", "", "Log level (ERROR, WARN, INFO, DEBUG)")
-		awsKeyID        = flag.String("aws-key-id", "", "AWS Access Key ID for Route53")
-		awsSecretKey    = flag.String("aws-secret-key", "", "AWS Secret Access Key for Route53")
-		awsSessionToken = flag.String("aws-session-token", "", "AWS Session Token for Route53 (for temporary credentials)")
-		awsRegion       = flag.String("aws-region", "", "AWS Region for Route53")
-		dryRun          = flag.Bool("dry-run", false, "Only check certificate without renewing")
-		force           = flag.Bool("force", false, "Force certificate renewal regardless of expiration threshold")
-		keySize         = flag.Int("key-size", 0, "RSA key size for certificates (2048, 4096)")
-		esxiUsername    = flag.String("esxi-user", "", "ESXi server username")
-		esxiPassword    = flag.String("esxi-pass", "", "ESXi server password")
+		showVersion         = flag.Bool("version", false, "Show version information and exit")
+		showConfig          = flag.Bool("show-config", false, "Print the effective merged configuration with the source of each value (secrets masked) and exit")
+		whyConfig           = flag.String("why-config", "", "Print the resolved value of one configuration key and where it came from (default, config file, environment, or flag), then exit")
+		pruneCacheFlag      = flag.Bool("prune-cache", false, "Remove expired or unreadable entries from the certificate cache, report what was removed, and exit")
+		compareFlag         = flag.String("compare", "", "Compare the certificates served by two hosts (host1,host2): issuer, SANs, key type, and expiry. Read-only; exits 1 if they differ")
+		dumpCert            = flag.String("dump-cert", "", "Print the certificate chain a host serves (hostname[:port]) as PEM and exit. Read-only; the chain is not verified")
+		dumpCertFile        = flag.String("dump-cert-file", "", "With -dump-cert, write the PEM chain to this file instead of stdout")
+		inventory           = flag.Bool("inventory", false, "Print the certificate of every configured host (issuer, expiry, days remaining, key type, needs renewal) and exit. Read-only; renews nothing")
+		outputFormat        = flag.String("output", inventoryOutputTable, "Output format for -inventory: table or json")
+		testSSH             = flag.Bool("test-ssh", false, "Connect to the host over SSH with the configured credentials, print the negotiated algorithms, the authentication method used, and a listing of the certificate directory, then exit. Changes nothing")
+		pruneOlderThan      = flag.Duration("prune-older-than", 0, "With -prune-cache, also remove entries cached longer ago than this (e.g. 720h)")
+		printSchema         = flag.Bool("print-schema", false, "Print a JSON Schema for the config file (for editor validation and completion) and exit")
+		expandEnv           = flag.Bool("expand-env", false, "Expand ${VAR} references in config file string values from the environment")
+		noUpdateCheck       = flag.Bool("no-update-check", false, "Skip the background check for a newer release on GitHub")
+		hostname            = flag.String("hostname", "", "ESXi server hostname")
+		checkReachable      = flag.Bool("check-reachable", false, "During validation, fail fast unless the host accepts a TCP connection on port 443")
+		ipVersion           = flag.String("ip-version", "", "IP version for connections to the host (TLS checks, SSH, SOAP): auto, 4, or 6")
+		timing              = flag.Bool("timing", false, "Print a per-phase timing breakdown (AWS validation, check, generation, upload, validation) at the end of the run")
+		explain             = flag.Bool("explain", false, "Log a one-line statement of why the certificate is or isn't being renewed")
+		postRenewHook       = flag.String("post-renew-hook", "", "Command to run after a successful renewal (env: ESXI_HOST, CERT_PATH, KEY_PATH, NEW_EXPIRY, STATUS)")
+		postFailHook        = flag.String("post-fail-hook", "", "Command to run after a failed run (same environment, plus ERROR)")
+		preuploadCheckCmd   = flag.String("preupload-check-cmd", "", "Command run on the host over SSH before installing; a non-zero exit aborts the install (e.g. a free space check)")
+		noServiceManagement = flag.Bool("no-service-management", false, "Skip the SOAP API entirely and install over SSH without starting or stopping TSM-SSH (for hosts with SSH always enabled)")
+		uploadLockWait      = flag.Duration("upload-lock-wait", 0, "How long to wait for another run installing on the same host before giving up (default 0, fail at once)")
+		strictHooks         = flag.Bool("strict-hooks", false, "Fail the run when a hook command fails instead of only logging a warning")
+		checkChain          = flag.Bool("check-chain", false, "Verify the full chain the host serves: it must build to a trusted root, with no gaps or intermediates expiring before the leaf")
+		insecure            = flag.Bool("insecure", false, "Accept the ESXi host's certificate without verification (required for self-signed hosts unless -ca-bundle is given)")
+		caBundle            = flag.String("ca-bundle", "", "PEM file of trusted roots for -check-chain and -verify-trust instead of the system roots (implies -check-chain)")
+		verifyTrust         = flag.Bool("verify-trust", false, "After installation, verify the new certificate chains to a trusted root (-ca-bundle or system roots) and matches the hostname")
+		requireValidation   = flag.Bool("require-validation", false, "Fail the run when the host can't be confirmed serving the new certificate instead of only logging a warning")
+		verifyCertFile      = flag.Bool("verify-cert-file", false, "Before installing over SSH, read the certificate file being replaced (rui.crt by default) and warn if port 443 serves a different certificate")
+		protectedPattern    = flag.String("protected-pattern", "", "Regular expression of protected hostnames (e.g. '(?i)prod'); runs that would modify a matching host are refused without -i-know-what-im-doing")
+		confirmProtected    = flag.Bool("i-know-what-im-doing", false, "Allow modifying a host that matches -protected-pattern. Only accepted on the command line")
+		renewIfIssuerNot    = flag.String("renew-if-issuer-not", "", "Renew regardless of expiry when the installed certificate's issuer CN/O does not contain this text (e.g. \"Let's Encrypt\")")
+		pfxOutput           = flag.String("pfx-output", "", "Also write the certificate, chain and key as a PKCS#12 (.pfx) file at this path")
+		pfxPassword         = flag.String("pfx-password", "", "Password of the -pfx-file input and protecting the -pfx-output file (a warning is logged when empty)")
+		pfxFile             = flag.String("pfx-file", "", "Install the certificate, chain and key from this PKCS#12 (.pfx) file instead of ordering one via ACME")
+		outputDir           = flag.String("output-dir", "", "Also write each generated certificate, chain and key to this directory (e.g. for deploy scripts)")
+		outputLayout        = flag.String("output-layout", "", "Layout of -output-dir: flat (<host>-cert.pem, <host>-key.pem) or certbot (live/<host>/fullchain.pem etc. linked to archive/<host>/)")
+		s3UploadBucket      = flag.String("s3-upload-bucket", "", "Archive each generated certificate, chain and key in this S3 bucket (private, SSE-S3 encrypted) using the configured AWS credentials")
+		s3Prefix            = flag.String("s3-prefix", "", "Key prefix for -s3-upload-bucket objects (e.g. certs/)")
+		s3Region            = flag.String("s3-region", "", "Region of the -s3-upload-bucket bucket (default the AWS region)")
+		strictDistribution  = flag.Bool("strict-distribution", false, "Fail the run when the S3 upload fails instead of only logging a warning")
+		schedule            = flag.String("schedule", "", "Keep running and check for renewal at each time of this cron expression (e.g. \"0 3 * * *\" or @daily)")
+		statusFile          = flag.String("status-file", "", "With -schedule, write a JSON status file (last run, per-host outcomes, next run) after each run for monitoring")
+		healthzAddr         = flag.String("healthz-addr", "", "With -schedule, serve a liveness probe at http://<addr>/healthz (e.g. :8080 for a Kubernetes livenessProbe)")
+		domain              = flag.String("domain", "", "DNS domain managed by Route53 (for DNS validation)")
+		email               = flag.String("email", "", "Email address for ACME registration")
+		acmeUserAgent       = flag.String("acme-user-agent", "", "Identify this client to the ACME CA with this string, added to the user agent")
+		acmeProfile         = flag.String("acme-profile", "", "ACME certificate profile to request from the CA (e.g. shortlived)")
+		preferredChain      = flag.String("preferred-chain", "", "Prefer the CA's alternate chain whose top certificate is issued by this common name (e.g. \"ISRG Root X1\")")
+		threshold           = flag.Float64("threshold", 0, "Renewal threshold (e.g., 0.33 for 1/3 of remaining lifetime)")
+		cacheReuseThreshold = flag.Float64("cache-reuse-threshold", 0, "Reuse a cached certificate only while more than this fraction of its lifetime remains (default 0.5)")
+		logFile             = flag.String("log", "", "Path to log file (defaults to binary_name.log)")
+		quiet               = flag.Bool("quiet", false, "Log only to the log file; stdout stays silent and errors are written to stderr (suits cron)")
+		stdoutOnly          = flag.Bool("stdout-only", false, "Log only to stdout and skip the log file")
+		logSyslog           = flag.Bool("log-syslog", false, "Also send log output to the local syslog daemon or journald (not available on Windows)")
+		syslogFacility      = flag.String("syslog-facility", "", "Syslog facility for -log-syslog: user, daemon, or local0-local7 (default user)")
+		syslogTag           = flag.String("syslog-tag", "", "Syslog tag for -log-syslog (default the executable name)")
+		logLevel            = flag.String("log-level", "", "Log level (ERROR, WARN, INFO, DEBUG, TRACE)")
+		awsKeyID            = flag.String("aws-key-id", "", "AWS Access Key ID for Route53")
+		awsSecretKey        = flag.String("aws-secret-key", "", "AWS Secret Access Key for Route53")
+		awsSessionToken     = flag.String("aws-session-token", "", "AWS Session Token for Route53 (for temporary credentials)")
+		awsRegion           = flag.String("aws-region", "", "AWS Region for Route53")
+		awsEndpoint         = flag.String("aws-endpoint", "", "Custom AWS endpoint URL for STS and Route53 (e.g. LocalStack or a non-standard partition)")
+		route53ZoneID       = flag.String("route53-zone-id", "", "Route53 hosted zone ID to use for the DNS challenge (default: most specific matching zone)")
+		route53Profile      = flag.String("route53-profile", "", "Shared AWS profile for the Route53 DNS provider only; validation keeps the explicit keys or default chain")
+		route53MaxRetries   = flag.Int("route53-max-retries", 0, "Attempts per AWS request to STS and Route53, including the first (default 5)")
+		dnsTTLFlag          = flag.Int("dns-ttl", 0, "TTL in seconds of the DNS-01 challenge TXT record (default 60)")
+		dnsPropagationWait  = flag.Duration("dns-propagation-wait", 0, "Wait this long after creating the DNS-01 record before checking its propagation (e.g. 30s)")
+		dnsSkipPropagation  = flag.Bool("dns-skip-propagation-check", false, "Don't check that the DNS-01 record has propagated before notifying the CA (for fast internal DNS; combine with -dns-propagation-wait)")
+		dnsPreflight        = flag.Bool("dns-preflight", false, "Before ordering, create a test DNS-01 TXT record through Route53, check that public resolvers return it, and delete it; abort if that fails. Doesn't contact the CA")
+		maxValidateAttempts = flag.Int("validate-attempts", 0, "Stop checking that the host serves the new certificate after this many attempts, or at the 5m timeout if sooner (0 checks until the timeout)")
+		validateFirstWait   = flag.Duration("validate-initial-interval", 0, "First wait between checks that the host serves the new certificate; doubles after each attempt up to -validate-max-interval (default 5s)")
+		validateMaxWait     = flag.Duration("validate-max-interval", 0, "Longest wait between checks that the host serves the new certificate (default 30s)")
+		awsTimeout          = flag.Duration("aws-timeout", 0, "Timeout for each AWS HTTP request to STS and Route53 (default: AWS SDK default)")
+		awsAssumeRoleArn    = flag.String("aws-assume-role-arn", "", "IAM role ARN to assume via STS for Route53 access (e.g. a cross-account DNS role)")
+		awsExternalID       = flag.String("aws-external-id", "", "External ID to pass when assuming the role (optional)")
+		dryRun              = flag.Bool("dry-run", false, "Only check certificate without renewing")
+		printCommands       = flag.Bool("print-commands", false, "With -dry-run, print the SSH commands and remote paths the install would use, without connecting")
+		force               = flag.Bool("force", false, "Force certificate renewal regardless of expiration threshold")
+		forceUpload         = flag.Bool("force-upload", false, "Renew and upload even when the installed certificate already matches (same issuer, SANs, and enough validity)")
+		keySize             = flag.Int("key-size", 0, "RSA size of the ACME account key (2048, 3072, 4096); use -key-type for the certificate key")
+		keyType             = flag.String("key-type", "", "Certificate key type: rsa2048, rsa3072, rsa4096, ec256, ec384 (default: rsa2048)")
+		accountKeyType      = flag.String("account-key-type", "", "ACME account key type, independent of the certificate key: rsa2048, rsa3072, rsa4096, ec256, ec384 (default: RSA with -key-size bits)")
+		esxiUsername        = flag.String("esxi-user", "", "ESXi server username")
+		esxiPassword        = flag.String("esxi-pass", "", "ESXi server password")
+		installMethod       = flag.String("install-method", "", "Certificate install method: ssh (copy files over SSH) or soap-certmgr (SOAP HostCertificateManager, no SSH)")
+		targetType          = flag.String("target-type", "", "Remote system type: esxi (ESXi host) or vcsa (vCenter Server Appliance, restarted with service-control)")
+		reloadMethod        = flag.String("reload-method", "", "How ESXi services pick up the new certificate: restart (default) or reload (refresh or SIGHUP hostd, falling back to a restart if the host still serves the old certificate)")
+		chainMode           = flag.String("chain-mode", "", "Certificate content written to the host: full (leaf + intermediates) or leaf-only")
+		services            = flag.String("services", "", "Certificate destinations as cert_path[,key_path]=restart_command entries separated by ';' (default: rui.crt/rui.key with the built-in ESXi restart)")
+		challengeType       = flag.String("challenge-type", "", "ACME challenge type: dns-01 (Route53) or http-01 (serve the token over HTTP, no AWS needed)")
+		httpChallengePort   = flag.Int("http-challenge-port", 0, "Port to serve HTTP-01 challenge tokens on (default 80)")
+		cacheLockTimeout    = flag.Duration("cache-lock-timeout", 0, "How long to wait for another run to release the certificate cache lock (e.g. 30s)")
+		maxRenewals         = flag.Int("max-renewals", -1, "Refuse to renew a host that was already renewed this many times within -renewal-window, guarding against renewal loops (0 disables; default 3)")
+		minIssuanceInterval = flag.Duration("min-issuance-interval", 0, "Refuse to place an ACME order within this long of the previous one from this machine, for any host; a hosts-list run counts once (e.g. 1h; 0 disables)")
+		minRemainingDays    = flag.Int("min-remaining-after-renew-days", -1, "Fail the run when the newly installed certificate has fewer days of validity left than this, e.g. a short-dated certificate from a misconfigured CA (0 disables; default 30)")
+		renewalWindow       = flag.Duration("renewal-window", 0, "Window for -max-renewals (default 24h)")
+		sshStopTimeout      = flag.Duration("ssh-stop-timeout", 0, "How long to keep re-issuing the TSM-SSH stop and polling until it reports stopped (e.g. 45s)")
+		esxiTOTPSecret      = flag.String("esxi-totp-secret", "", "Base32 TOTP secret for ESXi hosts that prompt for a verification code over SSH")
+		smtpHost            = flag.String("smtp-host", "", "SMTP server for emailing renewal reports (enables email reports)")
+		smtpPort            = flag.Int("smtp-port", 0, "SMTP server port (default 587, STARTTLS is used when offered)")
+		esxiSSHPort         = flag.Int("esxi-ssh-port", 0, "SSH port of the ESXi host (default 22)")
+		esxiHTTPSPort       = flag.Int("esxi-https-port", 0, "HTTPS port of the ESXi host for TLS checks and the SOAP API (default 443; a port in -hostname takes precedence)")
+		soapConnectRetries  = flag.Int("soap-connect-retries", -1, "Retries with backoff when connecting to the ESXi SOAP API fails transiently; authentication failures are not retried (default 3)")
+		soapKeepAlive       = flag.Duration("soap-keepalive", 0, "With -schedule, keep the ESXi SOAP session alive at this idle interval and reuse it across runs instead of logging in each time (e.g. 10m)")
+		smtpUsername        = flag.String("smtp-user", "", "SMTP username (optional)")
+		smtpPassword        = flag.String("smtp-pass", "", "SMTP password (optional)")
+		mailFrom            = flag.String("mail-from", "", "Sender address for renewal report emails")
+		mailTo              = flag.String("mail-to", "", "Comma-separated recipient addresses for renewal report emails")
+		testIssuance        = flag.Bool("test-issuance", false, "Order a certificate from Let's Encrypt staging to verify DNS/AWS setup, without uploading to ESXi")
+		failFast            = flag.Bool("fail-fast", false, "With a hosts list, stop at the first host that fails instead of continuing")
+		reuseKey            = flag.Bool("reuse-key", false, "Reuse the previously cached certificate private key instead of generating a fresh one")
+		mustStaple          = flag.Bool("must-staple", false, "Request the OCSP Must-Staple extension in issued certificates (only safe if the host staples OCSP)")
+		csrFile             = flag.String("csr-file", "", "Submit this existing CSR (PEM or DER) instead of generating a key and CSR; requires -key-file")
+		keyFile             = flag.String("key-file", "", "PEM private key matching -csr-file, installed alongside the issued certificate")
 	)
 
+	// Repeatable flags
+	var acmeContacts stringListFlag
+	flag.Var(&acmeContacts, "acme-contact", "Additional contact email for the ACME account (repeatable)")
+	var extKeyUsageFlags stringListFlag
+	flag.Var(&extKeyUsageFlags, "eku", "Extended key usage to request in the certificate: serverAuth or clientAuth (repeatable)")
+	var labels stringListFlag
+	flag.Var(&labels, "label", "Metadata attached to the run's status file, mail report and hook environment as key=value, e.g. env=prod (repeatable)")
+	var expectedIssuers stringListFlag
+	flag.Var(&expectedIssuers, "expected-issuer", "Refuse to install a certificate whose issuer CN/O contains none of these texts (repeatable)")
+
 	// Parse flags first to get config file path
 	flag.Parse()
 
+	// The schema describes the config file format, so it needs no configuration
+	if *printSchema {
+		schema, err := configFileSchema()
+		if err != nil {
+			return Config{}, fmt.Errorf("failed to generate config schema: %v", err)
+		}
+		fmt.Println(string(schema))
+		os.Exit(0)
+	}
+
+	// Comparison is read-only and needs no host configuration beyond TLS trust
+	if *compareFlag != "" {
+		runCompare(*compareFlag, *insecure, *caBundle)
+	}
+
+	// Dumping a served chain is read-only and needs no configuration at all
+	if *dumpCert != "" {
+		runDumpCert(*dumpCert, *dumpCertFile)
+	}
+
+	// Cache maintenance runs standalone, without a host configuration
+	if *pruneCacheFlag {
+		runPruneCache(*pruneOlderThan, *cacheLockTimeout)
+	}
+
 	// Handle version flag
 	if *showVersion {
 		v := version.Get()
 		fmt.Println(v.Detailed())
 
 		// Check for updates and display if available
-		if updateMsg := version.GetUpdateNotification(); updateMsg != "" {
-			fmt.Println()
-			fmt.Println(updateMsg)
+		if !*noUpdateCheck && updateCheckEnabledByEnv() {
+			if updateMsg := version.StartUpdateCheck().Notification(updateCheckWait); updateMsg != "" {
+				fmt.Println()
+				fmt.Println(updateMsg)
+			}
 		}
 
 		os.Exit(0)
@@ -63,6 +204,11 @@ func parseArgs() (Config, error) {
 		os.Exit(0)
 	}
 
+	// Expansion applies while the file is read, so it is set before loading
+	if *expandEnv {
+		cm.Set("expand_env", true, ConfigSourceFlag)
+	}
+
 	// Load configuration file if specified
 	if err := cm.LoadConfigFile(configFile); err != nil {
 		return Config{}, fmt.Errorf("failed to load config file: %v", err)
@@ -81,9 +227,30 @@ func parseArgs() (Config, error) {
 	if *email != "" {
 		cm.Set("email", *email, ConfigSourceFlag)
 	}
+	if len(acmeContacts) > 0 {
+		cm.Set("acme_contacts", strings.Join(acmeContacts, ","), ConfigSourceFlag)
+	}
+	if *acmeUserAgent != "" {
+		cm.Set("acme_user_agent", *acmeUserAgent, ConfigSourceFlag)
+	}
+	if flagProvided("acme-profile") && strings.TrimSpace(*acmeProfile) == "" {
+		return Config{}, fmt.Errorf("acme-profile must not be empty")
+	}
+	if *acmeProfile != "" {
+		cm.Set("acme_profile", *acmeProfile, ConfigSourceFlag)
+	}
+	if flagProvided("preferred-chain") && strings.TrimSpace(*preferredChain) == "" {
+		return Config{}, fmt.Errorf("preferred-chain must not be empty")
+	}
+	if *preferredChain != "" {
+		cm.Set("preferred_chain", *preferredChain, ConfigSourceFlag)
+	}
 	if *threshold != 0 {
 		cm.Set("threshold", *threshold, ConfigSourceFlag)
 	}
+	if *cacheReuseThreshold != 0 {
+		cm.Set("cache_reuse_threshold", *cacheReuseThreshold, ConfigSourceFlag)
+	}
 	if *logFile != "" {
 		cm.Set("log_file", *logFile, ConfigSourceFlag)
 	}
@@ -102,51 +269,401 @@ func parseArgs() (Config, error) {
 	if *awsRegion != "" {
 		cm.Set("aws_region", *awsRegion, ConfigSourceFlag)
 	}
+	if *awsEndpoint != "" {
+		cm.Set("aws_endpoint", *awsEndpoint, ConfigSourceFlag)
+	}
+	if *route53ZoneID != "" {
+		cm.Set("route53_zone_id", *route53ZoneID, ConfigSourceFlag)
+	}
+	if *route53Profile != "" {
+		cm.Set("route53_profile", *route53Profile, ConfigSourceFlag)
+	}
+	if *route53MaxRetries != 0 {
+		cm.Set("route53_max_retries", *route53MaxRetries, ConfigSourceFlag)
+	}
+	if *dnsTTLFlag != 0 {
+		cm.Set("dns_ttl", *dnsTTLFlag, ConfigSourceFlag)
+	}
+	if *dnsPropagationWait != 0 {
+		cm.Set("dns_propagation_wait", *dnsPropagationWait, ConfigSourceFlag)
+	}
+	if *dnsSkipPropagation {
+		cm.Set("dns_skip_propagation", *dnsSkipPropagation, ConfigSourceFlag)
+	}
+	if *dnsPreflight {
+		cm.Set("dns_preflight", *dnsPreflight, ConfigSourceFlag)
+	}
+	if *maxValidateAttempts != 0 {
+		cm.Set("validate_attempts", *maxValidateAttempts, ConfigSourceFlag)
+	}
+	if *validateFirstWait != 0 {
+		cm.Set("validate_interval", *validateFirstWait, ConfigSourceFlag)
+	}
+	if *validateMaxWait != 0 {
+		cm.Set("validate_max_interval", *validateMaxWait, ConfigSourceFlag)
+	}
+	if *awsTimeout != 0 {
+		cm.Set("aws_timeout", *awsTimeout, ConfigSourceFlag)
+	}
+	if *awsAssumeRoleArn != "" {
+		cm.Set("aws_assume_role_arn", *awsAssumeRoleArn, ConfigSourceFlag)
+	}
+	if *awsExternalID != "" {
+		cm.Set("aws_external_id", *awsExternalID, ConfigSourceFlag)
+	}
 	if *dryRun {
 		cm.Set("dry_run", *dryRun, ConfigSourceFlag)
 	}
+	if *printCommands {
+		cm.Set("print_commands", *printCommands, ConfigSourceFlag)
+	}
 	if *force {
 		cm.Set("force", *force, ConfigSourceFlag)
 	}
+	if *checkReachable {
+		cm.Set("check_reachable", *checkReachable, ConfigSourceFlag)
+	}
+	if *quiet {
+		cm.Set("quiet", *quiet, ConfigSourceFlag)
+	}
+	if *stdoutOnly {
+		cm.Set("stdout_only", *stdoutOnly, ConfigSourceFlag)
+	}
+	if *logSyslog {
+		cm.Set("log_syslog", *logSyslog, ConfigSourceFlag)
+	}
+	if *syslogFacility != "" {
+		cm.Set("syslog_facility", *syslogFacility, ConfigSourceFlag)
+	}
+	if *syslogTag != "" {
+		cm.Set("syslog_tag", *syslogTag, ConfigSourceFlag)
+	}
+	if *schedule != "" {
+		cm.Set("schedule", *schedule, ConfigSourceFlag)
+	}
+	if *statusFile != "" {
+		cm.Set("status_file", *statusFile, ConfigSourceFlag)
+	}
+	if *healthzAddr != "" {
+		cm.Set("healthz_addr", *healthzAddr, ConfigSourceFlag)
+	}
+	if *s3UploadBucket != "" {
+		cm.Set("s3_upload_bucket", *s3UploadBucket, ConfigSourceFlag)
+	}
+	if *s3Prefix != "" {
+		cm.Set("s3_prefix", *s3Prefix, ConfigSourceFlag)
+	}
+	if *s3Region != "" {
+		cm.Set("s3_region", *s3Region, ConfigSourceFlag)
+	}
+	if *strictDistribution {
+		cm.Set("strict_distribution", *strictDistribution, ConfigSourceFlag)
+	}
+	if *pfxOutput != "" {
+		cm.Set("pfx_output", *pfxOutput, ConfigSourceFlag)
+	}
+	if *pfxPassword != "" {
+		cm.Set("pfx_password", *pfxPassword, ConfigSourceFlag)
+	}
+	if *pfxFile != "" {
+		cm.Set("pfx_file", *pfxFile, ConfigSourceFlag)
+	}
+	if *outputDir != "" {
+		cm.Set("output_dir", *outputDir, ConfigSourceFlag)
+	}
+	if *outputLayout != "" {
+		cm.Set("output_layout", *outputLayout, ConfigSourceFlag)
+	}
+	if *renewIfIssuerNot != "" {
+		cm.Set("renew_if_issuer_not", *renewIfIssuerNot, ConfigSourceFlag)
+	}
+	if len(labels) > 0 {
+		cm.Set("labels", strings.Join(labels, ","), ConfigSourceFlag)
+	}
+	if len(expectedIssuers) > 0 {
+		cm.Set("expected_issuers", strings.Join(expectedIssuers, ","), ConfigSourceFlag)
+	}
+	if *verifyTrust {
+		cm.Set("verify_trust", *verifyTrust, ConfigSourceFlag)
+	}
+	if *requireValidation {
+		cm.Set("require_validation", *requireValidation, ConfigSourceFlag)
+	}
+	if *protectedPattern != "" {
+		cm.Set("protected_pattern", *protectedPattern, ConfigSourceFlag)
+	}
+	if *confirmProtected {
+		cm.Set("i_know_what_im_doing", *confirmProtected, ConfigSourceFlag)
+	}
+	if *verifyCertFile {
+		cm.Set("verify_cert_file", *verifyCertFile, ConfigSourceFlag)
+	}
+	if *insecure {
+		cm.Set("insecure", *insecure, ConfigSourceFlag)
+	}
+	if *checkChain {
+		cm.Set("check_chain", *checkChain, ConfigSourceFlag)
+	}
+	if *caBundle != "" {
+		cm.Set("ca_bundle", *caBundle, ConfigSourceFlag)
+	}
+	if *ipVersion != "" {
+		cm.Set("ip_version", *ipVersion, ConfigSourceFlag)
+	}
+	if *postRenewHook != "" {
+		cm.Set("post_renew_hook", *postRenewHook, ConfigSourceFlag)
+	}
+	if *postFailHook != "" {
+		cm.Set("post_fail_hook", *postFailHook, ConfigSourceFlag)
+	}
+	if *noServiceManagement {
+		cm.Set("no_service_management", *noServiceManagement, ConfigSourceFlag)
+	}
+	if *preuploadCheckCmd != "" {
+		cm.Set("preupload_check_cmd", *preuploadCheckCmd, ConfigSourceFlag)
+	}
+	if *uploadLockWait != 0 {
+		cm.Set("upload_lock_wait", *uploadLockWait, ConfigSourceFlag)
+	}
+	if *strictHooks {
+		cm.Set("strict_hooks", *strictHooks, ConfigSourceFlag)
+	}
+	if *explain {
+		cm.Set("explain", *explain, ConfigSourceFlag)
+	}
+	if *timing {
+		cm.Set("timing", *timing, ConfigSourceFlag)
+	}
+	if *forceUpload {
+		cm.Set("force_upload", *forceUpload, ConfigSourceFlag)
+	}
 	if *keySize != 0 {
 		cm.Set("key_size", *keySize, ConfigSourceFlag)
 	}
+	if *keyType != "" {
+		cm.Set("key_type", *keyType, ConfigSourceFlag)
+	}
+	if *accountKeyType != "" {
+		cm.Set("account_key_type", *accountKeyType, ConfigSourceFlag)
+	}
 	if *esxiUsername != "" {
 		cm.Set("esxi_username", *esxiUsername, ConfigSourceFlag)
 	}
 	if *esxiPassword != "" {
 		cm.Set("esxi_password", *esxiPassword, ConfigSourceFlag)
 	}
+	if *installMethod != "" {
+		cm.Set("install_method", *installMethod, ConfigSourceFlag)
+	}
+	if *targetType != "" {
+		cm.Set("target_type", *targetType, ConfigSourceFlag)
+	}
+	if *reloadMethod != "" {
+		cm.Set("reload_method", *reloadMethod, ConfigSourceFlag)
+	}
+	if *challengeType != "" {
+		cm.Set("challenge_type", *challengeType, ConfigSourceFlag)
+	}
+	if *httpChallengePort != 0 {
+		cm.Set("http_challenge_port", *httpChallengePort, ConfigSourceFlag)
+	}
+	if *chainMode != "" {
+		cm.Set("chain_mode", *chainMode, ConfigSourceFlag)
+	}
+	if *services != "" {
+		targets, err := parseServicesSpec(*services)
+		if err != nil {
+			return Config{}, err
+		}
+		cm.Set("services", targets, ConfigSourceFlag)
+	}
+	if *minRemainingDays >= 0 {
+		cm.Set("min_remaining_days", *minRemainingDays, ConfigSourceFlag)
+	}
+	if *minIssuanceInterval != 0 {
+		cm.Set("min_issuance_interval", *minIssuanceInterval, ConfigSourceFlag)
+	}
+	if *maxRenewals >= 0 {
+		cm.Set("max_renewals", *maxRenewals, ConfigSourceFlag)
+	}
+	if *renewalWindow != 0 {
+		cm.Set("renewal_window", *renewalWindow, ConfigSourceFlag)
+	}
+	if *cacheLockTimeout != 0 {
+		cm.Set("cache_lock_timeout", *cacheLockTimeout, ConfigSourceFlag)
+	}
+	if *sshStopTimeout != 0 {
+		cm.Set("ssh_stop_timeout", *sshStopTimeout, ConfigSourceFlag)
+	}
+	if *smtpHost != "" {
+		cm.Set("smtp_host", *smtpHost, ConfigSourceFlag)
+	}
+	if *esxiSSHPort != 0 {
+		cm.Set("esxi_ssh_port", *esxiSSHPort, ConfigSourceFlag)
+	}
+	if *esxiHTTPSPort != 0 {
+		cm.Set("esxi_https_port", *esxiHTTPSPort, ConfigSourceFlag)
+	}
+	if *soapConnectRetries >= 0 {
+		cm.Set("soap_connect_retries", *soapConnectRetries, ConfigSourceFlag)
+	}
+	if *soapKeepAlive != 0 {
+		cm.Set("soap_keepalive", *soapKeepAlive, ConfigSourceFlag)
+	}
+	if *smtpPort != 0 {
+		cm.Set("smtp_port", *smtpPort, ConfigSourceFlag)
+	}
+	if *smtpUsername != "" {
+		cm.Set("smtp_username", *smtpUsername, ConfigSourceFlag)
+	}
+	if *smtpPassword != "" {
+		cm.Set("smtp_password", *smtpPassword, ConfigSourceFlag)
+	}
+	if *mailFrom != "" {
+		cm.Set("mail_from", *mailFrom, ConfigSourceFlag)
+	}
+	if *mailTo != "" {
+		cm.Set("mail_to", *mailTo, ConfigSourceFlag)
+	}
+	if *esxiTOTPSecret != "" {
+		cm.Set("esxi_totp_secret", *esxiTOTPSecret, ConfigSourceFlag)
+	}
+	if *testIssuance {
+		cm.Set("test_issuance", *testIssuance, ConfigSourceFlag)
+	}
+	if *failFast {
+		cm.Set("fail_fast", *failFast, ConfigSourceFlag)
+	}
+	if *reuseKey {
+		cm.Set("reuse_key", *reuseKey, ConfigSourceFlag)
+	}
+	if *csrFile != "" {
+		cm.Set("csr_file", *csrFile, ConfigSourceFlag)
+	}
+	if *keyFile != "" {
+		cm.Set("key_file", *keyFile, ConfigSourceFlag)
+	}
+	if *mustStaple {
+		cm.Set("must_staple", *mustStaple, ConfigSourceFlag)
+	}
+	if len(extKeyUsageFlags) > 0 {
+		cm.Set("ext_key_usages", strings.Join(extKeyUsageFlags, ","), ConfigSourceFlag)
+	}
+	if *noUpdateCheck {
+		cm.Set("check_updates", false, ConfigSourceFlag)
+	}
 
 	// Build final configuration
 	config := cm.BuildConfig()
 
+	// Apply the IP version preference to every connection made to the host, including the
+	// optional reachability check during validation
+	dialNetwork = networkForIPVersion(config.IPVersion)
+	validateAttempts = config.ValidateAttempts
+	if config.ValidateInterval > 0 {
+		validateInterval = config.ValidateInterval
+	}
+	if config.ValidateMaxInterval > 0 {
+		validateMaxInterval = config.ValidateMaxInterval
+	}
+
+	// Show the merged configuration before validation so invalid settings can be diagnosed too
+	if *showConfig {
+		cm.ShowConfig(os.Stdout)
+		os.Exit(0)
+	}
+	if *whyConfig != "" {
+		if err := cm.WhyConfig(os.Stdout, *whyConfig); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitCodeFailure)
+		}
+		os.Exit(0)
+	}
+
+	// SSH diagnostics only need the host and its credentials, not a full renewal configuration
+	if *testSSH {
+		runTestSSH(config)
+	}
+
+	// The inventory only reads each host's certificate, so it needs no renewal configuration
+	if *inventory {
+		runInventory(config, *outputFormat)
+	}
+
 	// Validate configuration
 	if err := cm.ValidateConfig(config); err != nil {
 		return config, err
 	}
 
+	// Verify host certificates against the CA bundle unless -insecure accepts them as-is
+	if err := configureHostTrust(config); err != nil {
+		return config, err
+	}
+
 	// Print configuration sources in debug mode
-	if config.LogLevel == "DEBUG" {
+	if parseLogLevel(config.LogLevel) >= LOG_DEBUG {
 		cm.PrintConfigSources()
 	}
 
 	return config, nil
 }
 
+// stringListFlag collects the values of a flag given more than once
+type stringListFlag []string
+
+func (s *stringListFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringListFlag) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// flagProvided reports whether the named flag was given on the command line, even with an empty value
+func flagProvided(name string) bool {
+	provided := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			provided = true
+		}
+	})
+	return provided
+}
+
+// Prune the certificate cache, print what was removed, and exit
+func runPruneCache(olderThan, lockTimeout time.Duration) {
+	if lockTimeout == 0 {
+		lockTimeout = defaultCacheLockTimeout
+	}
+
+	cacheDir := defaultCacheDir()
+	pruned, err := pruneCache(cacheDir, olderThan, lockTimeout, time.Now())
+	for _, entry := range pruned {
+		fmt.Printf("Removed %s: %s\n", entry.Hostname, entry.Reason)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error pruning cache: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Pruned %d cache entries from %s\n", len(pruned), cacheDir)
+	os.Exit(0)
+}
+
 // Print help and usage examples
 func printHelp() {
+	// Check for updates in the background while the help text is printed
+	var updateCheck *version.UpdateCheck
+	if updateCheckEnabledByEnv() {
+		updateCheck = version.StartUpdateCheck()
+	}
+
 	v := version.Get()
 	fmt.Printf("ESXi Certificate Manager %s\n", v.String())
 	fmt.Println("=======================")
 	fmt.Println("This tool checks and automatically renews SSL certificates for ESXi servers.")
 	fmt.Println("")
-
-	// Check for updates and display if available
-	if updateMsg := version.GetUpdateNotification(); updateMsg != "" {
-		fmt.Println(updateMsg)
-		fmt.Println("")
-	}
 	fmt.Println("Usage:")
 	fmt.Printf("  %s [options]\n", os.Args[0])
 	fmt.Println("")
@@ -154,34 +671,53 @@ func printHelp() {
 	flag.PrintDefaults()
 	fmt.Println("")
 	fmt.Println("Examples:")
+	fmt.Printf("  # Check that two HA hosts serve matching certificates\n")
+	fmt.Printf("  %s --compare esxi01.lab.example.com,esxi02.lab.example.com --insecure\n", os.Args[0])
+	fmt.Printf("  # Save the chain a host serves before renewing it\n")
+	fmt.Printf("  %s --dump-cert esxi01.lab.example.com --dump-cert-file esxi01-before.pem\n", os.Args[0])
+	fmt.Printf("  # List the certificate status of every host in a config file as JSON\n")
+	fmt.Printf("  %s --inventory --config hosts.json --insecure --output json\n", os.Args[0])
+	fmt.Printf("  # Diagnose SSH connectivity and authentication without changing anything\n")
+	fmt.Printf("  %s --test-ssh --hostname esxi01.lab.example.com --esxi-user root --esxi-pass secret\n", os.Args[0])
+	fmt.Println("")
+	fmt.Printf("  # Remove expired cache entries and those cached more than 90 days ago\n")
+	fmt.Printf("  %s --prune-cache --prune-older-than 2160h\n", os.Args[0])
+	fmt.Println("")
 	fmt.Printf("  # Show version information\n")
 	fmt.Printf("  %s --version\n", os.Args[0])
 	fmt.Println("")
 	fmt.Printf("  # Check certificate only (using AWS default credential chain)\n")
-	fmt.Printf("  %s --hostname esxi01.lab.example.com --dry-run\n", os.Args[0])
+	fmt.Printf("  %s --hostname esxi01.lab.example.com --insecure --dry-run\n", os.Args[0])
 	fmt.Println("")
 	fmt.Printf("  # Using AWS default profile (from ~/.aws/credentials)\n")
-	fmt.Printf("  %s --hostname esxi01.lab.example.com --domain lab.example.com --email admin@example.com \\\n", os.Args[0])
+	fmt.Printf("  %s --hostname esxi01.lab.example.com --insecure --domain lab.example.com --email admin@example.com \\\n", os.Args[0])
 	fmt.Printf("    --esxi-user root --esxi-pass password\n")
 	fmt.Println("")
 	fmt.Printf("  # Using a configuration file\n")
 	fmt.Printf("  %s --config /path/to/config.json\n", os.Args[0])
 	fmt.Println("")
 	fmt.Printf("  # Check and renew with explicit AWS credentials\n")
-	fmt.Printf("  %s --hostname esxi01.lab.example.com --domain lab.example.com --email admin@example.com \\\n", os.Args[0])
+	fmt.Printf("  %s --hostname esxi01.lab.example.com --insecure --domain lab.example.com --email admin@example.com \\\n", os.Args[0])
 	fmt.Printf("    --esxi-user root --esxi-pass password --aws-key-id AKIAXXXXXXXX --aws-secret-key xxxxxxxx\n")
 	fmt.Println("")
 	fmt.Printf("  # With temporary credentials (session token)\n")
-	fmt.Printf("  %s --hostname esxi01.lab.example.com --domain lab.example.com --email admin@example.com \\\n", os.Args[0])
+	fmt.Printf("  %s --hostname esxi01.lab.example.com --insecure --domain lab.example.com --email admin@example.com \\\n", os.Args[0])
 	fmt.Printf("    --esxi-user root --esxi-pass password --aws-key-id ASIAXXXXXXXX --aws-secret-key xxxxxxxx \\\n")
 	fmt.Printf("    --aws-session-token xxxxxxxx\n")
 	fmt.Println("")
 	fmt.Printf("  # With custom threshold, log file, and debug logging\n")
-	fmt.Printf("  %s --hostname esxi01.lab.example.com --domain lab.example.com --email admin@example.com \\\n", os.Args[0])
+	fmt.Printf("  %s --hostname esxi01.lab.example.com --insecure --domain lab.example.com --email admin@example.com \\\n", os.Args[0])
 	fmt.Printf("    --esxi-user root --esxi-pass password --threshold 0.5 --log /var/log/esxi-cert.log --log-level DEBUG\n")
 	fmt.Println("")
+	fmt.Printf("  # Verify DNS/AWS setup by ordering a certificate from Let's Encrypt staging (no ESXi upload)\n")
+	fmt.Printf("  %s --hostname esxi01.lab.example.com --domain lab.example.com --email admin@example.com --test-issuance\n", os.Args[0])
+	fmt.Println("")
+	fmt.Printf("  # Email a success/failure report after each run\n")
+	fmt.Printf("  %s --config /path/to/config.json --smtp-host smtp.example.com --smtp-user reports --smtp-pass xxxxxxxx \\\n", os.Args[0])
+	fmt.Printf("    --mail-from esxi-cert@example.com --mail-to ops@example.com\n")
+	fmt.Println("")
 	fmt.Printf("  # Force certificate renewal regardless of expiration\n")
-	fmt.Printf("  %s --hostname esxi01.lab.example.com --domain lab.example.com --email admin@example.com \\\n", os.Args[0])
+	fmt.Printf("  %s --hostname esxi01.lab.example.com --insecure --domain lab.example.com --email admin@example.com \\\n", os.Args[0])
 	fmt.Printf("    --esxi-user root --esxi-pass password --force\n")
 	fmt.Println("")
 	fmt.Printf("Configuration File:\n")
@@ -202,6 +738,10 @@ func printHelp() {
 	fmt.Printf("    \"update_check_repo\": \"lab-update-esxi-cert\"\n")
 	fmt.Printf("  }\n")
 	fmt.Println("")
+	fmt.Printf("  To manage several hosts, add a \"hosts\" array. Each entry needs a \"hostname\" and may override\n")
+	fmt.Printf("  \"threshold\", \"key_size\", \"esxi_username\", \"esxi_password\", and \"esxi_totp_secret\":\n")
+	fmt.Printf("    \"hosts\": [{\"hostname\": \"esxi01.lab.example.com\"}, {\"hostname\": \"esxi02.lab.example.com\", \"threshold\": 0.5}]\n")
+	fmt.Println("")
 	fmt.Printf("AWS Credentials:\n")
 	fmt.Printf("  The tool supports multiple ways to provide AWS credentials for Route53 DNS validation:\n")
 	fmt.Printf("  1. Default credential chain (recommended): Omit --aws-key-id and --aws-secret-key flags\n")
@@ -214,4 +754,37 @@ func printHelp() {
 	fmt.Printf("3. Use ENV variables for credentials whenever possible to avoid exposing credentials in your terminal's history.\n")
 	fmt.Printf("4. Use --force to renew certificates regardless of expiration threshold (bypasses cache).\n")
 	fmt.Printf("5. Configuration can be specified via config file, environment variables, or command-line flags.\n")
+	fmt.Printf("6. Use --install-method soap-certmgr to install via the SOAP certificate manager without SSH (falls back to SSH on hosts older than 6.0).\n")
+	fmt.Printf("7. Hosts with SSH two-factor authentication: set --esxi-totp-secret so verification-code prompts are answered with a TOTP code.\n")
+	fmt.Printf("8. Use --test-issuance to exercise the full ACME order against Let's Encrypt staging; the certificate is discarded and ESXi is never contacted.\n")
+	fmt.Printf("9. Batch runs (\"hosts\" list) exit 0 when every host succeeds, 50 on partial failure, and 1 when all hosts fail.\n")
+	fmt.Printf("10. --reuse-key keeps the same private key across renewals, which suits key pinning but means a leaked key stays valid\n")
+	fmt.Printf("    for every future certificate. The default fresh key per renewal limits the impact of a compromise to one certificate.\n")
+	fmt.Printf("11. --must-staple is off by default: browsers hard-fail a Must-Staple certificate unless the server staples a valid OCSP\n")
+	fmt.Printf("    response, so only enable it once you have confirmed ESXi (rhttpproxy) staples OCSP.\n")
+	fmt.Printf("12. Renewal is skipped, before any order, when the installed certificate already matches (issuer of the cached certificate\n")
+	fmt.Printf("    or --expected-issuer, SANs, lifetime above threshold), avoiding needless orders and restarts on repeated --force runs.\n")
+	fmt.Printf("    Use --force-upload to always renew and upload.\n")
+	fmt.Printf("13. --challenge-type http-01 needs no Route53 access (--domain and AWS credentials are not used), but the CA must reach\n")
+	fmt.Printf("    this machine on port 80 for the hostname; use --http-challenge-port when port 80 is forwarded to another local port.\n")
+	fmt.Printf("14. --services deploys the certificate to several paths in one run, e.g.\n")
+	fmt.Printf("    --services '/etc/vmware/ssl/rui.crt=;/etc/vmware/ssl/vasa.crt=/etc/init.d/vvold restart' (an empty command uses the built-in restart).\n")
+	fmt.Printf("15. With --csr-file the CSR's names and extensions are used as-is; --key-file must hold the matching private key, and\n")
+	fmt.Printf("    --reuse-key and --must-staple do not apply.\n")
+	fmt.Printf("16. --post-renew-hook and --post-fail-hook run through the shell with ESXI_HOST, CERT_PATH, KEY_PATH, NEW_EXPIRY\n")
+	fmt.Printf("    and STATUS set; a failing hook is only a warning unless --strict-hooks is given.\n")
+	fmt.Printf("17. Connecting to a host requires --insecure (accept its certificate unverified, as for self-signed hosts) or\n")
+	fmt.Printf("    --ca-bundle (verify it against those roots).\n")
+
+	if updateMsg := updateCheck.Notification(updateCheckWait); updateMsg != "" {
+		fmt.Println("")
+		fmt.Println(updateMsg)
+	}
+}
+
+// updateCheckEnabledByEnv reports whether CHECK_UPDATES allows update checks before the
+// full configuration is loaded (help and version output)
+func updateCheckEnabledByEnv() bool {
+	enabled, err := strconv.ParseBool(os.Getenv("CHECK_UPDATES"))
+	return err != nil || enabled
 }
@@ -18,6 +18,7 @@ func TestParseArgs_ValidConfiguration(t *testing.T) {
 	os.Args = []string{
 		"test-program",
 		"-hostname", "test.example.com",
+		"-insecure",
 		"-domain", "example.com",
 		"-email", "test@example.com",
 		"-aws-key-id", "AKIATEST123",
@@ -145,6 +146,7 @@ func TestParseArgs_PrecedenceOrder(t *testing.T) {
 		"test-program",
 		"-config", configFile,
 		"-hostname", "cmdline.example.com",
+		"-insecure",
 		"-aws-key-id", "AKIATEST123",
 		"-aws-secret-key", "test-secret",
 		"-esxi-user", "root",
@@ -176,22 +178,22 @@ func TestParseArgs_InvalidConfigurations(t *testing.T) {
 		},
 		{
 			name:       "missing AWS credentials",
-			args:       []string{"test-program", "-hostname", "test.example.com", "-domain", "example.com", "-email", "test@example.com"},
+			args:       []string{"test-program", "-hostname", "test.example.com", "-insecure", "-domain", "example.com", "-email", "test@example.com"},
 			shouldFail: true,
 		},
 		{
 			name:       "dry-run and force together",
-			args:       []string{"test-program", "-hostname", "test.example.com", "-aws-key-id", "key", "-aws-secret-key", "secret", "-dry-run", "-force"},
+			args:       []string{"test-program", "-hostname", "test.example.com", "-insecure", "-aws-key-id", "key", "-aws-secret-key", "secret", "-dry-run", "-force"},
 			shouldFail: true,
 		},
 		{
 			name:       "invalid key size",
-			args:       []string{"test-program", "-hostname", "test.example.com", "-aws-key-id", "key", "-aws-secret-key", "secret", "-key-size", "1024"},
+			args:       []string{"test-program", "-hostname", "test.example.com", "-insecure", "-aws-key-id", "key", "-aws-secret-key", "secret", "-key-size", "1024"},
 			shouldFail: true,
 		},
 		{
 			name:       "invalid threshold",
-			args:       []string{"test-program", "-hostname", "test.example.com", "-aws-key-id", "key", "-aws-secret-key", "secret", "-threshold", "1.5"},
+			args:       []string{"test-program", "-hostname", "test.example.com", "-insecure", "-aws-key-id", "key", "-aws-secret-key", "secret", "-threshold", "1.5"},
 			shouldFail: true,
 		},
 	}
@@ -223,6 +225,7 @@ func TestParseArgs_DryRunMode(t *testing.T) {
 	os.Args = []string{
 		"test-program",
 		"-hostname", "test.example.com",
+		"-insecure",
 		"-aws-key-id", "AKIATEST123",
 		"-aws-secret-key", "test-secret",
 		"-dry-run",
@@ -251,6 +254,7 @@ func TestParseArgs_ForceMode(t *testing.T) {
 	os.Args = []string{
 		"test-program",
 		"-hostname", "test.example.com",
+		"-insecure",
 		"-domain", "example.com",
 		"-email", "test@example.com",
 		"-aws-key-id", "AKIATEST123",
@@ -278,6 +282,7 @@ func TestParseArgs_CustomThresholdAndKeySize(t *testing.T) {
 	os.Args = []string{
 		"test-program",
 		"-hostname", "test.example.com",
+		"-insecure",
 		"-domain", "example.com",
 		"-email", "test@example.com",
 		"-aws-key-id", "AKIATEST123",
@@ -309,6 +314,7 @@ func TestParseArgs_LoggingOptions(t *testing.T) {
 	os.Args = []string{
 		"test-program",
 		"-hostname", "test.example.com",
+		"-insecure",
 		"-aws-key-id", "AKIATEST123",
 		"-aws-secret-key", "test-secret",
 		"-log", "/tmp/test.log",
@@ -337,6 +343,7 @@ func TestParseArgs_AWSSessionToken(t *testing.T) {
 	os.Args = []string{
 		"test-program",
 		"-hostname", "test.example.com",
+		"-insecure",
 		"-domain", "example.com",
 		"-email", "test@example.com",
 		"-aws-key-id", "ASIATEST123",
@@ -361,6 +368,36 @@ func TestParseArgs_AWSSessionToken(t *testing.T) {
 	}
 }
 
+func TestParseArgs_ACMEProfile(t *testing.T) {
+	baseArgs := []string{
+		"test-program",
+		"-hostname", "test.example.com",
+		"-insecure",
+		"-domain", "example.com",
+		"-email", "test@example.com",
+		"-esxi-user", "root",
+		"-esxi-pass", "password",
+	}
+	oldArgs := os.Args
+	defer func() { os.Args = oldArgs }()
+
+	resetFlags()
+	os.Args = append(append([]string{}, baseArgs...), "-acme-profile", "shortlived")
+	config, err := parseArgs()
+	if err != nil {
+		t.Fatalf("Expected ACME profile to be valid, got error: %v", err)
+	}
+	if config.ACMEProfile != "shortlived" {
+		t.Errorf("Expected ACME profile shortlived, got %q", config.ACMEProfile)
+	}
+
+	resetFlags()
+	os.Args = append(append([]string{}, baseArgs...), "-acme-profile", "")
+	if _, err := parseArgs(); err == nil || !strings.Contains(err.Error(), "acme-profile must not be empty") {
+		t.Errorf("Expected empty ACME profile to be rejected, got: %v", err)
+	}
+}
+
 func TestParseArgs_MalformedConfigFile(t *testing.T) {
 	resetFlags()
 
@@ -392,6 +429,7 @@ func TestParseArgs_NonexistentConfigFile(t *testing.T) {
 		"test-program",
 		"-config", "/nonexistent/config.json",
 		"-hostname", "test.example.com",
+		"-insecure",
 		"-aws-key-id", "AKIATEST123",
 		"-aws-secret-key", "test-secret",
 		"-dry-run",
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// shellQuote quotes s as a single word for the host's POSIX shell. Words made only of
+// characters the shell never interprets are returned unchanged, so the usual paths stay readable.
+func shellQuote(s string) string {
+	if s != "" && strings.IndexFunc(s, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("@%+=:,./_-", r))
+	}) < 0 {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'"'"'`) + "'"
+}
+
+// Get the command that writes a remote file from the SSH session's stdin
+func writeFileCommand(remotePath string) string {
+	return fmt.Sprintf("cat > %s", shellQuote(remotePath))
+}
+
+// Get the command that reads a remote file back for verification
+func readFileCommand(remotePath string) string {
+	return fmt.Sprintf("cat %s", shellQuote(remotePath))
+}
+
+// sshInstallCommands returns every command the SSH install runs, in order, built from the
+// same helpers performSSHCertificateInstallation uses. esxiVersion selects the built-in
+// restart sequence and may be empty when the version is not known.
+func sshInstallCommands(config Config, esxiVersion string) []string {
+	var commands []string
+	if config.PreuploadCheckCmd != "" {
+		commands = append(commands, config.PreuploadCheckCmd)
+	}
+
+	targets := serviceTargets(config)
+	for _, target := range targets {
+		commands = append(commands, fileStateCommand(target.CertPath), fileStateCommand(target.ResolvedKeyPath()))
+		commands = append(commands, backupCommands(target)...)
+		commands = append(commands, writeFileCommand(target.CertPath), writeFileCommand(target.ResolvedKeyPath()))
+		commands = append(commands, permissionCommands(target)...)
+		commands = append(commands, readFileCommand(target.CertPath))
+	}
+
+	restartCommands, useBuiltin := serviceRestartCommands(targets)
+	if useBuiltin && config.ReloadMethod == reloadMethodReload {
+		commands = append(commands, reloadCommandsForVersion(esxiVersion)...)
+	} else if useBuiltin {
+		commands = append(commands, restartCommandsForVersion(esxiVersion)...)
+	}
+	return append(commands, restartCommands...)
+}
+
+// printInstallCommands writes the SSH install plan for -print-commands without connecting
+// to the host. Steps that go through the SOAP API rather than SSH are shown as comments.
+func printInstallCommands(w io.Writer, config Config) {
+	fmt.Fprintf(w, "# Install commands for %s (%s)\n", config.Hostname, esxiSSHAddress(config))
+	if config.InstallMethod == installMethodSOAPCertMgr {
+		fmt.Fprintf(w, "# Install method %s uploads through the SOAP API; these SSH commands only run if it falls back to SSH\n", installMethodSOAPCertMgr)
+	}
+
+	manageService := !config.NoServiceManagement && config.TargetType != targetTypeVCSA
+	if manageService {
+		fmt.Fprintln(w, "# SOAP: start the TSM-SSH service if it is not running")
+	}
+
+	if _, useBuiltin := serviceRestartCommands(serviceTargets(config)); useBuiltin && config.ReloadMethod == reloadMethodReload {
+		fmt.Fprintln(w, "# The built-in reload below is for ESXi 7.x and earlier; ESXi 8.x and later reload rhttpproxy before hostd")
+		fmt.Fprintln(w, "# If the host does not serve the new certificate after the reload, the built-in restart runs instead")
+	} else if useBuiltin {
+		fmt.Fprintln(w, "# The built-in restart below is for ESXi 7.x and earlier; ESXi 8.x and later run /etc/init.d/rhttpproxy restart before hostd")
+	}
+	for _, cmd := range sshInstallCommands(config, "") {
+		fmt.Fprintln(w, cmd)
+	}
+
+	if manageService {
+		fmt.Fprintln(w, "# SOAP: stop the TSM-SSH service")
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSSHInstallCommands(t *testing.T) {
+	t.Run("default destination with built-in restart", func(t *testing.T) {
+		commands := sshInstallCommands(Config{}, "8.0.2")
+		expected := []string{
+			"if [ -f /etc/vmware/ssl/rui.crt ]; then wc -c < /etc/vmware/ssl/rui.crt; else echo absent; fi",
+			"if [ -f /etc/vmware/ssl/rui.key ]; then wc -c < /etc/vmware/ssl/rui.key; else echo absent; fi",
+			"cp -f /etc/vmware/ssl/rui.crt /etc/vmware/ssl/rui.crt.backup 2>/dev/null || true",
+			"cp -f /etc/vmware/ssl/rui.key /etc/vmware/ssl/rui.key.backup 2>/dev/null || true",
+			"ls -la /etc/vmware/ssl/rui.crt /etc/vmware/ssl/rui.key",
+			"cat > /etc/vmware/ssl/rui.crt",
+			"cat > /etc/vmware/ssl/rui.key",
+			"chmod 644 /etc/vmware/ssl/rui.crt",
+			"chmod 600 /etc/vmware/ssl/rui.key",
+			"chown root:root /etc/vmware/ssl/rui.crt /etc/vmware/ssl/rui.key",
+			"cat /etc/vmware/ssl/rui.crt",
+			"/etc/init.d/rhttpproxy restart",
+			"/etc/init.d/hostd restart",
+			"/etc/init.d/vpxa restart",
+		}
+		if !reflect.DeepEqual(commands, expected) {
+			t.Errorf("Unexpected commands:\n%s", strings.Join(commands, "\n"))
+		}
+	})
+
+	t.Run("pre-upload check and custom restart", func(t *testing.T) {
+		config := Config{
+			PreuploadCheckCmd: "test -d /etc/vmware/ssl",
+			Services:          []ServiceTarget{{CertPath: "/etc/vmware/ssl/vasa.crt", RestartCommand: "/etc/init.d/vvold restart"}},
+		}
+		commands := sshInstallCommands(config, "")
+		if commands[0] != "test -d /etc/vmware/ssl" {
+			t.Errorf("Expected pre-upload check first, got %s", commands[0])
+		}
+		if last := commands[len(commands)-1]; last != "/etc/init.d/vvold restart" {
+			t.Errorf("Expected custom restart last, got %s", last)
+		}
+		for _, cmd := range commands {
+			if strings.Contains(cmd, "hostd") {
+				t.Errorf("Did not expect built-in restart, got %s", cmd)
+			}
+		}
+	})
+
+	t.Run("paths with shell metacharacters are quoted", func(t *testing.T) {
+		config := Config{Services: []ServiceTarget{{CertPath: "/opt/my certs/it's.crt", RestartCommand: "true"}}}
+		commands := sshInstallCommands(config, "")
+		for _, want := range []string{
+			`cat > '/opt/my certs/it'"'"'s.crt'`,
+			`cp -f '/opt/my certs/it'"'"'s.key' '/opt/my certs/it'"'"'s.key.backup' 2>/dev/null || true`,
+			`chown root:root '/opt/my certs/it'"'"'s.crt' '/opt/my certs/it'"'"'s.key'`,
+		} {
+			if !slices.Contains(commands, want) {
+				t.Errorf("Expected command %s, got:\n%s", want, strings.Join(commands, "\n"))
+			}
+		}
+	})
+}
+
+func TestShellQuote(t *testing.T) {
+	tests := map[string]string{
+		"/etc/vmware/ssl/rui.crt": "/etc/vmware/ssl/rui.crt",
+		"":                        "''",
+		"/tmp/a b":                "'/tmp/a b'",
+		"/tmp/$(reboot)":          "'/tmp/$(reboot)'",
+		"it's":                    `'it'"'"'s'`,
+	}
+	for in, want := range tests {
+		if got := shellQuote(in); got != want {
+			t.Errorf("shellQuote(%q) = %s, want %s", in, got, want)
+		}
+	}
+}
+
+func TestPrintInstallCommands(t *testing.T) {
+	var buf bytes.Buffer
+	printInstallCommands(&buf, Config{Hostname: "esxi.lab.example.com"})
+	output := buf.String()
+
+	for _, want := range []string{"# SOAP: start the TSM-SSH service", "cat > /etc/vmware/ssl/rui.key", "/etc/init.d/hostd restart", "# SOAP: stop the TSM-SSH service"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("Expected output to contain %q, got:\n%s", want, output)
+		}
+	}
+
+	buf.Reset()
+	printInstallCommands(&buf, Config{Hostname: "vcsa.lab.example.com", TargetType: targetTypeVCSA})
+	if strings.Contains(buf.String(), "TSM-SSH") {
+		t.Errorf("Did not expect service management on a vCenter appliance, got:\n%s", buf.String())
+	}
+	if !strings.Contains(buf.String(), vcsaRestartCommand) {
+		t.Errorf("Expected service-control restart, got:\n%s", buf.String())
+	}
+}
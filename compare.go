@@ -0,0 +1,115 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// Hosts renewed in the same cycle expire within this much of each other
+const compareExpiryTolerance = 24 * time.Hour
+
+// CertificateDifference is one field on which two hosts' certificates disagree
+type CertificateDifference struct {
+	Field string
+	A, B  string
+}
+
+// publicKeyDescription describes a certificate's public key, e.g. "RSA 4096" or "ECDSA P-256"
+func publicKeyDescription(cert *x509.Certificate) string {
+	switch key := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return fmt.Sprintf("RSA %d", key.N.BitLen())
+	case *ecdsa.PublicKey:
+		return "ECDSA " + key.Curve.Params().Name
+	default:
+		return cert.PublicKeyAlgorithm.String()
+	}
+}
+
+// compareCertificates lists the differences in issuer, SANs, key type, and expiry
+func compareCertificates(a, b *x509.Certificate) []CertificateDifference {
+	var diffs []CertificateDifference
+	add := func(field, valueA, valueB string) {
+		if valueA != valueB {
+			diffs = append(diffs, CertificateDifference{Field: field, A: valueA, B: valueB})
+		}
+	}
+
+	add("issuer", a.Issuer.String(), b.Issuer.String())
+	add("SANs", strings.Join(certificateSANs(a), ", "), strings.Join(certificateSANs(b), ", "))
+	add("key type", publicKeyDescription(a), publicKeyDescription(b))
+
+	gap := a.NotAfter.Sub(b.NotAfter)
+	if gap < 0 {
+		gap = -gap
+	}
+	if gap > compareExpiryTolerance {
+		diffs = append(diffs, CertificateDifference{Field: "expiry", A: a.NotAfter.Format(time.RFC3339), B: b.NotAfter.Format(time.RFC3339)})
+	}
+	return diffs
+}
+
+// parseCompareHosts splits the -compare argument into exactly two hostnames
+func parseCompareHosts(arg string) (string, string, error) {
+	hosts := strings.Split(arg, ",")
+	if len(hosts) != 2 || strings.TrimSpace(hosts[0]) == "" || strings.TrimSpace(hosts[1]) == "" {
+		return "", "", fmt.Errorf("compare needs exactly two hosts, e.g. -compare esxi01,esxi02")
+	}
+	return strings.TrimSpace(hosts[0]), strings.TrimSpace(hosts[1]), nil
+}
+
+// compareHosts fetches both hosts' certificates over TLS (read-only) and writes a report of
+// their differences, returning how many were found
+func compareHosts(w io.Writer, hostA, hostB string, dialer TLSDialer) (int, error) {
+	certs := make([]*x509.Certificate, 2)
+	for i, host := range []string{hostA, hostB} {
+		_, cert, err := checkCertificateWithDialer(host, defaultThreshold, dialer)
+		if err != nil {
+			return 0, err
+		}
+		certs[i] = cert
+	}
+
+	diffs := compareCertificates(certs[0], certs[1])
+	if len(diffs) == 0 {
+		fmt.Fprintf(w, "Certificates on %s and %s match (issuer, SANs, key type, and expiry within %s)\n", hostA, hostB, compareExpiryTolerance)
+		return 0, nil
+	}
+
+	fmt.Fprintf(w, "Certificates on %s and %s differ:\n", hostA, hostB)
+	for _, diff := range diffs {
+		fmt.Fprintf(w, "  %s:\n    %s: %s\n    %s: %s\n", diff.Field, hostA, diff.A, hostB, diff.B)
+	}
+	return len(diffs), nil
+}
+
+// Compare two hosts' certificates, print the report, and exit non-zero on any difference
+func runCompare(arg string, insecure bool, caBundle string) {
+	hostA, hostB, err := parseCompareHosts(arg)
+	if err == nil && !insecure && caBundle == "" {
+		err = fmt.Errorf("compare requires -insecure to accept the hosts' certificates without verification or -ca-bundle to verify them")
+	}
+	if err == nil {
+		err = configureHostTrust(Config{Insecure: insecure, CABundle: caBundle})
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitCodeFailure)
+	}
+
+	diffs, err := compareHosts(os.Stdout, hostA, hostB, &DefaultTLSDialer{})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error comparing certificates: %v\n", err)
+		os.Exit(exitCodeFailure)
+	}
+	if diffs > 0 {
+		os.Exit(exitCodeFailure)
+	}
+	os.Exit(0)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"testing"
+	"time"
+)
+
+func TestParseCompareHosts(t *testing.T) {
+	a, b, err := parseCompareHosts("esxi01.lab.example.com, esxi02.lab.example.com")
+	if err != nil || a != "esxi01.lab.example.com" || b != "esxi02.lab.example.com" {
+		t.Errorf("Unexpected result: %q, %q, %v", a, b, err)
+	}
+
+	for _, arg := range []string{"esxi01", "esxi01,", "esxi01,esxi02,esxi03"} {
+		if _, _, err := parseCompareHosts(arg); err == nil {
+			t.Errorf("Expected error for %q", arg)
+		}
+	}
+}
+
+func TestCompareCertificates(t *testing.T) {
+	now := time.Now()
+	root := issueTestCertificate(t, "Test Root", true, now.Add(10*365*24*time.Hour), nil)
+	otherRoot := issueTestCertificate(t, "Other Root", true, now.Add(10*365*24*time.Hour), nil)
+	expiry := now.Add(90 * 24 * time.Hour)
+
+	fields := func(diffs []CertificateDifference) map[string]bool {
+		found := make(map[string]bool)
+		for _, diff := range diffs {
+			found[diff.Field] = true
+		}
+		return found
+	}
+
+	t.Run("same renewal cycle", func(t *testing.T) {
+		a := issueTestCertificate(t, "esxi.lab.example.com", false, expiry, root)
+		b := issueTestCertificate(t, "esxi.lab.example.com", false, expiry.Add(10*time.Minute), root)
+		if diffs := compareCertificates(a.cert, b.cert); len(diffs) != 0 {
+			t.Errorf("Expected no differences, got %+v", diffs)
+		}
+	})
+
+	t.Run("drifted host", func(t *testing.T) {
+		a := issueTestCertificate(t, "esxi01.lab.example.com", false, expiry, root)
+		b := issueTestCertificate(t, "esxi02.lab.example.com", false, now.Add(10*24*time.Hour), otherRoot)
+		found := fields(compareCertificates(a.cert, b.cert))
+		for _, field := range []string{"issuer", "SANs", "expiry"} {
+			if !found[field] {
+				t.Errorf("Expected a %s difference, got %v", field, found)
+			}
+		}
+		if found["key type"] {
+			t.Error("Did not expect a key type difference between two P-256 keys")
+		}
+	})
+}
+
+func TestPublicKeyDescription(t *testing.T) {
+	ecCert := issueTestCertificate(t, "esxi01.lab.example.com", false, time.Now().Add(time.Hour), nil)
+	if desc := publicKeyDescription(ecCert.cert); desc != "ECDSA P-256" {
+		t.Errorf("Expected ECDSA P-256, got %s", desc)
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if desc := publicKeyDescription(&x509.Certificate{PublicKey: &key.PublicKey}); desc != "RSA 2048" {
+		t.Errorf("Expected RSA 2048, got %s", desc)
+	}
+}
@@ -3,10 +3,20 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"io"
+	"net"
+	"net/mail"
+	"net/url"
 	"os"
 	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/pquerna/otp/totp"
 )
 
 // ConfigSource represents the source of a configuration value
@@ -90,6 +100,16 @@ func (cm *ConfigManager) GetInt(key string) int {
 	return 0
 }
 
+// GetDuration gets a time.Duration configuration value
+func (cm *ConfigManager) GetDuration(key string) time.Duration {
+	if val, exists := cm.Get(key); exists {
+		if d, ok := val.(time.Duration); ok {
+			return d
+		}
+	}
+	return 0
+}
+
 // GetSource gets the source of a configuration value
 func (cm *ConfigManager) GetSource(key string) ConfigSource {
 	if val, exists := cm.values[key]; exists {
@@ -101,55 +121,204 @@ func (cm *ConfigManager) GetSource(key string) ConfigSource {
 // LoadDefaults loads default configuration values
 func (cm *ConfigManager) LoadDefaults() {
 	cm.Set("threshold", defaultThreshold, ConfigSourceDefault)
+	cm.Set("cache_reuse_threshold", defaultCacheReuseThreshold, ConfigSourceDefault)
 	cm.Set("key_size", 4096, ConfigSourceDefault)
+	cm.Set("key_type", "", ConfigSourceDefault)
+	cm.Set("account_key_type", "", ConfigSourceDefault)
 	cm.Set("log_level", "INFO", ConfigSourceDefault)
 	cm.Set("aws_region", "us-east-1", ConfigSourceDefault)
 	cm.Set("dry_run", false, ConfigSourceDefault)
+	cm.Set("print_commands", false, ConfigSourceDefault)
 	cm.Set("force", false, ConfigSourceDefault)
-	cm.Set("check_updates", false, ConfigSourceDefault)
+	cm.Set("check_updates", true, ConfigSourceDefault)
 	cm.Set("update_check_owner", "", ConfigSourceDefault)
 	cm.Set("update_check_repo", "", ConfigSourceDefault)
+	cm.Set("ssh_stop_timeout", defaultSSHStopTimeout, ConfigSourceDefault)
+	cm.Set("install_method", installMethodSSH, ConfigSourceDefault)
+	cm.Set("target_type", targetTypeESXi, ConfigSourceDefault)
+	cm.Set("reload_method", reloadMethodRestart, ConfigSourceDefault)
+	cm.Set("cache_lock_timeout", defaultCacheLockTimeout, ConfigSourceDefault)
+	cm.Set("max_renewals", defaultMaxRenewals, ConfigSourceDefault)
+	cm.Set("min_remaining_days", defaultMinRemainingDays, ConfigSourceDefault)
+	cm.Set("renewal_window", defaultRenewalWindow, ConfigSourceDefault)
+	cm.Set("smtp_port", 587, ConfigSourceDefault)
+	cm.Set("esxi_ssh_port", defaultESXiSSHPort, ConfigSourceDefault)
+	cm.Set("esxi_https_port", defaultESXiHTTPSPort, ConfigSourceDefault)
+	cm.Set("soap_connect_retries", defaultSOAPConnectRetries, ConfigSourceDefault)
+	cm.Set("route53_max_retries", defaultRoute53MaxRetries, ConfigSourceDefault)
+	cm.Set("dns_ttl", defaultDNSTTL, ConfigSourceDefault)
+	cm.Set("dns_propagation_wait", time.Duration(0), ConfigSourceDefault)
+	cm.Set("dns_skip_propagation", false, ConfigSourceDefault)
+	cm.Set("dns_preflight", false, ConfigSourceDefault)
+	cm.Set("validate_interval", defaultCheckFirstInterval, ConfigSourceDefault)
+	cm.Set("validate_max_interval", defaultCheckInterval, ConfigSourceDefault)
+	cm.Set("aws_timeout", time.Duration(0), ConfigSourceDefault)
+	cm.Set("soap_keepalive", time.Duration(0), ConfigSourceDefault)
+	cm.Set("upload_lock_wait", time.Duration(0), ConfigSourceDefault)
+	cm.Set("test_issuance", false, ConfigSourceDefault)
+	cm.Set("fail_fast", false, ConfigSourceDefault)
+	cm.Set("reuse_key", false, ConfigSourceDefault)
+	cm.Set("must_staple", false, ConfigSourceDefault)
+	cm.Set("expand_env", false, ConfigSourceDefault)
+	cm.Set("chain_mode", chainModeFull, ConfigSourceDefault)
+	cm.Set("output_layout", outputLayoutFlat, ConfigSourceDefault)
+	cm.Set("force_upload", false, ConfigSourceDefault)
+	cm.Set("no_service_management", false, ConfigSourceDefault)
+	cm.Set("check_reachable", false, ConfigSourceDefault)
+	cm.Set("ip_version", ipVersionAuto, ConfigSourceDefault)
+	cm.Set("timing", false, ConfigSourceDefault)
+	cm.Set("explain", false, ConfigSourceDefault)
+	cm.Set("strict_hooks", false, ConfigSourceDefault)
+	cm.Set("strict_distribution", false, ConfigSourceDefault)
+	cm.Set("require_validation", false, ConfigSourceDefault)
+	cm.Set("verify_cert_file", false, ConfigSourceDefault)
+	cm.Set("i_know_what_im_doing", false, ConfigSourceDefault)
+	cm.Set("check_chain", false, ConfigSourceDefault)
+	cm.Set("insecure", false, ConfigSourceDefault)
+	cm.Set("verify_trust", false, ConfigSourceDefault)
+	cm.Set("quiet", false, ConfigSourceDefault)
+	cm.Set("stdout_only", false, ConfigSourceDefault)
+	cm.Set("log_syslog", false, ConfigSourceDefault)
+	cm.Set("syslog_facility", "user", ConfigSourceDefault)
+	cm.Set("csr_file", "", ConfigSourceDefault)
+	cm.Set("key_file", "", ConfigSourceDefault)
+	cm.Set("challenge_type", challengeTypeDNS01, ConfigSourceDefault)
+	cm.Set("http_challenge_port", defaultHTTPChallengePort, ConfigSourceDefault)
 }
 
 // LoadEnvironmentVariables loads configuration from environment variables
 func (cm *ConfigManager) LoadEnvironmentVariables() {
 	envMappings := map[string]string{
-		"hostname":           "ESXI_HOSTNAME",
-		"domain":             "AWS_ROUTE53_DOMAIN",
-		"email":              "EMAIL",
-		"threshold":          "CERT_THRESHOLD",
-		"log_file":           "LOG_FILE",
-		"log_level":          "LOG_LEVEL",
-		"aws_key_id":         "AWS_ACCESS_KEY_ID",
-		"aws_secret_key":     "AWS_SECRET_ACCESS_KEY",
-		"aws_session_token":  "AWS_SESSION_TOKEN",
-		"aws_region":         "AWS_REGION",
-		"dry_run":            "DRY_RUN",
-		"force":              "FORCE_RENEWAL",
-		"key_size":           "CERT_KEY_SIZE",
-		"esxi_username":      "ESXI_USERNAME",
-		"esxi_password":      "ESXI_PASSWORD",
-		"check_updates":      "CHECK_UPDATES",
-		"update_check_owner": "UPDATE_CHECK_OWNER",
-		"update_check_repo":  "UPDATE_CHECK_REPO",
+		"hostname":              "ESXI_HOSTNAME",
+		"domain":                "AWS_ROUTE53_DOMAIN",
+		"email":                 "EMAIL",
+		"acme_contacts":         "ACME_CONTACTS",
+		"acme_user_agent":       "ACME_USER_AGENT",
+		"acme_profile":          "ACME_PROFILE",
+		"preferred_chain":       "PREFERRED_CHAIN",
+		"threshold":             "CERT_THRESHOLD",
+		"cache_reuse_threshold": "CACHE_REUSE_THRESHOLD",
+		"log_file":              "LOG_FILE",
+		"log_level":             "LOG_LEVEL",
+		"aws_key_id":            "AWS_ACCESS_KEY_ID",
+		"aws_secret_key":        "AWS_SECRET_ACCESS_KEY",
+		"aws_session_token":     "AWS_SESSION_TOKEN",
+		"aws_region":            "AWS_REGION",
+		"dry_run":               "DRY_RUN",
+		"print_commands":        "PRINT_COMMANDS",
+		"force":                 "FORCE_RENEWAL",
+		"key_size":              "CERT_KEY_SIZE",
+		"key_type":              "CERT_KEY_TYPE",
+		"account_key_type":      "ACCOUNT_KEY_TYPE",
+		"esxi_username":         "ESXI_USERNAME",
+		"esxi_password":         "ESXI_PASSWORD",
+		"esxi_totp_secret":      "ESXI_TOTP_SECRET",
+		"check_updates":         "CHECK_UPDATES",
+		"update_check_owner":    "UPDATE_CHECK_OWNER",
+		"update_check_repo":     "UPDATE_CHECK_REPO",
+		"ssh_stop_timeout":      "SSH_STOP_TIMEOUT",
+		"install_method":        "INSTALL_METHOD",
+		"target_type":           "TARGET_TYPE",
+		"no_service_management": "NO_SERVICE_MANAGEMENT",
+		"cache_lock_timeout":    "CACHE_LOCK_TIMEOUT",
+		"max_renewals":          "MAX_RENEWALS",
+		"min_issuance_interval": "MIN_ISSUANCE_INTERVAL",
+		"min_remaining_days":    "MIN_REMAINING_AFTER_RENEW_DAYS",
+		"renewal_window":        "RENEWAL_WINDOW",
+		"smtp_host":             "SMTP_HOST",
+		"smtp_port":             "SMTP_PORT",
+		"esxi_ssh_port":         "ESXI_SSH_PORT",
+		"esxi_https_port":       "ESXI_HTTPS_PORT",
+		"soap_connect_retries":  "SOAP_CONNECT_RETRIES",
+		"soap_keepalive":        "SOAP_KEEPALIVE",
+		"smtp_username":         "SMTP_USERNAME",
+		"smtp_password":         "SMTP_PASSWORD",
+		"mail_from":             "MAIL_FROM",
+		"mail_to":               "MAIL_TO",
+		"test_issuance":         "TEST_ISSUANCE",
+		"fail_fast":             "FAIL_FAST",
+		"reuse_key":             "REUSE_KEY",
+		"must_staple":           "MUST_STAPLE",
+		"ext_key_usages":        "EXT_KEY_USAGES",
+		"aws_endpoint":          "AWS_ENDPOINT_URL",
+		"route53_zone_id":       "ROUTE53_ZONE_ID",
+		"route53_profile":       "ROUTE53_PROFILE",
+		"route53_max_retries":   "ROUTE53_MAX_RETRIES",
+		"dns_ttl":               "DNS_TTL",
+		"dns_propagation_wait":  "DNS_PROPAGATION_WAIT",
+		"dns_skip_propagation":  "DNS_SKIP_PROPAGATION",
+		"dns_preflight":         "DNS_PREFLIGHT",
+		"validate_attempts":     "VALIDATE_ATTEMPTS",
+		"validate_interval":     "VALIDATE_INTERVAL",
+		"validate_max_interval": "VALIDATE_MAX_INTERVAL",
+		"aws_timeout":           "AWS_TIMEOUT",
+		"aws_assume_role_arn":   "AWS_ASSUME_ROLE_ARN",
+		"challenge_type":        "CHALLENGE_TYPE",
+		"reload_method":         "RELOAD_METHOD",
+		"http_challenge_port":   "HTTP_CHALLENGE_PORT",
+		"check_reachable":       "CHECK_REACHABLE",
+		"ip_version":            "IP_VERSION",
+		"timing":                "TIMING",
+		"explain":               "EXPLAIN",
+		"post_renew_hook":       "POST_RENEW_HOOK",
+		"post_fail_hook":        "POST_FAIL_HOOK",
+		"preupload_check_cmd":   "PREUPLOAD_CHECK_CMD",
+		"upload_lock_wait":      "UPLOAD_LOCK_WAIT",
+		"strict_hooks":          "STRICT_HOOKS",
+		"check_chain":           "CHECK_CHAIN",
+		"insecure":              "INSECURE",
+		"verify_trust":          "VERIFY_TRUST",
+		"require_validation":    "REQUIRE_VALIDATION",
+		"verify_cert_file":      "VERIFY_CERT_FILE",
+		"protected_pattern":     "PROTECTED_PATTERN",
+		"renew_if_issuer_not":   "RENEW_IF_ISSUER_NOT",
+		"expected_issuers":      "EXPECTED_ISSUERS",
+		"labels":                "LABELS",
+		"pfx_output":            "PFX_OUTPUT",
+		"pfx_password":          "PFX_PASSWORD",
+		"pfx_file":              "PFX_FILE",
+		"output_dir":            "OUTPUT_DIR",
+		"output_layout":         "OUTPUT_LAYOUT",
+		"s3_upload_bucket":      "S3_UPLOAD_BUCKET",
+		"s3_prefix":             "S3_PREFIX",
+		"s3_region":             "S3_REGION",
+		"strict_distribution":   "STRICT_DISTRIBUTION",
+		"schedule":              "SCHEDULE",
+		"status_file":           "STATUS_FILE",
+		"healthz_addr":          "HEALTHZ_ADDR",
+		"ca_bundle":             "CA_BUNDLE",
+		"quiet":                 "QUIET",
+		"stdout_only":           "STDOUT_ONLY",
+		"log_syslog":            "LOG_SYSLOG",
+		"syslog_facility":       "SYSLOG_FACILITY",
+		"syslog_tag":            "SYSLOG_TAG",
+		"csr_file":              "CSR_FILE",
+		"key_file":              "KEY_FILE",
+		"force_upload":          "FORCE_UPLOAD",
+		"chain_mode":            "CHAIN_MODE",
+		"aws_external_id":       "AWS_EXTERNAL_ID",
 	}
 
 	for configKey, envVar := range envMappings {
 		if value := os.Getenv(envVar); value != "" {
 			// Type conversion based on the configuration key
 			switch configKey {
-			case "threshold":
+			case "threshold", "cache_reuse_threshold":
 				if f, err := strconv.ParseFloat(value, 64); err == nil {
 					cm.Set(configKey, f, ConfigSourceEnvVar)
 				}
-			case "key_size":
+			case "key_size", "smtp_port", "http_challenge_port", "max_renewals", "min_remaining_days", "esxi_ssh_port", "esxi_https_port", "soap_connect_retries", "route53_max_retries", "validate_attempts", "dns_ttl":
 				if i, err := strconv.Atoi(value); err == nil {
 					cm.Set(configKey, i, ConfigSourceEnvVar)
 				}
-			case "dry_run", "force", "check_updates":
+			case "dry_run", "print_commands", "force", "check_updates", "test_issuance", "fail_fast", "reuse_key", "must_staple", "force_upload", "check_reachable", "timing", "explain", "strict_hooks", "strict_distribution", "check_chain", "insecure", "verify_trust", "require_validation", "verify_cert_file", "dns_skip_propagation", "dns_preflight", "quiet", "stdout_only", "log_syslog", "no_service_management":
 				if b, err := strconv.ParseBool(value); err == nil {
 					cm.Set(configKey, b, ConfigSourceEnvVar)
 				}
+			case "ssh_stop_timeout", "cache_lock_timeout", "renewal_window", "soap_keepalive", "upload_lock_wait", "aws_timeout", "dns_propagation_wait", "min_issuance_interval", "validate_interval", "validate_max_interval":
+				if d, err := time.ParseDuration(value); err == nil {
+					cm.Set(configKey, d, ConfigSourceEnvVar)
+				}
 			default:
 				cm.Set(configKey, value, ConfigSourceEnvVar)
 			}
@@ -159,39 +328,162 @@ func (cm *ConfigManager) LoadEnvironmentVariables() {
 
 // ConfigFile represents the structure of a configuration file
 type ConfigFile struct {
-	Hostname         string  `json:"hostname,omitempty"`
-	Domain           string  `json:"domain,omitempty"`
-	Email            string  `json:"email,omitempty"`
-	Threshold        float64 `json:"threshold,omitempty"`
-	LogFile          string  `json:"log_file,omitempty"`
-	LogLevel         string  `json:"log_level,omitempty"`
-	AWSKeyID         string  `json:"aws_key_id,omitempty"`
-	AWSSecretKey     string  `json:"aws_secret_key,omitempty"`
-	AWSSessionToken  string  `json:"aws_session_token,omitempty"`
-	AWSRegion        string  `json:"aws_region,omitempty"`
-	DryRun           bool    `json:"dry_run,omitempty"`
-	Force            bool    `json:"force,omitempty"`
-	KeySize          int     `json:"key_size,omitempty"`
-	ESXiUsername     string  `json:"esxi_username,omitempty"`
-	ESXiPassword     string  `json:"esxi_password,omitempty"`
-	CheckUpdates     bool    `json:"check_updates,omitempty"`
-	UpdateCheckOwner string  `json:"update_check_owner,omitempty"`
-	UpdateCheckRepo  string  `json:"update_check_repo,omitempty"`
+	Hostname            string          `json:"hostname,omitempty"`
+	Domain              string          `json:"domain,omitempty"`
+	Email               string          `json:"email,omitempty"`
+	ACMEContacts        []string        `json:"acme_contacts,omitempty"`
+	ACMEUserAgent       string          `json:"acme_user_agent,omitempty"`
+	ACMEProfile         string          `json:"acme_profile,omitempty"`
+	PreferredChain      string          `json:"preferred_chain,omitempty"`
+	Threshold           float64         `json:"threshold,omitempty"`
+	CacheReuseThreshold float64         `json:"cache_reuse_threshold,omitempty"`
+	LogFile             string          `json:"log_file,omitempty"`
+	LogLevel            string          `json:"log_level,omitempty"`
+	AWSKeyID            string          `json:"aws_key_id,omitempty"`
+	AWSSecretKey        string          `json:"aws_secret_key,omitempty"`
+	AWSSessionToken     string          `json:"aws_session_token,omitempty"`
+	AWSRegion           string          `json:"aws_region,omitempty"`
+	AWSEndpoint         string          `json:"aws_endpoint,omitempty"`
+	Route53ZoneID       string          `json:"route53_zone_id,omitempty"`
+	Route53Profile      string          `json:"route53_profile,omitempty"`
+	Route53MaxRetries   *int            `json:"route53_max_retries,omitempty"`
+	DNSTTL              *int            `json:"dns_ttl,omitempty"`
+	DNSPropagationWait  string          `json:"dns_propagation_wait,omitempty"`
+	DNSSkipPropagation  bool            `json:"dns_skip_propagation,omitempty"`
+	DNSPreflight        bool            `json:"dns_preflight,omitempty"`
+	ValidateAttempts    *int            `json:"validate_attempts,omitempty"`
+	ValidateInterval    string          `json:"validate_interval,omitempty"`
+	ValidateMaxInterval string          `json:"validate_max_interval,omitempty"`
+	AWSTimeout          string          `json:"aws_timeout,omitempty"`
+	AWSAssumeRoleArn    string          `json:"aws_assume_role_arn,omitempty"`
+	AWSExternalID       string          `json:"aws_external_id,omitempty"`
+	DryRun              bool            `json:"dry_run,omitempty"`
+	PrintCommands       bool            `json:"print_commands,omitempty"`
+	Force               bool            `json:"force,omitempty"`
+	ForceUpload         bool            `json:"force_upload,omitempty"`
+	CheckReachable      bool            `json:"check_reachable,omitempty"`
+	IPVersion           string          `json:"ip_version,omitempty"`
+	Timing              bool            `json:"timing,omitempty"`
+	Explain             bool            `json:"explain,omitempty"`
+	PostRenewHook       string          `json:"post_renew_hook,omitempty"`
+	PostFailHook        string          `json:"post_fail_hook,omitempty"`
+	PreuploadCheckCmd   string          `json:"preupload_check_cmd,omitempty"`
+	UploadLockWait      string          `json:"upload_lock_wait,omitempty"`
+	StrictHooks         bool            `json:"strict_hooks,omitempty"`
+	CheckChain          bool            `json:"check_chain,omitempty"`
+	Insecure            bool            `json:"insecure,omitempty"`
+	VerifyTrust         bool            `json:"verify_trust,omitempty"`
+	RequireValidation   bool            `json:"require_validation,omitempty"`
+	VerifyCertFile      bool            `json:"verify_cert_file,omitempty"`
+	ProtectedPattern    string          `json:"protected_pattern,omitempty"`
+	RenewIfIssuerNot    string          `json:"renew_if_issuer_not,omitempty"`
+	ExpectedIssuers     []string        `json:"expected_issuers,omitempty"`
+	Labels              labelSet        `json:"labels,omitempty"`
+	PFXOutput           string          `json:"pfx_output,omitempty"`
+	PFXPassword         string          `json:"pfx_password,omitempty"`
+	PFXFile             string          `json:"pfx_file,omitempty"`
+	OutputDir           string          `json:"output_dir,omitempty"`
+	OutputLayout        string          `json:"output_layout,omitempty"`
+	S3UploadBucket      string          `json:"s3_upload_bucket,omitempty"`
+	S3Prefix            string          `json:"s3_prefix,omitempty"`
+	S3Region            string          `json:"s3_region,omitempty"`
+	StrictDistribution  bool            `json:"strict_distribution,omitempty"`
+	Schedule            string          `json:"schedule,omitempty"`
+	StatusFile          string          `json:"status_file,omitempty"`
+	HealthzAddr         string          `json:"healthz_addr,omitempty"`
+	CABundle            string          `json:"ca_bundle,omitempty"`
+	Quiet               bool            `json:"quiet,omitempty"`
+	StdoutOnly          bool            `json:"stdout_only,omitempty"`
+	LogSyslog           bool            `json:"log_syslog,omitempty"`
+	SyslogFacility      string          `json:"syslog_facility,omitempty"`
+	SyslogTag           string          `json:"syslog_tag,omitempty"`
+	CSRFile             string          `json:"csr_file,omitempty"`
+	KeyFile             string          `json:"key_file,omitempty"`
+	KeySize             int             `json:"key_size,omitempty"`
+	KeyType             string          `json:"key_type,omitempty"`
+	AccountKeyType      string          `json:"account_key_type,omitempty"`
+	ESXiUsername        string          `json:"esxi_username,omitempty"`
+	ESXiPassword        string          `json:"esxi_password,omitempty"`
+	ESXiTOTPSecret      string          `json:"esxi_totp_secret,omitempty"`
+	CheckUpdates        *bool           `json:"check_updates,omitempty"`
+	UpdateCheckOwner    string          `json:"update_check_owner,omitempty"`
+	UpdateCheckRepo     string          `json:"update_check_repo,omitempty"`
+	SSHStopTimeout      string          `json:"ssh_stop_timeout,omitempty"`
+	InstallMethod       string          `json:"install_method,omitempty"`
+	TargetType          string          `json:"target_type,omitempty"`
+	NoServiceManagement bool            `json:"no_service_management,omitempty"`
+	ChainMode           string          `json:"chain_mode,omitempty"`
+	ChallengeType       string          `json:"challenge_type,omitempty"`
+	ReloadMethod        string          `json:"reload_method,omitempty"`
+	HTTPChallengePort   int             `json:"http_challenge_port,omitempty"`
+	CacheLockTimeout    string          `json:"cache_lock_timeout,omitempty"`
+	MaxRenewals         *int            `json:"max_renewals,omitempty"`
+	MinIssuanceInterval string          `json:"min_issuance_interval,omitempty"`
+	MinRemainingDays    *int            `json:"min_remaining_days,omitempty"`
+	RenewalWindow       string          `json:"renewal_window,omitempty"`
+	SMTPHost            string          `json:"smtp_host,omitempty"`
+	SMTPPort            int             `json:"smtp_port,omitempty"`
+	ESXiSSHPort         int             `json:"esxi_ssh_port,omitempty"`
+	ESXiHTTPSPort       int             `json:"esxi_https_port,omitempty"`
+	SOAPConnectRetries  *int            `json:"soap_connect_retries,omitempty"`
+	SOAPKeepAlive       string          `json:"soap_keepalive,omitempty"`
+	SMTPUsername        string          `json:"smtp_username,omitempty"`
+	SMTPPassword        string          `json:"smtp_password,omitempty"`
+	MailFrom            string          `json:"mail_from,omitempty"`
+	MailTo              string          `json:"mail_to,omitempty"`
+	TestIssuance        bool            `json:"test_issuance,omitempty"`
+	FailFast            bool            `json:"fail_fast,omitempty"`
+	ReuseKey            bool            `json:"reuse_key,omitempty"`
+	MustStaple          bool            `json:"must_staple,omitempty"`
+	ExtKeyUsages        []string        `json:"ext_key_usages,omitempty"`
+	ExpandEnv           bool            `json:"expand_env,omitempty"`
+	Hosts               []HostConfig    `json:"hosts,omitempty"`
+	Services            []ServiceTarget `json:"services,omitempty"`
 }
 
-// LoadConfigFile loads configuration from a JSON file
+// HostConfig holds per-host overrides applied on top of the global configuration
+type HostConfig struct {
+	Hostname       string  `json:"hostname"`
+	Threshold      float64 `json:"threshold,omitempty"`
+	KeySize        int     `json:"key_size,omitempty"`
+	ESXiUsername   string  `json:"esxi_username,omitempty"`
+	ESXiPassword   string  `json:"esxi_password,omitempty"`
+	ESXiTOTPSecret string  `json:"esxi_totp_secret,omitempty"`
+	ESXiSSHPort    int     `json:"esxi_ssh_port,omitempty"`
+	ESXiHTTPSPort  int     `json:"esxi_https_port,omitempty"`
+	TargetType     string  `json:"target_type,omitempty"`
+}
+
+// Config file path that reads the configuration from stdin instead
+const configFromStdin = "-"
+
+// Source of piped configuration (replaceable in tests)
+var configStdin io.Reader = os.Stdin
+
+// LoadConfigFile loads configuration from a JSON file, or from stdin when the path is "-"
 func (cm *ConfigManager) LoadConfigFile(filePath string) error {
 	if filePath == "" {
 		return nil // No config file specified
 	}
 
-	if _, err := os.Stat(filePath); os.IsNotExist(err) {
-		return nil // Config file doesn't exist, not an error
-	}
+	var data []byte
+	var err error
+	if filePath == configFromStdin {
+		// Piped configuration, e.g. render-config | lab-update-esxi-cert -config -
+		data, err = io.ReadAll(configStdin)
+		if err != nil {
+			return fmt.Errorf("failed to read config from stdin: %v", err)
+		}
+		filePath = "<stdin>"
+	} else {
+		if _, err := os.Stat(filePath); os.IsNotExist(err) {
+			return nil // Config file doesn't exist, not an error
+		}
 
-	data, err := os.ReadFile(filePath)
-	if err != nil {
-		return fmt.Errorf("failed to read config file %s: %v", filePath, err)
+		data, err = os.ReadFile(filePath)
+		if err != nil {
+			return fmt.Errorf("failed to read config file %s: %v", filePath, err)
+		}
 	}
 
 	var configFile ConfigFile
@@ -199,6 +491,14 @@ func (cm *ConfigManager) LoadConfigFile(filePath string) error {
 		return fmt.Errorf("failed to parse config file %s: %v", filePath, err)
 	}
 
+	// Expansion is opt-in, as passwords may legitimately contain $
+	if configFile.ExpandEnv && !cm.GetBool("expand_env") {
+		cm.Set("expand_env", true, ConfigSourceConfigFile)
+	}
+	if cm.GetBool("expand_env") {
+		expandConfigFileEnv(reflect.ValueOf(&configFile).Elem())
+	}
+
 	// Map config file values to configuration manager
 	if configFile.Hostname != "" {
 		cm.Set("hostname", configFile.Hostname, ConfigSourceConfigFile)
@@ -209,9 +509,24 @@ func (cm *ConfigManager) LoadConfigFile(filePath string) error {
 	if configFile.Email != "" {
 		cm.Set("email", configFile.Email, ConfigSourceConfigFile)
 	}
+	if len(configFile.ACMEContacts) > 0 {
+		cm.Set("acme_contacts", strings.Join(configFile.ACMEContacts, ","), ConfigSourceConfigFile)
+	}
+	if configFile.ACMEUserAgent != "" {
+		cm.Set("acme_user_agent", configFile.ACMEUserAgent, ConfigSourceConfigFile)
+	}
+	if configFile.ACMEProfile != "" {
+		cm.Set("acme_profile", configFile.ACMEProfile, ConfigSourceConfigFile)
+	}
+	if configFile.PreferredChain != "" {
+		cm.Set("preferred_chain", configFile.PreferredChain, ConfigSourceConfigFile)
+	}
 	if configFile.Threshold != 0 {
 		cm.Set("threshold", configFile.Threshold, ConfigSourceConfigFile)
 	}
+	if configFile.CacheReuseThreshold != 0 {
+		cm.Set("cache_reuse_threshold", configFile.CacheReuseThreshold, ConfigSourceConfigFile)
+	}
 	if configFile.LogFile != "" {
 		cm.Set("log_file", configFile.LogFile, ConfigSourceConfigFile)
 	}
@@ -230,15 +545,76 @@ func (cm *ConfigManager) LoadConfigFile(filePath string) error {
 	if configFile.AWSRegion != "" {
 		cm.Set("aws_region", configFile.AWSRegion, ConfigSourceConfigFile)
 	}
+	if configFile.AWSEndpoint != "" {
+		cm.Set("aws_endpoint", configFile.AWSEndpoint, ConfigSourceConfigFile)
+	}
+	if configFile.Route53ZoneID != "" {
+		cm.Set("route53_zone_id", configFile.Route53ZoneID, ConfigSourceConfigFile)
+	}
+	if configFile.Route53Profile != "" {
+		cm.Set("route53_profile", configFile.Route53Profile, ConfigSourceConfigFile)
+	}
+	if configFile.Route53MaxRetries != nil {
+		cm.Set("route53_max_retries", *configFile.Route53MaxRetries, ConfigSourceConfigFile)
+	}
+	if configFile.ValidateAttempts != nil {
+		cm.Set("validate_attempts", *configFile.ValidateAttempts, ConfigSourceConfigFile)
+	}
+	if configFile.ValidateInterval != "" {
+		d, err := time.ParseDuration(configFile.ValidateInterval)
+		if err != nil {
+			return fmt.Errorf("invalid validate_interval %q in config file %s: %v", configFile.ValidateInterval, filePath, err)
+		}
+		cm.Set("validate_interval", d, ConfigSourceConfigFile)
+	}
+	if configFile.ValidateMaxInterval != "" {
+		d, err := time.ParseDuration(configFile.ValidateMaxInterval)
+		if err != nil {
+			return fmt.Errorf("invalid validate_max_interval %q in config file %s: %v", configFile.ValidateMaxInterval, filePath, err)
+		}
+		cm.Set("validate_max_interval", d, ConfigSourceConfigFile)
+	}
+	if configFile.DNSTTL != nil {
+		cm.Set("dns_ttl", *configFile.DNSTTL, ConfigSourceConfigFile)
+	}
+	if configFile.DNSPropagationWait != "" {
+		d, err := time.ParseDuration(configFile.DNSPropagationWait)
+		if err != nil {
+			return fmt.Errorf("invalid dns_propagation_wait %q in config file %s: %v", configFile.DNSPropagationWait, filePath, err)
+		}
+		cm.Set("dns_propagation_wait", d, ConfigSourceConfigFile)
+	}
+	if configFile.AWSTimeout != "" {
+		d, err := time.ParseDuration(configFile.AWSTimeout)
+		if err != nil {
+			return fmt.Errorf("invalid aws_timeout %q in config file %s: %v", configFile.AWSTimeout, filePath, err)
+		}
+		cm.Set("aws_timeout", d, ConfigSourceConfigFile)
+	}
+	if configFile.AWSAssumeRoleArn != "" {
+		cm.Set("aws_assume_role_arn", configFile.AWSAssumeRoleArn, ConfigSourceConfigFile)
+	}
+	if configFile.AWSExternalID != "" {
+		cm.Set("aws_external_id", configFile.AWSExternalID, ConfigSourceConfigFile)
+	}
 	if configFile.KeySize != 0 {
 		cm.Set("key_size", configFile.KeySize, ConfigSourceConfigFile)
 	}
+	if configFile.KeyType != "" {
+		cm.Set("key_type", configFile.KeyType, ConfigSourceConfigFile)
+	}
+	if configFile.AccountKeyType != "" {
+		cm.Set("account_key_type", configFile.AccountKeyType, ConfigSourceConfigFile)
+	}
 	if configFile.ESXiUsername != "" {
 		cm.Set("esxi_username", configFile.ESXiUsername, ConfigSourceConfigFile)
 	}
 	if configFile.ESXiPassword != "" {
 		cm.Set("esxi_password", configFile.ESXiPassword, ConfigSourceConfigFile)
 	}
+	if configFile.ESXiTOTPSecret != "" {
+		cm.Set("esxi_totp_secret", configFile.ESXiTOTPSecret, ConfigSourceConfigFile)
+	}
 	if configFile.UpdateCheckOwner != "" {
 		cm.Set("update_check_owner", configFile.UpdateCheckOwner, ConfigSourceConfigFile)
 	}
@@ -246,33 +622,378 @@ func (cm *ConfigManager) LoadConfigFile(filePath string) error {
 		cm.Set("update_check_repo", configFile.UpdateCheckRepo, ConfigSourceConfigFile)
 	}
 
+	if configFile.InstallMethod != "" {
+		cm.Set("install_method", configFile.InstallMethod, ConfigSourceConfigFile)
+	}
+	if configFile.TargetType != "" {
+		cm.Set("target_type", configFile.TargetType, ConfigSourceConfigFile)
+	}
+	if configFile.ChallengeType != "" {
+		cm.Set("challenge_type", configFile.ChallengeType, ConfigSourceConfigFile)
+	}
+	if configFile.ReloadMethod != "" {
+		cm.Set("reload_method", configFile.ReloadMethod, ConfigSourceConfigFile)
+	}
+	if configFile.HTTPChallengePort != 0 {
+		cm.Set("http_challenge_port", configFile.HTTPChallengePort, ConfigSourceConfigFile)
+	}
+	if configFile.ChainMode != "" {
+		cm.Set("chain_mode", configFile.ChainMode, ConfigSourceConfigFile)
+	}
+	if configFile.SMTPHost != "" {
+		cm.Set("smtp_host", configFile.SMTPHost, ConfigSourceConfigFile)
+	}
+	if configFile.ESXiSSHPort != 0 {
+		cm.Set("esxi_ssh_port", configFile.ESXiSSHPort, ConfigSourceConfigFile)
+	}
+	if configFile.ESXiHTTPSPort != 0 {
+		cm.Set("esxi_https_port", configFile.ESXiHTTPSPort, ConfigSourceConfigFile)
+	}
+	if configFile.SOAPConnectRetries != nil {
+		cm.Set("soap_connect_retries", *configFile.SOAPConnectRetries, ConfigSourceConfigFile)
+	}
+	if configFile.SOAPKeepAlive != "" {
+		d, err := time.ParseDuration(configFile.SOAPKeepAlive)
+		if err != nil {
+			return fmt.Errorf("invalid soap_keepalive %q in config file %s: %v", configFile.SOAPKeepAlive, filePath, err)
+		}
+		cm.Set("soap_keepalive", d, ConfigSourceConfigFile)
+	}
+	if configFile.SMTPPort != 0 {
+		cm.Set("smtp_port", configFile.SMTPPort, ConfigSourceConfigFile)
+	}
+	if configFile.SMTPUsername != "" {
+		cm.Set("smtp_username", configFile.SMTPUsername, ConfigSourceConfigFile)
+	}
+	if configFile.SMTPPassword != "" {
+		cm.Set("smtp_password", configFile.SMTPPassword, ConfigSourceConfigFile)
+	}
+	if configFile.MailFrom != "" {
+		cm.Set("mail_from", configFile.MailFrom, ConfigSourceConfigFile)
+	}
+	if configFile.MailTo != "" {
+		cm.Set("mail_to", configFile.MailTo, ConfigSourceConfigFile)
+	}
+	if configFile.SSHStopTimeout != "" {
+		d, err := time.ParseDuration(configFile.SSHStopTimeout)
+		if err != nil {
+			return fmt.Errorf("invalid ssh_stop_timeout %q in config file %s: %v", configFile.SSHStopTimeout, filePath, err)
+		}
+		cm.Set("ssh_stop_timeout", d, ConfigSourceConfigFile)
+	}
+
+	if configFile.CacheLockTimeout != "" {
+		d, err := time.ParseDuration(configFile.CacheLockTimeout)
+		if err != nil {
+			return fmt.Errorf("invalid cache_lock_timeout %q in config file %s: %v", configFile.CacheLockTimeout, filePath, err)
+		}
+		cm.Set("cache_lock_timeout", d, ConfigSourceConfigFile)
+	}
+
+	if configFile.MaxRenewals != nil {
+		cm.Set("max_renewals", *configFile.MaxRenewals, ConfigSourceConfigFile)
+	}
+	if configFile.MinIssuanceInterval != "" {
+		d, err := time.ParseDuration(configFile.MinIssuanceInterval)
+		if err != nil {
+			return fmt.Errorf("invalid min_issuance_interval %q in config file %s: %v", configFile.MinIssuanceInterval, filePath, err)
+		}
+		cm.Set("min_issuance_interval", d, ConfigSourceConfigFile)
+	}
+	if configFile.MinRemainingDays != nil {
+		cm.Set("min_remaining_days", *configFile.MinRemainingDays, ConfigSourceConfigFile)
+	}
+	if configFile.RenewalWindow != "" {
+		d, err := time.ParseDuration(configFile.RenewalWindow)
+		if err != nil {
+			return fmt.Errorf("invalid renewal_window %q in config file %s: %v", configFile.RenewalWindow, filePath, err)
+		}
+		cm.Set("renewal_window", d, ConfigSourceConfigFile)
+	}
+
+	if len(configFile.Hosts) > 0 {
+		cm.Set("hosts", configFile.Hosts, ConfigSourceConfigFile)
+	}
+
+	if configFile.PostRenewHook != "" {
+		cm.Set("post_renew_hook", configFile.PostRenewHook, ConfigSourceConfigFile)
+	}
+	if configFile.PostFailHook != "" {
+		cm.Set("post_fail_hook", configFile.PostFailHook, ConfigSourceConfigFile)
+	}
+	if configFile.PreuploadCheckCmd != "" {
+		cm.Set("preupload_check_cmd", configFile.PreuploadCheckCmd, ConfigSourceConfigFile)
+	}
+	if configFile.UploadLockWait != "" {
+		d, err := time.ParseDuration(configFile.UploadLockWait)
+		if err != nil {
+			return fmt.Errorf("invalid upload_lock_wait %q in config file %s: %v", configFile.UploadLockWait, filePath, err)
+		}
+		cm.Set("upload_lock_wait", d, ConfigSourceConfigFile)
+	}
+	if configFile.IPVersion != "" {
+		cm.Set("ip_version", configFile.IPVersion, ConfigSourceConfigFile)
+	}
+	if configFile.Schedule != "" {
+		cm.Set("schedule", configFile.Schedule, ConfigSourceConfigFile)
+	}
+	if configFile.StatusFile != "" {
+		cm.Set("status_file", configFile.StatusFile, ConfigSourceConfigFile)
+	}
+	if configFile.HealthzAddr != "" {
+		cm.Set("healthz_addr", configFile.HealthzAddr, ConfigSourceConfigFile)
+	}
+	if configFile.SyslogFacility != "" {
+		cm.Set("syslog_facility", configFile.SyslogFacility, ConfigSourceConfigFile)
+	}
+	if configFile.SyslogTag != "" {
+		cm.Set("syslog_tag", configFile.SyslogTag, ConfigSourceConfigFile)
+	}
+	if configFile.S3UploadBucket != "" {
+		cm.Set("s3_upload_bucket", configFile.S3UploadBucket, ConfigSourceConfigFile)
+	}
+	if configFile.S3Prefix != "" {
+		cm.Set("s3_prefix", configFile.S3Prefix, ConfigSourceConfigFile)
+	}
+	if configFile.S3Region != "" {
+		cm.Set("s3_region", configFile.S3Region, ConfigSourceConfigFile)
+	}
+	if configFile.PFXOutput != "" {
+		cm.Set("pfx_output", configFile.PFXOutput, ConfigSourceConfigFile)
+	}
+	if configFile.OutputDir != "" {
+		cm.Set("output_dir", configFile.OutputDir, ConfigSourceConfigFile)
+	}
+	if configFile.OutputLayout != "" {
+		cm.Set("output_layout", configFile.OutputLayout, ConfigSourceConfigFile)
+	}
+	if configFile.PFXPassword != "" {
+		cm.Set("pfx_password", configFile.PFXPassword, ConfigSourceConfigFile)
+	}
+	if configFile.PFXFile != "" {
+		cm.Set("pfx_file", configFile.PFXFile, ConfigSourceConfigFile)
+	}
+	if configFile.ProtectedPattern != "" {
+		cm.Set("protected_pattern", configFile.ProtectedPattern, ConfigSourceConfigFile)
+	}
+	if configFile.RenewIfIssuerNot != "" {
+		cm.Set("renew_if_issuer_not", configFile.RenewIfIssuerNot, ConfigSourceConfigFile)
+	}
+	if len(configFile.ExpectedIssuers) > 0 {
+		cm.Set("expected_issuers", strings.Join(configFile.ExpectedIssuers, ","), ConfigSourceConfigFile)
+	}
+	if len(configFile.Labels) > 0 {
+		cm.Set("labels", strings.Join(labelsFromMap(configFile.Labels), ","), ConfigSourceConfigFile)
+	}
+	if configFile.CABundle != "" {
+		cm.Set("ca_bundle", configFile.CABundle, ConfigSourceConfigFile)
+	}
+	if configFile.CSRFile != "" {
+		cm.Set("csr_file", configFile.CSRFile, ConfigSourceConfigFile)
+	}
+	if configFile.KeyFile != "" {
+		cm.Set("key_file", configFile.KeyFile, ConfigSourceConfigFile)
+	}
+
+	if len(configFile.Services) > 0 {
+		cm.Set("services", configFile.Services, ConfigSourceConfigFile)
+	}
+
+	// Update checks default to on, so only an explicit setting in the file applies
+	if configFile.CheckUpdates != nil {
+		cm.Set("check_updates", *configFile.CheckUpdates, ConfigSourceConfigFile)
+	}
+
 	// Handle boolean values (they could be explicitly set to false)
 	cm.Set("dry_run", configFile.DryRun, ConfigSourceConfigFile)
+	cm.Set("print_commands", configFile.PrintCommands, ConfigSourceConfigFile)
 	cm.Set("force", configFile.Force, ConfigSourceConfigFile)
-	cm.Set("check_updates", configFile.CheckUpdates, ConfigSourceConfigFile)
+	cm.Set("force_upload", configFile.ForceUpload, ConfigSourceConfigFile)
+	cm.Set("check_reachable", configFile.CheckReachable, ConfigSourceConfigFile)
+	cm.Set("timing", configFile.Timing, ConfigSourceConfigFile)
+	cm.Set("explain", configFile.Explain, ConfigSourceConfigFile)
+	cm.Set("strict_hooks", configFile.StrictHooks, ConfigSourceConfigFile)
+	cm.Set("strict_distribution", configFile.StrictDistribution, ConfigSourceConfigFile)
+	cm.Set("check_chain", configFile.CheckChain, ConfigSourceConfigFile)
+	cm.Set("insecure", configFile.Insecure, ConfigSourceConfigFile)
+	cm.Set("verify_trust", configFile.VerifyTrust, ConfigSourceConfigFile)
+	cm.Set("require_validation", configFile.RequireValidation, ConfigSourceConfigFile)
+	cm.Set("verify_cert_file", configFile.VerifyCertFile, ConfigSourceConfigFile)
+	cm.Set("dns_skip_propagation", configFile.DNSSkipPropagation, ConfigSourceConfigFile)
+	cm.Set("dns_preflight", configFile.DNSPreflight, ConfigSourceConfigFile)
+	cm.Set("quiet", configFile.Quiet, ConfigSourceConfigFile)
+	cm.Set("stdout_only", configFile.StdoutOnly, ConfigSourceConfigFile)
+	cm.Set("log_syslog", configFile.LogSyslog, ConfigSourceConfigFile)
+	cm.Set("no_service_management", configFile.NoServiceManagement, ConfigSourceConfigFile)
+	cm.Set("test_issuance", configFile.TestIssuance, ConfigSourceConfigFile)
+	cm.Set("fail_fast", configFile.FailFast, ConfigSourceConfigFile)
+	cm.Set("reuse_key", configFile.ReuseKey, ConfigSourceConfigFile)
+	cm.Set("must_staple", configFile.MustStaple, ConfigSourceConfigFile)
+	if len(configFile.ExtKeyUsages) > 0 {
+		cm.Set("ext_key_usages", strings.Join(configFile.ExtKeyUsages, ","), ConfigSourceConfigFile)
+	}
 
 	logDebug("Loaded configuration from file: %s", filePath)
 	return nil
 }
 
+// expandEnvValue expands ${VAR} and $VAR references from the environment, with $$ for a literal $
+func expandEnvValue(s string) string {
+	return os.Expand(s, func(name string) string {
+		if name == "$" {
+			return "$"
+		}
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			logWarn("Config file references unset environment variable %s", name)
+		}
+		return value
+	})
+}
+
+// expandConfigFileEnv expands environment references in every string value of a parsed
+// config file, including those in the hosts and services lists
+func expandConfigFileEnv(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(expandEnvValue(v.String()))
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			expandConfigFileEnv(v.Field(i))
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			expandConfigFileEnv(v.Index(i))
+		}
+	case reflect.Ptr:
+		if !v.IsNil() {
+			expandConfigFileEnv(v.Elem())
+		}
+	}
+}
+
 // BuildConfig builds the final Config struct from the configuration manager
 func (cm *ConfigManager) BuildConfig() Config {
 	config := Config{
 		Hostname:            cm.GetString("hostname"),
 		Domain:              cm.GetString("domain"),
 		Email:               cm.GetString("email"),
+		ACMEContacts:        parseMailRecipients(cm.GetString("acme_contacts")),
+		ACMEUserAgent:       cm.GetString("acme_user_agent"),
+		ACMEProfile:         cm.GetString("acme_profile"),
+		PreferredChain:      cm.GetString("preferred_chain"),
 		Threshold:           cm.GetFloat64("threshold"),
+		CacheReuseThreshold: cm.GetFloat64("cache_reuse_threshold"),
 		LogFile:             cm.GetString("log_file"),
 		LogLevel:            cm.GetString("log_level"),
 		Route53KeyID:        cm.GetString("aws_key_id"),
 		Route53SecretKey:    cm.GetString("aws_secret_key"),
 		Route53SessionToken: cm.GetString("aws_session_token"),
 		Route53Region:       cm.GetString("aws_region"),
+		AWSEndpoint:         cm.GetString("aws_endpoint"),
+		Route53ZoneID:       strings.TrimPrefix(cm.GetString("route53_zone_id"), "/hostedzone/"),
+		Route53Profile:      cm.GetString("route53_profile"),
+		Route53MaxRetries:   cm.GetInt("route53_max_retries"),
+		DNSTTL:              cm.GetInt("dns_ttl"),
+		DNSPropagationWait:  cm.GetDuration("dns_propagation_wait"),
+		DNSSkipPropagation:  cm.GetBool("dns_skip_propagation"),
+		DNSPreflight:        cm.GetBool("dns_preflight"),
+		ValidateAttempts:    cm.GetInt("validate_attempts"),
+		ValidateInterval:    cm.GetDuration("validate_interval"),
+		ValidateMaxInterval: cm.GetDuration("validate_max_interval"),
+		AWSTimeout:          cm.GetDuration("aws_timeout"),
+		AWSAssumeRoleArn:    cm.GetString("aws_assume_role_arn"),
+		AWSExternalID:       cm.GetString("aws_external_id"),
 		DryRun:              cm.GetBool("dry_run"),
+		PrintCommands:       cm.GetBool("print_commands"),
 		Force:               cm.GetBool("force"),
+		ForceUpload:         cm.GetBool("force_upload"),
+		CheckUpdates:        cm.GetBool("check_updates"),
+		CheckReachable:      cm.GetBool("check_reachable"),
+		IPVersion:           cm.GetString("ip_version"),
+		Timing:              cm.GetBool("timing"),
+		Explain:             cm.GetBool("explain"),
+		PostRenewHook:       cm.GetString("post_renew_hook"),
+		PostFailHook:        cm.GetString("post_fail_hook"),
+		PreuploadCheckCmd:   cm.GetString("preupload_check_cmd"),
+		UploadLockWait:      cm.GetDuration("upload_lock_wait"),
+		StrictHooks:         cm.GetBool("strict_hooks"),
+		CheckChain:          cm.GetBool("check_chain"),
+		Insecure:            cm.GetBool("insecure"),
+		VerifyTrust:         cm.GetBool("verify_trust"),
+		RequireValidation:   cm.GetBool("require_validation"),
+		VerifyCertFile:      cm.GetBool("verify_cert_file"),
+		ProtectedPattern:    cm.GetString("protected_pattern"),
+		ConfirmProtected:    cm.GetBool("i_know_what_im_doing"),
+		RenewIfIssuerNot:    cm.GetString("renew_if_issuer_not"),
+		ExpectedIssuers:     parseMailRecipients(cm.GetString("expected_issuers")),
+		Labels:              parseMailRecipients(cm.GetString("labels")),
+		PFXOutput:           cm.GetString("pfx_output"),
+		PFXPassword:         cm.GetString("pfx_password"),
+		PFXFile:             cm.GetString("pfx_file"),
+		OutputDir:           cm.GetString("output_dir"),
+		OutputLayout:        cm.GetString("output_layout"),
+		S3UploadBucket:      cm.GetString("s3_upload_bucket"),
+		S3Prefix:            cm.GetString("s3_prefix"),
+		S3Region:            cm.GetString("s3_region"),
+		StrictDistribution:  cm.GetBool("strict_distribution"),
+		Schedule:            cm.GetString("schedule"),
+		StatusFile:          cm.GetString("status_file"),
+		HealthzAddr:         cm.GetString("healthz_addr"),
+		CABundle:            cm.GetString("ca_bundle"),
+		Quiet:               cm.GetBool("quiet"),
+		StdoutOnly:          cm.GetBool("stdout_only"),
+		LogSyslog:           cm.GetBool("log_syslog"),
+		SyslogFacility:      cm.GetString("syslog_facility"),
+		SyslogTag:           cm.GetString("syslog_tag"),
+		CSRFile:             cm.GetString("csr_file"),
+		KeyFile:             cm.GetString("key_file"),
 		KeySize:             cm.GetInt("key_size"),
+		KeyType:             cm.GetString("key_type"),
+		AccountKeyType:      cm.GetString("account_key_type"),
 		ESXiUsername:        cm.GetString("esxi_username"),
 		ESXiPassword:        cm.GetString("esxi_password"),
+		ESXiTOTPSecret:      cm.GetString("esxi_totp_secret"),
+		SSHStopTimeout:      cm.GetDuration("ssh_stop_timeout"),
+		InstallMethod:       cm.GetString("install_method"),
+		TargetType:          cm.GetString("target_type"),
+		NoServiceManagement: cm.GetBool("no_service_management"),
+		ChainMode:           cm.GetString("chain_mode"),
+		ChallengeType:       cm.GetString("challenge_type"),
+		ReloadMethod:        cm.GetString("reload_method"),
+		HTTPChallengePort:   cm.GetInt("http_challenge_port"),
+		CacheLockTimeout:    cm.GetDuration("cache_lock_timeout"),
+		MaxRenewals:         cm.GetInt("max_renewals"),
+		MinRemainingDays:    cm.GetInt("min_remaining_days"),
+		MinIssuanceInterval: cm.GetDuration("min_issuance_interval"),
+		RenewalWindow:       cm.GetDuration("renewal_window"),
+		SMTPHost:            cm.GetString("smtp_host"),
+		SMTPPort:            cm.GetInt("smtp_port"),
+		ESXiSSHPort:         cm.GetInt("esxi_ssh_port"),
+		ESXiHTTPSPort:       cm.GetInt("esxi_https_port"),
+		SOAPConnectRetries:  cm.GetInt("soap_connect_retries"),
+		SOAPKeepAlive:       cm.GetDuration("soap_keepalive"),
+		SMTPUsername:        cm.GetString("smtp_username"),
+		SMTPPassword:        cm.GetString("smtp_password"),
+		MailFrom:            cm.GetString("mail_from"),
+		MailTo:              cm.GetString("mail_to"),
+		TestIssuance:        cm.GetBool("test_issuance"),
+		FailFast:            cm.GetBool("fail_fast"),
+		ReuseKey:            cm.GetBool("reuse_key"),
+		MustStaple:          cm.GetBool("must_staple"),
+		ExtKeyUsages:        parseMailRecipients(cm.GetString("ext_key_usages")),
+	}
+
+	if services, ok := cm.Get("services"); ok {
+		if targets, ok := services.([]ServiceTarget); ok {
+			config.Services = targets
+		}
+	}
+
+	if hosts, ok := cm.Get("hosts"); ok {
+		if hostConfigs, ok := hosts.([]HostConfig); ok {
+			config.Hosts = hostConfigs
+		}
 	}
 
 	// Set default log file if not specified
@@ -286,15 +1007,47 @@ func (cm *ConfigManager) BuildConfig() Config {
 
 // ValidateConfig validates the final configuration
 func (cm *ConfigManager) ValidateConfig(config Config) error {
+	// With a hosts list, validate each host's effective configuration instead
+	if len(config.Hosts) > 0 {
+		seen := make(map[string]bool)
+		for i, host := range config.Hosts {
+			if host.Hostname == "" {
+				return fmt.Errorf("hosts[%d]: hostname is required", i)
+			}
+			if seen[strings.ToLower(host.Hostname)] {
+				return fmt.Errorf("hosts[%d]: duplicate hostname %s", i, host.Hostname)
+			}
+			seen[strings.ToLower(host.Hostname)] = true
+
+			if err := cm.ValidateConfig(config.ForHost(host)); err != nil {
+				return fmt.Errorf("host %s: %v", host.Hostname, err)
+			}
+		}
+		return nil
+	}
+
 	// Required fields validation
 	if config.Hostname == "" {
 		return fmt.Errorf("hostname is required")
 	}
+	if err := validateHostname(config.Hostname); err != nil {
+		return err
+	}
+
+	// Connections to the host must be knowingly unverified or verified against a CA bundle
+	if !config.TestIssuance && !config.Insecure && config.CABundle == "" {
+		return fmt.Errorf("connecting to %s requires -insecure to accept its certificate without verification (typical for self-signed lab hosts) or -ca-bundle to verify it against trusted roots", config.Hostname)
+	}
+	if config.CheckReachable {
+		if err := checkHostReachable(esxiHTTPSAddress(config), reachabilityTimeout); err != nil {
+			return err
+		}
+	}
 
 	// AWS credentials validation - can use either explicit credentials OR default credential chain
-	// If one is provided, both key ID and secret must be provided
-	if (config.Route53KeyID != "" && config.Route53SecretKey == "") ||
-		(config.Route53KeyID == "" && config.Route53SecretKey != "") {
+	// If one is provided, both key ID and secret must be provided; a blank secret counts as missing
+	hasKeyID, hasSecret := strings.TrimSpace(config.Route53KeyID) != "", strings.TrimSpace(config.Route53SecretKey) != ""
+	if hasKeyID != hasSecret {
 		return fmt.Errorf("both AWS Access Key ID and Secret Access Key must be provided together, or omit both to use AWS default credential chain")
 	}
 
@@ -305,27 +1058,395 @@ func (cm *ConfigManager) ValidateConfig(config Config) error {
 		logDebug("Using AWS default credential chain (no explicit credentials provided)")
 	}
 
+	// Validate custom AWS endpoint (LocalStack, GovCloud, etc.)
+	if config.AWSEndpoint != "" {
+		endpoint, err := url.Parse(config.AWSEndpoint)
+		if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+			return fmt.Errorf("invalid AWS endpoint %s, must be an http:// or https:// URL", config.AWSEndpoint)
+		}
+	}
+
+	// Validate the requested ACME profile name
+	if config.ACMEProfile != "" && (strings.TrimSpace(config.ACMEProfile) == "" || strings.ContainsAny(config.ACMEProfile, " \t\n")) {
+		return fmt.Errorf("invalid ACME profile %q, must be a non-empty name without whitespace", config.ACMEProfile)
+	}
+
+	// Validate the preferred chain, matched against the common name of the chain's top issuer
+	if config.PreferredChain != "" && strings.TrimSpace(config.PreferredChain) == "" {
+		return fmt.Errorf("invalid preferred chain %q, must be the common name of a root or intermediate", config.PreferredChain)
+	}
+
+	// Validate AWS retry and timeout settings
+	if config.Route53MaxRetries < 0 {
+		return fmt.Errorf("invalid Route53 max retries %d, must not be negative", config.Route53MaxRetries)
+	}
+	// Validate the DNS-01 record TTL and propagation settings
+	if config.DNSTTL < 0 {
+		return fmt.Errorf("invalid DNS TTL %d, must not be negative (0 uses the default of %d)", config.DNSTTL, defaultDNSTTL)
+	}
+	if config.DNSPropagationWait < 0 {
+		return fmt.Errorf("invalid DNS propagation wait %s, must not be negative", config.DNSPropagationWait)
+	}
+	if config.ChallengeType == challengeTypeHTTP01 && (config.DNSPropagationWait > 0 || config.DNSSkipPropagation) {
+		return fmt.Errorf("dns-propagation-wait and dns-skip-propagation-check only apply to the %s challenge", challengeTypeDNS01)
+	}
+	if config.ChallengeType == challengeTypeHTTP01 && config.DNSPreflight {
+		return fmt.Errorf("dns-preflight only applies to the %s challenge", challengeTypeDNS01)
+	}
+	if config.ValidateAttempts < 0 {
+		return fmt.Errorf("invalid validate attempts %d, must not be negative (0 checks until the timeout)", config.ValidateAttempts)
+	}
+	if config.ValidateInterval < 0 || config.ValidateMaxInterval < 0 {
+		return fmt.Errorf("invalid validate intervals %s and %s, must not be negative", config.ValidateInterval, config.ValidateMaxInterval)
+	}
+	if config.ValidateInterval > 0 && config.ValidateMaxInterval > 0 && config.ValidateMaxInterval < config.ValidateInterval {
+		return fmt.Errorf("invalid validate max interval %s, must not be shorter than the initial interval %s", config.ValidateMaxInterval, config.ValidateInterval)
+	}
+	if config.AWSTimeout < 0 {
+		return fmt.Errorf("invalid AWS timeout %s, must not be negative", config.AWSTimeout)
+	}
+
+	// Validate pinned Route53 hosted zone
+	if config.Route53ZoneID != "" {
+		if config.ChallengeType == challengeTypeHTTP01 {
+			return fmt.Errorf("route53 zone ID cannot be used with challenge type %s", challengeTypeHTTP01)
+		}
+		if strings.ContainsAny(config.Route53ZoneID, "/ \t") {
+			return fmt.Errorf("invalid Route53 hosted zone ID %s", config.Route53ZoneID)
+		}
+	}
+
+	// Validate the shared profile for the DNS provider
+	if config.Route53Profile != "" {
+		if config.ChallengeType == challengeTypeHTTP01 {
+			return fmt.Errorf("route53 profile cannot be used with challenge type %s", challengeTypeHTTP01)
+		}
+		if strings.TrimSpace(config.Route53Profile) != config.Route53Profile || strings.ContainsAny(config.Route53Profile, " \t\n") {
+			return fmt.Errorf("invalid Route53 profile %q, must be a profile name without whitespace", config.Route53Profile)
+		}
+	}
+
+	// Validate role assumption settings
+	if config.AWSAssumeRoleArn != "" && !strings.HasPrefix(config.AWSAssumeRoleArn, "arn:") {
+		return fmt.Errorf("invalid AWS role ARN %s, must start with arn:", config.AWSAssumeRoleArn)
+	}
+	if config.AWSExternalID != "" && config.AWSAssumeRoleArn == "" {
+		return fmt.Errorf("aws-external-id requires aws-assume-role-arn")
+	}
+
 	// Validate flag combinations
 	if config.DryRun && config.Force {
 		return fmt.Errorf("cannot use dry-run and force together")
 	}
+	if config.TestIssuance && config.DryRun {
+		return fmt.Errorf("cannot use test-issuance and dry-run together")
+	}
+	if config.PrintCommands && !config.DryRun {
+		return fmt.Errorf("print-commands requires dry-run")
+	}
 
-	// Validate required fields for non-dry-run mode
+	// Validate required fields for non-dry-run mode; an imported PFX needs neither ACME nor Route53
 	if !config.DryRun {
-		if config.Domain == "" {
+		if config.Domain == "" && config.ChallengeType != challengeTypeHTTP01 && config.PFXFile == "" {
 			return fmt.Errorf("domain is required for Route53 DNS validation")
 		}
-		if config.Email == "" {
-			return fmt.Errorf("email is required for ACME registration")
+		if config.PFXFile == "" {
+			if config.Email == "" {
+				return fmt.Errorf("email is required for ACME registration")
+			}
+			if err := validateEmailAddress(config.Email); err != nil {
+				return err
+			}
 		}
-		if config.ESXiUsername == "" || config.ESXiPassword == "" {
+		// Test issuance never touches the ESXi host, so its credentials are optional
+		if !config.TestIssuance && (config.ESXiUsername == "" || config.ESXiPassword == "") {
 			return fmt.Errorf("ESXi username and password are required for certificate upload")
 		}
 	}
 
+	// Additional ACME contacts must be email addresses
+	for _, contact := range config.ACMEContacts {
+		if _, err := mail.ParseAddress(strings.TrimPrefix(contact, "mailto:")); err != nil {
+			return fmt.Errorf("invalid ACME contact %s, must be an email address", contact)
+		}
+	}
+
+	// Expected issuers are matched as substrings, so a blank entry would accept any CA
+	for _, issuer := range config.ExpectedIssuers {
+		if strings.TrimSpace(issuer) == "" {
+			return fmt.Errorf("expected issuer must not be empty")
+		}
+	}
+
+	if err := validateLabels(config.Labels); err != nil {
+		return err
+	}
+
+	if config.ProtectedPattern != "" {
+		if _, err := regexp.Compile(config.ProtectedPattern); err != nil {
+			return fmt.Errorf("invalid protected pattern %q: %v", config.ProtectedPattern, err)
+		}
+	}
+
+	// Validate TOTP secret (must be a usable base32 shared secret)
+	if config.ESXiTOTPSecret != "" {
+		if _, err := totp.GenerateCode(config.ESXiTOTPSecret, time.Now()); err != nil {
+			return fmt.Errorf("invalid ESXi TOTP secret, must be a base32-encoded shared secret: %v", err)
+		}
+	}
+
+	if config.Schedule != "" {
+		if _, err := parseSchedule(config.Schedule); err != nil {
+			return err
+		}
+	}
+
+	// The S3 options only refine an upload to -s3-upload-bucket
+	if config.S3UploadBucket == "" && (config.S3Prefix != "" || config.S3Region != "" || config.StrictDistribution) {
+		return fmt.Errorf("s3-prefix, s3-region and strict-distribution require s3-upload-bucket")
+	}
+	if strings.ContainsAny(config.S3UploadBucket, "/: ") {
+		return fmt.Errorf("invalid S3 bucket %s, must be a bucket name, not a URL or path", config.S3UploadBucket)
+	}
+
+	// A PFX password only makes sense with a PFX output path
+	if config.PFXPassword != "" && config.PFXOutput == "" && config.PFXFile == "" {
+		return fmt.Errorf("pfx-password requires pfx-output or pfx-file")
+	}
+	if config.PFXOutput != "" && len(config.Hosts) > 0 {
+		return fmt.Errorf("pfx-output cannot be used with a hosts list, as every host would overwrite the same file")
+	}
+
+	// Validate the output directory layout (empty means the default flat layout)
+	switch config.OutputLayout {
+	case "", outputLayoutFlat, outputLayoutCertbot:
+	default:
+		return fmt.Errorf("invalid output layout %s, must be one of: %s, %s", config.OutputLayout, outputLayoutFlat, outputLayoutCertbot)
+	}
+	if config.OutputLayout == outputLayoutCertbot && config.OutputDir == "" {
+		return fmt.Errorf("output-layout %s requires output-dir", outputLayoutCertbot)
+	}
+
+	// Validate ESXi ports (zero means the default)
+	if config.ESXiSSHPort < 0 || config.ESXiSSHPort > 65535 {
+		return fmt.Errorf("invalid ESXi SSH port %d, must be between 1 and 65535", config.ESXiSSHPort)
+	}
+	if config.ESXiHTTPSPort < 0 || config.ESXiHTTPSPort > 65535 {
+		return fmt.Errorf("invalid ESXi HTTPS port %d, must be between 1 and 65535", config.ESXiHTTPSPort)
+	}
+
+	if config.SOAPConnectRetries < 0 {
+		return fmt.Errorf("invalid SOAP connect retries %d, must not be negative", config.SOAPConnectRetries)
+	}
+
+	// Kept-alive sessions are only logged out when a schedule stops, so require one
+	if config.SOAPKeepAlive < 0 {
+		return fmt.Errorf("invalid SOAP keepalive %s, must not be negative", config.SOAPKeepAlive)
+	}
+	if config.SOAPKeepAlive > 0 && config.Schedule == "" {
+		return fmt.Errorf("soap-keepalive requires schedule, as sessions are only reused between scheduled runs")
+	}
+	if config.StatusFile != "" && config.Schedule == "" {
+		return fmt.Errorf("status-file requires schedule, as the status is written after each scheduled run")
+	}
+	if config.HealthzAddr != "" {
+		if config.Schedule == "" {
+			return fmt.Errorf("healthz-addr requires schedule, as a one-shot run exits when done")
+		}
+		if _, _, err := net.SplitHostPort(config.HealthzAddr); err != nil {
+			return fmt.Errorf("invalid healthz address %s, must be host:port or :port", config.HealthzAddr)
+		}
+	}
+
 	// Validate key size
-	if config.KeySize != 2048 && config.KeySize != 4096 {
-		return fmt.Errorf("invalid key size %d, must be 2048 or 4096", config.KeySize)
+	if err := validateKeySize(config.KeySize); err != nil {
+		return err
+	}
+
+	// Validate key types (empty means RSA with the key size)
+	if config.KeyType != "" {
+		if _, err := parseKeyType(config.KeyType); err != nil {
+			return err
+		}
+	}
+	if config.AccountKeyType != "" {
+		if _, err := parseKeyType(config.AccountKeyType); err != nil {
+			return fmt.Errorf("account key: %v", err)
+		}
+	}
+
+	// Validate SSH stop timeout
+	if config.SSHStopTimeout < 0 {
+		return fmt.Errorf("invalid SSH stop timeout %s, must not be negative", config.SSHStopTimeout)
+	}
+
+	if config.MinIssuanceInterval < 0 {
+		return fmt.Errorf("invalid min issuance interval %s, must not be negative (0 disables the check)", config.MinIssuanceInterval)
+	}
+	if config.MinRemainingDays < 0 {
+		return fmt.Errorf("invalid min remaining after renew days %d, must not be negative (0 disables the check)", config.MinRemainingDays)
+	}
+
+	// Validate cache lock timeout
+	if config.MaxRenewals < 0 {
+		return fmt.Errorf("invalid max renewals %d, must not be negative (0 disables the check)", config.MaxRenewals)
+	}
+	if config.MaxRenewals > 0 && config.RenewalWindow <= 0 {
+		return fmt.Errorf("invalid renewal window %s, must be positive", config.RenewalWindow)
+	}
+
+	if config.UploadLockWait < 0 {
+		return fmt.Errorf("invalid upload lock wait %s, must not be negative", config.UploadLockWait)
+	}
+
+	if config.CacheLockTimeout < 0 {
+		return fmt.Errorf("invalid cache lock timeout %s, must not be negative", config.CacheLockTimeout)
+	}
+
+	// Validate SMTP report settings
+	if config.SMTPHost != "" {
+		if config.MailFrom == "" || len(parseMailRecipients(config.MailTo)) == 0 {
+			return fmt.Errorf("mail-from and mail-to are required when smtp-host is set")
+		}
+		if config.SMTPPort <= 0 || config.SMTPPort > 65535 {
+			return fmt.Errorf("invalid SMTP port %d, must be between 1 and 65535", config.SMTPPort)
+		}
+	}
+
+	// Validate install method (empty means the default SSH method)
+	switch config.InstallMethod {
+	case "", installMethodSSH, installMethodSOAPCertMgr:
+	default:
+		return fmt.Errorf("invalid install method %s, must be one of: %s, %s", config.InstallMethod, installMethodSSH, installMethodSOAPCertMgr)
+	}
+
+	// Validate target type (empty means an ESXi host)
+	switch config.TargetType {
+	case "", targetTypeESXi:
+	case targetTypeVCSA:
+		if config.InstallMethod == installMethodSOAPCertMgr {
+			return fmt.Errorf("install method %s cannot be used with target type %s", installMethodSOAPCertMgr, targetTypeVCSA)
+		}
+	default:
+		return fmt.Errorf("invalid target type %s, must be one of: %s, %s", config.TargetType, targetTypeESXi, targetTypeVCSA)
+	}
+
+	// Validate how services pick up the new certificate (empty means a full restart)
+	switch config.ReloadMethod {
+	case "", reloadMethodRestart:
+	case reloadMethodReload:
+		if config.TargetType == targetTypeVCSA {
+			return fmt.Errorf("reload method %s only applies to ESXi hosts, not target type %s", reloadMethodReload, targetTypeVCSA)
+		}
+	default:
+		return fmt.Errorf("invalid reload method %s, must be one of: %s, %s", config.ReloadMethod, reloadMethodRestart, reloadMethodReload)
+	}
+
+	// Validate ACME challenge settings (empty means the default DNS-01 challenge)
+	switch config.ChallengeType {
+	case "", challengeTypeDNS01:
+	case challengeTypeHTTP01:
+		if config.HTTPChallengePort <= 0 || config.HTTPChallengePort > 65535 {
+			return fmt.Errorf("invalid HTTP challenge port %d, must be between 1 and 65535", config.HTTPChallengePort)
+		}
+	default:
+		return fmt.Errorf("invalid challenge type %s, must be one of: %s, %s", config.ChallengeType, challengeTypeDNS01, challengeTypeHTTP01)
+	}
+
+	if err := validateExtKeyUsages(config.ExtKeyUsages); err != nil {
+		return err
+	}
+
+	// Validate an imported PFX: it must decode with the password and carry the certificate's
+	// own key, and it bypasses everything that shapes an ACME order
+	if config.PFXFile != "" {
+		if config.CSRFile != "" || config.KeyFile != "" {
+			return fmt.Errorf("pfx-file cannot be used with csr-file or key-file")
+		}
+		if config.TestIssuance || config.ReuseKey || config.MustStaple || len(config.ExtKeyUsages) > 0 {
+			return fmt.Errorf("pfx-file cannot be used with test-issuance, reuse-key, must-staple or eku, as no certificate is ordered")
+		}
+		if _, _, _, err := loadPFXFile(config.PFXFile, config.PFXPassword); err != nil {
+			return err
+		}
+	}
+
+	// Validate a supplied CSR: it needs its private key, and fixes the key and extensions itself
+	if config.CSRFile != "" || config.KeyFile != "" {
+		if config.CSRFile == "" || config.KeyFile == "" {
+			return fmt.Errorf("csr-file and key-file must be provided together")
+		}
+		if config.ReuseKey {
+			return fmt.Errorf("reuse-key cannot be used with csr-file")
+		}
+		if config.MustStaple {
+			return fmt.Errorf("must-staple cannot be used with csr-file; add the extension to the CSR instead")
+		}
+		if len(config.ExtKeyUsages) > 0 {
+			return fmt.Errorf("eku cannot be used with csr-file; add the extension to the CSR instead")
+		}
+		if _, _, err := loadCSRWithKey(config); err != nil {
+			return err
+		}
+	}
+
+	// Without service management nothing talks to the SOAP API, so SOAP-only settings cannot apply
+	if config.NoServiceManagement {
+		if config.InstallMethod == installMethodSOAPCertMgr {
+			return fmt.Errorf("no-service-management cannot be used with install method %s", installMethodSOAPCertMgr)
+		}
+		if config.SOAPKeepAlive > 0 {
+			return fmt.Errorf("no-service-management cannot be used with soap-keepalive, as no SOAP session is opened")
+		}
+	}
+
+	// The pre-upload check runs over SSH, which the SOAP install path does not use
+	if config.PreuploadCheckCmd != "" && config.InstallMethod == installMethodSOAPCertMgr {
+		return fmt.Errorf("preupload-check-cmd cannot be used with install method %s", installMethodSOAPCertMgr)
+	}
+
+	// Validate certificate destinations, which are only written by the SSH install path
+	if len(config.Services) > 0 {
+		if config.InstallMethod == installMethodSOAPCertMgr {
+			return fmt.Errorf("services cannot be used with install method %s", installMethodSOAPCertMgr)
+		}
+		if err := validateServiceTargets(config.Services); err != nil {
+			return err
+		}
+	}
+
+	// Validate chain mode (empty means the default full chain)
+	switch config.ChainMode {
+	case "", chainModeFull, chainModeLeafOnly:
+	default:
+		return fmt.Errorf("invalid chain mode %s, must be one of: %s, %s", config.ChainMode, chainModeFull, chainModeLeafOnly)
+	}
+	if config.ChainMode == chainModeLeafOnly && config.CheckChain {
+		return fmt.Errorf("check-chain cannot be used with chain mode %s, as the host serves no intermediates to check", chainModeLeafOnly)
+	}
+
+	// Validate the trusted roots for chain checks up front rather than mid-run
+	if config.CABundle != "" {
+		if _, err := loadCABundle(config.CABundle); err != nil {
+			return err
+		}
+	}
+
+	// Validate IP version preference (empty means auto)
+	switch config.IPVersion {
+	case "", ipVersionAuto, ipVersion4, ipVersion6:
+	default:
+		return fmt.Errorf("invalid IP version %s, must be one of: %s, %s, %s", config.IPVersion, ipVersionAuto, ipVersion4, ipVersion6)
+	}
+
+	// Validate log output
+	if config.Quiet && config.StdoutOnly {
+		return fmt.Errorf("quiet and stdout-only cannot be used together")
+	}
+	if config.LogSyslog {
+		if err := validateSyslogFacility(config.SyslogFacility); err != nil {
+			return err
+		}
 	}
 
 	// Validate threshold
@@ -333,8 +1454,12 @@ func (cm *ConfigManager) ValidateConfig(config Config) error {
 		return fmt.Errorf("invalid threshold %.2f, must be between 0 and 1", config.Threshold)
 	}
 
+	if config.CacheReuseThreshold < 0 || config.CacheReuseThreshold >= 1 {
+		return fmt.Errorf("invalid cache reuse threshold %.2f, must be between 0 and 1", config.CacheReuseThreshold)
+	}
+
 	// Validate log level
-	validLogLevels := []string{"ERROR", "WARN", "INFO", "DEBUG"}
+	validLogLevels := []string{"ERROR", "WARN", "INFO", "DEBUG", "TRACE", "AUDIT"}
 	isValidLogLevel := false
 	upperLogLevel := strings.ToUpper(config.LogLevel)
 	for _, level := range validLogLevels {
@@ -350,10 +1475,140 @@ func (cm *ConfigManager) ValidateConfig(config Config) error {
 	return nil
 }
 
+// Hostname labels: alphanumerics and inner hyphens, at most 63 characters
+var hostnameLabelPattern = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?$`)
+
+// validateHostname accepts DNS names and IP literals, each with an optional port
+func validateHostname(hostname string) error {
+	host := hostname
+	if h, port, err := net.SplitHostPort(hostname); err == nil {
+		if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+			return fmt.Errorf("invalid hostname %s: port must be between 1 and 65535", hostname)
+		}
+		host = h
+	}
+
+	if net.ParseIP(strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")) != nil {
+		return nil
+	}
+
+	name := strings.TrimSuffix(host, ".")
+	if name == "" || len(name) > 253 {
+		return fmt.Errorf("invalid hostname %s: must be a DNS name or IP address", hostname)
+	}
+	for _, label := range strings.Split(name, ".") {
+		if !hostnameLabelPattern.MatchString(label) {
+			return fmt.Errorf("invalid hostname %s: must be a DNS name or IP address", hostname)
+		}
+	}
+	return nil
+}
+
+// validateEmailAddress checks the ACME registration email before any network work. The CA
+// rejects a domain without a dot, so a typo like admin@exmaple fails here instead.
+func validateEmailAddress(email string) error {
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return fmt.Errorf("invalid email %s, must be a plain address like admin@example.com", email)
+	}
+	domain := addr.Address[strings.LastIndex(addr.Address, "@")+1:]
+	if !strings.Contains(strings.Trim(domain, "."), ".") {
+		return fmt.Errorf("invalid email %s: domain %s is not a fully qualified domain name", email, domain)
+	}
+	return nil
+}
+
+// checkHostReachable makes a quick TCP connection to the host's HTTPS port (or the port in the hostname)
+func checkHostReachable(hostname string, timeout time.Duration) error {
+	address := hostname
+	if _, _, err := net.SplitHostPort(hostname); err != nil {
+		address = net.JoinHostPort(hostname, "443")
+	}
+
+	conn, err := net.DialTimeout(dialNetwork, address, timeout)
+	if err != nil {
+		return fmt.Errorf("host %s is not reachable: %v", address, err)
+	}
+	conn.Close()
+
+	logDebug("Host %s is reachable", address)
+	return nil
+}
+
 // PrintConfigSources prints the sources of all configuration values (for debugging)
 func (cm *ConfigManager) PrintConfigSources() {
 	logDebug("Configuration sources:")
-	for key, value := range cm.values {
-		logDebug("  %s: %v (from %s)", key, value.Value, value.Source)
+	for _, key := range cm.sortedKeys() {
+		value := cm.values[key]
+		logDebug("  %s: %s (from %s)", key, formatConfigValue(key, value.Value), value.Source)
+	}
+}
+
+// ShowConfig writes the effective merged configuration with the source of each value,
+// masking secrets so the output is safe to share
+func (cm *ConfigManager) ShowConfig(w io.Writer) {
+	fmt.Fprintln(w, "Effective configuration:")
+	for _, key := range cm.sortedKeys() {
+		value := cm.values[key]
+		fmt.Fprintf(w, "  %-20s = %s (from %s)\n", key, formatConfigValue(key, value.Value), value.Source)
+	}
+}
+
+// WhyConfig writes the resolved value of a single configuration key and the source it came
+// from, masking secrets. Flag-style names such as log-level are accepted too.
+func (cm *ConfigManager) WhyConfig(w io.Writer, key string) error {
+	key = strings.ReplaceAll(strings.ToLower(strings.TrimSpace(key)), "-", "_")
+	value, ok := cm.values[key]
+	if !ok {
+		return fmt.Errorf("%s is not set by any source (see -show-config for the configured keys)", key)
+	}
+	fmt.Fprintf(w, "%s = %s (from %s)\n", key, formatConfigValue(key, value.Value), value.Source)
+	return nil
+}
+
+// sortedKeys returns the configuration keys in a stable order for display
+func (cm *ConfigManager) sortedKeys() []string {
+	keys := make([]string, 0, len(cm.values))
+	for key := range cm.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Configuration keys whose values must never be displayed
+var secretConfigKeys = map[string]bool{
+	"aws_secret_key":    true,
+	"aws_session_token": true,
+	"esxi_password":     true,
+	"esxi_totp_secret":  true,
+	"pfx_password":      true,
+	"smtp_password":     true,
+}
+
+// formatConfigValue renders a configuration value for display, masking secrets
+func formatConfigValue(key string, value interface{}) string {
+	if secretConfigKeys[key] {
+		return maskSecret(fmt.Sprint(value))
+	}
+
+	if hosts, ok := value.([]HostConfig); ok {
+		rendered := make([]string, 0, len(hosts))
+		for _, host := range hosts {
+			host.ESXiPassword = maskSecret(host.ESXiPassword)
+			host.ESXiTOTPSecret = maskSecret(host.ESXiTOTPSecret)
+			rendered = append(rendered, fmt.Sprintf("%+v", host))
+		}
+		return "[" + strings.Join(rendered, " ") + "]"
+	}
+
+	return fmt.Sprintf("%v", value)
+}
+
+// maskSecret masks a secret value, leaving empty values visibly empty
+func maskSecret(secret string) string {
+	if secret == "" {
+		return ""
 	}
+	return maskPassword(secret)
 }
@@ -3,11 +3,16 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
+	"io"
 	"log"
+	"net"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"lab-update-esxi-cert/testutil"
 )
@@ -28,6 +33,12 @@ func TestConfigManager_LoadDefaults(t *testing.T) {
 		{"aws_region", "us-east-1", ConfigSourceDefault},
 		{"dry_run", false, ConfigSourceDefault},
 		{"force", false, ConfigSourceDefault},
+		{"ssh_stop_timeout", defaultSSHStopTimeout, ConfigSourceDefault},
+		{"install_method", installMethodSSH, ConfigSourceDefault},
+		{"chain_mode", chainModeFull, ConfigSourceDefault},
+		{"challenge_type", challengeTypeDNS01, ConfigSourceDefault},
+		{"http_challenge_port", defaultHTTPChallengePort, ConfigSourceDefault},
+		{"cache_lock_timeout", defaultCacheLockTimeout, ConfigSourceDefault},
 	}
 
 	for _, tt := range tests {
@@ -381,6 +392,9 @@ func TestConfigManager_TypeConversions(t *testing.T) {
 		{"CERT_KEY_SIZE", "2048", "key_size", 2048},
 		{"DRY_RUN", "true", "dry_run", true},
 		{"FORCE_RENEWAL", "false", "force", false},
+		{"TEST_ISSUANCE", "true", "test_issuance", true},
+		{"SSH_STOP_TIMEOUT", "45s", "ssh_stop_timeout", 45 * time.Second},
+		{"CACHE_LOCK_TIMEOUT", "2m", "cache_lock_timeout", 2 * time.Minute},
 	}
 
 	for _, tc := range testCases {
@@ -458,6 +472,9 @@ func buildConfigFromMap(configMap map[string]interface{}) Config {
 	if v, ok := configMap["esxi_password"].(string); ok {
 		config.ESXiPassword = v
 	}
+	if v, ok := configMap["insecure"].(bool); ok {
+		config.Insecure = v
+	}
 
 	// Set defaults for required fields if not present
 	if config.LogLevel == "" {
@@ -598,6 +615,141 @@ func TestConfigManager_ValidateConfig_EdgeCases(t *testing.T) {
 			},
 			shouldError: false,
 		},
+		{
+			name: "pfx file with csr file",
+			modifier: func(c *Config) {
+				c.PFXFile = "/etc/ssl/esxi01.pfx"
+				c.CSRFile = "/etc/ssl/esxi01.csr"
+				c.KeyFile = "/etc/ssl/esxi01.key"
+			},
+			shouldError: true,
+			errorPart:   "pfx-file cannot be used with csr-file or key-file",
+		},
+		{
+			name: "pfx file with key file",
+			modifier: func(c *Config) {
+				c.PFXFile = "/etc/ssl/esxi01.pfx"
+				c.KeyFile = "/etc/ssl/esxi01.key"
+			},
+			shouldError: true,
+			errorPart:   "pfx-file cannot be used with csr-file or key-file",
+		},
+		{
+			name: "missing pfx file",
+			modifier: func(c *Config) {
+				c.PFXFile = "/nonexistent/esxi01.pfx"
+			},
+			shouldError: true,
+			errorPart:   "failed to read PFX file",
+		},
+		{
+			name: "negative validate attempts",
+			modifier: func(c *Config) {
+				c.ValidateAttempts = -1
+			},
+			shouldError: true,
+			errorPart:   "validate attempts",
+		},
+		{
+			name: "S3 prefix without bucket",
+			modifier: func(c *Config) {
+				c.S3Prefix = "certs/"
+			},
+			shouldError: true,
+			errorPart:   "require s3-upload-bucket",
+		},
+		{
+			name: "S3 bucket given as URL",
+			modifier: func(c *Config) {
+				c.S3UploadBucket = "s3://pki-archive"
+			},
+			shouldError: true,
+			errorPart:   "invalid S3 bucket",
+		},
+		{
+			name: "strict S3 distribution",
+			modifier: func(c *Config) {
+				c.S3UploadBucket = "pki-archive"
+				c.S3Prefix = "certs/"
+				c.StrictDistribution = true
+			},
+			shouldError: false,
+		},
+		{
+			name: "negative DNS TTL",
+			modifier: func(c *Config) {
+				c.DNSTTL = -1
+			},
+			shouldError: true,
+			errorPart:   "invalid DNS TTL -1",
+		},
+		{
+			name: "skipped propagation check with fixed wait",
+			modifier: func(c *Config) {
+				c.DNSTTL = 10
+				c.DNSPropagationWait = 15 * time.Second
+				c.DNSSkipPropagation = true
+			},
+			shouldError: false,
+		},
+		{
+			name: "propagation check settings with HTTP-01",
+			modifier: func(c *Config) {
+				c.ChallengeType = challengeTypeHTTP01
+				c.HTTPChallengePort = 8080
+				c.DNSSkipPropagation = true
+			},
+			shouldError: true,
+			errorPart:   "only apply to the dns-01 challenge",
+		},
+		{
+			name: "unrecognized extended key usage",
+			modifier: func(c *Config) {
+				c.ExtKeyUsages = []string{"serverAuth", "emailProtection"}
+			},
+			shouldError: true,
+			errorPart:   "extended key usage",
+		},
+		{
+			name: "status file without schedule",
+			modifier: func(c *Config) {
+				c.StatusFile = "/var/run/esxi-cert.status.json"
+			},
+			shouldError: true,
+			errorPart:   "status-file requires schedule",
+		},
+		{
+			name: "healthz address without schedule",
+			modifier: func(c *Config) {
+				c.HealthzAddr = ":8080"
+			},
+			shouldError: true,
+			errorPart:   "healthz-addr requires schedule",
+		},
+		{
+			name: "invalid healthz address",
+			modifier: func(c *Config) {
+				c.Schedule = "@daily"
+				c.HealthzAddr = "8080"
+			},
+			shouldError: true,
+			errorPart:   "invalid healthz address 8080",
+		},
+		{
+			name: "blank expected issuer",
+			modifier: func(c *Config) {
+				c.ExpectedIssuers = []string{"Let's Encrypt", " "}
+			},
+			shouldError: true,
+			errorPart:   "expected issuer",
+		},
+		{
+			name: "audit log level accepted as trace",
+			modifier: func(c *Config) {
+				c.LogLevel = "audit"
+			},
+			shouldError: false,
+		},
 		{
 			name: "AWS key ID provided without secret",
 			modifier: func(c *Config) {
@@ -607,6 +759,15 @@ func TestConfigManager_ValidateConfig_EdgeCases(t *testing.T) {
 			shouldError: true,
 			errorPart:   "both AWS Access Key ID and Secret Access Key",
 		},
+		{
+			name: "AWS key ID provided with a blank secret",
+			modifier: func(c *Config) {
+				c.Route53KeyID = "AKIATEST123"
+				c.Route53SecretKey = "   "
+			},
+			shouldError: true,
+			errorPart:   "both AWS Access Key ID and Secret Access Key",
+		},
 		{
 			name: "AWS secret provided without key ID",
 			modifier: func(c *Config) {
@@ -617,123 +778,1124 @@ func TestConfigManager_ValidateConfig_EdgeCases(t *testing.T) {
 			errorPart:   "both AWS Access Key ID and Secret Access Key",
 		},
 		{
-			name: "both AWS credentials empty (should use default chain)",
+			name: "SMTP host without recipients",
 			modifier: func(c *Config) {
-				c.Route53KeyID = ""
-				c.Route53SecretKey = ""
+				c.SMTPHost = "smtp.example.com"
+				c.SMTPPort = 587
+				c.MailFrom = "esxi-cert@example.com"
+			},
+			shouldError: true,
+			errorPart:   "mail-from and mail-to",
+		},
+		{
+			name: "SMTP report fully configured",
+			modifier: func(c *Config) {
+				c.SMTPHost = "smtp.example.com"
+				c.SMTPPort = 587
+				c.MailFrom = "esxi-cert@example.com"
+				c.MailTo = "ops@example.com"
 			},
 			shouldError: false,
 		},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			// Start with a valid config
-			config := Config{
-				Hostname:         "test.example.com",
-				Domain:           "example.com",
-				Email:            "test@example.com",
-				Threshold:        0.33,
-				LogLevel:         "INFO",
-				Route53KeyID:     "AKIATEST123",
-				Route53SecretKey: "secret",
-				Route53Region:    "us-east-1",
-				KeySize:          4096,
-				ESXiUsername:     "root",
-				ESXiPassword:     "password",
-			}
-
-			// Apply the modifier
-			tt.modifier(&config)
-
-			err := cm.ValidateConfig(config)
-
-			if tt.shouldError && err == nil {
-				t.Errorf("Expected validation error for %s", tt.name)
-			}
-			if !tt.shouldError && err != nil {
-				t.Errorf("Expected validation to pass for %s, got error: %v", tt.name, err)
-			}
-			if tt.shouldError && err != nil && !strings.Contains(err.Error(), tt.errorPart) {
-				t.Errorf("Expected error to contain '%s', got: %v", tt.errorPart, err)
-			}
-		})
-	}
-}
-
-func TestConfigManager_PrintConfigSources(t *testing.T) {
-	// Capture log output
-	var buf bytes.Buffer
-	originalOutput := log.Writer()
-	log.SetOutput(&buf)
-	defer func() {
-		log.SetOutput(originalOutput)
-	}()
-
-	// Set currentLogLevel to DEBUG to capture debug output
-	originalLogLevel := currentLogLevel
-	currentLogLevel = LOG_DEBUG
-	defer func() {
-		currentLogLevel = originalLogLevel
-	}()
-
-	cm := NewConfigManager()
-	cm.Set("test_key1", "value1", ConfigSourceFlag)
-	cm.Set("test_key2", 42, ConfigSourceEnvVar)
-	cm.Set("test_key3", true, ConfigSourceConfigFile)
-
-	cm.PrintConfigSources()
-
-	output := buf.String()
-
-	// Verify output contains expected information
-	if !strings.Contains(output, "Configuration sources:") {
-		t.Error("Expected output to contain 'Configuration sources:'")
-	}
-	if !strings.Contains(output, "test_key1") {
-		t.Error("Expected output to contain test_key1")
-	}
-	if !strings.Contains(output, "command_line") {
-		t.Error("Expected output to contain command_line source")
-	}
-}
-
-func TestConfigManager_LoadConfigFile_JSONEdgeCases(t *testing.T) {
-	t.Run("config file with all zero values", func(t *testing.T) {
-		cm := NewConfigManager()
-		cm.LoadDefaults()
-
-		// Create a config file with explicit zero values
-		tempDir := t.TempDir()
-		configFile := filepath.Join(tempDir, "zero-values.json")
-
-		configData := map[string]interface{}{
-			"threshold": 0.0,   // Should NOT be loaded (zero value)
-			"key_size":  0,     // Should NOT be loaded (zero value)
-			"hostname":  "",    // Should NOT be loaded (empty string)
-			"dry_run":   false, // Should be loaded (explicit boolean)
-			"force":     false, // Should be loaded (explicit boolean)
-		}
-
-		data, _ := json.Marshal(configData)
-		os.WriteFile(configFile, data, 0644)
-
-		err := cm.LoadConfigFile(configFile)
-		if err != nil {
-			t.Fatalf("Failed to load config file: %v", err)
-		}
-
-		// Zero values should not override defaults (except booleans)
-		if cm.GetFloat64("threshold") != defaultThreshold {
-			t.Errorf("Expected default threshold, got %f", cm.GetFloat64("threshold"))
-		}
-		if cm.GetInt("key_size") != 4096 {
-			t.Errorf("Expected default key size, got %d", cm.GetInt("key_size"))
-		}
-
-		// Booleans should be loaded even if false
-		if source := cm.GetSource("dry_run"); source != ConfigSourceConfigFile {
-			t.Errorf("Expected dry_run to be loaded from config file, got source %s", source)
-		}
-	})
+		{
+			name: "soap-certmgr install method",
+			modifier: func(c *Config) {
+				c.InstallMethod = installMethodSOAPCertMgr
+			},
+			shouldError: false,
+		},
+		{
+			name: "services with SSH install",
+			modifier: func(c *Config) {
+				c.Services = []ServiceTarget{{CertPath: "/etc/vmware/ssl/rui.crt"}, {CertPath: "/etc/vmware/ssl/vasa.crt", RestartCommand: "/etc/init.d/vvold restart"}}
+			},
+			shouldError: false,
+		},
+		{
+			name: "services with soap-certmgr install",
+			modifier: func(c *Config) {
+				c.InstallMethod = installMethodSOAPCertMgr
+				c.Services = []ServiceTarget{{CertPath: "/etc/vmware/ssl/rui.crt"}}
+			},
+			shouldError: true,
+			errorPart:   "services cannot be used",
+		},
+		{
+			name: "services with relative path",
+			modifier: func(c *Config) {
+				c.Services = []ServiceTarget{{CertPath: "rui.crt"}}
+			},
+			shouldError: true,
+			errorPart:   "must be absolute",
+		},
+		{
+			name: "EC certificate and account key types",
+			modifier: func(c *Config) {
+				c.KeyType = "ec384"
+				c.AccountKeyType = "ec256"
+			},
+			shouldError: false,
+		},
+		{
+			name: "invalid key type",
+			modifier: func(c *Config) {
+				c.KeyType = "dsa1024"
+			},
+			shouldError: true,
+			errorPart:   "invalid key type dsa1024",
+		},
+		{
+			name: "invalid account key type",
+			modifier: func(c *Config) {
+				c.AccountKeyType = "rsa1024"
+			},
+			shouldError: true,
+			errorPart:   "account key: key type rsa1024 is not supported by Let's Encrypt",
+		},
+		{
+			name: "neither insecure nor CA bundle",
+			modifier: func(c *Config) {
+				c.Insecure = false
+			},
+			shouldError: true,
+			errorPart:   "requires -insecure",
+		},
+		{
+			name: "CA bundle instead of insecure",
+			modifier: func(c *Config) {
+				c.Insecure = false
+				c.CABundle = writeTestCABundle(t)
+			},
+			shouldError: false,
+		},
+		{
+			name: "test issuance needs neither",
+			modifier: func(c *Config) {
+				c.Insecure = false
+				c.TestIssuance = true
+			},
+			shouldError: false,
+		},
+		{
+			name: "invalid ESXi SSH port",
+			modifier: func(c *Config) {
+				c.ESXiSSHPort = 70000
+			},
+			shouldError: true,
+			errorPart:   "invalid ESXi SSH port 70000",
+		},
+		{
+			name: "email without domain",
+			modifier: func(c *Config) {
+				c.Email = "admin"
+			},
+			shouldError: true,
+			errorPart:   "invalid email admin",
+		},
+		{
+			name: "email with single-label domain",
+			modifier: func(c *Config) {
+				c.Email = "admin@exmaple"
+			},
+			shouldError: true,
+			errorPart:   "not a fully qualified domain name",
+		},
+		{
+			name: "email with display name",
+			modifier: func(c *Config) {
+				c.Email = "Admin <admin@example.com>"
+			},
+			shouldError: true,
+			errorPart:   "must be a plain address",
+		},
+		{
+			name: "invalid email ignored in dry-run",
+			modifier: func(c *Config) {
+				c.DryRun = true
+				c.Email = "admin@exmaple"
+			},
+			shouldError: false,
+		},
+		{
+			name: "invalid ACME contact",
+			modifier: func(c *Config) {
+				c.ACMEContacts = []string{"pki-team"}
+			},
+			shouldError: true,
+			errorPart:   "invalid ACME contact pki-team",
+		},
+		{
+			name: "pre-upload check with SOAP install",
+			modifier: func(c *Config) {
+				c.PreuploadCheckCmd = "df /etc"
+				c.InstallMethod = installMethodSOAPCertMgr
+			},
+			shouldError: true,
+			errorPart:   "preupload-check-cmd cannot be used with install method soap-certmgr",
+		},
+		{
+			name: "SOAP keepalive without schedule",
+			modifier: func(c *Config) {
+				c.SOAPKeepAlive = 10 * time.Minute
+			},
+			shouldError: true,
+			errorPart:   "soap-keepalive requires schedule",
+		},
+		{
+			name: "invalid schedule",
+			modifier: func(c *Config) {
+				c.Schedule = "every day"
+			},
+			shouldError: true,
+			errorPart:   "invalid schedule",
+		},
+		{
+			name: "pfx password without output",
+			modifier: func(c *Config) {
+				c.PFXPassword = "secret"
+			},
+			shouldError: true,
+			errorPart:   "pfx-password requires pfx-output",
+		},
+		{
+			name: "P-521 key type rejected by CA",
+			modifier: func(c *Config) {
+				c.KeyType = "ec521"
+			},
+			shouldError: true,
+			errorPart:   "key type ec521 is not supported by Let's Encrypt, which accepts: rsa2048, rsa3072, rsa4096, ec256, ec384",
+		},
+		{
+			name: "RSA 3072 key size",
+			modifier: func(c *Config) {
+				c.KeySize = 3072
+			},
+			shouldError: false,
+		},
+		{
+			name: "RSA 8192 key size rejected by CA",
+			modifier: func(c *Config) {
+				c.KeySize = 8192
+			},
+			shouldError: true,
+			errorPart:   "Let's Encrypt accepts RSA keys of 2048, 3072 or 4096 bits",
+		},
+		{
+			name: "IPv6 only",
+			modifier: func(c *Config) {
+				c.IPVersion = ipVersion6
+			},
+			shouldError: false,
+		},
+		{
+			name: "invalid IP version",
+			modifier: func(c *Config) {
+				c.IPVersion = "5"
+			},
+			shouldError: true,
+			errorPart:   "invalid IP version 5",
+		},
+		{
+			name: "csr file without key file",
+			modifier: func(c *Config) {
+				c.CSRFile = "/tmp/request.csr"
+			},
+			shouldError: true,
+			errorPart:   "csr-file and key-file must be provided together",
+		},
+		{
+			name: "csr file with reuse key",
+			modifier: func(c *Config) {
+				c.CSRFile = "/tmp/request.csr"
+				c.KeyFile = "/tmp/request.key"
+				c.ReuseKey = true
+			},
+			shouldError: true,
+			errorPart:   "reuse-key cannot be used with csr-file",
+		},
+		{
+			name: "quiet with stdout-only",
+			modifier: func(c *Config) {
+				c.Quiet = true
+				c.StdoutOnly = true
+			},
+			shouldError: true,
+			errorPart:   "quiet and stdout-only cannot be used together",
+		},
+		{
+			name: "invalid install method",
+			modifier: func(c *Config) {
+				c.InstallMethod = "ftp"
+			},
+			shouldError: true,
+			errorPart:   "invalid install method",
+		},
+		{
+			name: "valid TOTP secret",
+			modifier: func(c *Config) {
+				c.ESXiTOTPSecret = "JBSWY3DPEHPK3PXP"
+			},
+			shouldError: false,
+		},
+		{
+			name: "invalid TOTP secret",
+			modifier: func(c *Config) {
+				c.ESXiTOTPSecret = "not-base32!"
+			},
+			shouldError: true,
+			errorPart:   "TOTP secret",
+		},
+		{
+			name: "custom AWS endpoint",
+			modifier: func(c *Config) {
+				c.AWSEndpoint = "http://localhost:4566"
+			},
+			shouldError: false,
+		},
+		{
+			name: "AWS endpoint without scheme",
+			modifier: func(c *Config) {
+				c.AWSEndpoint = "localhost:4566"
+			},
+			shouldError: true,
+			errorPart:   "invalid AWS endpoint",
+		},
+		{
+			name:        "ACME profile",
+			modifier:    func(c *Config) { c.ACMEProfile = "shortlived" },
+			shouldError: false,
+		},
+		{
+			name:        "blank ACME profile",
+			modifier:    func(c *Config) { c.ACMEProfile = "  " },
+			shouldError: true,
+			errorPart:   "invalid ACME profile",
+		},
+		{
+			name:        "preferred chain",
+			modifier:    func(c *Config) { c.PreferredChain = "ISRG Root X1" },
+			shouldError: false,
+		},
+		{
+			name:        "blank preferred chain",
+			modifier:    func(c *Config) { c.PreferredChain = " " },
+			shouldError: true,
+			errorPart:   "invalid preferred chain",
+		},
+		{
+			name: "print commands without dry run",
+			modifier: func(c *Config) {
+				c.PrintCommands = true
+				c.DryRun = false
+			},
+			shouldError: true,
+			errorPart:   "print-commands requires dry-run",
+		},
+		{
+			name:        "vCenter appliance target",
+			modifier:    func(c *Config) { c.TargetType = targetTypeVCSA },
+			shouldError: false,
+		},
+		{
+			name:        "invalid target type",
+			modifier:    func(c *Config) { c.TargetType = "nsx" },
+			shouldError: true,
+			errorPart:   "invalid target type",
+		},
+		{
+			name: "vCenter appliance with SOAP install method",
+			modifier: func(c *Config) {
+				c.TargetType = targetTypeVCSA
+				c.InstallMethod = installMethodSOAPCertMgr
+			},
+			shouldError: true,
+			errorPart:   "cannot be used with target type vcsa",
+		},
+		{
+			name:        "no service management",
+			modifier:    func(c *Config) { c.NoServiceManagement = true },
+			shouldError: false,
+		},
+		{
+			name: "no service management with SOAP install method",
+			modifier: func(c *Config) {
+				c.NoServiceManagement = true
+				c.InstallMethod = installMethodSOAPCertMgr
+			},
+			shouldError: true,
+			errorPart:   "no-service-management cannot be used",
+		},
+		{
+			name:        "negative AWS timeout",
+			modifier:    func(c *Config) { c.AWSTimeout = -time.Second },
+			shouldError: true,
+			errorPart:   "invalid AWS timeout",
+		},
+		{
+			name:        "valid Route53 zone ID",
+			modifier:    func(c *Config) { c.Route53ZoneID = "Z0123456789ABCDEFGHIJ" },
+			shouldError: false,
+		},
+		{
+			name: "Route53 zone ID with HTTP-01 challenge",
+			modifier: func(c *Config) {
+				c.Route53ZoneID = "Z0123456789ABCDEFGHIJ"
+				c.ChallengeType = challengeTypeHTTP01
+			},
+			shouldError: true,
+			errorPart:   "route53 zone ID cannot be used",
+		},
+		{
+			name:        "Route53 profile",
+			modifier:    func(c *Config) { c.Route53Profile = "dns-only" },
+			shouldError: false,
+		},
+		{
+			name:        "Route53 profile with whitespace",
+			modifier:    func(c *Config) { c.Route53Profile = "dns only" },
+			shouldError: true,
+			errorPart:   "invalid Route53 profile",
+		},
+		{
+			name: "Route53 profile with HTTP-01",
+			modifier: func(c *Config) {
+				c.Route53Profile = "dns-only"
+				c.ChallengeType = challengeTypeHTTP01
+			},
+			shouldError: true,
+			errorPart:   "route53 profile cannot be used",
+		},
+		{
+			name: "assume role with external ID",
+			modifier: func(c *Config) {
+				c.AWSAssumeRoleArn = "arn:aws:iam::123456789012:role/dns-manager"
+				c.AWSExternalID = "lab-external-id"
+			},
+			shouldError: false,
+		},
+		{
+			name: "invalid role ARN",
+			modifier: func(c *Config) {
+				c.AWSAssumeRoleArn = "dns-manager"
+			},
+			shouldError: true,
+			errorPart:   "invalid AWS role ARN",
+		},
+		{
+			name: "external ID without role ARN",
+			modifier: func(c *Config) {
+				c.AWSExternalID = "lab-external-id"
+			},
+			shouldError: true,
+			errorPart:   "requires aws-assume-role-arn",
+		},
+		{
+			name: "leaf-only chain mode",
+			modifier: func(c *Config) {
+				c.ChainMode = chainModeLeafOnly
+			},
+			shouldError: false,
+		},
+		{
+			name: "invalid chain mode",
+			modifier: func(c *Config) {
+				c.ChainMode = "intermediates"
+			},
+			shouldError: true,
+			errorPart:   "invalid chain mode",
+		},
+		{
+			name: "check chain with leaf-only chain mode",
+			modifier: func(c *Config) {
+				c.ChainMode = chainModeLeafOnly
+				c.CheckChain = true
+			},
+			shouldError: true,
+			errorPart:   "check-chain cannot be used with chain mode leaf-only",
+		},
+		{
+			name: "certbot output layout",
+			modifier: func(c *Config) {
+				c.OutputDir = "/etc/esxi-cert"
+				c.OutputLayout = outputLayoutCertbot
+			},
+			shouldError: false,
+		},
+		{
+			name: "certbot output layout without output dir",
+			modifier: func(c *Config) {
+				c.OutputLayout = outputLayoutCertbot
+			},
+			shouldError: true,
+			errorPart:   "requires output-dir",
+		},
+		{
+			name: "invalid output layout",
+			modifier: func(c *Config) {
+				c.OutputDir = "/etc/esxi-cert"
+				c.OutputLayout = "acme.sh"
+			},
+			shouldError: true,
+			errorPart:   "invalid output layout",
+		},
+		{
+			name: "HTTP-01 challenge without domain",
+			modifier: func(c *Config) {
+				c.ChallengeType = challengeTypeHTTP01
+				c.HTTPChallengePort = 8080
+				c.Domain = ""
+			},
+			shouldError: false,
+		},
+		{
+			name: "HTTP-01 challenge with invalid port",
+			modifier: func(c *Config) {
+				c.ChallengeType = challengeTypeHTTP01
+				c.HTTPChallengePort = 0
+			},
+			shouldError: true,
+			errorPart:   "invalid HTTP challenge port",
+		},
+		{
+			name: "negative min remaining after renew days",
+			modifier: func(c *Config) {
+				c.MinRemainingDays = -1
+			},
+			shouldError: true,
+			errorPart:   "invalid min remaining after renew days",
+		},
+		{
+			name: "negative min issuance interval",
+			modifier: func(c *Config) {
+				c.MinIssuanceInterval = -time.Minute
+			},
+			shouldError: true,
+			errorPart:   "invalid min issuance interval",
+		},
+		{
+			name: "cache reuse threshold of 1",
+			modifier: func(c *Config) {
+				c.CacheReuseThreshold = 1
+			},
+			shouldError: true,
+			errorPart:   "invalid cache reuse threshold",
+		},
+		{
+			name: "malformed label key",
+			modifier: func(c *Config) {
+				c.Labels = []string{"data-center=syd1"}
+			},
+			shouldError: true,
+			errorPart:   "invalid label key",
+		},
+		{
+			name: "invalid protected pattern",
+			modifier: func(c *Config) {
+				c.ProtectedPattern = "prod("
+			},
+			shouldError: true,
+			errorPart:   "invalid protected pattern",
+		},
+		{
+			name: "DNS pre-flight with HTTP-01",
+			modifier: func(c *Config) {
+				c.ChallengeType = challengeTypeHTTP01
+				c.DNSPreflight = true
+			},
+			shouldError: true,
+			errorPart:   "dns-preflight only applies",
+		},
+		{
+			name: "validate max interval shorter than the initial interval",
+			modifier: func(c *Config) {
+				c.ValidateInterval = time.Minute
+				c.ValidateMaxInterval = 30 * time.Second
+			},
+			shouldError: true,
+			errorPart:   "invalid validate max interval",
+		},
+		{
+			name: "invalid reload method",
+			modifier: func(c *Config) {
+				c.ReloadMethod = "sighup"
+			},
+			shouldError: true,
+			errorPart:   "invalid reload method",
+		},
+		{
+			name: "invalid challenge type",
+			modifier: func(c *Config) {
+				c.ChallengeType = "tls-alpn-01"
+			},
+			shouldError: true,
+			errorPart:   "invalid challenge type",
+		},
+		{
+			name: "test issuance without ESXi credentials",
+			modifier: func(c *Config) {
+				c.TestIssuance = true
+				c.ESXiUsername = ""
+				c.ESXiPassword = ""
+			},
+			shouldError: false,
+		},
+		{
+			name: "test issuance with dry run",
+			modifier: func(c *Config) {
+				c.TestIssuance = true
+				c.DryRun = true
+			},
+			shouldError: true,
+			errorPart:   "test-issuance and dry-run",
+		},
+		{
+			name: "both AWS credentials empty (should use default chain)",
+			modifier: func(c *Config) {
+				c.Route53KeyID = ""
+				c.Route53SecretKey = ""
+			},
+			shouldError: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Start with a valid config
+			config := Config{
+				Hostname:         "test.example.com",
+				Domain:           "example.com",
+				Email:            "test@example.com",
+				Threshold:        0.33,
+				LogLevel:         "INFO",
+				Route53KeyID:     "AKIATEST123",
+				Route53SecretKey: "secret",
+				Route53Region:    "us-east-1",
+				KeySize:          4096,
+				ESXiUsername:     "root",
+				ESXiPassword:     "password",
+				Insecure:         true,
+			}
+
+			// Apply the modifier
+			tt.modifier(&config)
+
+			err := cm.ValidateConfig(config)
+
+			if tt.shouldError && err == nil {
+				t.Errorf("Expected validation error for %s", tt.name)
+			}
+			if !tt.shouldError && err != nil {
+				t.Errorf("Expected validation to pass for %s, got error: %v", tt.name, err)
+			}
+			if tt.shouldError && err != nil && !strings.Contains(err.Error(), tt.errorPart) {
+				t.Errorf("Expected error to contain '%s', got: %v", tt.errorPart, err)
+			}
+		})
+	}
+}
+
+func TestConfigManager_PrintConfigSources(t *testing.T) {
+	// Capture log output
+	var buf bytes.Buffer
+	originalOutput := log.Writer()
+	log.SetOutput(&buf)
+	defer func() {
+		log.SetOutput(originalOutput)
+	}()
+
+	// Set currentLogLevel to DEBUG to capture debug output
+	originalLogLevel := currentLogLevel
+	currentLogLevel = LOG_DEBUG
+	defer func() {
+		currentLogLevel = originalLogLevel
+	}()
+
+	cm := NewConfigManager()
+	cm.Set("test_key1", "value1", ConfigSourceFlag)
+	cm.Set("test_key2", 42, ConfigSourceEnvVar)
+	cm.Set("test_key3", true, ConfigSourceConfigFile)
+
+	cm.PrintConfigSources()
+
+	output := buf.String()
+
+	// Verify output contains expected information
+	if !strings.Contains(output, "Configuration sources:") {
+		t.Error("Expected output to contain 'Configuration sources:'")
+	}
+	if !strings.Contains(output, "test_key1") {
+		t.Error("Expected output to contain test_key1")
+	}
+	if !strings.Contains(output, "command_line") {
+		t.Error("Expected output to contain command_line source")
+	}
+}
+
+func TestConfigManager_LoadConfigFile_JSONEdgeCases(t *testing.T) {
+	t.Run("config file with all zero values", func(t *testing.T) {
+		cm := NewConfigManager()
+		cm.LoadDefaults()
+
+		// Create a config file with explicit zero values
+		tempDir := t.TempDir()
+		configFile := filepath.Join(tempDir, "zero-values.json")
+
+		configData := map[string]interface{}{
+			"threshold": 0.0,   // Should NOT be loaded (zero value)
+			"key_size":  0,     // Should NOT be loaded (zero value)
+			"hostname":  "",    // Should NOT be loaded (empty string)
+			"dry_run":   false, // Should be loaded (explicit boolean)
+			"force":     false, // Should be loaded (explicit boolean)
+		}
+
+		data, _ := json.Marshal(configData)
+		os.WriteFile(configFile, data, 0644)
+
+		err := cm.LoadConfigFile(configFile)
+		if err != nil {
+			t.Fatalf("Failed to load config file: %v", err)
+		}
+
+		// Zero values should not override defaults (except booleans)
+		if cm.GetFloat64("threshold") != defaultThreshold {
+			t.Errorf("Expected default threshold, got %f", cm.GetFloat64("threshold"))
+		}
+		if cm.GetInt("key_size") != 4096 {
+			t.Errorf("Expected default key size, got %d", cm.GetInt("key_size"))
+		}
+
+		// Booleans should be loaded even if false
+		if source := cm.GetSource("dry_run"); source != ConfigSourceConfigFile {
+			t.Errorf("Expected dry_run to be loaded from config file, got source %s", source)
+		}
+	})
+}
+
+func TestConfigManager_LoadConfigFile_SSHStopTimeout(t *testing.T) {
+	tempDir := t.TempDir()
+
+	t.Run("valid duration", func(t *testing.T) {
+		cm := NewConfigManager()
+		cm.LoadDefaults()
+
+		configFile := filepath.Join(tempDir, "valid-timeout.json")
+		os.WriteFile(configFile, []byte(`{"ssh_stop_timeout": "90s"}`), 0644)
+
+		if err := cm.LoadConfigFile(configFile); err != nil {
+			t.Fatalf("Failed to load config file: %v", err)
+		}
+		if d := cm.GetDuration("ssh_stop_timeout"); d != 90*time.Second {
+			t.Errorf("Expected ssh_stop_timeout 90s, got %s", d)
+		}
+	})
+
+	t.Run("invalid duration", func(t *testing.T) {
+		cm := NewConfigManager()
+		configFile := filepath.Join(tempDir, "invalid-timeout.json")
+		os.WriteFile(configFile, []byte(`{"ssh_stop_timeout": "soon"}`), 0644)
+
+		if err := cm.LoadConfigFile(configFile); err == nil {
+			t.Error("Expected error for invalid ssh_stop_timeout")
+		}
+	})
+}
+
+func TestConfigManager_LoadConfigFile_Stdin(t *testing.T) {
+	defer func(r io.Reader) { configStdin = r }(configStdin)
+
+	t.Run("valid JSON", func(t *testing.T) {
+		configStdin = strings.NewReader(`{"hostname": "piped.example.com", "threshold": 0.5}`)
+		cm := NewConfigManager()
+		cm.LoadDefaults()
+
+		if err := cm.LoadConfigFile("-"); err != nil {
+			t.Fatalf("Failed to load config from stdin: %v", err)
+		}
+		if hostname := cm.GetString("hostname"); hostname != "piped.example.com" {
+			t.Errorf("Expected hostname from stdin, got %q", hostname)
+		}
+		if value := cm.values["threshold"]; value.Source != ConfigSourceConfigFile || value.Value != 0.5 {
+			t.Errorf("Expected threshold 0.5 from config file source, got %+v", value)
+		}
+	})
+
+	t.Run("invalid JSON", func(t *testing.T) {
+		configStdin = strings.NewReader(`{"hostname":`)
+		cm := NewConfigManager()
+
+		err := cm.LoadConfigFile("-")
+		if err == nil || !strings.Contains(err.Error(), "<stdin>") {
+			t.Errorf("Expected parse error naming stdin, got %v", err)
+		}
+	})
+}
+
+func TestConfigManager_ACMEContacts(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "contacts.json")
+	os.WriteFile(configFile, []byte(`{"acme_contacts": ["pki-team@example.com", "oncall@example.com"], "acme_user_agent": "example-lab/1.0"}`), 0644)
+
+	cm := NewConfigManager()
+	cm.LoadDefaults()
+	if err := cm.LoadConfigFile(configFile); err != nil {
+		t.Fatalf("Failed to load config file: %v", err)
+	}
+
+	config := cm.BuildConfig()
+	if !reflect.DeepEqual(config.ACMEContacts, []string{"pki-team@example.com", "oncall@example.com"}) {
+		t.Errorf("Unexpected ACME contacts: %v", config.ACMEContacts)
+	}
+	if config.ACMEUserAgent != "example-lab/1.0" {
+		t.Errorf("Unexpected ACME user agent: %q", config.ACMEUserAgent)
+	}
+
+	// Flags override the file's list as a whole
+	cm.Set("acme_contacts", "security@example.com", ConfigSourceFlag)
+	if contacts := cm.BuildConfig().ACMEContacts; !reflect.DeepEqual(contacts, []string{"security@example.com"}) {
+		t.Errorf("Expected flag contacts to replace the file's, got %v", contacts)
+	}
+}
+
+func TestConfigManager_LoadConfigFile_ExpandEnv(t *testing.T) {
+	tempDir := t.TempDir()
+	t.Setenv("TEST_ESXI_PASSWORD", "from-env")
+	t.Setenv("TEST_HOST_PASSWORD", "host-from-env")
+
+	contents := `{
+		"esxi_password": "${TEST_ESXI_PASSWORD}",
+		"smtp_password": "pa$$word",
+		"hosts": [{"hostname": "esxi01.lab.example.com", "esxi_password": "$TEST_HOST_PASSWORD"}]%s
+	}`
+
+	tests := []struct {
+		name         string
+		fileOption   string
+		flag         bool
+		wantPassword string
+		wantSMTP     string
+		wantHost     string
+	}{
+		{"disabled by default", "", false, "${TEST_ESXI_PASSWORD}", "pa$$word", "$TEST_HOST_PASSWORD"},
+		{"enabled in config file", `, "expand_env": true`, false, "from-env", "pa$word", "host-from-env"},
+		{"enabled by flag", "", true, "from-env", "pa$word", "host-from-env"},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cm := NewConfigManager()
+			cm.LoadDefaults()
+			if tt.flag {
+				cm.Set("expand_env", true, ConfigSourceFlag)
+			}
+
+			configFile := filepath.Join(tempDir, fmt.Sprintf("expand-%d.json", i))
+			os.WriteFile(configFile, []byte(fmt.Sprintf(contents, tt.fileOption)), 0644)
+			if err := cm.LoadConfigFile(configFile); err != nil {
+				t.Fatalf("Failed to load config file: %v", err)
+			}
+
+			config := cm.BuildConfig()
+			if config.ESXiPassword != tt.wantPassword {
+				t.Errorf("Expected esxi_password %q, got %q", tt.wantPassword, config.ESXiPassword)
+			}
+			if config.SMTPPassword != tt.wantSMTP {
+				t.Errorf("Expected smtp_password %q, got %q", tt.wantSMTP, config.SMTPPassword)
+			}
+			if config.Hosts[0].ESXiPassword != tt.wantHost {
+				t.Errorf("Expected host esxi_password %q, got %q", tt.wantHost, config.Hosts[0].ESXiPassword)
+			}
+		})
+	}
+}
+
+func TestConfigManager_LoadConfigFile_Hosts(t *testing.T) {
+	tempDir := t.TempDir()
+	configFile := filepath.Join(tempDir, "hosts.json")
+	os.WriteFile(configFile, []byte(`{
+		"domain": "lab.example.com",
+		"email": "admin@example.com",
+		"esxi_username": "root",
+		"esxi_password": "global-pass",
+		"insecure": true,
+		"hosts": [
+			{"hostname": "esxi01.lab.example.com"},
+			{"hostname": "esxi02.lab.example.com", "threshold": 0.5, "key_size": 2048, "esxi_password": "host-pass"},
+			{"hostname": "vcsa.lab.example.com", "target_type": "vcsa"}
+		]
+	}`), 0644)
+
+	cm := NewConfigManager()
+	cm.LoadDefaults()
+	if err := cm.LoadConfigFile(configFile); err != nil {
+		t.Fatalf("Failed to load config file: %v", err)
+	}
+
+	config := cm.BuildConfig()
+	if len(config.Hosts) != 3 {
+		t.Fatalf("Expected 3 hosts, got %d", len(config.Hosts))
+	}
+	if err := cm.ValidateConfig(config); err != nil {
+		t.Errorf("Expected hosts config to be valid, got: %v", err)
+	}
+
+	first := config.ForHost(config.Hosts[0])
+	if first.Hostname != "esxi01.lab.example.com" || first.Threshold != defaultThreshold || first.KeySize != 4096 || first.ESXiPassword != "global-pass" {
+		t.Errorf("Expected first host to inherit global settings, got %+v", first)
+	}
+
+	second := config.ForHost(config.Hosts[1])
+	if second.Threshold != 0.5 || second.KeySize != 2048 || second.ESXiPassword != "host-pass" {
+		t.Errorf("Expected second host overrides to apply, got %+v", second)
+	}
+	if second.ESXiUsername != "root" || second.Domain != "lab.example.com" {
+		t.Errorf("Expected second host to inherit non-overridden settings, got %+v", second)
+	}
+	if second.Hosts != nil {
+		t.Error("Expected per-host config to have no hosts list")
+	}
+
+	if first.TargetType != targetTypeESXi || config.ForHost(config.Hosts[2]).TargetType != targetTypeVCSA {
+		t.Errorf("Expected per-host target type override, got %s and %s", first.TargetType, config.ForHost(config.Hosts[2]).TargetType)
+	}
+}
+
+func TestConfigManager_LoadConfigFile_Services(t *testing.T) {
+	tempDir := t.TempDir()
+	configFile := filepath.Join(tempDir, "services.json")
+	os.WriteFile(configFile, []byte(`{
+		"services": [
+			{"cert_path": "/etc/vmware/ssl/rui.crt", "key_path": "/etc/vmware/ssl/rui.key"},
+			{"cert_path": "/etc/vmware/ssl/vasa.crt", "restart_command": "/etc/init.d/vvold restart"}
+		]
+	}`), 0644)
+
+	cm := NewConfigManager()
+	cm.LoadDefaults()
+	if err := cm.LoadConfigFile(configFile); err != nil {
+		t.Fatalf("Failed to load config file: %v", err)
+	}
+
+	config := cm.BuildConfig()
+	if len(config.Services) != 2 {
+		t.Fatalf("Expected 2 services, got %d", len(config.Services))
+	}
+	if config.Services[1].RestartCommand != "/etc/init.d/vvold restart" || config.Services[1].ResolvedKeyPath() != "/etc/vmware/ssl/vasa.key" {
+		t.Errorf("Unexpected second service: %+v", config.Services[1])
+	}
+	if cm.GetSource("services") != ConfigSourceConfigFile {
+		t.Errorf("Expected services source to be config file, got %s", cm.GetSource("services"))
+	}
+}
+
+func TestConfigManager_ValidateConfig_Hosts(t *testing.T) {
+	baseConfig := func() Config {
+		return Config{
+			Domain:       "lab.example.com",
+			Email:        "admin@example.com",
+			Threshold:    0.33,
+			LogLevel:     "INFO",
+			KeySize:      4096,
+			ESXiUsername: "root",
+			ESXiPassword: "password",
+			Insecure:     true,
+		}
+	}
+
+	tests := []struct {
+		name        string
+		hosts       []HostConfig
+		expectError string
+	}{
+		{
+			name:  "valid hosts without top-level hostname",
+			hosts: []HostConfig{{Hostname: "esxi01.lab.example.com"}, {Hostname: "esxi02.lab.example.com", Threshold: 0.5}},
+		},
+		{
+			name:        "missing hostname",
+			hosts:       []HostConfig{{Hostname: "esxi01.lab.example.com"}, {Threshold: 0.5}},
+			expectError: "hosts[1]: hostname is required",
+		},
+		{
+			name:        "duplicate hostname",
+			hosts:       []HostConfig{{Hostname: "esxi01.lab.example.com"}, {Hostname: "ESXI01.lab.example.com"}},
+			expectError: "duplicate hostname",
+		},
+		{
+			name:        "invalid threshold override",
+			hosts:       []HostConfig{{Hostname: "esxi01.lab.example.com", Threshold: 1.5}},
+			expectError: "host esxi01.lab.example.com: invalid threshold",
+		},
+		{
+			name:        "invalid key size override",
+			hosts:       []HostConfig{{Hostname: "esxi01.lab.example.com", KeySize: 1024}},
+			expectError: "invalid key size 1024",
+		},
+	}
+
+	cm := NewConfigManager()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := baseConfig()
+			config.Hosts = tt.hosts
+
+			err := cm.ValidateConfig(config)
+			if tt.expectError == "" {
+				if err != nil {
+					t.Errorf("Expected no error, got: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.expectError) {
+				t.Errorf("Expected error containing %q, got: %v", tt.expectError, err)
+			}
+		})
+	}
+}
+
+func TestConfigManager_WhyConfig(t *testing.T) {
+	cm := NewConfigManager()
+	cm.LoadDefaults()
+	cm.Set("threshold", 0.5, ConfigSourceEnvVar)
+	cm.Set("esxi_password", "super-secret", ConfigSourceFlag)
+
+	tests := []struct {
+		key      string
+		expected string
+	}{
+		{"threshold", "threshold = 0.5 (from environment)\n"},
+		{"log-level", "log_level = INFO (from default)\n"},
+		{"ESXI_PASSWORD", "esxi_password = ************ (from command_line)\n"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := cm.WhyConfig(&buf, tt.key); err != nil {
+			t.Errorf("WhyConfig(%q) error = %v", tt.key, err)
+		}
+		if buf.String() != tt.expected {
+			t.Errorf("WhyConfig(%q) = %q, want %q", tt.key, buf.String(), tt.expected)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := cm.WhyConfig(&buf, "hostname"); err == nil || !strings.Contains(err.Error(), "not set") {
+		t.Errorf("Expected an error for an unset key, got %v", err)
+	}
+}
+
+func TestConfigManager_ShowConfig(t *testing.T) {
+	cm := NewConfigManager()
+	cm.LoadDefaults()
+	cm.Set("hostname", "esxi01.example.com", ConfigSourceFlag)
+	cm.Set("threshold", 0.5, ConfigSourceConfigFile)
+	cm.Set("esxi_password", "super-secret", ConfigSourceEnvVar)
+	cm.Set("aws_secret_key", "aws-secret-value", ConfigSourceConfigFile)
+	cm.Set("aws_session_token", "session-token-value", ConfigSourceEnvVar)
+	cm.Set("hosts", []HostConfig{{Hostname: "esxi02.example.com", ESXiPassword: "host-secret"}}, ConfigSourceConfigFile)
+
+	var buf bytes.Buffer
+	cm.ShowConfig(&buf)
+	output := buf.String()
+
+	expected := []string{
+		"hostname             = esxi01.example.com (from command_line)",
+		"threshold            = 0.5 (from config_file)",
+		"esxi_password        = ************ (from environment)",
+		"key_size             = 4096 (from default)",
+		"esxi02.example.com",
+	}
+	for _, part := range expected {
+		if !strings.Contains(output, part) {
+			t.Errorf("Expected output to contain %q, got:\n%s", part, output)
+		}
+	}
+
+	for _, secret := range []string{"super-secret", "aws-secret-value", "session-token-value", "host-secret"} {
+		if strings.Contains(output, secret) {
+			t.Errorf("Expected secret %q to be masked, got:\n%s", secret, output)
+		}
+	}
+}
+
+func TestValidateHostname(t *testing.T) {
+	tests := []struct {
+		hostname string
+		valid    bool
+	}{
+		{"esxi01.lab.example.com", true},
+		{"esxi01.lab.example.com.", true},
+		{"esxi01", true},
+		{"esxi01.lab.example.com:8443", true},
+		{"192.168.1.10", true},
+		{"192.168.1.10:443", true},
+		{"::1", true},
+		{"[::1]", true},
+		{"[fe80::1]:443", true},
+		{"esxi_01.example.com", false},
+		{"-esxi.example.com", false},
+		{"esxi..example.com", false},
+		{"https://esxi01.example.com", false},
+		{"esxi01.example.com/sdk", false},
+		{"esxi01.example.com:0", false},
+		{"esxi01.example.com:99999", false},
+		{"esxi 01.example.com", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.hostname, func(t *testing.T) {
+			err := validateHostname(tt.hostname)
+			if tt.valid && err != nil {
+				t.Errorf("Expected %s to be valid, got: %v", tt.hostname, err)
+			}
+			if !tt.valid && err == nil {
+				t.Errorf("Expected %s to be rejected", tt.hostname)
+			}
+		})
+	}
+}
+
+func TestCheckHostReachable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	address := listener.Addr().String()
+
+	if err := checkHostReachable(address, time.Second); err != nil {
+		t.Errorf("Expected %s to be reachable, got: %v", address, err)
+	}
+
+	listener.Close()
+	if err := checkHostReachable(address, time.Second); err == nil {
+		t.Errorf("Expected %s to be unreachable after closing the listener", address)
+	}
+}
+
+func TestConfigManager_LoadConfigFile_CheckUpdates(t *testing.T) {
+	tempDir := t.TempDir()
+
+	tests := []struct {
+		name     string
+		content  string
+		expected bool
+	}{
+		{"omitted keeps the default", `{"hostname": "esxi01.example.com"}`, true},
+		{"explicitly disabled", `{"check_updates": false}`, false},
+		{"explicitly enabled", `{"check_updates": true}`, true},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cm := NewConfigManager()
+			cm.LoadDefaults()
+
+			configFile := filepath.Join(tempDir, fmt.Sprintf("check-updates-%d.json", i))
+			os.WriteFile(configFile, []byte(tt.content), 0644)
+			if err := cm.LoadConfigFile(configFile); err != nil {
+				t.Fatalf("Failed to load config file: %v", err)
+			}
+
+			if got := cm.BuildConfig().CheckUpdates; got != tt.expected {
+				t.Errorf("Expected CheckUpdates %v, got %v", tt.expected, got)
+			}
+		})
+	}
 }
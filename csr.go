@@ -0,0 +1,186 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+)
+
+// loadCSRFile reads a certificate signing request in PEM or DER form and checks its signature
+func loadCSRFile(path string) (*x509.CertificateRequest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSR file: %v", err)
+	}
+
+	der := data
+	if block, _ := pem.Decode(data); block != nil {
+		if !strings.Contains(block.Type, "CERTIFICATE REQUEST") {
+			return nil, fmt.Errorf("CSR file %s contains a %s block, expected CERTIFICATE REQUEST", path, block.Type)
+		}
+		der = block.Bytes
+	}
+
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CSR file %s: %v", path, err)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("CSR file %s has an invalid signature: %v", path, err)
+	}
+
+	return csr, nil
+}
+
+// loadKeyFile reads the PEM private key that belongs to a supplied CSR
+func loadKeyFile(path string) (crypto.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %v", err)
+	}
+
+	key, err := certcrypto.ParsePEMPrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse key file %s: %v", path, err)
+	}
+	return key, nil
+}
+
+// csrNames returns the DNS names a CSR requests, including a common name not repeated in the SANs
+func csrNames(csr *x509.CertificateRequest) []string {
+	names := append([]string{}, csr.DNSNames...)
+	if cn := csr.Subject.CommonName; cn != "" {
+		for _, name := range names {
+			if strings.EqualFold(name, cn) {
+				return names
+			}
+		}
+		names = append([]string{cn}, names...)
+	}
+	return names
+}
+
+// loadCSRWithKey loads the CSR and key files and checks that they belong together and cover the hostname
+func loadCSRWithKey(config Config) (*x509.CertificateRequest, crypto.PrivateKey, error) {
+	csr, err := loadCSRFile(config.CSRFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	key, err := loadKeyFile(config.KeyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, nil, fmt.Errorf("key file %s does not contain a signing key", config.KeyFile)
+	}
+	pub, ok := signer.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !pub.Equal(csr.PublicKey) {
+		return nil, nil, fmt.Errorf("public key in CSR file %s does not match key file %s", config.CSRFile, config.KeyFile)
+	}
+
+	names := csrNames(csr)
+	covered := false
+	for _, name := range names {
+		if strings.EqualFold(name, config.Hostname) {
+			covered = true
+			break
+		}
+	}
+	if !covered {
+		return nil, nil, fmt.Errorf("CSR file %s does not include hostname %s (requests: %s)", config.CSRFile, config.Hostname, strings.Join(names, ", "))
+	}
+
+	return csr, key, nil
+}
+
+// Extended key usages accepted by -eku, in the order they are written to the CSR
+var extKeyUsageNames = []string{"serverAuth", "clientAuth"}
+
+// OIDs of the extended key usage extension and the usages -eku can request
+var (
+	oidExtKeyUsage = asn1.ObjectIdentifier{2, 5, 29, 37}
+	extKeyUsages   = map[string]struct {
+		usage x509.ExtKeyUsage
+		oid   asn1.ObjectIdentifier
+	}{
+		"serverAuth": {x509.ExtKeyUsageServerAuth, asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 1}},
+		"clientAuth": {x509.ExtKeyUsageClientAuth, asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 2}},
+	}
+)
+
+// validateExtKeyUsages checks each -eku value against the recognized usages
+func validateExtKeyUsages(names []string) error {
+	for _, name := range names {
+		if _, ok := extKeyUsages[name]; !ok {
+			return fmt.Errorf("invalid extended key usage %s, must be one of: %s", name, strings.Join(extKeyUsageNames, ", "))
+		}
+	}
+	return nil
+}
+
+// extKeyUsageExtension encodes the requested usages as an extended key usage extension
+func extKeyUsageExtension(names []string) (pkix.Extension, error) {
+	var oids []asn1.ObjectIdentifier
+	for _, name := range extKeyUsageNames {
+		if slices.Contains(names, name) {
+			oids = append(oids, extKeyUsages[name].oid)
+		}
+	}
+	value, err := asn1.Marshal(oids)
+	if err != nil {
+		return pkix.Extension{}, err
+	}
+	return pkix.Extension{Id: oidExtKeyUsage, Value: value}, nil
+}
+
+// buildCSR creates the CSR for the hostname that lego would otherwise generate itself, with
+// the requested extended key usages (and Must-Staple) as requested extensions
+func buildCSR(config Config, key crypto.PrivateKey) (*x509.CertificateRequest, error) {
+	ekuExt, err := extKeyUsageExtension(config.ExtKeyUsages)
+	if err != nil {
+		return nil, err
+	}
+	domains := certificateDomains(config)
+	template := &x509.CertificateRequest{
+		Subject:         pkix.Name{CommonName: domains[0]},
+		DNSNames:        domains,
+		ExtraExtensions: []pkix.Extension{ekuExt},
+	}
+	if config.MustStaple {
+		value, err := asn1.Marshal([]int{tlsFeatureStatusRequest})
+		if err != nil {
+			return nil, err
+		}
+		template.ExtraExtensions = append(template.ExtraExtensions, pkix.Extension{Id: oidTLSFeature, Value: value})
+	}
+
+	der, err := x509.CreateCertificateRequest(rand.Reader, template, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create CSR: %v", err)
+	}
+	return x509.ParseCertificateRequest(der)
+}
+
+// missingExtKeyUsages returns the requested usages the issued certificate does not carry; CAs
+// may ignore the usages in a CSR and issue their own profile instead
+func missingExtKeyUsages(cert *x509.Certificate, names []string) []string {
+	var missing []string
+	for _, name := range names {
+		if !slices.Contains(cert.ExtKeyUsage, extKeyUsages[name].usage) {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+)
+
+// writeTestCSR generates a key and CSR for the given names and writes both as PEM files
+func writeTestCSR(t *testing.T, dir, commonName string, dnsNames []string) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: commonName},
+		DNSNames: dnsNames,
+	}, key)
+	if err != nil {
+		t.Fatalf("Failed to create CSR: %v", err)
+	}
+
+	csrPath := filepath.Join(dir, "request.csr")
+	keyPath := filepath.Join(dir, "request.key")
+	os.WriteFile(csrPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}), 0600)
+	os.WriteFile(keyPath, certcrypto.PEMEncode(key), 0600)
+	return csrPath, keyPath
+}
+
+func TestLoadCSRFile(t *testing.T) {
+	tempDir := t.TempDir()
+	csrPath, keyPath := writeTestCSR(t, tempDir, "esxi01.lab.example.com", []string{"esxi01.lab.example.com"})
+
+	t.Run("PEM", func(t *testing.T) {
+		csr, err := loadCSRFile(csrPath)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if csr.Subject.CommonName != "esxi01.lab.example.com" {
+			t.Errorf("Unexpected common name %s", csr.Subject.CommonName)
+		}
+	})
+
+	t.Run("DER", func(t *testing.T) {
+		data, _ := os.ReadFile(csrPath)
+		block, _ := pem.Decode(data)
+		derPath := filepath.Join(tempDir, "request.der")
+		os.WriteFile(derPath, block.Bytes, 0600)
+
+		if _, err := loadCSRFile(derPath); err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	})
+
+	t.Run("wrong PEM block", func(t *testing.T) {
+		_, err := loadCSRFile(keyPath)
+		if err == nil || !strings.Contains(err.Error(), "expected CERTIFICATE REQUEST") {
+			t.Errorf("Expected block type error, got %v", err)
+		}
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		if _, err := loadCSRFile(filepath.Join(tempDir, "missing.csr")); err == nil {
+			t.Error("Expected error for missing CSR file")
+		}
+	})
+}
+
+func TestLoadCSRWithKey(t *testing.T) {
+	tempDir := t.TempDir()
+	csrPath, keyPath := writeTestCSR(t, tempDir, "esxi01.lab.example.com", []string{"esxi01.lab.example.com", "esxi01"})
+
+	t.Run("matching key and hostname", func(t *testing.T) {
+		csr, key, err := loadCSRWithKey(Config{Hostname: "ESXi01.lab.example.com", CSRFile: csrPath, KeyFile: keyPath})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if csr == nil || key == nil {
+			t.Fatal("Expected CSR and key to be returned")
+		}
+	})
+
+	t.Run("hostname not in CSR", func(t *testing.T) {
+		_, _, err := loadCSRWithKey(Config{Hostname: "esxi02.lab.example.com", CSRFile: csrPath, KeyFile: keyPath})
+		if err == nil || !strings.Contains(err.Error(), "does not include hostname esxi02.lab.example.com") {
+			t.Errorf("Expected hostname error, got %v", err)
+		}
+	})
+
+	t.Run("mismatched key", func(t *testing.T) {
+		otherDir := t.TempDir()
+		_, otherKey := writeTestCSR(t, otherDir, "esxi01.lab.example.com", nil)
+
+		_, _, err := loadCSRWithKey(Config{Hostname: "esxi01.lab.example.com", CSRFile: csrPath, KeyFile: otherKey})
+		if err == nil || !strings.Contains(err.Error(), "does not match key file") {
+			t.Errorf("Expected key mismatch error, got %v", err)
+		}
+	})
+}
+
+func TestCSRNames(t *testing.T) {
+	tests := []struct {
+		name     string
+		csr      *x509.CertificateRequest
+		expected []string
+	}{
+		{
+			name:     "common name repeated in SANs",
+			csr:      &x509.CertificateRequest{Subject: pkix.Name{CommonName: "a.example.com"}, DNSNames: []string{"a.example.com", "b.example.com"}},
+			expected: []string{"a.example.com", "b.example.com"},
+		},
+		{
+			name:     "common name only",
+			csr:      &x509.CertificateRequest{Subject: pkix.Name{CommonName: "a.example.com"}},
+			expected: []string{"a.example.com"},
+		},
+		{
+			name:     "common name missing from SANs",
+			csr:      &x509.CertificateRequest{Subject: pkix.Name{CommonName: "a.example.com"}, DNSNames: []string{"b.example.com"}},
+			expected: []string{"a.example.com", "b.example.com"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if names := csrNames(tt.csr); !reflect.DeepEqual(names, tt.expected) {
+				t.Errorf("csrNames() = %v, expected %v", names, tt.expected)
+			}
+		})
+	}
+}
+
+func TestBuildCSRExtKeyUsages(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	config := Config{Hostname: "esxi01.example.com", ExtKeyUsages: []string{"clientAuth", "serverAuth"}, MustStaple: true}
+	csr, err := buildCSR(config, key)
+	if err != nil {
+		t.Fatalf("buildCSR failed: %v", err)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		t.Fatalf("Expected a valid CSR signature: %v", err)
+	}
+	if !reflect.DeepEqual(csrNames(csr), []string{"esxi01.example.com"}) {
+		t.Errorf("Unexpected CSR names: %v", csrNames(csr))
+	}
+
+	var ekus []asn1.ObjectIdentifier
+	mustStaple := false
+	for _, ext := range csr.Extensions {
+		switch {
+		case ext.Id.Equal(oidExtKeyUsage):
+			if _, err := asn1.Unmarshal(ext.Value, &ekus); err != nil {
+				t.Fatalf("Failed to decode extended key usages: %v", err)
+			}
+		case ext.Id.Equal(oidTLSFeature):
+			mustStaple = true
+		}
+	}
+	if len(ekus) != 2 || !ekus[0].Equal(extKeyUsages["serverAuth"].oid) || !ekus[1].Equal(extKeyUsages["clientAuth"].oid) {
+		t.Errorf("Expected serverAuth and clientAuth in the CSR, got %v", ekus)
+	}
+	if !mustStaple {
+		t.Error("Expected the Must-Staple extension in the CSR")
+	}
+}
+
+func TestExtKeyUsages(t *testing.T) {
+	if err := validateExtKeyUsages([]string{"serverAuth", "clientAuth"}); err != nil {
+		t.Errorf("Expected recognized usages to validate, got: %v", err)
+	}
+	if err := validateExtKeyUsages([]string{"codeSigning"}); err == nil || !strings.Contains(err.Error(), "serverAuth, clientAuth") {
+		t.Errorf("Expected codeSigning to be rejected, got: %v", err)
+	}
+
+	serverOnly := &x509.Certificate{ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}}
+	if missing := missingExtKeyUsages(serverOnly, []string{"serverAuth", "clientAuth"}); !reflect.DeepEqual(missing, []string{"clientAuth"}) {
+		t.Errorf("Expected clientAuth to be reported missing, got %v", missing)
+	}
+	if missing := missingExtKeyUsages(serverOnly, nil); len(missing) != 0 {
+		t.Errorf("Expected nothing missing without -eku, got %v", missing)
+	}
+}
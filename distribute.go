@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// s3PutObjectAPI is the part of the S3 client used for distribution
+type s3PutObjectAPI interface {
+	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+}
+
+// s3ObjectPrefix returns the key prefix for one issued certificate:
+// <prefix><host>/<issued at>/, so every issuance is kept side by side
+func s3ObjectPrefix(prefix, hostname string, issued time.Time) string {
+	return path.Join(strings.TrimPrefix(prefix, "/"), normalizeDomain(esxiHostOnly(hostname)), issued.UTC().Format("20060102T150405Z")) + "/"
+}
+
+// putCertificateObjects uploads the leaf, chain, full chain and key as private objects
+// encrypted at rest, returning the keys written
+func putCertificateObjects(ctx context.Context, client s3PutObjectAPI, bucket, prefix string, files outputFiles) ([]string, error) {
+	var keys []string
+	for _, object := range []struct {
+		name string
+		data []byte
+	}{
+		{"cert.pem", files.cert},
+		{"chain.pem", files.chain},
+		{"fullchain.pem", files.fullchain},
+		{"privkey.pem", files.privkey},
+	} {
+		key := prefix + object.name
+		_, err := client.PutObject(ctx, &s3.PutObjectInput{
+			Bucket:               aws.String(bucket),
+			Key:                  aws.String(key),
+			Body:                 bytes.NewReader(object.data),
+			ContentType:          aws.String("application/x-pem-file"),
+			ACL:                  s3types.ObjectCannedACLPrivate,
+			ServerSideEncryption: s3types.ServerSideEncryptionAes256,
+		})
+		if err != nil {
+			return keys, fmt.Errorf("failed to upload s3://%s/%s: %v", bucket, key, err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// newS3Client builds an S3 client from the same AWS configuration as Route53, in the
+// -s3-region when given. A custom endpoint (e.g. LocalStack) is addressed path-style.
+func newS3Client(ctx context.Context, config Config) (*s3.Client, error) {
+	if config.S3Region != "" {
+		config.Route53Region = config.S3Region
+	}
+	awsCfg, err := loadAWSConfig(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	return s3.NewFromConfig(awsCfg, func(o *s3.Options) {
+		o.UsePathStyle = config.AWSEndpoint != ""
+	}), nil
+}
+
+// distributeCertificateToS3 archives the generated certificate, chain and key in the
+// -s3-upload-bucket
+func distributeCertificateToS3(config Config, certPath, keyPath string) error {
+	files, err := readOutputFiles(certPath, keyPath)
+	if err != nil {
+		return err
+	}
+	leaf, err := readCertificateFile(certPath)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	client, err := newS3Client(ctx, config)
+	if err != nil {
+		return err
+	}
+
+	prefix := s3ObjectPrefix(config.S3Prefix, config.Hostname, leaf.NotBefore)
+	keys, err := putCertificateObjects(ctx, client, config.S3UploadBucket, prefix, files)
+	if err != nil {
+		return err
+	}
+	logInfo("Certificate archived to s3://%s/%s (%d objects)", config.S3UploadBucket, prefix, len(keys))
+	return nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// fakeS3 records the objects put and fails the object named failKey
+type fakeS3 struct {
+	objects map[string]string
+	inputs  []*s3.PutObjectInput
+	failKey string
+}
+
+func (f *fakeS3) PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	if aws.ToString(params.Key) == f.failKey {
+		return nil, errors.New("AccessDenied")
+	}
+	body, _ := io.ReadAll(params.Body)
+	f.objects[aws.ToString(params.Key)] = string(body)
+	f.inputs = append(f.inputs, params)
+	return &s3.PutObjectOutput{}, nil
+}
+
+func TestS3ObjectPrefix(t *testing.T) {
+	issued := time.Date(2026, 3, 1, 3, 4, 5, 0, time.FixedZone("CET", 3600))
+	tests := []struct {
+		prefix, hostname, expected string
+	}{
+		{"", "esxi01.lab.example.com", "esxi01.lab.example.com/20260301T020405Z/"},
+		{"certs/", "ESXi01.lab.example.com:443", "certs/esxi01.lab.example.com/20260301T020405Z/"},
+		{"/archive/esxi", "esxi01.lab.example.com.", "archive/esxi/esxi01.lab.example.com/20260301T020405Z/"},
+	}
+	for _, tt := range tests {
+		if got := s3ObjectPrefix(tt.prefix, tt.hostname, issued); got != tt.expected {
+			t.Errorf("s3ObjectPrefix(%q, %q) = %q, want %q", tt.prefix, tt.hostname, got, tt.expected)
+		}
+	}
+}
+
+func TestPutCertificateObjects(t *testing.T) {
+	files := outputFiles{cert: []byte("leaf"), chain: []byte("chain"), fullchain: []byte("leafchain"), privkey: []byte("key")}
+	client := &fakeS3{objects: make(map[string]string)}
+
+	keys, err := putCertificateObjects(context.Background(), client, "pki-archive", "esxi01/20260301T030405Z/", files)
+	if err != nil {
+		t.Fatalf("putCertificateObjects() error = %v", err)
+	}
+	if len(keys) != 4 || client.objects["esxi01/20260301T030405Z/privkey.pem"] != "key" || client.objects["esxi01/20260301T030405Z/fullchain.pem"] != "leafchain" {
+		t.Errorf("Unexpected objects: %v", client.objects)
+	}
+	for _, input := range client.inputs {
+		if aws.ToString(input.Bucket) != "pki-archive" || input.ACL != s3types.ObjectCannedACLPrivate || input.ServerSideEncryption != s3types.ServerSideEncryptionAes256 {
+			t.Errorf("Expected a private, encrypted object in pki-archive, got %+v", input)
+		}
+	}
+
+	client = &fakeS3{objects: make(map[string]string), failKey: "p/chain.pem"}
+	if _, err := putCertificateObjects(context.Background(), client, "pki-archive", "p/", files); err == nil || !strings.Contains(err.Error(), "s3://pki-archive/p/chain.pem") {
+		t.Errorf("Expected the failed object in the error, got %v", err)
+	}
+}
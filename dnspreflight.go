@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-acme/lego/v4/challenge"
+)
+
+// dnsRecursiveNameservers are the public resolvers that must see a challenge record, both
+// in lego's propagation check and in the -dns-preflight check
+var dnsRecursiveNameservers = []string{"8.8.8.8:53", "1.1.1.1:53"}
+
+// How long the pre-flight waits for its test record to resolve, and how often it looks
+var (
+	dnsPreflightTimeout  = 2 * time.Minute
+	dnsPreflightInterval = 4 * time.Second
+)
+
+// txtLookupFunc resolves the TXT records of a name
+type txtLookupFunc func(ctx context.Context, name string) ([]string, error)
+
+// publicTXTLookup resolves TXT records through the public recursive nameservers, so the
+// pre-flight sees what the CA's resolvers will rather than a local cache
+func publicTXTLookup(ctx context.Context, name string) ([]string, error) {
+	var lastErr error
+	for _, server := range dnsRecursiveNameservers {
+		resolver := &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, network, server)
+			},
+		}
+		records, err := resolver.LookupTXT(ctx, name)
+		if err == nil {
+			return records, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// challengeRecordValue is the TXT value lego's DNS-01 providers create for a key authorization
+func challengeRecordValue(keyAuth string) string {
+	sum := sha256.Sum256([]byte(keyAuth))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// runDNSPreflight creates a throwaway challenge record for domain through the provider, waits
+// for the resolvers to return it, and deletes it again. Nothing is sent to the CA, so a DNS
+// problem stops the run before an order is placed.
+func runDNSPreflight(provider challenge.Provider, domain string, lookup txtLookupFunc) error {
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	token := hex.EncodeToString(nonce)
+	keyAuth := "preflight." + token
+	name := "_acme-challenge." + domain
+	value := challengeRecordValue(keyAuth)
+
+	logInfo("DNS pre-flight: creating test TXT record %s", name)
+	if err := provider.Present(domain, token, keyAuth); err != nil {
+		return fmt.Errorf("DNS pre-flight failed: could not create test TXT record %s: %v", name, err)
+	}
+	defer func() {
+		if err := provider.CleanUp(domain, token, keyAuth); err != nil {
+			logWarn("DNS pre-flight: failed to delete test TXT record %s: %v", name, err)
+		} else {
+			logDebug("DNS pre-flight: deleted test TXT record %s", name)
+		}
+	}()
+
+	start := time.Now()
+	deadline := start.Add(dnsPreflightTimeout)
+	var lastErr error
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), dnsPreflightInterval)
+		records, err := lookup(ctx, name)
+		cancel()
+		if err == nil && slices.Contains(records, value) {
+			logInfo("DNS pre-flight passed: test TXT record %s resolved after %s", name, time.Since(start).Round(time.Second))
+			return nil
+		}
+		lastErr = err
+
+		if !time.Now().Add(dnsPreflightInterval).Before(deadline) {
+			break
+		}
+		time.Sleep(dnsPreflightInterval)
+	}
+
+	detail := "the record was not returned"
+	if lastErr != nil {
+		detail = lastErr.Error()
+	}
+	return fmt.Errorf("DNS pre-flight failed: test TXT record %s did not resolve through %s within %s (%s)",
+		name, strings.Join(dnsRecursiveNameservers, ", "), dnsPreflightTimeout, detail)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeDNSProvider records the TXT values it was asked to create and delete
+type fakeDNSProvider struct {
+	records    map[string]string
+	presentErr error
+	cleaned    bool
+}
+
+func (p *fakeDNSProvider) Present(domain, token, keyAuth string) error {
+	if p.presentErr != nil {
+		return p.presentErr
+	}
+	p.records["_acme-challenge."+domain] = challengeRecordValue(keyAuth)
+	return nil
+}
+
+func (p *fakeDNSProvider) CleanUp(domain, token, keyAuth string) error {
+	delete(p.records, "_acme-challenge."+domain)
+	p.cleaned = true
+	return nil
+}
+
+func TestRunDNSPreflight(t *testing.T) {
+	oldTimeout, oldInterval := dnsPreflightTimeout, dnsPreflightInterval
+	dnsPreflightTimeout, dnsPreflightInterval = 50*time.Millisecond, 10*time.Millisecond
+	defer func() { dnsPreflightTimeout, dnsPreflightInterval = oldTimeout, oldInterval }()
+
+	t.Run("record resolves", func(t *testing.T) {
+		provider := &fakeDNSProvider{records: map[string]string{}}
+		lookups := 0
+		lookup := func(ctx context.Context, name string) ([]string, error) {
+			// Not visible on the first lookup, as if still propagating
+			if lookups++; lookups == 1 {
+				return nil, errors.New("no such host")
+			}
+			return []string{"unrelated", provider.records[name]}, nil
+		}
+
+		if err := runDNSPreflight(provider, "esxi01.lab.example.com", lookup); err != nil {
+			t.Fatalf("Expected the pre-flight to pass, got %v", err)
+		}
+		if !provider.cleaned || len(provider.records) != 0 {
+			t.Error("Expected the test record to be deleted")
+		}
+	})
+
+	t.Run("record never resolves", func(t *testing.T) {
+		provider := &fakeDNSProvider{records: map[string]string{}}
+		lookup := func(ctx context.Context, name string) ([]string, error) {
+			return []string{"stale"}, nil
+		}
+
+		err := runDNSPreflight(provider, "esxi01.lab.example.com", lookup)
+		if err == nil || !strings.Contains(err.Error(), "_acme-challenge.esxi01.lab.example.com did not resolve") {
+			t.Fatalf("Expected the pre-flight to fail, got %v", err)
+		}
+		if !provider.cleaned {
+			t.Error("Expected the test record to be deleted after a failure")
+		}
+	})
+
+	t.Run("record cannot be created", func(t *testing.T) {
+		provider := &fakeDNSProvider{presentErr: errors.New("AccessDenied")}
+		err := runDNSPreflight(provider, "esxi01.lab.example.com", nil)
+		if err == nil || !strings.Contains(err.Error(), "could not create test TXT record") || provider.cleaned {
+			t.Errorf("Expected the pre-flight to stop at record creation, got %v", err)
+		}
+	})
+}
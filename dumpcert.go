@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net"
+	"os"
+)
+
+// fetchPeerCertificates returns the chain a host presents over TLS, leaf first. Nothing is
+// verified, as the certificates are only read.
+func fetchPeerCertificates(host string, dialer TLSDialer) ([]*x509.Certificate, error) {
+	address := host
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		address = net.JoinHostPort(host, "443")
+	}
+
+	conn, err := dialer.Dial(dialNetwork, address, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %v", address, err)
+	}
+	defer conn.Close()
+
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("%s presented no certificate", address)
+	}
+	return certs, nil
+}
+
+// writeCertificatesPEM writes each certificate as a PEM block, in order
+func writeCertificatesPEM(w io.Writer, certs []*x509.Certificate) error {
+	for _, cert := range certs {
+		if err := pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Print the chain a host serves as PEM, or write it to file when given, and exit
+func runDumpCert(host, file string) {
+	certs, err := fetchPeerCertificates(host, &DefaultTLSDialer{})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitCodeFailure)
+	}
+
+	var buf bytes.Buffer
+	writeCertificatesPEM(&buf, certs)
+	if file == "" {
+		os.Stdout.Write(buf.Bytes())
+		os.Exit(0)
+	}
+	if err := writeFileAtomic(file, buf.Bytes(), 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: failed to write %s: %v\n", file, err)
+		os.Exit(exitCodeFailure)
+	}
+	fmt.Printf("Wrote %d certificates served by %s to %s\n", len(certs), host, file)
+	os.Exit(0)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFetchPeerCertificates(t *testing.T) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+
+	certs, err := fetchPeerCertificates(server.Listener.Addr().String(), &DefaultTLSDialer{})
+	if err != nil {
+		t.Fatalf("fetchPeerCertificates() error = %v", err)
+	}
+	if len(certs) != 1 || !certs[0].Equal(server.Certificate()) {
+		t.Fatalf("Expected the server's certificate, got %d certificates", len(certs))
+	}
+
+	var buf bytes.Buffer
+	if err := writeCertificatesPEM(&buf, certs); err != nil {
+		t.Fatalf("writeCertificatesPEM() error = %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "served.pem")
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatalf("Failed to write PEM: %v", err)
+	}
+	parsed, err := readCertificateBundle(path)
+	if err != nil || len(parsed) != 1 || !parsed[0].Equal(server.Certificate()) {
+		t.Errorf("Expected the PEM to round-trip (%v)", err)
+	}
+
+	if _, err := fetchPeerCertificates("127.0.0.1:1", &DefaultTLSDialer{}); err == nil {
+		t.Error("Expected an error for a closed port")
+	}
+}
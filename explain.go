@@ -0,0 +1,85 @@
+package main
+
+import (
+	"crypto/x509"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+)
+
+// describeLifetime renders the remaining lifetime of a certificate, e.g. "12.3% lifetime remaining (14 days)"
+func describeLifetime(cert *x509.Certificate, now time.Time) string {
+	days := int(cert.NotAfter.Sub(now).Hours() / 24)
+	return fmt.Sprintf("%.1f%% lifetime remaining (%d days)", lifetimeRemaining(cert)*100, days)
+}
+
+// reportDecision logs the decision at INFO for -explain, and otherwise keeps it in the debug log
+func reportDecision(config Config, decision string) {
+	if config.DryRun {
+		decision = "[dry run] " + decision
+	}
+	if config.Explain {
+		logInfo("Decision: %s", decision)
+		return
+	}
+	logDebug("Decision: %s", decision)
+}
+
+// reportCacheDecision logs why the cached certificate is or isn't reused, at INFO for -explain
+// and otherwise in the debug log
+func reportCacheDecision(config Config, decision string) {
+	if config.Explain {
+		logInfo("Cache decision: %s", decision)
+		return
+	}
+	logDebug("Cache decision: %s", decision)
+}
+
+// cacheDecision decides whether a cached certificate can be reused: it must be signed as
+// expected for keyType and have more than threshold of its lifetime left. The reason reads
+// e.g. "cache hit: 72.0% remaining, SHA256-RSA".
+func cacheDecision(cert *x509.Certificate, keyType certcrypto.KeyType, threshold float64, now time.Time) (bool, string) {
+	if !slices.Contains(acceptedSignatureAlgorithms(keyType), cert.SignatureAlgorithm) {
+		return false, fmt.Sprintf("cache miss: signature algorithm %s not accepted for a %s key", cert.SignatureAlgorithm, keyType)
+	}
+
+	remaining := 0.0
+	if lifetime := cert.NotAfter.Sub(cert.NotBefore); lifetime > 0 {
+		remaining = float64(cert.NotAfter.Sub(now)) / float64(lifetime)
+	}
+	if remaining > threshold {
+		return true, fmt.Sprintf("cache hit: %.1f%% remaining, %s", remaining*100, cert.SignatureAlgorithm)
+	}
+	return false, fmt.Sprintf("cache miss: %.1f%% remaining below %.0f%% reuse threshold", remaining*100, threshold*100)
+}
+
+// explainRenewalDecision states in one sentence why the workflow renews or leaves the certificate
+// alone, following the same precedence as executeWorkflow: force, a broken chain, a foreign
+// issuer, and finally the lifetime threshold
+func explainRenewalDecision(config Config, cert *x509.Certificate, needsRenewal, chainBroken bool) string {
+	if cert == nil {
+		if config.Force || needsRenewal {
+			return "Renewing: no certificate details were available from the host"
+		}
+		return "Not renewing: no certificate details were available from the host"
+	}
+
+	lifetime := describeLifetime(cert, time.Now())
+	threshold := config.Threshold * 100
+
+	switch {
+	case config.Force:
+		return fmt.Sprintf("Renewing because -force is set (%s, threshold %.0f%%)", lifetime, threshold)
+	case chainBroken:
+		return fmt.Sprintf("Renewing because the installed certificate chain is broken (%s)", lifetime)
+	case foreignIssuer(config, cert):
+		return fmt.Sprintf("Renewing because the installed certificate was issued by %q, not %q (%s)",
+			cert.Issuer.String(), config.RenewIfIssuerNot, lifetime)
+	case needsRenewal:
+		return fmt.Sprintf("Renewing because %s is below the %.0f%% threshold", lifetime, threshold)
+	default:
+		return fmt.Sprintf("Not renewing: %s, above the %.0f%% threshold; use -force to override", lifetime, threshold)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+)
+
+func TestExplainRenewalDecision(t *testing.T) {
+	now := time.Now()
+	// 90-day certificate with 14 days left: about 15.6% remaining
+	expiring := &x509.Certificate{NotBefore: now.Add(-76 * 24 * time.Hour), NotAfter: now.Add(14*24*time.Hour + time.Hour)}
+	// 90-day certificate with 60 days left: about 66.7% remaining
+	fresh := &x509.Certificate{
+		Issuer:    pkix.Name{Organization: []string{"Let's Encrypt"}},
+		NotBefore: now.Add(-30 * 24 * time.Hour),
+		NotAfter:  now.Add(60*24*time.Hour + time.Hour),
+	}
+	selfSigned := &x509.Certificate{
+		Issuer:    pkix.Name{Organization: []string{"VMware Installer"}},
+		NotBefore: now.Add(-30 * 24 * time.Hour),
+		NotAfter:  now.Add(5 * 365 * 24 * time.Hour),
+	}
+
+	tests := []struct {
+		name         string
+		config       Config
+		cert         *x509.Certificate
+		needsRenewal bool
+		chainBroken  bool
+		want         []string
+	}{
+		{
+			name:         "below threshold",
+			config:       Config{Threshold: 0.33},
+			cert:         expiring,
+			needsRenewal: true,
+			want:         []string{"Renewing because 15.", "(14 days) is below the 33% threshold"},
+		},
+		{
+			name:   "above threshold",
+			config: Config{Threshold: 0.33},
+			cert:   fresh,
+			want:   []string{"Not renewing: 66.", "(60 days), above the 33% threshold; use -force to override"},
+		},
+		{
+			name:   "force",
+			config: Config{Threshold: 0.33, Force: true},
+			cert:   fresh,
+			want:   []string{"Renewing because -force is set"},
+		},
+		{
+			name:        "broken chain",
+			config:      Config{Threshold: 0.33},
+			cert:        fresh,
+			chainBroken: true,
+			want:        []string{"chain is broken"},
+		},
+		{
+			name:   "foreign issuer",
+			config: Config{Threshold: 0.33, RenewIfIssuerNot: "Let's Encrypt"},
+			cert:   selfSigned,
+			want:   []string{"Renewing because the installed certificate was issued by", "VMware Installer"},
+		},
+		{
+			name:   "no certificate",
+			config: Config{Threshold: 0.33},
+			want:   []string{"Not renewing: no certificate details"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := explainRenewalDecision(tt.config, tt.cert, tt.needsRenewal, tt.chainBroken)
+			for _, want := range tt.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("explainRenewalDecision() = %q, want it to contain %q", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCacheDecision(t *testing.T) {
+	now := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	cert := &x509.Certificate{
+		SignatureAlgorithm: x509.SHA256WithRSA,
+		NotBefore:          now.Add(-25 * 24 * time.Hour),
+		NotAfter:           now.Add(75 * 24 * time.Hour),
+	}
+
+	if reuse, reason := cacheDecision(cert, certcrypto.RSA2048, 0.5, now); !reuse || reason != "cache hit: 75.0% remaining, SHA256-RSA" {
+		t.Errorf("Expected a cache hit, got %v %q", reuse, reason)
+	}
+	if reuse, reason := cacheDecision(cert, certcrypto.RSA2048, 0.8, now); reuse || reason != "cache miss: 75.0% remaining below 80% reuse threshold" {
+		t.Errorf("Expected a miss below the reuse threshold, got %v %q", reuse, reason)
+	}
+
+	cert.SignatureAlgorithm = x509.ECDSAWithSHA384
+	if reuse, reason := cacheDecision(cert, certcrypto.RSA2048, 0.5, now); reuse || !strings.Contains(reason, "signature algorithm ECDSA-SHA384 not accepted") {
+		t.Errorf("Expected a miss for an ECDSA signature on an RSA key type, got %v %q", reuse, reason)
+	}
+	if reuse, _ := cacheDecision(cert, certcrypto.EC256, 0.5, now); !reuse {
+		t.Error("Expected an ECDSA signature to be reused for an EC key type")
+	}
+}
+
+func TestRunWorkflow_Decision(t *testing.T) {
+	mockDeps := Dependencies{
+		AWSValidator: func(Config) error { return nil },
+		CertChecker: func(string, float64) (bool, *x509.Certificate, error) {
+			return false, &x509.Certificate{NotBefore: time.Now().Add(-30 * 24 * time.Hour), NotAfter: time.Now().Add(60 * 24 * time.Hour)}, nil
+		},
+	}
+	config := Config{Hostname: "test.example.com", Domain: "example.com", Threshold: 0.33}
+
+	result, err := runWorkflow(config, mockDeps)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !strings.HasPrefix(result.Decision, "Not renewing:") {
+		t.Errorf("Expected the decision to be recorded, got %q", result.Decision)
+	}
+}
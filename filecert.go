@@ -0,0 +1,62 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// fileCertificateDivergence describes how the certificate a host serves differs from the
+// one in its certificate file, or returns "" when they are the same issuance
+func fileCertificateDivergence(file, served *x509.Certificate) string {
+	if sameCertificate(file, served) {
+		return ""
+	}
+	return fmt.Sprintf("file has %s expiring %s; port 443 serves %s expiring %s",
+		describeIssuance(file), file.NotAfter.Format(time.RFC3339), describeIssuance(served), served.NotAfter.Format(time.RFC3339))
+}
+
+// readRemoteCertificate reads and parses the first certificate in a file on the host
+func readRemoteCertificate(client *ssh.Client, remotePath string) (*x509.Certificate, error) {
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create SSH session: %v", err)
+	}
+	defer session.Close()
+
+	output, err := session.Output(readFileCommand(remotePath))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", remotePath, err)
+	}
+	block, _ := pem.Decode(output)
+	if block == nil {
+		return nil, fmt.Errorf("%s holds no PEM certificate", remotePath)
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// checkServedMatchesFile compares the certificate in the target's file with the one served
+// on the HTTPS port and warns when they differ, e.g. when 443 is fronted by a certificate
+// this tool does not install, which would make validation fail after the upload
+func checkServedMatchesFile(client *ssh.Client, config Config, target ServiceTarget, dialer TLSDialer) {
+	file, err := readRemoteCertificate(client, target.CertPath)
+	if err != nil {
+		logWarn("Could not compare %s with the served certificate: %v", target.CertPath, err)
+		return
+	}
+	served, err := fetchPeerCertificates(esxiHTTPSAddress(config), dialer)
+	if err != nil {
+		logWarn("Could not compare %s with the served certificate: %v", target.CertPath, err)
+		return
+	}
+
+	if divergence := fileCertificateDivergence(file, served[0]); divergence != "" {
+		logWarn("The certificate served by %s is not the one in %s (%s). Port 443 may be fronted by a different certificate, "+
+			"in which case validation will not see the new one", esxiHTTPSAddress(config), target.CertPath, divergence)
+		return
+	}
+	logInfo("Certificate in %s matches the one served by %s", target.CertPath, esxiHTTPSAddress(config))
+}
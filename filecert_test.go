@@ -0,0 +1,25 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileCertificateDivergence(t *testing.T) {
+	now := time.Now()
+	root := issueTestCertificate(t, "Test Root", true, now.Add(10*365*24*time.Hour), nil)
+	installed := issueTestCertificate(t, "esxi01.lab.example.com", false, now.Add(30*24*time.Hour), root).cert
+	proxy := issueTestCertificate(t, "esxi01.lab.example.com", false, now.Add(300*24*time.Hour), root).cert
+
+	if divergence := fileCertificateDivergence(installed, installed); divergence != "" {
+		t.Errorf("Expected no divergence for the same certificate, got %q", divergence)
+	}
+
+	divergence := fileCertificateDivergence(installed, proxy)
+	for _, want := range []string{installed.NotAfter.Format(time.RFC3339), proxy.NotAfter.Format(time.RFC3339), "port 443 serves"} {
+		if !strings.Contains(divergence, want) {
+			t.Errorf("Expected the divergence to mention %q, got %q", want, divergence)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// A scheduled run still unfinished this long after it was due is treated as hung, so the
+// liveness probe fails and the orchestrator restarts the process
+const healthzRunGrace = time.Hour
+
+// healthState holds the latest schedule status for the -healthz-addr endpoint
+type healthState struct {
+	mu     sync.Mutex
+	status scheduleStatus
+}
+
+// scheduleHealth is updated with every status the schedule records
+var scheduleHealth healthState
+
+func (h *healthState) update(status scheduleStatus) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.status = status
+}
+
+func (h *healthState) snapshot() scheduleStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.status
+}
+
+// check reports whether the schedule is alive: it has started and its next run is not
+// overdue by more than the grace period
+func (h *healthState) check(now time.Time) error {
+	status := h.snapshot()
+	if status.UpdatedAt.IsZero() {
+		return errors.New("schedule has not started")
+	}
+	if overdue := now.Sub(status.NextRun); overdue > healthzRunGrace {
+		return fmt.Errorf("run due at %s has not finished after %s", status.NextRun.Format(time.RFC3339), overdue.Round(time.Second))
+	}
+	return nil
+}
+
+// ServeHTTP answers liveness probes with the current status as JSON: 200 while the schedule
+// is alive, 503 otherwise. A failed renewal is reported in the body but is not a liveness
+// failure, as restarting the process would not fix it.
+func (h *healthState) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	code := http.StatusOK
+	body := struct {
+		Healthy bool   `json:"healthy"`
+		Reason  string `json:"reason,omitempty"`
+		scheduleStatus
+	}{Healthy: true, scheduleStatus: h.snapshot()}
+
+	if err := h.check(time.Now()); err != nil {
+		code = http.StatusServiceUnavailable
+		body.Healthy, body.Reason = false, err.Error()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(body)
+}
+
+// startHealthzServer serves /healthz on addr until the returned function shuts it down. The
+// listener is opened before returning, so a port already in use fails the start at once.
+func startHealthzServer(addr string, health *healthState) (func(), error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on healthz address %s: %v", addr, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/healthz", health)
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logError("Healthz server failed: %v", err)
+		}
+	}()
+	logInfo("Serving liveness probe on http://%s/healthz", listener.Addr())
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(ctx)
+	}, nil
+}
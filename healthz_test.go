@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHealthStateCheck(t *testing.T) {
+	now := time.Now()
+	var health healthState
+	if err := health.check(now); err == nil {
+		t.Error("Expected a schedule that hasn't started to be unhealthy")
+	}
+
+	health.update(scheduleStatus{UpdatedAt: now, NextRun: now.Add(time.Hour)})
+	if err := health.check(now); err != nil {
+		t.Errorf("Expected a waiting schedule to be healthy, got: %v", err)
+	}
+
+	// A run in progress past its due time is fine until the grace period runs out
+	health.update(scheduleStatus{UpdatedAt: now.Add(-2 * time.Hour), NextRun: now.Add(-10 * time.Minute)})
+	if err := health.check(now); err != nil {
+		t.Errorf("Expected a run in progress to be healthy, got: %v", err)
+	}
+	health.update(scheduleStatus{UpdatedAt: now.Add(-3 * time.Hour), NextRun: now.Add(-healthzRunGrace - time.Minute)})
+	if err := health.check(now); err == nil {
+		t.Error("Expected a hung run to be unhealthy")
+	}
+}
+
+func TestHealthStateServeHTTP(t *testing.T) {
+	var health healthState
+	success := false
+	health.update(scheduleStatus{UpdatedAt: time.Now(), NextRun: time.Now().Add(time.Hour), Success: &success, Error: "renewal failed"})
+
+	rec := httptest.NewRecorder()
+	health.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected 200 despite a failed renewal, got %d", rec.Code)
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Expected a JSON body: %v", err)
+	}
+	if body["healthy"] != true || body["error"] != "renewal failed" || body["next_run"] == nil {
+		t.Errorf("Unexpected body: %s", rec.Body.String())
+	}
+
+	health.update(scheduleStatus{})
+	rec = httptest.NewRecorder()
+	health.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 before the schedule starts, got %d", rec.Code)
+	}
+}
+
+func TestStartHealthzServer(t *testing.T) {
+	var health healthState
+	health.update(scheduleStatus{UpdatedAt: time.Now(), NextRun: time.Now().Add(time.Hour)})
+
+	// Reserve a free port, then hand it to the server
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to reserve a port: %v", err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	shutdown, err := startHealthzServer(addr, &health)
+	if err != nil {
+		t.Fatalf("Failed to start healthz server: %v", err)
+	}
+	defer shutdown()
+
+	resp, err := http.Get("http://" + addr + "/healthz")
+	if err != nil {
+		t.Fatalf("Healthz request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected 200, got %d", resp.StatusCode)
+	}
+
+	if _, err := startHealthzServer(addr, &health); err == nil {
+		t.Error("Expected a port already in use to fail the start")
+	}
+}
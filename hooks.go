@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Hook statuses passed to hook commands in STATUS
+const (
+	hookStatusSuccess = "success"
+	hookStatusFailure = "failure"
+)
+
+// runHookCommand runs a hook through the platform shell with the given extra environment
+// variables and returns its combined output
+func runHookCommand(command string, env []string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), hookTimeout)
+	defer cancel()
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", command)
+	}
+	cmd.Env = append(os.Environ(), env...)
+
+	output, err := cmd.CombinedOutput()
+	if ctx.Err() == context.DeadlineExceeded {
+		return string(output), fmt.Errorf("timed out after %s", hookTimeout)
+	}
+	return string(output), err
+}
+
+// hookEnvironment describes the run to a hook command
+func hookEnvironment(config Config, result WorkflowResult, workflowErr error) []string {
+	status := hookStatusSuccess
+	if workflowErr != nil {
+		status = hookStatusFailure
+	}
+
+	env := []string{
+		"ESXI_HOST=" + config.Hostname,
+		"CERT_PATH=" + result.CertPath,
+		"KEY_PATH=" + result.KeyPath,
+		"STATUS=" + status,
+		"ACTION=" + string(result.Action),
+		"RUN_ID=" + result.RunID,
+		"SSH_STATE=" + string(result.SSHServiceState),
+	}
+	if !result.NewExpiry.IsZero() {
+		env = append(env, "NEW_EXPIRY="+result.NewExpiry.Format(time.RFC3339))
+	} else {
+		env = append(env, "NEW_EXPIRY=")
+	}
+	if !result.InstalledExpiry.IsZero() {
+		env = append(env, "INSTALLED_DAYS_REMAINING="+strconv.Itoa(result.InstalledDaysRemaining))
+	} else {
+		env = append(env, "INSTALLED_DAYS_REMAINING=")
+	}
+	if len(config.Labels) > 0 {
+		env = append(env, labelEnvironment(config.Labels)...)
+	}
+	if workflowErr != nil {
+		env = append(env, "ERROR="+workflowErr.Error())
+	}
+	return env
+}
+
+// runHooks runs the post-renew hook after a renewal, or the post-fail hook after a failure.
+// Hook failures are logged as warnings and only returned when strict hooks are enabled.
+func runHooks(config Config, result WorkflowResult, workflowErr error, runner func(string, []string) (string, error)) error {
+	var name, command string
+	switch {
+	case workflowErr != nil:
+		name, command = "post-fail", config.PostFailHook
+	case result.Action == ActionRenewed:
+		name, command = "post-renew", config.PostRenewHook
+	}
+	if command == "" || runner == nil {
+		return nil
+	}
+
+	logInfo("Running %s hook: %s", name, command)
+	output, err := runner(command, hookEnvironment(config, result, workflowErr))
+	if output = strings.TrimSpace(output); output != "" {
+		logInfo("%s hook output:\n%s", name, output)
+	}
+	if err != nil {
+		if config.StrictHooks {
+			return fmt.Errorf("%s hook failed: %v", name, err)
+		}
+		logWarn("%s hook failed: %v", name, err)
+		return nil
+	}
+
+	logInfo("%s hook completed successfully", name)
+	return nil
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"errors"
+	"log"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHookEnvironment(t *testing.T) {
+	expiry := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	config := Config{Hostname: "esxi01.example.com"}
+	result := WorkflowResult{Action: ActionRenewed, CertPath: "/tmp/cert.pem", KeyPath: "/tmp/key.pem", NewExpiry: expiry}
+	result.setInstalledCertificate(&x509.Certificate{NotAfter: expiry}, expiry.Add(-89*24*time.Hour-time.Hour))
+
+	env := hookEnvironment(config, result, nil)
+	want := []string{
+		"ESXI_HOST=esxi01.example.com",
+		"CERT_PATH=/tmp/cert.pem",
+		"KEY_PATH=/tmp/key.pem",
+		"STATUS=success",
+		"ACTION=renewed",
+		"NEW_EXPIRY=2026-01-02T03:04:05Z",
+		"INSTALLED_DAYS_REMAINING=89",
+	}
+	for _, w := range want {
+		if !containsString(env, w) {
+			t.Errorf("environment %v missing %s", env, w)
+		}
+	}
+
+	env = hookEnvironment(config, WorkflowResult{}, errors.New("upload failed"))
+	for _, w := range []string{"STATUS=failure", "NEW_EXPIRY=", "INSTALLED_DAYS_REMAINING=", "ERROR=upload failed"} {
+		if !containsString(env, w) {
+			t.Errorf("failure environment %v missing %s", env, w)
+		}
+	}
+}
+
+func containsString(values []string, want string) bool {
+	for _, v := range values {
+		if v == want {
+			return true
+		}
+	}
+	return false
+}
+
+func TestRunHooks(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      Config
+		result      WorkflowResult
+		workflowErr error
+		runnerErr   error
+		wantCommand string
+		shouldError bool
+	}{
+		{
+			name:        "renew hook after renewal",
+			config:      Config{PostRenewHook: "renew.sh", PostFailHook: "fail.sh"},
+			result:      WorkflowResult{Action: ActionRenewed},
+			wantCommand: "renew.sh",
+		},
+		{
+			name:   "no hook when up to date",
+			config: Config{PostRenewHook: "renew.sh", PostFailHook: "fail.sh"},
+			result: WorkflowResult{Action: ActionUpToDate},
+		},
+		{
+			name:        "fail hook after error",
+			config:      Config{PostRenewHook: "renew.sh", PostFailHook: "fail.sh"},
+			workflowErr: errors.New("boom"),
+			wantCommand: "fail.sh",
+		},
+		{
+			name:        "hook failure is only a warning",
+			config:      Config{PostRenewHook: "renew.sh"},
+			result:      WorkflowResult{Action: ActionRenewed},
+			runnerErr:   errors.New("exit status 1"),
+			wantCommand: "renew.sh",
+		},
+		{
+			name:        "hook failure with strict hooks",
+			config:      Config{PostRenewHook: "renew.sh", StrictHooks: true},
+			result:      WorkflowResult{Action: ActionRenewed},
+			runnerErr:   errors.New("exit status 1"),
+			wantCommand: "renew.sh",
+			shouldError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ran string
+			runner := func(command string, env []string) (string, error) {
+				ran = command
+				return "hook output", tt.runnerErr
+			}
+
+			err := runHooks(tt.config, tt.result, tt.workflowErr, runner)
+			if tt.shouldError != (err != nil) {
+				t.Errorf("runHooks() error = %v, shouldError %v", err, tt.shouldError)
+			}
+			if ran != tt.wantCommand {
+				t.Errorf("ran %q, want %q", ran, tt.wantCommand)
+			}
+		})
+	}
+}
+
+func TestRunHookCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a POSIX shell")
+	}
+
+	output, err := runHookCommand(`echo "$ESXI_HOST $STATUS"`, []string{"ESXI_HOST=esxi01", "STATUS=success"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if strings.TrimSpace(output) != "esxi01 success" {
+		t.Errorf("output = %q", output)
+	}
+
+	if _, err := runHookCommand("exit 3", nil); err == nil {
+		t.Error("Expected error for non-zero exit")
+	}
+}
+
+func TestRunWorkflow_Hooks(t *testing.T) {
+	var buf bytes.Buffer
+	originalOutput := log.Writer()
+	log.SetOutput(&buf)
+	defer log.SetOutput(originalOutput)
+
+	var env []string
+	config := Config{
+		Hostname:      "test.example.com",
+		Domain:        "example.com",
+		Force:         true,
+		Threshold:     0.33,
+		PostRenewHook: "deploy.sh",
+		StrictHooks:   true,
+	}
+	mockDeps := Dependencies{
+		AWSValidator: func(Config) error { return nil },
+		CertChecker: func(string, float64) (bool, *x509.Certificate, error) {
+			return false, &x509.Certificate{NotAfter: time.Now().Add(60 * 24 * time.Hour)}, nil
+		},
+		CertGenerator: func(Config) (string, string, error) { return "cert.pem", "key.pem", nil },
+		CertUploader:  func(Config, string, string) (SSHServiceState, error) { return "", nil },
+		CertValidator: func(string, *x509.Certificate) (bool, error) { return true, nil },
+		HookRunner: func(command string, hookEnv []string) (string, error) {
+			env = hookEnv
+			return "", errors.New("exit status 2")
+		},
+	}
+
+	if _, err := runWorkflow(config, mockDeps); err == nil || !strings.Contains(err.Error(), "post-renew hook failed") {
+		t.Errorf("Expected strict hook failure, got %v", err)
+	}
+	for _, w := range []string{"CERT_PATH=cert.pem", "KEY_PATH=key.pem", "STATUS=success", "INSTALLED_DAYS_REMAINING=59"} {
+		if !containsString(env, w) {
+			t.Errorf("hook environment %v missing %s", env, w)
+		}
+	}
+}
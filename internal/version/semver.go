@@ -0,0 +1,28 @@
+package version
+
+import (
+	"strings"
+
+	"golang.org/x/mod/semver"
+)
+
+// IsNewer reports whether latest is a newer semantic version than current.
+// A leading "v" is optional. Pre-releases sort before their release
+// (v1.0.0-rc.1 < v1.0.0) and build metadata is ignored. Versions that are
+// not valid semver are never considered newer.
+func IsNewer(current, latest string) bool {
+	current, latest = canonicalSemver(current), canonicalSemver(latest)
+	if !semver.IsValid(current) || !semver.IsValid(latest) {
+		return false
+	}
+	return semver.Compare(latest, current) > 0
+}
+
+// canonicalSemver trims whitespace and adds the "v" prefix required by x/mod/semver
+func canonicalSemver(v string) string {
+	v = strings.TrimSpace(v)
+	if v != "" && !strings.HasPrefix(v, "v") {
+		v = "v" + v
+	}
+	return v
+}
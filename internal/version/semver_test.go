@@ -0,0 +1,34 @@
+package version
+
+import "testing"
+
+func TestIsNewer(t *testing.T) {
+	tests := []struct {
+		current  string
+		latest   string
+		expected bool
+	}{
+		{"v1.9.0", "v1.10.0", true},
+		{"v1.10.0", "v1.9.0", false},
+		{"v1.0.0", "v1.0.0", false},
+		{"1.0.0", "v1.0.1", true},
+		{"v1.0.0", "2.0.0", true},
+		{"v1.0.0-rc.1", "v1.0.0", true},
+		{"v1.0.0", "v1.0.0-rc.1", false},
+		{"v1.0.0-alpha", "v1.0.0-beta", true},
+		{"v1.0.0-rc.2", "v1.0.0-rc.10", true},
+		{"v1.0.0+build.1", "v1.0.0+build.2", false},
+		{"v1.0.0", "v1.0.1+build.5", true},
+		{"development", "v1.0.0", false},
+		{"v1.0.0", "nightly", false},
+		{"", "v1.0.0", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.current+"->"+tt.latest, func(t *testing.T) {
+			if got := IsNewer(tt.current, tt.latest); got != tt.expected {
+				t.Errorf("IsNewer(%q, %q) = %v, expected %v", tt.current, tt.latest, got, tt.expected)
+			}
+		})
+	}
+}
@@ -1,19 +1,28 @@
 package version
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
+	"net/http"
+	"net/url"
+	"strings"
 	"time"
 
+	goversion "github.com/hashicorp/go-version"
 	"github.com/tcnksm/go-latest"
 )
 
 // Constants for hardcoded repository information
 const (
-	GitHubOwner = "ozskywalker"
-	GitHubRepo  = "lab-update-esxi-cert"
+	GitHubOwner  = "ozskywalker"
+	GitHubRepo   = "lab-update-esxi-cert"
+	GitHubAPIURL = "https://api.github.com"
 )
 
+// updateCheckTimeout bounds the whole update check, including slow proxies
+const updateCheckTimeout = 10 * time.Second
+
 // UpdateInfo contains information about available updates
 type UpdateInfo struct {
 	CurrentVersion string
@@ -22,13 +31,29 @@ type UpdateInfo struct {
 	IsUpToDate     bool
 }
 
+// NewHTTPClient returns the HTTP client used for update checks. Its transport honors the
+// standard HTTP_PROXY, HTTPS_PROXY, and NO_PROXY environment variables.
+func NewHTTPClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyFromEnvironment
+	return &http.Client{Transport: transport, Timeout: updateCheckTimeout}
+}
+
 // CheckForUpdates checks if there's a newer version available on GitHub
 // Uses hardcoded repository information
 func CheckForUpdates() (*UpdateInfo, error) {
-	// Create GitHub tag checker with hardcoded repo info
-	githubTag := &latest.GithubTag{
-		Owner:      GitHubOwner,
-		Repository: GitHubRepo,
+	return CheckForUpdatesWithClient(NewHTTPClient(), GitHubAPIURL)
+}
+
+// CheckForUpdatesWithClient checks for updates using the given HTTP client and GitHub API base URL,
+// which lets tests point the check at a mock server
+func CheckForUpdatesWithClient(client *http.Client, apiURL string) (*UpdateInfo, error) {
+	// Create GitHub tag source with hardcoded repo info
+	githubTag := &githubTagSource{
+		client: client,
+		apiURL: apiURL,
+		owner:  GitHubOwner,
+		repo:   GitHubRepo,
 	}
 
 	// Get current version info
@@ -54,7 +79,7 @@ func CheckForUpdates() (*UpdateInfo, error) {
 		if err != nil {
 			return nil, fmt.Errorf("failed to check for updates: %v", err)
 		}
-	case <-time.After(10 * time.Second):
+	case <-time.After(updateCheckTimeout):
 		return nil, fmt.Errorf("update check timed out")
 	}
 
@@ -63,12 +88,81 @@ func CheckForUpdates() (*UpdateInfo, error) {
 		CurrentVersion: currentVer,
 		LatestVersion:  res.Current,
 		UpdateURL:      res.Meta.URL,
-		IsUpToDate:     !res.Outdated,
+		IsUpToDate:     !IsNewer(currentVer, res.Current),
 	}
 
 	return updateInfo, nil
 }
 
+// githubTagSource is a go-latest Source that lists repository tags through a caller-supplied
+// HTTP client (go-latest's GithubTag always uses the default client)
+type githubTagSource struct {
+	client *http.Client
+	apiURL string
+	owner  string
+	repo   string
+}
+
+// Validate checks the source is fully configured
+func (s *githubTagSource) Validate() error {
+	if s.owner == "" || s.repo == "" {
+		return fmt.Errorf("GitHub owner and repository must be set")
+	}
+	if s.client == nil {
+		return fmt.Errorf("HTTP client must be set")
+	}
+	if _, err := url.Parse(s.apiURL); err != nil {
+		return fmt.Errorf("invalid GitHub API URL %s: %v", s.apiURL, err)
+	}
+	return nil
+}
+
+// Fetch lists the repository tags and parses them as versions
+func (s *githubTagSource) Fetch() (*latest.FetchResponse, error) {
+	endpoint := fmt.Sprintf("%s/repos/%s/%s/tags", strings.TrimSuffix(s.apiURL, "/"), s.owner, s.repo)
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status from GitHub: %d", resp.StatusCode)
+	}
+
+	var tags []struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tags); err != nil {
+		return nil, fmt.Errorf("failed to decode GitHub tags: %v", err)
+	}
+
+	fr := &latest.FetchResponse{Meta: &latest.Meta{}}
+	var newest *goversion.Version
+	for _, tag := range tags {
+		v, err := goversion.NewVersion(tag.Name)
+		if err != nil {
+			fr.Malformeds = append(fr.Malformeds, tag.Name)
+			continue
+		}
+		fr.Versions = append(fr.Versions, v)
+
+		// Link the release page of the newest tag
+		if newest == nil || v.GreaterThan(newest) {
+			newest = v
+			fr.Meta.URL = fmt.Sprintf("https://github.com/%s/%s/releases/tag/%s", s.owner, s.repo, tag.Name)
+		}
+	}
+
+	return fr, nil
+}
+
 // GetUpdateNotification returns a single-line update notification string
 // Returns empty string if up-to-date or check fails
 func GetUpdateNotification() string {
@@ -86,6 +180,47 @@ func GetUpdateNotification() string {
 		updateInfo.CurrentVersion, updateInfo.LatestVersion, updateInfo.UpdateURL)
 }
 
+// UpdateCheck is an update check running in the background
+type UpdateCheck struct {
+	done         chan struct{}
+	notification string
+}
+
+// StartUpdateCheck begins checking for updates in the background so callers never block on GitHub
+func StartUpdateCheck() *UpdateCheck {
+	check := &UpdateCheck{done: make(chan struct{})}
+	go func() {
+		check.notification = GetUpdateNotification()
+		close(check.done)
+	}()
+	return check
+}
+
+// Notification returns the update notification if the check finishes within wait,
+// or an empty string otherwise. A nil check (update checks disabled) returns immediately.
+func (c *UpdateCheck) Notification(wait time.Duration) string {
+	if c == nil {
+		return ""
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	// Prefer a finished result even when the wait has already elapsed
+	select {
+	case <-c.done:
+		return c.notification
+	default:
+	}
+
+	select {
+	case <-c.done:
+		return c.notification
+	case <-timer.C:
+		return ""
+	}
+}
+
 // PrintUpdateNotification prints a user-friendly update notification
 func (u *UpdateInfo) PrintUpdateNotification() {
 	if u.IsUpToDate {
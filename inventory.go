@@ -0,0 +1,132 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// Output formats for -inventory
+const (
+	inventoryOutputTable = "table"
+	inventoryOutputJSON  = "json"
+)
+
+// inventoryEntry is one host's certificate as reported by -inventory. Error is set, and the
+// certificate fields left empty, when the host's certificate could not be fetched.
+type inventoryEntry struct {
+	Hostname      string     `json:"hostname"`
+	Issuer        string     `json:"issuer,omitempty"`
+	Expiry        *time.Time `json:"expiry,omitempty"`
+	DaysRemaining *int       `json:"days_remaining,omitempty"`
+	KeyType       string     `json:"key_type,omitempty"`
+	NeedsRenewal  bool       `json:"needs_renewal"`
+	Error         string     `json:"error,omitempty"`
+}
+
+// inventoryHostConfigs returns the per-host configuration of every configured host: the hosts
+// list when one is given, otherwise the single -hostname
+func inventoryHostConfigs(config Config) []Config {
+	if len(config.Hosts) == 0 {
+		return []Config{config}
+	}
+	configs := make([]Config, 0, len(config.Hosts))
+	for _, host := range config.Hosts {
+		configs = append(configs, config.ForHost(host))
+	}
+	return configs
+}
+
+// collectInventory fetches each host's certificate with the same check a renewal run makes,
+// without renewing anything. A host that can't be reached is reported, not fatal.
+func collectInventory(config Config, checker func(string, float64) (bool, *x509.Certificate, error), now time.Time) []inventoryEntry {
+	hosts := inventoryHostConfigs(config)
+	entries := make([]inventoryEntry, 0, len(hosts))
+	for _, hostConfig := range hosts {
+		entry := inventoryEntry{Hostname: hostConfig.Hostname}
+		needsRenewal, cert, err := checker(esxiHTTPSAddress(hostConfig), hostConfig.Threshold)
+		if err != nil {
+			entry.Error = logRedactor.redact(err.Error())
+			entries = append(entries, entry)
+			continue
+		}
+
+		expiry := cert.NotAfter
+		days := int(cert.NotAfter.Sub(now).Hours() / 24)
+		entry.Issuer = cert.Issuer.String()
+		entry.Expiry, entry.DaysRemaining = &expiry, &days
+		entry.KeyType = publicKeyDescription(cert)
+		entry.NeedsRenewal = needsRenewal || foreignIssuer(hostConfig, cert)
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// writeInventory writes the inventory as an aligned table or a JSON array
+func writeInventory(w io.Writer, entries []inventoryEntry, format string) error {
+	if format == inventoryOutputJSON {
+		data, err := json.MarshalIndent(entries, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(data))
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "HOSTNAME\tISSUER\tEXPIRY\tDAYS\tKEY TYPE\tRENEW")
+	for _, entry := range entries {
+		if entry.Error != "" {
+			fmt.Fprintf(tw, "%s\tERROR: %s\t\t\t\t\n", entry.Hostname, entry.Error)
+			continue
+		}
+		renew := "no"
+		if entry.NeedsRenewal {
+			renew = "yes"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", entry.Hostname, entry.Issuer, entry.Expiry.Format(time.RFC3339),
+			strconv.Itoa(*entry.DaysRemaining), entry.KeyType, renew)
+	}
+	return tw.Flush()
+}
+
+// Print the certificate inventory of every configured host and exit non-zero if any host
+// could not be checked
+func runInventory(config Config, format string) {
+	var err error
+	switch {
+	case format != inventoryOutputTable && format != inventoryOutputJSON:
+		err = fmt.Errorf("invalid output format %q: must be %s or %s", format, inventoryOutputTable, inventoryOutputJSON)
+	case config.Hostname == "" && len(config.Hosts) == 0:
+		err = fmt.Errorf("inventory requires hostname or a hosts list")
+	case !config.Insecure && config.CABundle == "":
+		err = fmt.Errorf("inventory requires -insecure to accept the hosts' certificates without verification or -ca-bundle to verify them")
+	}
+	if err == nil {
+		err = configureHostTrust(config)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitCodeFailure)
+	}
+
+	checker := func(hostname string, threshold float64) (bool, *x509.Certificate, error) {
+		return checkCertificateWithDialer(hostname, threshold, &DefaultTLSDialer{})
+	}
+	entries := collectInventory(config, checker, time.Now())
+	if err := writeInventory(os.Stdout, entries, format); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing inventory: %v\n", err)
+		os.Exit(exitCodeFailure)
+	}
+	for _, entry := range entries {
+		if entry.Error != "" {
+			os.Exit(exitCodeFailure)
+		}
+	}
+	os.Exit(0)
+}
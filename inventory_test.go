@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCollectInventory(t *testing.T) {
+	now := time.Now()
+	root := issueTestCertificate(t, "Test Root", true, now.Add(10*365*24*time.Hour), nil)
+	certs := map[string]*x509.Certificate{
+		"esxi01.lab.example.com":      issueTestCertificate(t, "esxi01.lab.example.com", false, now.Add(60*24*time.Hour+time.Hour), root).cert,
+		"esxi02.lab.example.com:8443": issueTestCertificate(t, "esxi02.lab.example.com", false, now.Add(5*24*time.Hour+time.Hour), root).cert,
+	}
+
+	var checked []string
+	checker := func(address string, threshold float64) (bool, *x509.Certificate, error) {
+		checked = append(checked, address)
+		cert, ok := certs[address]
+		if !ok {
+			return false, nil, errors.New("connection refused")
+		}
+		return cert.NotAfter.Sub(now) < 10*24*time.Hour, cert, nil
+	}
+
+	config := Config{
+		Hostname:      "ignored.lab.example.com",
+		Threshold:     0.33,
+		ESXiHTTPSPort: 443,
+		Hosts: []HostConfig{
+			{Hostname: "esxi01.lab.example.com"},
+			{Hostname: "esxi02.lab.example.com", ESXiHTTPSPort: 8443},
+			{Hostname: "esxi03.lab.example.com"},
+		},
+	}
+	entries := collectInventory(config, checker, now)
+
+	if want := []string{"esxi01.lab.example.com", "esxi02.lab.example.com:8443", "esxi03.lab.example.com"}; strings.Join(checked, " ") != strings.Join(want, " ") {
+		t.Fatalf("Expected checks of %v, got %v", want, checked)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("Expected 3 entries, got %d", len(entries))
+	}
+	if entries[0].NeedsRenewal || *entries[0].DaysRemaining != 60 || entries[0].KeyType != "ECDSA P-256" || !strings.Contains(entries[0].Issuer, "Test Root") {
+		t.Errorf("Unexpected entry for esxi01: %+v", entries[0])
+	}
+	if !entries[1].NeedsRenewal || *entries[1].DaysRemaining != 5 {
+		t.Errorf("Expected esxi02 to need renewal with 5 days left, got %+v", entries[1])
+	}
+	if entries[2].Error == "" || entries[2].Expiry != nil {
+		t.Errorf("Expected esxi03 to report the connection error, got %+v", entries[2])
+	}
+
+	t.Run("foreign issuer", func(t *testing.T) {
+		config := Config{Hostname: "esxi01.lab.example.com", RenewIfIssuerNot: "Let's Encrypt"}
+		entries := collectInventory(config, checker, now)
+		if len(entries) != 1 || !entries[0].NeedsRenewal {
+			t.Errorf("Expected a certificate from another issuer to need renewal, got %+v", entries)
+		}
+	})
+}
+
+func TestWriteInventory(t *testing.T) {
+	expiry := time.Date(2026, 12, 1, 0, 0, 0, 0, time.UTC)
+	days := 47
+	entries := []inventoryEntry{
+		{Hostname: "esxi01.lab.example.com", Issuer: "CN=R11,O=Let's Encrypt,C=US", Expiry: &expiry, DaysRemaining: &days, KeyType: "RSA 2048"},
+		{Hostname: "esxi02.lab.example.com", Error: "failed to connect"},
+	}
+
+	var table bytes.Buffer
+	if err := writeInventory(&table, entries, inventoryOutputTable); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, want := range []string{"HOSTNAME", "esxi01.lab.example.com", "2026-12-01T00:00:00Z", "47", "RSA 2048", "ERROR: failed to connect"} {
+		if !strings.Contains(table.String(), want) {
+			t.Errorf("Expected table to contain %q:\n%s", want, table.String())
+		}
+	}
+
+	var out bytes.Buffer
+	if err := writeInventory(&out, entries, inventoryOutputJSON); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var decoded []inventoryEntry
+	if err := json.Unmarshal(out.Bytes(), &decoded); err != nil {
+		t.Fatalf("Inventory is not a JSON array: %v", err)
+	}
+	if len(decoded) != 2 || *decoded[0].DaysRemaining != 47 || decoded[1].Error != "failed to connect" {
+		t.Errorf("Unexpected JSON inventory: %s", out.String())
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// labelKeyPattern is the form of a -label key, usable unchanged as a metrics label name and
+// in an environment variable name
+var labelKeyPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// labelSet holds labels keyed by name, as in the config file and the status file
+type labelSet map[string]string
+
+// parseLabel splits a key=value label, checking that the key is well-formed
+func parseLabel(label string) (string, string, error) {
+	key, value, ok := strings.Cut(label, "=")
+	if !ok {
+		return "", "", fmt.Errorf("invalid label %q, expected key=value", label)
+	}
+	if !labelKeyPattern.MatchString(key) {
+		return "", "", fmt.Errorf("invalid label key %q, must start with a letter or underscore and contain only letters, digits and underscores", key)
+	}
+	return key, value, nil
+}
+
+// validateLabels checks every label and rejects a key given twice
+func validateLabels(labels []string) error {
+	seen := make(map[string]bool)
+	for _, label := range labels {
+		key, _, err := parseLabel(label)
+		if err != nil {
+			return err
+		}
+		if seen[key] {
+			return fmt.Errorf("label %q is given more than once", key)
+		}
+		seen[key] = true
+	}
+	return nil
+}
+
+// labelMap returns the run's labels keyed by name, or nil when there are none
+func labelMap(labels []string) labelSet {
+	if len(labels) == 0 {
+		return nil
+	}
+	m := make(labelSet, len(labels))
+	for _, label := range labels {
+		if key, value, err := parseLabel(label); err == nil {
+			m[key] = value
+		}
+	}
+	return m
+}
+
+// labelsFromMap renders config file labels as key=value pairs in key order
+func labelsFromMap(m labelSet) []string {
+	labels := make([]string, 0, len(m))
+	for key, value := range m {
+		labels = append(labels, key+"="+value)
+	}
+	sort.Strings(labels)
+	return labels
+}
+
+// labelEnvironment exposes the labels to hook commands, as LABELS=key=value,... and one
+// LABEL_<KEY>=value per label
+func labelEnvironment(labels []string) []string {
+	env := []string{"LABELS=" + strings.Join(labels, ",")}
+	for _, label := range labels {
+		if key, value, err := parseLabel(label); err == nil {
+			env = append(env, "LABEL_"+strings.ToUpper(key)+"="+value)
+		}
+	}
+	return env
+}
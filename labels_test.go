@@ -0,0 +1,65 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateLabels(t *testing.T) {
+	if err := validateLabels([]string{"env=prod", "datacenter=syd1", "_owner=", "note=a=b"}); err != nil {
+		t.Errorf("Expected valid labels, got %v", err)
+	}
+
+	tests := []struct {
+		labels    []string
+		errorPart string
+	}{
+		{[]string{"env"}, "expected key=value"},
+		{[]string{"=prod"}, "invalid label key"},
+		{[]string{"data-center=syd1"}, "invalid label key"},
+		{[]string{"1env=prod"}, "invalid label key"},
+		{[]string{"env=prod", "env=dev"}, "more than once"},
+	}
+	for _, tt := range tests {
+		if err := validateLabels(tt.labels); err == nil || !strings.Contains(err.Error(), tt.errorPart) {
+			t.Errorf("validateLabels(%v) error = %v, expected %q", tt.labels, err, tt.errorPart)
+		}
+	}
+}
+
+func TestLabelEnvironment(t *testing.T) {
+	env := labelEnvironment([]string{"env=prod", "owner=infra"})
+	expected := []string{"LABELS=env=prod,owner=infra", "LABEL_ENV=prod", "LABEL_OWNER=infra"}
+	if !reflect.DeepEqual(env, expected) {
+		t.Errorf("Unexpected label environment: %v", env)
+	}
+	if labelMap(nil) != nil {
+		t.Error("Expected no label map without labels")
+	}
+}
+
+func TestConfigFileLabels(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"labels": {"owner": "infra", "env": "prod"}}`), 0600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	cm := NewConfigManager()
+	cm.LoadDefaults()
+	if err := cm.LoadConfigFile(path); err != nil {
+		t.Fatalf("LoadConfigFile() error = %v", err)
+	}
+	config := cm.BuildConfig()
+	if !reflect.DeepEqual(config.Labels, []string{"env=prod", "owner=infra"}) {
+		t.Errorf("Expected the labels in key order, got %v", config.Labels)
+	}
+
+	status := buildScheduleStatus(config, WorkflowResult{}, nil, time.Time{}, time.Time{})
+	if status.Labels["env"] != "prod" || status.Labels["owner"] != "infra" {
+		t.Errorf("Expected the labels in the status, got %v", status.Labels)
+	}
+}
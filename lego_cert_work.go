@@ -1,30 +1,48 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"crypto"
-	"crypto/rand"
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/asn1"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"math"
 	"net"
+	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/go-acme/lego/v4/acme"
+	"github.com/go-acme/lego/v4/acme/api"
+	"github.com/go-acme/lego/v4/certcrypto"
 	"github.com/go-acme/lego/v4/certificate"
 	"github.com/go-acme/lego/v4/challenge/dns01"
+	"github.com/go-acme/lego/v4/challenge/http01"
 	"github.com/go-acme/lego/v4/lego"
 	"github.com/go-acme/lego/v4/providers/dns/route53"
 	"github.com/go-acme/lego/v4/registration"
+	"github.com/gofrs/flock"
+	"github.com/pquerna/otp/totp"
 	"github.com/vmware/govmomi"
 	"github.com/vmware/govmomi/find"
 	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/session"
+	"github.com/vmware/govmomi/vim25"
+	"github.com/vmware/govmomi/vim25/soap"
 	"github.com/vmware/govmomi/vim25/types"
 	"golang.org/x/crypto/ssh"
 )
@@ -42,6 +60,85 @@ func (d *DefaultTLSDialer) Dial(network, addr string, config *tls.Config) (*tls.
 	return tls.Dial(network, addr, config)
 }
 
+// IP version preferences for -ip-version
+const (
+	ipVersionAuto = "auto"
+	ipVersion4    = "4"
+	ipVersion6    = "6"
+)
+
+// dialNetwork is the network used for every connection to the host (TLS checks, SSH, SOAP),
+// narrowed to tcp4 or tcp6 by -ip-version
+var dialNetwork = "tcp"
+
+// esxiHostOnly returns the hostname without any port it was given with
+func esxiHostOnly(hostname string) string {
+	if host, _, err := net.SplitHostPort(hostname); err == nil {
+		return host
+	}
+	return hostname
+}
+
+// esxiHTTPSAddress returns the address for TLS and SOAP connections. A port given in the
+// hostname wins; otherwise -esxi-https-port is appended when it isn't the default 443.
+func esxiHTTPSAddress(config Config) string {
+	if _, _, err := net.SplitHostPort(config.Hostname); err == nil {
+		return config.Hostname
+	}
+	if config.ESXiHTTPSPort == 0 || config.ESXiHTTPSPort == defaultESXiHTTPSPort {
+		return config.Hostname
+	}
+	return net.JoinHostPort(config.Hostname, strconv.Itoa(config.ESXiHTTPSPort))
+}
+
+// esxiSSHAddress returns the host:port for SSH connections
+func esxiSSHAddress(config Config) string {
+	port := config.ESXiSSHPort
+	if port == 0 {
+		port = defaultESXiSSHPort
+	}
+	return net.JoinHostPort(esxiHostOnly(config.Hostname), strconv.Itoa(port))
+}
+
+// Get the dial network for an IP version preference
+func networkForIPVersion(ipVersion string) string {
+	switch ipVersion {
+	case ipVersion4:
+		return "tcp4"
+	case ipVersion6:
+		return "tcp6"
+	default:
+		return "tcp"
+	}
+}
+
+// Certificate installation methods
+const (
+	installMethodSSH         = "ssh"
+	installMethodSOAPCertMgr = "soap-certmgr"
+)
+
+// Remote system types the certificate is installed on
+const (
+	targetTypeESXi = "esxi"
+	targetTypeVCSA = "vcsa"
+)
+
+// ACME challenge types
+const (
+	challengeTypeDNS01  = "dns-01"
+	challengeTypeHTTP01 = "http-01"
+)
+
+// Certificate chain modes for the installed certificate file
+const (
+	chainModeFull     = "full"
+	chainModeLeafOnly = "leaf-only"
+)
+
+// errCertManagerUnsupported indicates the host has no HostCertificateManager (pre-6.0)
+var errCertManagerUnsupported = errors.New("host certificate manager not supported")
+
 // User struct for ACME registration
 type User struct {
 	Email        string
@@ -72,6 +169,43 @@ func (u *User) GetPrivateKey() crypto.PrivateKey {
 	return u.Key
 }
 
+// issuerMatches reports whether the certificate's issuer common name or organization
+// contains the pattern, ignoring case
+func issuerMatches(cert *x509.Certificate, pattern string) bool {
+	pattern = strings.ToLower(pattern)
+	if strings.Contains(strings.ToLower(cert.Issuer.CommonName), pattern) {
+		return true
+	}
+	for _, org := range cert.Issuer.Organization {
+		if strings.Contains(strings.ToLower(org), pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// foreignIssuer reports whether the installed certificate was issued by someone other than
+// the CA named by -renew-if-issuer-not, e.g. the self-signed VMware certificate on a new host
+func foreignIssuer(config Config, cert *x509.Certificate) bool {
+	return config.RenewIfIssuerNot != "" && cert != nil && !issuerMatches(cert, config.RenewIfIssuerNot)
+}
+
+// checkExpectedIssuer rejects a certificate whose issuer matches none of -expected-issuer,
+// guarding against a misconfigured or tampered ACME directory handing out certificates from
+// another CA. With no expected issuers configured every issuer is accepted.
+func checkExpectedIssuer(config Config, cert *x509.Certificate) error {
+	if len(config.ExpectedIssuers) == 0 {
+		return nil
+	}
+	for _, expected := range config.ExpectedIssuers {
+		if issuerMatches(cert, expected) {
+			return nil
+		}
+	}
+	return fmt.Errorf("certificate was issued by %q, which matches none of the expected issuers (%s)",
+		cert.Issuer.String(), strings.Join(config.ExpectedIssuers, ", "))
+}
+
 // Check if certificate needs renewal based on threshold with custom TLS dialer
 func checkCertificateWithDialer(hostname string, threshold float64, dialer TLSDialer) (bool, *x509.Certificate, error) {
 	logInfo("Checking certificate for %s with threshold %.2f", hostname, threshold)
@@ -85,9 +219,7 @@ func checkCertificateWithDialer(hostname string, threshold float64, dialer TLSDi
 	}
 
 	// Connect to server and get certificate
-	conn, err := dialer.Dial("tcp", net.JoinHostPort(host, port), &tls.Config{
-		InsecureSkipVerify: true,
-	})
+	conn, err := dialer.Dial(dialNetwork, net.JoinHostPort(host, port), hostTLSConfig(host))
 	if err != nil {
 		return false, nil, fmt.Errorf("failed to connect to %s: %v", hostname, err)
 	}
@@ -124,6 +256,255 @@ func checkCertificateWithDialer(hostname string, threshold float64, dialer TLSDi
 	return needsRenewal, cert, nil
 }
 
+// Fraction of a certificate's total validity period that remains
+func lifetimeRemaining(cert *x509.Certificate) float64 {
+	totalLifetime := cert.NotAfter.Sub(cert.NotBefore)
+	if totalLifetime <= 0 {
+		return 0
+	}
+	return float64(time.Until(cert.NotAfter)) / float64(totalLifetime)
+}
+
+// Read and parse the first certificate in a PEM file
+func readCertificateFile(path string) (*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read certificate file: %v", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("failed to decode certificate PEM %s", path)
+	}
+
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// Report whether the installed certificate already satisfies what a new one would provide,
+// so it can be kept without ordering: the SANs the order would request, the issuer of the
+// cached certificate (or, with no cache entry, one of -expected-issuer), and more remaining
+// lifetime than the renewal threshold. When it doesn't, the reason describes the first
+// difference found.
+func installedCertificateMatches(config Config, installed, cached *x509.Certificate) (bool, string) {
+	switch {
+	case cached != nil:
+		if installed.Issuer.String() != cached.Issuer.String() {
+			return false, fmt.Sprintf("issuer %q differs from %q", installed.Issuer, cached.Issuer)
+		}
+	case len(config.ExpectedIssuers) > 0:
+		if err := checkExpectedIssuer(config, installed); err != nil {
+			return false, err.Error()
+		}
+	default:
+		return false, "no cached certificate or -expected-issuer to compare the issuer with"
+	}
+
+	installedSANs, wantSANs := certificateSANs(installed), certificateDomains(config)
+	sort.Strings(wantSANs)
+	if strings.Join(installedSANs, ",") != strings.Join(wantSANs, ",") {
+		return false, fmt.Sprintf("SANs %v differ from %v", installedSANs, wantSANs)
+	}
+
+	if remaining := lifetimeRemaining(installed); remaining <= config.Threshold {
+		return false, fmt.Sprintf("%.1f%% lifetime remaining is not above the %.1f%% threshold", remaining*100, config.Threshold*100)
+	}
+
+	return true, ""
+}
+
+// cachedCertificate returns the host's cached certificate, or nil when there is none
+func cachedCertificate(config Config) *x509.Certificate {
+	certPath, _ := cacheFilePaths(defaultCacheDir(), config.Hostname)
+	cert, err := readCertificateFile(certPath)
+	if err != nil {
+		return nil
+	}
+	return cert
+}
+
+// Compute the SHA-256 thumbprint of a certificate in the colon-separated form ESXi displays
+func certificateThumbprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
+
+// Sorted, normalized list of a certificate's DNS and IP subject alternative names
+func certificateSANs(cert *x509.Certificate) []string {
+	sans := make([]string, 0, len(cert.DNSNames)+len(cert.IPAddresses))
+	for _, name := range cert.DNSNames {
+		sans = append(sans, normalizeDNSName(name))
+	}
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	sort.Strings(sans)
+	return sans
+}
+
+// Get the default certificate cache directory
+func defaultCacheDir() string {
+	return filepath.Join(os.TempDir(), "esxi-cert-cache")
+}
+
+// Get the cached certificate and key paths for a hostname. The name is normalized so the
+// same host always maps to the same entry however its case or trailing dot was written.
+func cacheFilePaths(cacheDir, hostname string) (string, string) {
+	hostname = normalizeDomain(hostname)
+	certPath := filepath.Join(cacheDir, fmt.Sprintf("%s-cert.pem", hostname))
+	keyPath := filepath.Join(cacheDir, fmt.Sprintf("%s-key.pem", hostname))
+	return certPath, keyPath
+}
+
+// ensureCacheDir creates the cache directory owner-only, tightening an existing one left
+// 0755 by older versions. A symlink in its place is refused, as the directory sits in a
+// shared temp location where another user could pre-create it.
+func ensureCacheDir(dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create cache directory %s: %v", dir, err)
+	}
+
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return fmt.Errorf("failed to inspect cache directory %s: %v", dir, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("cache directory %s is not a directory (symlinks are not allowed)", dir)
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		if err := os.Chmod(dir, 0700); err != nil {
+			return fmt.Errorf("failed to restrict cache directory %s to its owner: %v", dir, err)
+		}
+	}
+	return nil
+}
+
+// writeFileAtomic writes data to a randomly named temp file beside path and renames it into
+// place, so the final name is never opened for writing and readers never see a partial file
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath) // No-op once renamed
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// PrunedCacheEntry describes a cache entry removed by pruneCache and why
+type PrunedCacheEntry struct {
+	Hostname string
+	Reason   string
+}
+
+// Remove cache entries whose certificate has expired, cannot be parsed, or (when olderThan is
+// non-zero) was written more than olderThan ago. Each entry is locked exclusively while removed.
+func pruneCache(cacheDir string, olderThan, lockTimeout time.Duration, now time.Time) ([]PrunedCacheEntry, error) {
+	certPaths, err := filepath.Glob(filepath.Join(cacheDir, "*-cert.pem"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan cache directory %s: %v", cacheDir, err)
+	}
+
+	var pruned []PrunedCacheEntry
+	for _, certPath := range certPaths {
+		hostname := strings.TrimSuffix(filepath.Base(certPath), "-cert.pem")
+
+		reason := cacheEntryPruneReason(certPath, olderThan, now)
+		if reason == "" {
+			logDebug("Keeping cache entry for %s", hostname)
+			continue
+		}
+
+		lock, err := lockCacheEntry(certPath, lockTimeout, true)
+		if err != nil {
+			return pruned, err
+		}
+
+		_, keyPath := cacheFilePaths(cacheDir, hostname)
+		for _, path := range []string{certPath, keyPath} {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				lock.Unlock()
+				return pruned, fmt.Errorf("failed to remove %s: %v", path, err)
+			}
+		}
+
+		// The entry is gone, so its lock file has nothing left to protect
+		lock.Unlock()
+		os.Remove(lock.Path())
+
+		logInfo("Pruned cache entry for %s (%s)", hostname, reason)
+		pruned = append(pruned, PrunedCacheEntry{Hostname: hostname, Reason: reason})
+	}
+
+	return pruned, nil
+}
+
+// Get the reason a cached certificate should be pruned, or "" to keep it
+func cacheEntryPruneReason(certPath string, olderThan time.Duration, now time.Time) string {
+	cert, err := readCertificateFile(certPath)
+	if err != nil {
+		return "unreadable certificate"
+	}
+	if now.After(cert.NotAfter) {
+		return fmt.Sprintf("expired %s", cert.NotAfter.Format(time.RFC3339))
+	}
+
+	if olderThan > 0 {
+		info, err := os.Stat(certPath)
+		if err == nil && now.Sub(info.ModTime()) > olderThan {
+			return fmt.Sprintf("cached %s, older than %s", info.ModTime().Format(time.RFC3339), olderThan)
+		}
+	}
+
+	return ""
+}
+
+// Lock a cache entry (keyed on its certificate path) so concurrent runs serialize safely.
+// Readers take a shared lock, writers an exclusive one. A timeout of zero waits indefinitely.
+func lockCacheEntry(path string, timeout time.Duration, exclusive bool) (*flock.Flock, error) {
+	lock := flock.New(path + ".lock")
+
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	var locked bool
+	var err error
+	if exclusive {
+		locked, err = lock.TryLockContext(ctx, cacheLockRetryDelay)
+	} else {
+		locked, err = lock.TryRLockContext(ctx, cacheLockRetryDelay)
+	}
+	if err != nil || !locked {
+		return nil, fmt.Errorf("failed to acquire cache lock %s within %s: %v", lock.Path(), timeout, err)
+	}
+
+	logDebug("Acquired cache lock %s (exclusive=%t)", lock.Path(), exclusive)
+	return lock, nil
+}
+
 // Check for cached certificate that's still valid
 // cacheDir parameter is optional - if empty, uses default temp directory
 func getCachedCertificate(config Config) (string, string, bool) {
@@ -135,23 +516,36 @@ func getCachedCertificateWithDir(config Config, cacheDir string) (string, string
 	// If force is enabled, skip cache completely
 	if config.Force {
 		logInfo("Force renewal enabled - skipping certificate cache")
+		reportCacheDecision(config, "cache miss: -force skips the cache")
 		return "", "", false
 	}
 
 	// Use default cache directory if not specified
 	if cacheDir == "" {
-		cacheDir = filepath.Join(os.TempDir(), "esxi-cert-cache")
+		cacheDir = defaultCacheDir()
+	}
+	if err := ensureCacheDir(cacheDir); err != nil {
+		logWarn("Certificate cache unavailable: %v", err)
+		return "", "", false
 	}
-	os.MkdirAll(cacheDir, 0755)
 
-	certPath := filepath.Join(cacheDir, fmt.Sprintf("%s-cert.pem", config.Hostname))
-	keyPath := filepath.Join(cacheDir, fmt.Sprintf("%s-key.pem", config.Hostname))
+	certPath, keyPath := cacheFilePaths(cacheDir, config.Hostname)
+
+	// Hold a shared lock so a concurrent run cannot rewrite the entry while we read it
+	lock, err := lockCacheEntry(certPath, config.CacheLockTimeout, false)
+	if err != nil {
+		logWarn("Failed to lock certificate cache: %v", err)
+		return "", "", false
+	}
+	defer lock.Unlock()
 
 	// Check if cached files exist
 	if _, err := os.Stat(certPath); os.IsNotExist(err) {
+		reportCacheDecision(config, "cache miss: no cached certificate at "+certPath)
 		return "", "", false
 	}
 	if _, err := os.Stat(keyPath); os.IsNotExist(err) {
+		reportCacheDecision(config, "cache miss: no cached key at "+keyPath)
 		return "", "", false
 	}
 
@@ -159,6 +553,7 @@ func getCachedCertificateWithDir(config Config, cacheDir string) (string, string
 	certData, err := os.ReadFile(certPath)
 	if err != nil {
 		logWarn("Failed to read cached certificate: %v", err)
+		reportCacheDecision(config, fmt.Sprintf("cache miss: cached certificate unreadable: %v", err))
 		return "", "", false
 	}
 
@@ -166,72 +561,456 @@ func getCachedCertificateWithDir(config Config, cacheDir string) (string, string
 	block, _ := pem.Decode(certData)
 	if block == nil {
 		logWarn("Failed to decode cached certificate PEM")
+		reportCacheDecision(config, "cache miss: cached certificate is not PEM")
 		return "", "", false
 	}
 
 	cert, err := x509.ParseCertificate(block.Bytes)
 	if err != nil {
 		logWarn("Failed to parse cached certificate: %v", err)
+		reportCacheDecision(config, fmt.Sprintf("cache miss: cached certificate unparseable: %v", err))
 		return "", "", false
 	}
 
-	// Check if certificate is still valid and has reasonable time left
-	now := time.Now()
-	timeRemaining := cert.NotAfter.Sub(now)
-	totalLifetime := cert.NotAfter.Sub(cert.NotBefore)
-	percentRemaining := timeRemaining.Seconds() / totalLifetime.Seconds()
+	if err := checkExpectedIssuer(config, cert); err != nil {
+		logWarn("Ignoring cached certificate: %v", err)
+		reportCacheDecision(config, fmt.Sprintf("cache miss: %v", err))
+		return "", "", false
+	}
 
-	// Verify cached certificate uses RSA signature algorithm
-	logDebug("Cached certificate signature algorithm: %s", cert.SignatureAlgorithm.String())
-	if cert.SignatureAlgorithm != x509.SHA256WithRSA {
-		logInfo("Cached certificate does not use SHA256WithRSA, regenerating...")
+	// The cached certificate must be signed for the key type a new order would use; a
+	// supplied CSR brings its own key type
+	keyType, err := certificateKeyType(config)
+	if config.CSRFile != "" {
+		keyType, err = keyTypeOf(cert.PublicKey), nil
+	}
+	if err != nil {
+		reportCacheDecision(config, fmt.Sprintf("cache miss: %v", err))
 		return "", "", false
 	}
 
+	logDebug("Cached certificate signature algorithm: %s", cert.SignatureAlgorithm.String())
+
 	// Use a higher threshold for cached certificates to avoid frequent regeneration
-	if percentRemaining > 0.5 { // 50% remaining
-		logInfo("Using cached certificate (%.1f%% lifetime remaining) with SHA256WithRSA signature", percentRemaining*100)
+	reuse, decision := cacheDecision(cert, keyType, cacheReuseThreshold(config), time.Now())
+	reportCacheDecision(config, decision)
+	if reuse {
+		logInfo("Using cached certificate (%.1f%% lifetime remaining) with %s signature", lifetimeRemaining(cert)*100, cert.SignatureAlgorithm)
 		return certPath, keyPath, true
 	}
 
-	logInfo("Cached certificate too close to expiration (%.1f%% remaining), will generate new one", percentRemaining*100)
+	logInfo("Cached certificate not reused (%s), will generate new one", decision)
 	return "", "", false
 }
 
 // Generate a new certificate using go-lego and Let's Encrypt
 func generateCertificate(config Config) (string, string, error) {
+	// A supplied PFX replaces ACME entirely
+	if config.PFXFile != "" {
+		return importPFXCertificate(config, defaultCacheDir())
+	}
+
 	// First check for cached certificate
 	if certPath, keyPath, found := getCachedCertificate(config); found {
 		return certPath, keyPath, nil
 	}
 
 	logInfo("No valid cached certificate found, generating new certificate...")
-	// Create a user
+
+	// Ordering while the CA's rate limit is in force would only extend it
+	rateLimits := NewRenewalHistory(defaultRenewalHistoryPath(), config.CacheLockTimeout)
+	scopes := rateLimitScopesFor(config)
+	if until, err := rateLimits.RateLimitedUntil(scopes.all()...); err != nil {
+		logWarn("Could not check rate-limit state: %v", err)
+	} else if !until.IsZero() {
+		return "", "", &RateLimitError{Hostname: config.Hostname, RetryAfter: until, Detail: "lockout recorded by an earlier rate-limited order"}
+	}
+
+	// Every order counts towards -min-issuance-interval, whether or not it succeeds
+	var intervalErr *IssuanceIntervalError
+	if err := rateLimits.ReserveIssuance(config.Hostname, config.batchID, config.MinIssuanceInterval); errors.As(err, &intervalErr) {
+		return "", "", err
+	} else if err != nil {
+		return "", "", fmt.Errorf("could not check the minimum issuance interval: %v", err)
+	}
+
+	certificates, err := obtainCertificate(config, acmeServerProduction)
+	if err != nil {
+		var rateErr *RateLimitError
+		if errors.As(err, &rateErr) && !rateErr.RetryAfter.IsZero() {
+			scope := scopes.forDetail(rateErr.Detail)
+			if recordErr := rateLimits.RecordRateLimit(scope, rateErr.RetryAfter); recordErr != nil {
+				logWarn("Could not record rate-limit lockout: %v", recordErr)
+			} else {
+				logInfo("Recorded rate-limit lockout for %s until %s", scope, rateErr.RetryAfter.Format(time.RFC3339))
+			}
+		}
+		return "", "", err
+	}
+
+	// Check the issuer before the certificate is cached, so a rejected one can never be uploaded
+	if len(config.ExpectedIssuers) > 0 {
+		block, _ := pem.Decode(certificates.Certificate)
+		if block == nil {
+			return "", "", fmt.Errorf("CA returned an unparseable certificate")
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to parse issued certificate: %v", err)
+		}
+		if err := checkExpectedIssuer(config, cert); err != nil {
+			return "", "", fmt.Errorf("refusing to install certificate: %v", err)
+		}
+		logInfo("Issuer %q is on the expected issuer list", cert.Issuer.CommonName)
+	}
+
+	// Save certificate to cache directory for reuse
+	cacheDir := defaultCacheDir()
+	if err := ensureCacheDir(cacheDir); err != nil {
+		return "", "", err
+	}
+
+	certPath, keyPath := cacheFilePaths(cacheDir, config.Hostname)
+
+	// Hold an exclusive lock so concurrent runs never see a half-written cache entry
+	lock, err := lockCacheEntry(certPath, config.CacheLockTimeout, true)
+	if err != nil {
+		return "", "", err
+	}
+	defer lock.Unlock()
+
+	// Write certificate to cache
+	if err := writeFileAtomic(certPath, certificates.Certificate, 0600); err != nil {
+		return "", "", fmt.Errorf("failed to write cert file: %v", err)
+	}
+
+	// Write key to cache
+	if err := writeFileAtomic(keyPath, certificates.PrivateKey, 0600); err != nil {
+		return "", "", fmt.Errorf("failed to write key file: %v", err)
+	}
+
+	logInfo("Certificate cached to %s", cacheDir)
+	return certPath, keyPath, nil
+}
+
+// testCertificateIssuance orders a certificate from the Let's Encrypt staging CA to prove the
+// DNS challenge and AWS setup work end to end. The result is neither cached nor uploaded.
+func testCertificateIssuance(config Config) error {
+	logInfo("Ordering test certificate for %s from %s", config.Hostname, acmeServerStaging)
+
+	certificates, err := obtainCertificate(config, acmeServerStaging)
+	if err != nil {
+		return err
+	}
+
+	block, _ := pem.Decode(certificates.Certificate)
+	if block == nil {
+		return fmt.Errorf("staging CA returned an unparseable certificate")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("failed to parse staging certificate: %v", err)
+	}
+
+	logInfo("Staging certificate issued by %s, valid until %s", cert.Issuer.CommonName, cert.NotAfter.Format(time.RFC3339))
+	return nil
+}
+
+// normalizeDomain lowercases a DNS name and drops a trailing root dot, which CAs reject
+func normalizeDomain(name string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(name)), ".")
+}
+
+// normalizeDomains normalizes DNS names and drops empty and duplicate entries, keeping the
+// first-seen order so the hostname stays first (and the common name)
+func normalizeDomains(names []string) []string {
+	var domains []string
+	for _, name := range names {
+		if name = normalizeDomain(name); name != "" && !slices.Contains(domains, name) {
+			domains = append(domains, name)
+		}
+	}
+	return domains
+}
+
+// certificateDomains returns the normalized names to order a certificate for, without any
+// port given with the hostname
+func certificateDomains(config Config) []string {
+	return normalizeDomains([]string{esxiHostOnly(config.Hostname)})
+}
+
+// obtainCertificate registers with the given ACME directory and completes a DNS-01 (Route53) or HTTP-01 order for the hostname
+func obtainCertificate(config Config, caDirURL string) (*certificate.Resource, error) {
+	// Fail fast if the domain has no accessible Route53 hosted zone, rather than timing out during the DNS challenge
+	if config.ChallengeType != challengeTypeHTTP01 {
+		zoneID, err := preflightRoute53HostedZone(config)
+		if err != nil {
+			return nil, err
+		}
+		config.Route53ZoneID = zoneID
+	}
+
+	// Create a user with the account key kept from earlier runs
+	accountKey, err := loadOrCreateAccountKey(config, defaultCacheDir(), caDirURL)
+	if err != nil {
+		return nil, err
+	}
 	user := &User{
 		Email: config.Email,
-		Key:   generatePrivateKey(config),
+		Key:   accountKey,
+	}
+
+	certKeyType, err := certificateKeyType(config)
+	if err != nil {
+		return nil, err
 	}
 
 	// Initialize ACME client
 	legoCfg := lego.NewConfig(user)
-	legoCfg.CADirURL = acmeServerProduction
+	legoCfg.CADirURL = caDirURL
+	legoCfg.Certificate.KeyType = certKeyType
+	legoCfg.UserAgent = config.ACMEUserAgent
+	logInfo("Certificate key type: %s", certKeyType)
 	client, err := lego.NewClient(legoCfg)
 	if err != nil {
-		return "", "", fmt.Errorf("failed to create ACME client: %v", err)
+		return nil, fmt.Errorf("failed to create ACME client: %v", err)
 	}
 
-	// Set up Route53 provider configuration
+	if err := configureChallenge(client, config); err != nil {
+		return nil, err
+	}
+
+	// Register user
+	reg, err := client.Registration.Register(registration.RegisterOptions{TermsOfServiceAgreed: true})
+	if err != nil {
+		return nil, acmeRequestError(config.Hostname, "register account", err)
+	}
+	user.Registration = reg
+
+	// lego registers the email alone, so additional contacts are set with an account update
+	if len(config.ACMEContacts) > 0 {
+		if err := updateACMEContacts(legoCfg, user, reg.URI, acmeContactURIs(config)); err != nil {
+			logWarn("Could not set additional ACME contacts: %v", err)
+		}
+	}
+
+	if config.ACMEProfile != "" {
+		logInfo("Requesting ACME profile: %s", config.ACMEProfile)
+	}
+	if config.PreferredChain != "" {
+		logInfo("Requesting preferred chain: %s", config.PreferredChain)
+	}
+
+	var certificates *certificate.Resource
+	if config.CSRFile != "" {
+		// Submit the externally generated CSR; lego never sees the key, so hand it the supplied one for the cache
+		csr, key, err := loadCSRWithKey(config)
+		if err != nil {
+			return nil, err
+		}
+
+		logInfo("Requesting certificate for %v using CSR file %s", csrNames(csr), config.CSRFile)
+		certificates, err = client.Certificate.ObtainForCSR(certificate.ObtainForCSRRequest{
+			CSR:            csr,
+			PrivateKey:     key,
+			Bundle:         true,
+			Profile:        config.ACMEProfile,
+			PreferredChain: config.PreferredChain,
+		})
+		if err != nil {
+			return nil, acmeRequestError(config.Hostname, "obtain certificate for CSR", err)
+		}
+	} else {
+		// Request the certificate with a key of the configured type
+		domains := certificateDomains(config)
+		logInfo("Certificate names: %s", strings.Join(domains, ", "))
+		request := certificate.ObtainRequest{
+			Domains:        domains,
+			Bundle:         true,
+			MustStaple:     config.MustStaple,
+			Profile:        config.ACMEProfile,
+			PreferredChain: config.PreferredChain,
+		}
+
+		// Reuse the cached certificate key when key pinning is requested; otherwise lego generates a fresh key
+		if config.ReuseKey {
+			key, err := loadCachedPrivateKey(config)
+			if err != nil {
+				logWarn("Cannot reuse private key, generating a fresh one: %v", err)
+			} else {
+				logInfo("Reusing previously cached private key for %s", config.Hostname)
+				request.PrivateKey = key
+			}
+		}
+
+		if len(config.ExtKeyUsages) > 0 {
+			// lego's own CSR has no room for extended key usages, so build one and submit it instead
+			key := request.PrivateKey
+			if key == nil {
+				if key, err = certcrypto.GeneratePrivateKey(certKeyType); err != nil {
+					return nil, fmt.Errorf("failed to generate certificate key: %v", err)
+				}
+			}
+			csr, err := buildCSR(config, key)
+			if err != nil {
+				return nil, err
+			}
+
+			logInfo("Requesting certificate for hostname: %v with extended key usages %s", domains, strings.Join(config.ExtKeyUsages, ", "))
+			certificates, err = client.Certificate.ObtainForCSR(certificate.ObtainForCSRRequest{
+				CSR:            csr,
+				PrivateKey:     key,
+				Bundle:         true,
+				Profile:        config.ACMEProfile,
+				PreferredChain: config.PreferredChain,
+			})
+			if err != nil {
+				return nil, acmeRequestError(config.Hostname, "obtain certificate", err)
+			}
+		} else {
+			logInfo("Requesting certificate for hostname: %v using %s private key", domains, certKeyType)
+			certificates, err = client.Certificate.Obtain(request)
+			if err != nil {
+				return nil, acmeRequestError(config.Hostname, "obtain certificate", err)
+			}
+		}
+	}
+
+	if issuer := chainTopIssuer(certificates.Certificate); issuer != "" {
+		logInfo("Selected certificate chain: %s", issuer)
+		if config.PreferredChain != "" && issuer != config.PreferredChain {
+			logWarn("Warning: The CA offered no chain issued by %q, using the default chain", config.PreferredChain)
+		}
+	}
+
+	// Verify the signature algorithm matches the certificate's key type
+	block, _ := pem.Decode(certificates.Certificate)
+	if block != nil {
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err == nil {
+			logDebug("Certificate signature algorithm: %s", cert.SignatureAlgorithm.String())
+			issuedKeyType := keyTypeOf(cert.PublicKey)
+			if !slices.Contains(acceptedSignatureAlgorithms(issuedKeyType), cert.SignatureAlgorithm) {
+				logWarn("Warning: Certificate signature algorithm %s is unexpected for a %s key", cert.SignatureAlgorithm, issuedKeyType)
+			} else {
+				logInfo("Confirmed: Certificate uses %s signature algorithm for its %s key", cert.SignatureAlgorithm, issuedKeyType)
+			}
+			if config.MustStaple {
+				if hasMustStaple(cert) {
+					logInfo("Confirmed: Certificate carries the OCSP Must-Staple extension")
+				} else {
+					logWarn("Warning: Must-Staple was requested but the issued certificate does not carry the extension")
+				}
+			}
+			if missing := missingExtKeyUsages(cert, config.ExtKeyUsages); len(missing) > 0 {
+				logWarn("Warning: The CA did not honor the requested extended key usages %s", strings.Join(missing, ", "))
+			} else if len(config.ExtKeyUsages) > 0 {
+				logInfo("Confirmed: Certificate carries the requested extended key usages")
+			}
+		}
+	}
+
+	return certificates, nil
+}
+
+// Contact URIs for the ACME account: the registration email followed by any -acme-contact
+// addresses, as mailto: URIs
+func acmeContactURIs(config Config) []string {
+	var contacts []string
+	for _, contact := range append([]string{config.Email}, config.ACMEContacts...) {
+		if contact == "" {
+			continue
+		}
+		if !strings.HasPrefix(contact, "mailto:") {
+			contact = "mailto:" + contact
+		}
+		contacts = append(contacts, contact)
+	}
+	return contacts
+}
+
+// Replace the contacts of a registered ACME account
+func updateACMEContacts(legoCfg *lego.Config, user *User, accountURL string, contacts []string) error {
+	core, err := api.New(legoCfg.HTTPClient, legoCfg.UserAgent, legoCfg.CADirURL, accountURL, user.Key)
+	if err != nil {
+		return err
+	}
+	if _, err := core.Accounts.Update(accountURL, acme.Account{Contact: contacts}); err != nil {
+		return err
+	}
+	logInfo("ACME account contacts: %s", strings.Join(contacts, ", "))
+	return nil
+}
+
+// OID of the TLS Feature extension (RFC 7633) and the status_request feature it lists for Must-Staple
+var (
+	oidTLSFeature           = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 24}
+	tlsFeatureStatusRequest = 5
+)
+
+// chainTopIssuer returns the issuer common name of the last certificate in a PEM bundle, the
+// name lego matches -preferred-chain against, or "" when the bundle cannot be parsed
+func chainTopIssuer(bundle []byte) string {
+	certs, err := certcrypto.ParsePEMBundle(bundle)
+	if err != nil || len(certs) == 0 {
+		return ""
+	}
+	return certs[len(certs)-1].Issuer.CommonName
+}
+
+// Check whether a certificate carries the OCSP Must-Staple (TLS Feature status_request) extension
+func hasMustStaple(cert *x509.Certificate) bool {
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oidTLSFeature) {
+			continue
+		}
+		var features []int
+		if _, err := asn1.Unmarshal(ext.Value, &features); err != nil {
+			return false
+		}
+		for _, feature := range features {
+			if feature == tlsFeatureStatusRequest {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// configureChallenge sets up the ACME challenge provider selected by the challenge type
+func configureChallenge(client *lego.Client, config Config) error {
+	if config.ChallengeType == challengeTypeHTTP01 {
+		port := strconv.Itoa(config.HTTPChallengePort)
+		logInfo("Using HTTP-01 challenge, serving tokens on port %s", port)
+		if err := client.Challenge.SetHTTP01Provider(http01.NewProviderServer("", port)); err != nil {
+			return fmt.Errorf("failed to set HTTP challenge provider: %v", err)
+		}
+		return nil
+	}
+
+	return configureRoute53Challenge(client, config)
+}
+
+// newRoute53ProviderConfig builds the lego Route53 provider configuration. A key ID selects
+// explicit credentials (ValidateConfig guarantees its secret is present); without one they are
+// left unset, so lego resolves them through the AWS default credential chain (environment,
+// shared profiles, SSO, instance roles) exactly as the STS validation in validateAWSCredentials does.
+func newRoute53ProviderConfig(config Config) *route53.Config {
 	route53Config := &route53.Config{
-		MaxRetries:         5,
-		TTL:                60,
+		MaxRetries:         route53MaxRetries(config),
+		TTL:                dnsTTL(config),
 		PropagationTimeout: 2 * time.Minute,
 		PollingInterval:    4 * time.Second,
-		HostedZoneID:       "", // Auto-detect
+		HostedZoneID:       config.Route53ZoneID, // Empty lets lego auto-detect
 		Region:             config.Route53Region,
 	}
 
-	// Only set explicit credentials if provided; otherwise lego will use AWS SDK default credential chain
-	if config.Route53KeyID != "" {
+	// Only set explicit credentials if provided; otherwise lego will use AWS SDK default credential chain.
+	// ValidateConfig rejects a key ID without its secret, so a lone key ID is never masked by the chain.
+	// A -route53-profile is applied through the client configureRoute53Challenge supplies.
+	if config.Route53Profile != "" {
+		logDebug("Configuring Route53 provider with AWS profile %s", config.Route53Profile)
+	} else if config.Route53KeyID != "" {
 		logDebug("Configuring Route53 provider with explicit AWS credentials")
 		route53Config.AccessKeyID = config.Route53KeyID
 		route53Config.SecretAccessKey = config.Route53SecretKey
@@ -240,184 +1019,535 @@ func generateCertificate(config Config) (string, string, error) {
 		logInfo("Configuring Route53 provider to use AWS default credential chain")
 	}
 
+	return route53Config
+}
+
+// configureRoute53Challenge sets up the Route53 DNS-01 challenge provider
+func configureRoute53Challenge(client *lego.Client, config Config) error {
+	route53Config := newRoute53ProviderConfig(config)
+
+	// lego has no endpoint, timeout or profile option, so hand it a preconfigured client for those
+	if config.AWSEndpoint != "" || config.AWSTimeout > 0 || config.Route53Profile != "" {
+		r53Client, err := newRoute53Client(context.TODO(), config)
+		if err != nil {
+			return err
+		}
+		route53Config.Client = r53Client
+	}
+
 	provider, err := route53.NewDNSProviderConfig(route53Config)
 
 	if err != nil {
-		return "", "", fmt.Errorf("failed to initialize Route53 provider: %v", err)
+		return fmt.Errorf("failed to initialize Route53 provider: %v", err)
+	}
+
+	// Prove the record can be created and resolved before committing to an order
+	if config.DNSPreflight {
+		if err := runDNSPreflight(provider, certificateDomains(config)[0], publicTXTLookup); err != nil {
+			return err
+		}
 	}
 
 	// Set DNS challenge provider
-	err = client.Challenge.SetDNS01Provider(provider, dns01.AddRecursiveNameservers([]string{"8.8.8.8:53", "1.1.1.1:53"}))
+	err = client.Challenge.SetDNS01Provider(provider, dns01ChallengeOptions(config)...)
 	if err != nil {
-		return "", "", fmt.Errorf("failed to set DNS challenge provider: %v", err)
+		return fmt.Errorf("failed to set DNS challenge provider: %v", err)
 	}
 
-	// Register user
-	reg, err := client.Registration.Register(registration.RegisterOptions{TermsOfServiceAgreed: true})
+	return nil
+}
+
+// dns01ChallengeOptions returns lego's DNS-01 options: the public resolvers used for the
+// propagation check, plus a fixed wait before it or no check at all when configured
+func dns01ChallengeOptions(config Config) []dns01.ChallengeOption {
+	opts := []dns01.ChallengeOption{dns01.AddRecursiveNameservers(dnsRecursiveNameservers)}
+	if config.DNSSkipPropagation || config.DNSPropagationWait > 0 {
+		if config.DNSSkipPropagation {
+			logInfo("Skipping the DNS propagation check; the CA is notified %s after the record is created", config.DNSPropagationWait)
+		}
+		opts = append(opts, dns01.PropagationWait(config.DNSPropagationWait, config.DNSSkipPropagation))
+	}
+	return opts
+}
+
+// Load the private key of the previously cached certificate for key reuse
+func loadCachedPrivateKey(config Config) (crypto.PrivateKey, error) {
+	return loadCachedPrivateKeyWithDir(config, "")
+}
+
+// loadCachedPrivateKeyWithDir allows specifying a custom cache directory for testing
+func loadCachedPrivateKeyWithDir(config Config, cacheDir string) (crypto.PrivateKey, error) {
+	if cacheDir == "" {
+		cacheDir = defaultCacheDir()
+	}
+
+	certPath, keyPath := cacheFilePaths(cacheDir, config.Hostname)
+
+	lock, err := lockCacheEntry(certPath, config.CacheLockTimeout, false)
 	if err != nil {
-		return "", "", fmt.Errorf("failed to register account: %v", err)
+		return nil, err
 	}
-	user.Registration = reg
+	defer lock.Unlock()
 
-	// Request certificate with RSA key (ensures RSA signature algorithm)
-	domains := []string{config.Hostname}
-	request := certificate.ObtainRequest{
-		Domains: domains,
-		Bundle:  true,
+	keyData, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cached private key: %v", err)
 	}
 
-	logInfo("Requesting certificate for hostname: %v using RSA private key", domains)
-	certificates, err := client.Certificate.Obtain(request)
+	key, err := certcrypto.ParsePEMPrivateKey(keyData)
 	if err != nil {
-		return "", "", fmt.Errorf("failed to obtain certificate: %v", err)
+		return nil, fmt.Errorf("failed to parse cached private key %s: %v", keyPath, err)
 	}
 
-	// Verify the certificate uses RSA signature algorithm
-	block, _ := pem.Decode(certificates.Certificate)
-	if block != nil {
-		cert, err := x509.ParseCertificate(block.Bytes)
-		if err == nil {
-			logDebug("Certificate signature algorithm: %s", cert.SignatureAlgorithm.String())
-			if cert.SignatureAlgorithm != x509.SHA256WithRSA {
-				logWarn("Warning: Certificate does not use SHA256WithRSA signature algorithm")
-			} else {
-				logInfo("Confirmed: Certificate uses SHA256WithRSA signature algorithm")
+	return key, nil
+}
+
+// Supported values for -key-type and -account-key-type
+var keyTypes = map[string]certcrypto.KeyType{
+	"rsa2048": certcrypto.RSA2048,
+	"rsa3072": certcrypto.RSA3072,
+	"rsa4096": certcrypto.RSA4096,
+	"ec256":   certcrypto.EC256,
+	"ec384":   certcrypto.EC384,
+}
+
+// Well-formed key types that Let's Encrypt rejects: RSA outside 2048-4096 bits and the P-521 curve.
+// Refusing them up front avoids a failed order after the DNS challenge has already been set up.
+var caUnsupportedKeyTypes = map[string]bool{
+	"rsa1024": true,
+	"rsa8192": true,
+	"ec521":   true,
+}
+
+// Key sizes Let's Encrypt accepts for RSA keys
+var caSupportedRSAKeySizes = []int{2048, 3072, 4096}
+
+// Parse a key type name such as "rsa4096" or "ec256"
+func parseKeyType(name string) (certcrypto.KeyType, error) {
+	keyType, ok := keyTypes[strings.ToLower(name)]
+	if !ok {
+		if caUnsupportedKeyTypes[strings.ToLower(name)] {
+			return "", fmt.Errorf("key type %s is not supported by Let's Encrypt, which accepts: rsa2048, rsa3072, rsa4096, ec256, ec384", name)
+		}
+		return "", fmt.Errorf("invalid key type %s, must be one of: rsa2048, rsa3072, rsa4096, ec256, ec384", name)
+	}
+	return keyType, nil
+}
+
+// validateKeySize checks an RSA key size against the sizes Let's Encrypt accepts
+func validateKeySize(size int) error {
+	for _, supported := range caSupportedRSAKeySizes {
+		if size == supported {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid key size %d, Let's Encrypt accepts RSA keys of 2048, 3072 or 4096 bits", size)
+}
+
+// Get the certificate key type: -key-type when set, otherwise lego's RSA 2048 default
+func certificateKeyType(config Config) (certcrypto.KeyType, error) {
+	if config.KeyType != "" {
+		return parseKeyType(config.KeyType)
+	}
+	return certcrypto.RSA2048, nil
+}
+
+// Get the ACME account key type: -account-key-type when set, otherwise RSA with -key-size bits
+func accountKeyType(config Config) (certcrypto.KeyType, error) {
+	if config.AccountKeyType != "" {
+		return parseKeyType(config.AccountKeyType)
+	}
+	return parseKeyType(fmt.Sprintf("rsa%d", config.KeySize))
+}
+
+// keyTypeOf returns the lego key type of an RSA or ECDSA public or private key, or "" for
+// any other key
+func keyTypeOf(key any) certcrypto.KeyType {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return keyTypeOf(&k.PublicKey)
+	case *ecdsa.PrivateKey:
+		return keyTypeOf(&k.PublicKey)
+	case *rsa.PublicKey:
+		return certcrypto.KeyType(strconv.Itoa(k.N.BitLen()))
+	case *ecdsa.PublicKey:
+		switch k.Curve {
+		case elliptic.P256():
+			return certcrypto.EC256
+		case elliptic.P384():
+			return certcrypto.EC384
+		}
+	}
+	return ""
+}
+
+// acceptedSignatureAlgorithms lists the signatures expected on a certificate for a key type.
+// The CA signs RSA keys from its RSA intermediates and EC keys from its ECDSA (E-series) ones.
+func acceptedSignatureAlgorithms(keyType certcrypto.KeyType) []x509.SignatureAlgorithm {
+	switch keyType {
+	case certcrypto.EC256, certcrypto.EC384:
+		return []x509.SignatureAlgorithm{x509.ECDSAWithSHA256, x509.ECDSAWithSHA384}
+	default:
+		return []x509.SignatureAlgorithm{x509.SHA256WithRSA}
+	}
+}
+
+// accountKeyPath returns where the ACME account key for a CA directory and email is kept
+func accountKeyPath(cacheDir, caDirURL, email string) string {
+	sum := sha256.Sum256([]byte(caDirURL + "\n" + strings.ToLower(email)))
+	return filepath.Join(cacheDir, fmt.Sprintf("account-%x-key.pem", sum[:8]))
+}
+
+// loadOrCreateAccountKey returns the ACME account key saved for the CA directory and email,
+// so every run uses the same account. A missing key, or one of another type than
+// -account-key-type asks for, is replaced by a newly generated one.
+func loadOrCreateAccountKey(config Config, cacheDir, caDirURL string) (crypto.PrivateKey, error) {
+	keyType, err := accountKeyType(config)
+	if err != nil {
+		return nil, err
+	}
+	if err := ensureCacheDir(cacheDir); err != nil {
+		return nil, err
+	}
+
+	path := accountKeyPath(cacheDir, caDirURL, config.Email)
+	if data, err := os.ReadFile(path); err == nil {
+		key, err := certcrypto.ParsePEMPrivateKey(data)
+		switch {
+		case err != nil:
+			logWarn("Ignoring unreadable ACME account key %s: %v", path, err)
+		case keyTypeOf(key) != keyType:
+			logInfo("ACME account key %s is %s, not %s; generating a new one", path, keyTypeOf(key), keyType)
+		default:
+			logDebug("Reusing %s ACME account key %s", keyType, path)
+			return key, nil
+		}
+	}
+
+	logInfo("Generating %s ACME account key", keyType)
+	key, err := certcrypto.GeneratePrivateKey(keyType)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate account key: %v", err)
+	}
+	if err := writeFileAtomic(path, certcrypto.PEMEncode(key), 0600); err != nil {
+		return nil, fmt.Errorf("failed to save account key %s: %v", path, err)
+	}
+	return key, nil
+}
+
+// Upload the certificate to the ESXi server using SSH file operations, returning the
+// TSM-SSH state left on the host (empty when SSH was not used or the service was not managed)
+func uploadCertificate(config Config, certPath, keyPath string) (SSHServiceState, error) {
+	logInfo("Uploading certificate to ESXi host %s via SSH file operations", config.Hostname)
+
+	// Read certificate and key files
+	certData, err := os.ReadFile(certPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read certificate file: %v", err)
+	}
+
+	keyData, err := os.ReadFile(keyPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read key file: %v", err)
+	}
+
+	certData, err = applyChainMode(certData, config.ChainMode)
+	if err != nil {
+		return "", err
+	}
+
+	logDebug("Certificate length: %d bytes, Key length: %d bytes", len(certData), len(keyData))
+
+	// Use the SOAP certificate manager when requested, falling back to SSH on hosts that lack it
+	if config.InstallMethod == installMethodSOAPCertMgr {
+		err := installCertificateViaCertManager(config, certData, keyData)
+		if !errors.Is(err, errCertManagerUnsupported) {
+			return "", err
+		}
+		logWarn("SOAP certificate manager not supported by this host (%v), falling back to SSH", err)
+	}
+
+	// Manage SSH service and perform certificate installation
+	return installCertificateViaSSH(config, certData, keyData)
+}
+
+// Select the part of the bundled certificate PEM to install: the full chain as issued,
+// or only the leaf certificate for hosts that expect a single certificate
+func applyChainMode(certPEM []byte, mode string) ([]byte, error) {
+	switch mode {
+	case "", chainModeFull:
+		return certPEM, nil
+	case chainModeLeafOnly:
+		rest := certPEM
+		for {
+			var block *pem.Block
+			block, rest = pem.Decode(rest)
+			if block == nil {
+				return nil, fmt.Errorf("no certificate found in PEM bundle")
+			}
+			if block.Type == "CERTIFICATE" {
+				logDebug("Chain mode %s: installing leaf certificate only", mode)
+				return pem.EncodeToMemory(block), nil
 			}
 		}
+	default:
+		return nil, fmt.Errorf("unknown chain mode %s", mode)
 	}
+}
 
-	// Save certificate to cache directory for reuse
-	cacheDir := filepath.Join(os.TempDir(), "esxi-cert-cache")
-	os.MkdirAll(cacheDir, 0755)
+// Connect to the ESXi SOAP API and locate the host system
+func connectESXiHost(ctx context.Context, config Config) (*govmomi.Client, *object.HostSystem, error) {
+	// Create ESXi connection URL for SOAP API
+	esxiURL, err := url.Parse(fmt.Sprintf("https://%s/sdk", esxiHTTPSAddress(config)))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse ESXi URL for service management: %v", err)
+	}
+
+	// Set credentials
+	esxiURL.User = url.UserPassword(config.ESXiUsername, config.ESXiPassword)
+
+	// With keepalive, reuse the session from an earlier scheduled run while it is valid
+	sessionKey := soapSessionKey(esxiURL)
+	var client *govmomi.Client
+	if config.SOAPKeepAlive > 0 {
+		client = soapSessions.get(ctx, sessionKey)
+	}
+
+	if client == nil {
+		err = retrySOAPConnect(ctx, config.SOAPConnectRetries, soapConnectRetryDelay, func() error {
+			var connectErr error
+			client, connectErr = newGovmomiClient(ctx, esxiURL, config.SOAPKeepAlive)
+			return connectErr
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to connect to ESXi SOAP API for service management: %v", err)
+		}
+
+		logInfo("Successfully connected to ESXi SOAP API")
+		if config.SOAPKeepAlive > 0 {
+			soapSessions.put(sessionKey, client)
+		}
+	}
+
+	// Find the host system
+	finder := find.NewFinder(client.Client, true)
+	var hostSystem *object.HostSystem
+
+	// Try to find host system
+	hosts, err := finder.HostSystemList(ctx, "*")
+	if err == nil && len(hosts) > 0 {
+		hostSystem = hosts[0]
+	} else {
+		// Try common host references as fallback
+		commonHostRefs := []string{"ha-host", "host-1", "host-0"}
+		for _, hostRef := range commonHostRefs {
+			hostMOR := types.ManagedObjectReference{
+				Type:  "HostSystem",
+				Value: hostRef,
+			}
+			testHost := object.NewHostSystem(client.Client, hostMOR)
+			if _, err := testHost.ObjectName(ctx); err == nil {
+				hostSystem = testHost
+				break
+			}
+		}
+	}
+
+	if hostSystem == nil {
+		soapSessions.remove(sessionKey)
+		client.Logout(ctx)
+		return nil, nil, fmt.Errorf("failed to find ESXi host system for service management")
+	}
+
+	return client, hostSystem, nil
+}
+
+// soapProxy selects the proxy for SOAP connections; tests replace it, as the environment is
+// only read once per process
+var soapProxy = http.ProxyFromEnvironment
+
+// configureSOAPTransport makes the SOAP client's transport honor HTTPS_PROXY/NO_PROXY,
+// -ip-version and the -insecure/-ca-bundle choice. govmomi dials TLS itself by default, which
+// bypasses the proxy CONNECT and the custom dialer, so the transport does the handshake instead.
+func configureSOAPTransport(transport *http.Transport, u *url.URL) {
+	transport.Proxy = soapProxy
+	transport.DialTLSContext = nil
+	transport.TLSClientConfig = hostTLSConfig(u.Hostname())
+
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	transport.DialContext = func(ctx context.Context, _, addr string) (net.Conn, error) {
+		return dialer.DialContext(ctx, dialNetwork, addr)
+	}
+
+	if proxyURL, err := transport.Proxy(&http.Request{URL: u}); err != nil {
+		logWarn("Ignoring invalid proxy setting for %s: %v", u.Host, err)
+		transport.Proxy = nil
+	} else if proxyURL != nil {
+		logInfo("Connecting to the ESXi SOAP API through proxy %s", proxyURL.Redacted())
+	}
+}
 
-	certPath := filepath.Join(cacheDir, fmt.Sprintf("%s-cert.pem", config.Hostname))
-	keyPath := filepath.Join(cacheDir, fmt.Sprintf("%s-key.pem", config.Hostname))
+// Create a logged-in govmomi client, dialing over the network selected by -ip-version.
+// A positive keepAlive keeps the session from idling out between scheduled runs.
+func newGovmomiClient(ctx context.Context, u *url.URL, keepAlive time.Duration) (*govmomi.Client, error) {
+	soapClient := soap.NewClient(u, true)
+	configureSOAPTransport(soapClient.DefaultTransport(), u)
+
+	vimClient, err := vim25.NewClient(ctx, soapClient)
+	if err != nil {
+		return nil, err
+	}
+	if keepAlive > 0 {
+		vimClient.RoundTripper = session.KeepAlive(vimClient.RoundTripper, keepAlive)
+	}
+
+	client := &govmomi.Client{
+		Client:         vimClient,
+		SessionManager: session.NewManager(vimClient),
+	}
+	if err := client.Login(ctx, u.User); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// isPermanentSOAPError reports errors that retrying cannot fix: rejected credentials and
+// host certificates that fail verification
+func isPermanentSOAPError(err error) bool {
+	if soap.IsSoapFault(err) {
+		switch soap.ToSoapFault(err).VimFault().(type) {
+		case types.InvalidLogin, *types.InvalidLogin, types.NoPermission, *types.NoPermission:
+			return true
+		}
+	}
+	return soap.IsCertificateUntrusted(err)
+}
+
+// retrySOAPConnect runs connect, retrying up to retries more times with a doubling delay
+// while the failure looks transient (e.g. the management agents are still restarting)
+func retrySOAPConnect(ctx context.Context, retries int, delay time.Duration, connect func() error) error {
+	for attempt := 1; ; attempt++ {
+		logInfo("Connecting to ESXi SOAP API (attempt %d of %d)...", attempt, retries+1)
+		err := connect()
+		if err == nil {
+			return nil
+		}
+		if isPermanentSOAPError(err) {
+			logWarn("SOAP connection failed and will not be retried: %v", err)
+			return err
+		}
+		if attempt > retries {
+			return err
+		}
 
-	// Write certificate to cache
-	if err := os.WriteFile(certPath, certificates.Certificate, 0600); err != nil {
-		return "", "", fmt.Errorf("failed to write cert file: %v", err)
+		logWarn("SOAP connection attempt %d failed: %v. Retrying in %s...", attempt, err, delay)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return err
+		}
+		delay *= 2
 	}
+}
 
-	// Write key to cache
-	if err := os.WriteFile(keyPath, certificates.PrivateKey, 0600); err != nil {
-		return "", "", fmt.Errorf("failed to write key file: %v", err)
+// certManagerLookupError marks a host without a HostCertificateManager as
+// errCertManagerUnsupported so the install falls back to SSH. Any other failure (a timeout,
+// a permission fault, an expired session) is returned as is and does not start TSM-SSH.
+func certManagerLookupError(err error) error {
+	if errors.Is(err, object.ErrNotSupported) {
+		return fmt.Errorf("%w: %v", errCertManagerUnsupported, err)
 	}
-
-	logInfo("Certificate cached to %s", cacheDir)
-	return certPath, keyPath, nil
+	return err
 }
 
-// Generate an RSA private key for certificate generation
-func generatePrivateKey(config Config) crypto.PrivateKey {
-	logInfo("Generating RSA private key with %d bits (ensures SHA256WithRSA signature algorithm)", config.KeySize)
+// Install certificate via the SOAP HostCertificateManager API (no SSH required).
+// The private key is PUT to the host's /host/ssl_key endpoint, then the certificate is
+// installed with InstallServerCertificate, which also notifies the affected services.
+func installCertificateViaCertManager(config Config, certData, keyData []byte) error {
+	logInfo("Installing certificate via SOAP HostCertificateManager API...")
 
-	// Validate key size
-	if config.KeySize != 2048 && config.KeySize != 4096 {
-		logWarn("Warning: Unusual key size %d, using 4096 bits", config.KeySize)
-		config.KeySize = 4096
-	}
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
 
-	key, err := rsa.GenerateKey(rand.Reader, config.KeySize)
+	client, hostSystem, err := connectESXiHost(ctx, config)
 	if err != nil {
-		logError("Failed to generate RSA key: %v", err)
-		os.Exit(1)
+		return err
 	}
+	defer releaseESXiClient(ctx, config, client)
 
-	logInfo("RSA key generated successfully - will result in SHA256WithRSA certificate signature")
-	return key
-}
-
-// Upload the certificate to the ESXi server using SSH file operations
-func uploadCertificate(config Config, certPath, keyPath string) error {
-	logInfo("Uploading certificate to ESXi host %s via SSH file operations", config.Hostname)
+	logInfo("Detected ESXi version: %s (%s)", client.Client.ServiceContent.About.Version, client.Client.ServiceContent.About.FullName)
 
-	// Read certificate and key files
-	certData, err := os.ReadFile(certPath)
+	// HostCertificateManager was added in ESXi 6.0
+	certManager, err := hostSystem.ConfigManager().CertificateManager(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to read certificate file: %v", err)
+		return certManagerLookupError(err)
 	}
 
-	keyData, err := os.ReadFile(keyPath)
+	// Upload the private key so it matches the certificate being installed
+	keyURL, err := url.Parse(fmt.Sprintf("https://%s/host/ssl_key", esxiHTTPSAddress(config)))
 	if err != nil {
-		return fmt.Errorf("failed to read key file: %v", err)
+		return fmt.Errorf("failed to parse ESXi key upload URL: %v", err)
+	}
+	upload := soap.DefaultUpload
+	upload.ContentLength = int64(len(keyData))
+	if err := client.Client.Upload(ctx, bytes.NewReader(keyData), keyURL, &upload); err != nil {
+		return fmt.Errorf("failed to upload private key: %v", err)
 	}
+	logDebug("Uploaded %d byte private key to %s", len(keyData), keyURL.Path)
 
-	logDebug("Certificate length: %d bytes, Key length: %d bytes", len(certData), len(keyData))
+	if err := certManager.InstallServerCertificate(ctx, string(certData)); err != nil {
+		return fmt.Errorf("failed to install server certificate: %v", err)
+	}
 
-	// Manage SSH service and perform certificate installation
-	return installCertificateViaSSH(config, certData, keyData)
+	logInfo("Certificate installation completed successfully via SOAP HostCertificateManager")
+	return nil
 }
 
 // Install certificate via SSH file operations with service management
-func installCertificateViaSSH(config Config, certData, keyData []byte) error {
+func installCertificateViaSSH(config Config, certData, keyData []byte) (SSHServiceState, error) {
+	// SSH is expected to be permanently enabled, so leave TSM-SSH alone and skip SOAP entirely.
+	// A vCenter appliance has no host service system, so it always takes this path. Without
+	// SOAP the service state cannot be queried, so none is reported.
+	if config.NoServiceManagement || config.TargetType == targetTypeVCSA {
+		logInfo("Installing certificate via SSH without service management...")
+		if err := performSSHCertificateInstallation(config, certData, keyData, ""); err != nil {
+			return "", err
+		}
+		return "", nil
+	}
+
 	logInfo("Installing certificate via SSH file operations with SOAP API service management...")
 
 	// Create context with timeout
 	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
 	defer cancel()
 
-	// Create ESXi connection URL for SOAP API service management
-	esxiURL, err := url.Parse(fmt.Sprintf("https://%s/sdk", config.Hostname))
-	if err != nil {
-		return fmt.Errorf("failed to parse ESXi URL for service management: %v", err)
-	}
-
-	// Set credentials
-	esxiURL.User = url.UserPassword(config.ESXiUsername, config.ESXiPassword)
-
 	// Connect to ESXi via SOAP API for service management
-	logInfo("Connecting to ESXi SOAP API for SSH service management...")
-	client, err := govmomi.NewClient(ctx, esxiURL, true)
+	client, hostSystem, err := connectESXiHost(ctx, config)
 	if err != nil {
-		return fmt.Errorf("failed to connect to ESXi SOAP API for service management: %v", err)
-	}
-	defer client.Logout(ctx)
-
-	logInfo("Successfully connected to ESXi SOAP API for service management")
-
-	// Find the host system
-	finder := find.NewFinder(client.Client, true)
-	var hostSystem *object.HostSystem
-
-	// Try to find host system
-	hosts, err := finder.HostSystemList(ctx, "*")
-	if err == nil && len(hosts) > 0 {
-		hostSystem = hosts[0]
-	} else {
-		// Try common host references as fallback
-		commonHostRefs := []string{"ha-host", "host-1", "host-0"}
-		for _, hostRef := range commonHostRefs {
-			hostMOR := types.ManagedObjectReference{
-				Type:  "HostSystem",
-				Value: hostRef,
-			}
-			testHost := object.NewHostSystem(client.Client, hostMOR)
-			if _, err := testHost.ObjectName(ctx); err == nil {
-				hostSystem = testHost
-				break
-			}
-		}
+		return "", err
 	}
+	defer releaseESXiClient(ctx, config, client)
 
-	if hostSystem == nil {
-		return fmt.Errorf("failed to find ESXi host system for service management")
-	}
+	// Detect the ESXi version, as the service restart sequence differs between releases
+	esxiVersion := client.Client.ServiceContent.About.Version
+	logInfo("Detected ESXi version: %s (%s)", esxiVersion, client.Client.ServiceContent.About.FullName)
 
 	// Get the service system for managing SSH service
 	serviceSystem, err := hostSystem.ConfigManager().ServiceSystem(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to get service system: %v", err)
+		return "", fmt.Errorf("failed to get service system: %v", err)
 	}
 
 	// Check and start TSM-SSH service if needed
 	// sshServiceWasRunning, err := ensureSSHServiceRunning(ctx, serviceSystem)
 	_, err = ensureSSHServiceRunning(ctx, serviceSystem)
 	if err != nil {
-		return fmt.Errorf("failed to manage SSH service: %v", err)
+		return "", fmt.Errorf("failed to manage SSH service: %v", err)
 	}
 
 	// Perform SSH certificate installation
-	sshErr := performSSHCertificateInstallation(config, certData, keyData)
+	sshErr := performSSHCertificateInstallation(config, certData, keyData, esxiVersion)
 
 	// // Stop TSM-SSH service if we started it
 	// if !sshServiceWasRunning {
@@ -432,42 +1562,53 @@ func installCertificateViaSSH(config Config, certData, keyData []byte) error {
 
 	// Stop TSM-SSH service anyway
 	logInfo("Stopping TSM-SSH service...")
-	err = stopSSHService(ctx, serviceSystem)
+	err = stopSSHService(ctx, serviceSystem, config.SSHStopTimeout)
 	if err != nil {
 		logWarn("Warning: Failed to stop TSM-SSH service: %v", err)
-	} else {
-		logInfo("TSM-SSH service stopped successfully")
 	}
 
-	return sshErr
+	// Report the state the host was actually left in, not just whether the stop call failed
+	return confirmSSHServiceState(ctx, serviceSystem), sshErr
 }
 
-// Perform SSH certificate installation by copying files and restarting services
-func performSSHCertificateInstallation(config Config, certData, keyData []byte) error {
-	logInfo("Performing SSH certificate installation...")
-	logDebug("SSH connection: %s@%s:22", config.ESXiUsername, config.Hostname)
-	logDebug("SSH password: %s", maskPassword(config.ESXiPassword))
+// newSSHClientConfig builds the SSH client configuration with password and keyboard-interactive
+// (password plus optional TOTP) authentication. onAuth, when set, is told each method as it is tried.
+func newSSHClientConfig(config Config, onAuth func(method string)) *ssh.ClientConfig {
+	challenge := keyboardInteractiveChallenge(config.ESXiPassword, config.ESXiTOTPSecret)
+	notify := onAuth
+	onAuth = func(method string) {
+		logTrace("SSH trying %s authentication", method)
+		if notify != nil {
+			notify(method)
+		}
+	}
 
-	// SSH configuration with multiple auth methods
-	sshConfig := &ssh.ClientConfig{
+	return &ssh.ClientConfig{
 		User: config.ESXiUsername,
 		Auth: []ssh.AuthMethod{
-			ssh.Password(config.ESXiPassword),
+			ssh.PasswordCallback(func() (string, error) {
+				onAuth("password")
+				return config.ESXiPassword, nil
+			}),
 			ssh.KeyboardInteractive(func(user, instruction string, questions []string, echos []bool) ([]string, error) {
-				answers := make([]string, len(questions))
-				for i := range questions {
-					answers[i] = config.ESXiPassword
-				}
-				return answers, nil
+				onAuth("keyboard-interactive")
+				return challenge(user, instruction, questions, echos)
 			}),
 		},
 		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
 		Timeout:         30 * time.Second,
 		ClientVersion:   "SSH-2.0-ESXi-Cert-Manager",
 	}
+}
+
+// Perform SSH certificate installation by copying files and restarting services
+func performSSHCertificateInstallation(config Config, certData, keyData []byte, esxiVersion string) error {
+	logInfo("Performing SSH certificate installation...")
+	logDebug("SSH connection: %s@%s", config.ESXiUsername, esxiSSHAddress(config))
+	logDebug("SSH password: %s", maskPassword(config.ESXiPassword))
 
 	// Connect to ESXi host
-	client, err := ssh.Dial("tcp", config.Hostname+":22", sshConfig)
+	client, err := dialSSH(esxiSSHAddress(config), newSSHClientConfig(config, nil))
 	if err != nil {
 		return fmt.Errorf("failed to connect via SSH: %v", err)
 	}
@@ -475,38 +1616,175 @@ func performSSHCertificateInstallation(config Config, certData, keyData []byte)
 
 	logInfo("Connected to ESXi via SSH successfully!")
 
-	// Step 1: Backup existing certificates
-	err = backupExistingCertificates(client)
-	if err != nil {
-		logWarn("Warning: Failed to backup existing certificates: %v", err)
+	// Let the operator gate the install on the host's state before anything is overwritten
+	if config.PreuploadCheckCmd != "" {
+		if err := runPreuploadCheck(client, config.PreuploadCheckCmd); err != nil {
+			return err
+		}
 	}
 
-	// Step 2: Copy new certificate and key files
-	err = copyCertificateFiles(client, certData, keyData)
-	if err != nil {
-		return fmt.Errorf("failed to copy certificate files: %v", err)
+	targets := serviceTargets(config)
+
+	// Before overwriting, check that the file being replaced is what the host actually serves
+	if config.VerifyCertFile {
+		checkServedMatchesFile(client, config, targets[0], &DefaultTLSDialer{})
 	}
 
-	// Step 3: Restart ESXi services
-	err = restartESXiServicesViaSSH(client)
-	if err != nil {
-		return fmt.Errorf("failed to restart ESXi services: %v", err)
+	for _, target := range targets {
+		logInfo("Installing certificate to %s", target.CertPath)
+
+		// Step 1: Backup existing certificates
+		err = backupExistingCertificates(client, target)
+		if err != nil {
+			logWarn("Warning: Failed to backup existing certificates: %v", err)
+		}
+
+		// Step 2: Copy new certificate and key files
+		err = copyCertificateFiles(client, target, certData, keyData)
+		if err != nil {
+			return fmt.Errorf("failed to copy certificate files to %s: %v", target.CertPath, err)
+		}
+
+		// Step 3: Verify the certificate landed intact
+		err = verifyCertificateFile(client, target, certData)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Step 4: Restart services once every destination is in place
+	commands, useBuiltin := serviceRestartCommands(targets)
+	if useBuiltin {
+		// Without a SOAP session the version has to come from the host itself
+		if esxiVersion == "" {
+			esxiVersion = detectESXiVersionViaSSH(client)
+		}
+		if config.ReloadMethod == reloadMethodReload {
+			err = reloadESXiServicesViaSSH(client, esxiVersion, servesCertificateCheck(config, certData, &DefaultTLSDialer{}))
+		} else {
+			err = restartESXiServicesViaSSH(client, esxiVersion)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to restart ESXi services: %v", err)
+		}
+	}
+	for _, cmd := range commands {
+		err = runSSHCommand(client, cmd)
+		if err != nil {
+			return fmt.Errorf("failed to restart service with '%s': %v", cmd, err)
+		}
+		logInfo("Command '%s' completed successfully", cmd)
 	}
 
 	logInfo("Certificate installation completed successfully via SSH")
 	return nil
 }
 
-// Backup existing certificates
-func backupExistingCertificates(client *ssh.Client) error {
-	logInfo("Backing up existing certificates...")
+// Check if a keyboard-interactive prompt is asking for a one-time verification code
+func isVerificationCodePrompt(question string) bool {
+	q := strings.ToLower(question)
+	for _, marker := range []string{"verification code", "one-time", "otp", "token", "authenticator", "code:"} {
+		if strings.Contains(q, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// Build the keyboard-interactive callback answering password prompts with the password
+// and, when a TOTP secret is configured, verification-code prompts with a fresh TOTP code
+func keyboardInteractiveChallenge(password, totpSecret string) ssh.KeyboardInteractiveChallenge {
+	return func(user, instruction string, questions []string, echos []bool) ([]string, error) {
+		answers := make([]string, len(questions))
+		for i, question := range questions {
+			if totpSecret != "" && isVerificationCodePrompt(question) {
+				code, err := totp.GenerateCode(totpSecret, time.Now())
+				if err != nil {
+					return nil, fmt.Errorf("failed to generate TOTP code: %v", err)
+				}
+				logDebug("Answering SSH verification code prompt %q with TOTP code", question)
+				answers[i] = code
+				continue
+			}
+			answers[i] = password
+		}
+		return answers, nil
+	}
+}
+
+// remoteFileState records whether a file exists on the host and its size
+type remoteFileState struct {
+	Path    string
+	Present bool
+	Size    int64
+}
+
+func (s remoteFileState) String() string {
+	if !s.Present {
+		return "absent"
+	}
+	return fmt.Sprintf("present, %d bytes", s.Size)
+}
+
+// Get the command that prints a remote file's size, or "absent" when it does not exist
+func fileStateCommand(remotePath string) string {
+	quoted := shellQuote(remotePath)
+	return fmt.Sprintf("if [ -f %s ]; then wc -c < %s; else echo absent; fi", quoted, quoted)
+}
+
+// Parse the output of fileStateCommand
+func parseRemoteFileState(remotePath, output string) (remoteFileState, error) {
+	output = strings.TrimSpace(output)
+	if output == "absent" {
+		return remoteFileState{Path: remotePath}, nil
+	}
+	size, err := strconv.ParseInt(output, 10, 64)
+	if err != nil {
+		return remoteFileState{}, fmt.Errorf("unexpected state output for %s: %q", remotePath, output)
+	}
+	return remoteFileState{Path: remotePath, Present: true, Size: size}, nil
+}
+
+// inspectRemoteFile reports whether a file exists on the host and its size
+func inspectRemoteFile(client *ssh.Client, remotePath string) (remoteFileState, error) {
+	session, err := client.NewSession()
+	if err != nil {
+		return remoteFileState{}, fmt.Errorf("failed to create SSH session: %v", err)
+	}
+	defer session.Close()
 
-	commands := []string{
-		"cp -f /etc/vmware/ssl/rui.crt /etc/vmware/ssl/rui.crt.backup 2>/dev/null || true",
-		"cp -f /etc/vmware/ssl/rui.key /etc/vmware/ssl/rui.key.backup 2>/dev/null || true",
-		"ls -la /etc/vmware/ssl/rui.*",
+	output, err := session.Output(fileStateCommand(remotePath))
+	if err != nil {
+		return remoteFileState{}, fmt.Errorf("failed to check %s: %v", remotePath, err)
+	}
+	return parseRemoteFileState(remotePath, string(output))
+}
+
+// Backup existing certificates. A bare host with neither file is a normal first install with
+// nothing to back up; a host with only one of the pair is reported as half-configured.
+func backupExistingCertificates(client *ssh.Client, target ServiceTarget) error {
+	certState, certErr := inspectRemoteFile(client, target.CertPath)
+	keyState, keyErr := inspectRemoteFile(client, target.ResolvedKeyPath())
+	if err := errors.Join(certErr, keyErr); err != nil {
+		// Without the state the backup commands still tolerate missing files
+		logWarn("Could not check existing certificate files: %v", err)
+	} else {
+		logInfo("Existing certificate %s: %s", certState.Path, certState)
+		logInfo("Existing key %s: %s", keyState.Path, keyState)
+
+		switch {
+		case !certState.Present && !keyState.Present:
+			logInfo("No existing certificate or key - first install, nothing to back up")
+			return nil
+		case !certState.Present || !keyState.Present:
+			logWarn("Only one of the certificate and key exists on the host; backing up the one that is present and replacing both")
+		}
 	}
 
+	logInfo("Backing up existing certificates...")
+
+	commands := backupCommands(target)
+
 	for _, cmd := range commands {
 		session, err := client.NewSession()
 		if err != nil {
@@ -526,28 +1804,44 @@ func backupExistingCertificates(client *ssh.Client) error {
 	return nil
 }
 
+// Get the commands that back up a destination's certificate and key before they are replaced
+func backupCommands(target ServiceTarget) []string {
+	certPath, keyPath := target.CertPath, target.ResolvedKeyPath()
+	return []string{
+		fmt.Sprintf("cp -f %s %s 2>/dev/null || true", shellQuote(certPath), shellQuote(certPath+".backup")),
+		fmt.Sprintf("cp -f %s %s 2>/dev/null || true", shellQuote(keyPath), shellQuote(keyPath+".backup")),
+		fmt.Sprintf("ls -la %s %s", shellQuote(certPath), shellQuote(keyPath)),
+	}
+}
+
+// Get the commands that set ownership and permissions on a destination's certificate and key
+func permissionCommands(target ServiceTarget) []string {
+	certPath, keyPath := shellQuote(target.CertPath), shellQuote(target.ResolvedKeyPath())
+	return []string{
+		fmt.Sprintf("chmod 644 %s", certPath),
+		fmt.Sprintf("chmod 600 %s", keyPath),
+		fmt.Sprintf("chown root:root %s %s", certPath, keyPath),
+	}
+}
+
 // Copy certificate files to ESXi
-func copyCertificateFiles(client *ssh.Client, certData, keyData []byte) error {
+func copyCertificateFiles(client *ssh.Client, target ServiceTarget, certData, keyData []byte) error {
 	logInfo("Copying new certificate and key files...")
 
 	// Copy certificate file
-	err := copyFileViaSSH(client, certData, "/etc/vmware/ssl/rui.crt")
+	err := copyFileViaSSH(client, certData, target.CertPath)
 	if err != nil {
 		return fmt.Errorf("failed to copy certificate file: %v", err)
 	}
 
 	// Copy key file
-	err = copyFileViaSSH(client, keyData, "/etc/vmware/ssl/rui.key")
+	err = copyFileViaSSH(client, keyData, target.ResolvedKeyPath())
 	if err != nil {
 		return fmt.Errorf("failed to copy key file: %v", err)
 	}
 
 	// Set proper permissions
-	commands := []string{
-		"chmod 644 /etc/vmware/ssl/rui.crt",
-		"chmod 600 /etc/vmware/ssl/rui.key",
-		"chown root:root /etc/vmware/ssl/rui.crt /etc/vmware/ssl/rui.key",
-	}
+	commands := permissionCommands(target)
 
 	for _, cmd := range commands {
 		session, err := client.NewSession()
@@ -568,6 +1862,60 @@ func copyCertificateFiles(client *ssh.Client, certData, keyData []byte) error {
 	return nil
 }
 
+// Read the certificate back from a destination and confirm it matches what was written
+func verifyCertificateFile(client *ssh.Client, target ServiceTarget, certData []byte) error {
+	session, err := client.NewSession()
+	if err != nil {
+		return fmt.Errorf("failed to create SSH session for verification: %v", err)
+	}
+	defer session.Close()
+
+	output, err := session.Output(readFileCommand(target.CertPath))
+	if err != nil {
+		return fmt.Errorf("failed to read back %s: %v", target.CertPath, err)
+	}
+
+	if !bytes.Equal(bytes.TrimSpace(output), bytes.TrimSpace(certData)) {
+		return fmt.Errorf("certificate at %s does not match the uploaded certificate", target.CertPath)
+	}
+
+	logDebug("Verified certificate at %s", target.CertPath)
+	return nil
+}
+
+// Run the operator's pre-upload check on the host, failing if it exits non-zero
+func runPreuploadCheck(client *ssh.Client, command string) error {
+	logInfo("Running pre-upload check: %s", command)
+	session, err := client.NewSession()
+	if err != nil {
+		return fmt.Errorf("failed to create SSH session for pre-upload check: %v", err)
+	}
+	defer session.Close()
+
+	output, err := session.CombinedOutput(command)
+	if out := strings.TrimSpace(string(output)); out != "" {
+		logInfo("Pre-upload check output:\n%s", out)
+	}
+	if err != nil {
+		return fmt.Errorf("pre-upload check failed, certificate not installed: %v", err)
+	}
+
+	logInfo("Pre-upload check passed")
+	return nil
+}
+
+// Run a single command over SSH
+func runSSHCommand(client *ssh.Client, cmd string) error {
+	logInfo("Executing: %s", cmd)
+	session, err := client.NewSession()
+	if err != nil {
+		return fmt.Errorf("failed to create SSH session: %v", err)
+	}
+	defer session.Close()
+
+	return session.Run(cmd)
+}
+
 // Copy file content via SSH
 func copyFileViaSSH(client *ssh.Client, data []byte, remotePath string) error {
 	session, err := client.NewSession()
@@ -579,8 +1927,7 @@ func copyFileViaSSH(client *ssh.Client, data []byte, remotePath string) error {
 	// Use cat to write the file content
 	session.Stdin = strings.NewReader(string(data))
 
-	cmd := fmt.Sprintf("cat > %s", remotePath)
-	err = session.Run(cmd)
+	err = session.Run(writeFileCommand(remotePath))
 	if err != nil {
 		return fmt.Errorf("failed to copy file to %s: %v", remotePath, err)
 	}
@@ -589,14 +1936,76 @@ func copyFileViaSSH(client *ssh.Client, data []byte, remotePath string) error {
 	return nil
 }
 
+// Parse the major release number from an ESXi version string (e.g. "8.0.2" -> 8)
+func esxiMajorVersion(version string) int {
+	major, _, _ := strings.Cut(version, ".")
+	n, err := strconv.Atoi(major)
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// Extract the version from `vmware -v` output, e.g. "VMware ESXi 8.0.2 build-22380479"
+func parseVMwareVersionOutput(output string) string {
+	fields := strings.Fields(output)
+	for i, field := range fields {
+		if field == "ESXi" && i+1 < len(fields) {
+			return fields[i+1]
+		}
+	}
+	return ""
+}
+
+// Detect the ESXi version over SSH, returning "" when it cannot be determined
+func detectESXiVersionViaSSH(client *ssh.Client) string {
+	session, err := client.NewSession()
+	if err != nil {
+		logWarn("Failed to create SSH session for version detection: %v", err)
+		return ""
+	}
+	defer session.Close()
+
+	output, err := session.Output("vmware -v")
+	if err != nil {
+		logWarn("Failed to detect ESXi version over SSH: %v", err)
+		return ""
+	}
+
+	version := parseVMwareVersionOutput(string(output))
+	if version == "" {
+		logWarn("Could not parse ESXi version from %q", strings.TrimSpace(string(output)))
+		return ""
+	}
+	logInfo("Detected ESXi version over SSH: %s", version)
+	return version
+}
+
+// Get the service restart commands for the detected ESXi version
+func restartCommandsForVersion(esxiVersion string) []string {
+	// ESXi 8.x serves the UI through rhttpproxy, which must be restarted to pick up the new certificate
+	if esxiMajorVersion(esxiVersion) >= 8 {
+		return []string{
+			"/etc/init.d/rhttpproxy restart",
+			"/etc/init.d/hostd restart",
+			"/etc/init.d/vpxa restart", // This may fail if not managed by vCenter, that's OK
+		}
+	}
+
+	return []string{
+		"/etc/init.d/hostd restart",
+		"/etc/init.d/vpxa restart", // This may fail if not managed by vCenter, that's OK
+	}
+}
+
 // Restart ESXi services via SSH
-func restartESXiServicesViaSSH(client *ssh.Client) error {
+func restartESXiServicesViaSSH(client *ssh.Client, esxiVersion string) error {
 	logInfo("Restarting ESXi services...")
 
 	// Commands to restart services
-	commands := []string{
-		"/etc/init.d/hostd restart",
-		"/etc/init.d/vpxa restart", // This may fail if not managed by vCenter, that's OK
+	commands := restartCommandsForVersion(esxiVersion)
+	if esxiMajorVersion(esxiVersion) >= 8 {
+		logInfo("ESXi %s detected - restarting rhttpproxy before hostd", esxiVersion)
 	}
 
 	success := true
@@ -653,8 +2062,16 @@ func restartESXiServicesViaSSH(client *ssh.Client) error {
 // 	return fingerprint[:16] // First 16 characters for comparison
 // }
 
+// HostServiceController is the subset of the host service system used to manage TSM-SSH
+// (enables testing with a fake service system)
+type HostServiceController interface {
+	Service(ctx context.Context) ([]types.HostService, error)
+	Start(ctx context.Context, id string) error
+	Stop(ctx context.Context, id string) error
+}
+
 // Ensure SSH service is running, return true if it was already running
-func ensureSSHServiceRunning(ctx context.Context, serviceSystem *object.HostServiceSystem) (bool, error) {
+func ensureSSHServiceRunning(ctx context.Context, serviceSystem HostServiceController) (bool, error) {
 	logInfo("Checking TSM-SSH service status...")
 
 	// Get service info
@@ -696,17 +2113,93 @@ func ensureSSHServiceRunning(ctx context.Context, serviceSystem *object.HostServ
 	return false, nil
 }
 
-// Stop SSH service
-func stopSSHService(ctx context.Context, serviceSystem *object.HostServiceSystem) error {
-	err := serviceSystem.Stop(ctx, "TSM-SSH")
+// SSHServiceState is the TSM-SSH state observed on the host after an SSH install
+type SSHServiceState string
+
+const (
+	SSHServiceStopped SSHServiceState = "stopped"
+	SSHServiceRunning SSHServiceState = "running"
+	SSHServiceUnknown SSHServiceState = "unknown"
+)
+
+// Re-query TSM-SSH after stopping it and log its actual state, warning if SSH was left running
+func confirmSSHServiceState(ctx context.Context, serviceSystem HostServiceController) SSHServiceState {
+	running, err := isSSHServiceRunning(ctx, serviceSystem)
+	switch {
+	case err != nil:
+		logWarn("Could not confirm TSM-SSH service state: %v", err)
+		return SSHServiceUnknown
+	case running:
+		logWarn("TSM-SSH service is still running; stop it on the host if SSH should be left disabled")
+		return SSHServiceRunning
+	default:
+		logInfo("TSM-SSH service confirmed stopped")
+		return SSHServiceStopped
+	}
+}
+
+// Check whether the TSM-SSH service is currently running
+func isSSHServiceRunning(ctx context.Context, serviceSystem HostServiceController) (bool, error) {
+	services, err := serviceSystem.Service(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to stop TSM-SSH service: %v", err)
+		return false, fmt.Errorf("failed to get services: %v", err)
 	}
-	return nil
+
+	for _, service := range services {
+		if service.Key == "TSM-SSH" {
+			return service.Running, nil
+		}
+	}
+
+	return false, fmt.Errorf("TSM-SSH service not found")
+}
+
+// Stop SSH service, waiting up to the timeout for it to actually stop
+func stopSSHService(ctx context.Context, serviceSystem HostServiceController, timeout time.Duration) error {
+	return stopSSHServiceWithInterval(ctx, serviceSystem, timeout, defaultSSHStopPollInterval)
+}
+
+// Stop SSH service with a custom poll interval. On some builds (e.g. 6.7U3) the stop is
+// asynchronous and returns before the service settles, so the stop is re-issued and the
+// service state polled until it reports not running or the timeout elapses.
+func stopSSHServiceWithInterval(ctx context.Context, serviceSystem HostServiceController, timeout, pollInterval time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	var lastErr error
+
+	for attempt := 1; ; attempt++ {
+		if err := serviceSystem.Stop(ctx, "TSM-SSH"); err != nil {
+			logDebug("TSM-SSH stop attempt %d returned error: %v", attempt, err)
+			lastErr = err
+		}
+
+		running, err := isSSHServiceRunning(ctx, serviceSystem)
+		if err != nil {
+			logDebug("TSM-SSH status poll %d failed: %v", attempt, err)
+			lastErr = err
+		} else {
+			logDebug("TSM-SSH status poll %d: running=%t", attempt, running)
+			if !running {
+				return nil
+			}
+		}
+
+		if !time.Now().Add(pollInterval).Before(deadline) {
+			break
+		}
+		time.Sleep(pollInterval)
+	}
+
+	if lastErr != nil {
+		return fmt.Errorf("failed to stop TSM-SSH service within %s: %v", timeout, lastErr)
+	}
+	return fmt.Errorf("TSM-SSH service still running after %s", timeout)
 }
 
-// Validate that the new certificate is installed on the ESXi server with custom dialer and timeouts
-func validateCertificateWithDialer(hostname string, oldCert *x509.Certificate, dialer TLSDialer, maxDuration, checkInterval time.Duration) (bool, error) {
+// Validate that the new certificate is installed on the ESXi server with custom dialer and timeouts.
+// Checking stops at maxDuration or, when maxAttempts is positive, after that many attempts,
+// whichever comes first. The wait between attempts starts at initialInterval and doubles up
+// to maxInterval, so a recovering host is seen quickly without being polled hard while down.
+func validateCertificateWithDialer(hostname string, oldCert *x509.Certificate, dialer TLSDialer, maxDuration, initialInterval, maxInterval time.Duration, maxAttempts int) (bool, error) {
 	logInfo("Validating certificate installation on %s", hostname)
 
 	startTime := time.Now()
@@ -720,16 +2213,26 @@ func validateCertificateWithDialer(hostname string, oldCert *x509.Certificate, d
 		port = "443"
 	}
 
-	for time.Now().Before(deadline) {
+	checkInterval := initialInterval
+	for attempt := 1; time.Now().Before(deadline); attempt++ {
+		if maxAttempts > 0 && attempt > maxAttempts {
+			logWarn("Validation gave up after %d attempts", maxAttempts)
+			return false, nil
+		}
+		if attempt > 1 {
+			time.Sleep(checkInterval)
+			if !time.Now().Before(deadline) {
+				break
+			}
+			checkInterval = nextCheckInterval(checkInterval, maxInterval)
+		}
+
 		// Connect to server and get certificate
-		conn, err := dialer.Dial("tcp", net.JoinHostPort(host, port), &tls.Config{
-			InsecureSkipVerify: true,
-		})
+		conn, err := dialer.Dial(dialNetwork, net.JoinHostPort(host, port), hostTLSConfig(host))
 
 		if err != nil {
 			logWarn("Failed to connect to %s: %v. Retrying in %s...",
 				hostname, err, checkInterval)
-			time.Sleep(checkInterval)
 			continue
 		}
 
@@ -739,7 +2242,6 @@ func validateCertificateWithDialer(hostname string, oldCert *x509.Certificate, d
 
 		if len(certs) == 0 {
 			logWarn("No certificates found for %s. Retrying in %s...", hostname, checkInterval)
-			time.Sleep(checkInterval)
 			continue
 		}
 
@@ -759,9 +2261,41 @@ func validateCertificateWithDialer(hostname string, oldCert *x509.Certificate, d
 		}
 
 		logDebug("Certificate not updated yet. Checking again in %s...", checkInterval)
-		time.Sleep(checkInterval)
 	}
 
 	logWarn("Validation timeout reached after %s", maxDuration)
 	return false, nil
 }
+
+// nextCheckInterval doubles a validation wait, capped at maxInterval
+func nextCheckInterval(interval, maxInterval time.Duration) time.Duration {
+	if interval*2 > maxInterval {
+		return max(interval, maxInterval)
+	}
+	return interval * 2
+}
+
+// Report whether two certificates are the same issuance (same issuer and serial number)
+func sameCertificate(a, b *x509.Certificate) bool {
+	return a != nil && b != nil && bytes.Equal(a.RawIssuer, b.RawIssuer) && a.SerialNumber.Cmp(b.SerialNumber) == 0
+}
+
+// Describe a certificate by issuer and serial number for diagnostics
+func describeIssuance(cert *x509.Certificate) string {
+	return fmt.Sprintf("issuer %q, serial %X", cert.Issuer.CommonName, cert.SerialNumber)
+}
+
+// diagnoseUnvalidatedCertificate explains what the host is serving when validation timed
+// out, by comparing the served certificate with the old one and the expected new one
+func diagnoseUnvalidatedCertificate(served, oldCert, expected *x509.Certificate) string {
+	switch {
+	case served == nil:
+		return "Host presented no certificate; its web services may still be restarting"
+	case sameCertificate(served, expected):
+		return fmt.Sprintf("Host is now serving the new certificate (%s); it appeared after the validation window closed", describeIssuance(served))
+	case sameCertificate(served, oldCert):
+		return fmt.Sprintf("Host is still serving the OLD certificate (%s); hostd (and rhttpproxy on ESXi 8) may not have restarted, or the certificate was written to a path the web service does not read", describeIssuance(served))
+	default:
+		return fmt.Sprintf("Host is serving a DIFFERENT unexpected certificate (%s); a load balancer or proxy in front of the host, or another process installing certificates, may be answering instead", describeIssuance(served))
+	}
+}
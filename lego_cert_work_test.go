@@ -1,15 +1,32 @@
 package main
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/rsa"
+	"crypto/tls"
 	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
 	"fmt"
+	"math/big"
 	"os"
 	"path/filepath"
+	"reflect"
+	"runtime"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/pquerna/otp/totp"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+
 	"lab-update-esxi-cert/testutil"
 )
 
@@ -202,6 +219,29 @@ func TestGetCachedCertificate_ValidCache(t *testing.T) {
 	}
 }
 
+func TestGetCachedCertificate_UnexpectedIssuer(t *testing.T) {
+	hostname := "test.example.com"
+	certPEM, keyPEM, err := testutil.GenerateValidCertificate(hostname)
+	if err != nil {
+		t.Fatalf("Failed to generate test certificate: %v", err)
+	}
+
+	cacheDir := filepath.Join(t.TempDir(), "esxi-cert-cache")
+	os.MkdirAll(cacheDir, 0700)
+	os.WriteFile(filepath.Join(cacheDir, fmt.Sprintf("%s-cert.pem", hostname)), certPEM, 0600)
+	os.WriteFile(filepath.Join(cacheDir, fmt.Sprintf("%s-key.pem", hostname)), keyPEM, 0600)
+
+	config := Config{Hostname: hostname, ExpectedIssuers: []string{"Let's Encrypt"}}
+	if _, _, found := getCachedCertificateWithDir(config, cacheDir); found {
+		t.Error("Expected a cached certificate from an unexpected issuer to be ignored")
+	}
+
+	config.ExpectedIssuers = []string{"Let's Encrypt", "test org"}
+	if _, _, found := getCachedCertificateWithDir(config, cacheDir); !found {
+		t.Error("Expected a cached certificate from an expected issuer to be used")
+	}
+}
+
 func TestGetCachedCertificate_ForceSkipsCache(t *testing.T) {
 	config := Config{
 		Hostname: "test.example.com",
@@ -382,55 +422,6 @@ func TestGetCachedCertificate_NonRSASignatureAlgorithm(t *testing.T) {
 	}
 }
 
-func TestGeneratePrivateKey(t *testing.T) {
-	tests := []struct {
-		name    string
-		keySize int
-	}{
-		{"2048-bit key", 2048},
-		{"4096-bit key", 4096},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			config := Config{KeySize: tt.keySize}
-
-			key := generatePrivateKey(config)
-			if key == nil {
-				t.Error("Expected private key to be generated")
-			}
-
-			// Verify it's an RSA key of the correct size
-			if rsaKey, ok := key.(*rsa.PrivateKey); ok {
-				actualSize := rsaKey.Size() * 8 // Convert bytes to bits
-				if actualSize != tt.keySize {
-					t.Errorf("Expected key size %d bits, got %d bits", tt.keySize, actualSize)
-				}
-			} else {
-				t.Error("Expected RSA private key")
-			}
-		})
-	}
-}
-
-func TestGeneratePrivateKey_InvalidSize(t *testing.T) {
-	// This would normally call os.Exit(1) due to key generation failure
-	// Test that unusual key sizes get corrected to 4096
-	config := Config{KeySize: 1024}
-
-	key := generatePrivateKey(config)
-	if key == nil {
-		t.Error("Expected private key to be generated even with unusual size")
-	}
-
-	if rsaKey, ok := key.(*rsa.PrivateKey); ok {
-		actualSize := rsaKey.Size() * 8
-		if actualSize != 4096 {
-			t.Errorf("Expected unusual key size to be corrected to 4096, got %d", actualSize)
-		}
-	}
-}
-
 func TestMaskPassword(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -455,9 +446,13 @@ func TestMaskPassword(t *testing.T) {
 
 func TestUserInterface(t *testing.T) {
 	// Test the User struct that implements the lego user interface
+	key, err := loadOrCreateAccountKey(Config{KeySize: 2048, Email: "test@example.com"}, t.TempDir(), "https://acme.example.com/directory")
+	if err != nil {
+		t.Fatalf("loadOrCreateAccountKey() error = %v", err)
+	}
 	user := &User{
 		Email: "test@example.com",
-		Key:   generatePrivateKey(Config{KeySize: 2048}),
+		Key:   key,
 	}
 
 	if user.GetEmail() != "test@example.com" {
@@ -500,7 +495,7 @@ func TestValidateCertificateWithDialer_CertificateChanged(t *testing.T) {
 	}
 
 	// Test that validation detects the certificate has changed
-	validated, err := validateCertificateWithDialer("test.example.com", oldCert, mockDialer, 10*time.Second, 1*time.Second)
+	validated, err := validateCertificateWithDialer("test.example.com", oldCert, mockDialer, 10*time.Second, 1*time.Second, 1*time.Second, 0)
 	if err != nil {
 		t.Errorf("Expected no error for certificate validation, got: %v", err)
 	}
@@ -530,7 +525,7 @@ func TestValidateCertificateWithDialer_SameCertificate(t *testing.T) {
 
 	// Test that validation times out when certificate hasn't changed
 	// (uses a short timeout to make test faster)
-	validated, err := validateCertificateWithDialer("test.example.com", cert, mockDialer, 2*time.Second, 500*time.Millisecond)
+	validated, err := validateCertificateWithDialer("test.example.com", cert, mockDialer, 2*time.Second, 500*time.Millisecond, 500*time.Millisecond, 0)
 	if err != nil {
 		t.Errorf("Expected no error for certificate validation, got: %v", err)
 	}
@@ -558,7 +553,7 @@ func TestValidateCertificateWithDialer_ConnectionFailure(t *testing.T) {
 	}
 
 	// Test that validation handles connection failures gracefully
-	validated, err := validateCertificateWithDialer("test.example.com", cert, mockDialer, 2*time.Second, 500*time.Millisecond)
+	validated, err := validateCertificateWithDialer("test.example.com", cert, mockDialer, 2*time.Second, 500*time.Millisecond, 500*time.Millisecond, 0)
 	if err != nil {
 		t.Errorf("Expected no error for certificate validation with connection failure, got: %v", err)
 	}
@@ -567,6 +562,131 @@ func TestValidateCertificateWithDialer_ConnectionFailure(t *testing.T) {
 	}
 }
 
+// countingDialer records how many times validation dialed the host
+type countingDialer struct {
+	TLSDialer
+	calls int
+}
+
+func (d *countingDialer) Dial(network, addr string, config *tls.Config) (*tls.Conn, error) {
+	d.calls++
+	return d.TLSDialer.Dial(network, addr, config)
+}
+
+func TestValidateCertificateWithDialer_MaxAttempts(t *testing.T) {
+	certPEM, _, err := testutil.GenerateValidCertificate("test.example.com")
+	if err != nil {
+		t.Fatalf("Failed to generate test certificate: %v", err)
+	}
+	cert, err := testutil.ParseCertificatePEM(certPEM)
+	if err != nil {
+		t.Fatalf("Failed to parse certificate: %v", err)
+	}
+
+	dialer := &countingDialer{TLSDialer: &testutil.MockTLSDialer{ShouldFail: true, FailError: fmt.Errorf("connection refused")}}
+
+	// The attempt limit is hit long before the time limit
+	start := time.Now()
+	validated, err := validateCertificateWithDialer("test.example.com", cert, dialer, time.Minute, 10*time.Millisecond, 10*time.Millisecond, 3)
+	if err != nil || validated {
+		t.Errorf("Expected validation to give up without error, got validated=%v err=%v", validated, err)
+	}
+	if dialer.calls != 3 {
+		t.Errorf("Expected 3 attempts, got %d", dialer.calls)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected the attempt limit to stop validation early, took %s", elapsed)
+	}
+}
+
+func TestValidateCertificateWithDialer_Backoff(t *testing.T) {
+	certPEM, _, err := testutil.GenerateValidCertificate("test.example.com")
+	if err != nil {
+		t.Fatalf("Failed to generate test certificate: %v", err)
+	}
+	cert, err := testutil.ParseCertificatePEM(certPEM)
+	if err != nil {
+		t.Fatalf("Failed to parse certificate: %v", err)
+	}
+
+	dialer := &countingDialer{TLSDialer: &testutil.MockTLSDialer{ShouldFail: true, FailError: fmt.Errorf("connection refused")}}
+
+	// Waits of 10, 20, 40, 40ms between five attempts
+	start := time.Now()
+	validateCertificateWithDialer("test.example.com", cert, dialer, time.Minute, 10*time.Millisecond, 40*time.Millisecond, 5)
+	if elapsed := time.Since(start); elapsed < 110*time.Millisecond {
+		t.Errorf("Expected the waits to grow to the cap, took only %s", elapsed)
+	}
+	if dialer.calls != 5 {
+		t.Errorf("Expected 5 attempts, got %d", dialer.calls)
+	}
+}
+
+func TestNextCheckInterval(t *testing.T) {
+	tests := []struct {
+		interval, maxInterval, expected time.Duration
+	}{
+		{5 * time.Second, 30 * time.Second, 10 * time.Second},
+		{20 * time.Second, 30 * time.Second, 30 * time.Second},
+		{30 * time.Second, 30 * time.Second, 30 * time.Second},
+		{30 * time.Second, 10 * time.Second, 30 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := nextCheckInterval(tt.interval, tt.maxInterval); got != tt.expected {
+			t.Errorf("nextCheckInterval(%s, %s) = %s, expected %s", tt.interval, tt.maxInterval, got, tt.expected)
+		}
+	}
+}
+
+func TestChainTopIssuer(t *testing.T) {
+	certPath, _, _, _ := writeTestBundle(t, t.TempDir())
+	bundle, err := os.ReadFile(certPath)
+	if err != nil {
+		t.Fatalf("Failed to read bundle: %v", err)
+	}
+
+	if got := chainTopIssuer(bundle); got != "Test Root" {
+		t.Errorf("Expected the intermediate's issuer Test Root, got %q", got)
+	}
+	if got := chainTopIssuer([]byte("not a certificate")); got != "" {
+		t.Errorf("Expected no issuer for an unparseable bundle, got %q", got)
+	}
+}
+
+func TestDiagnoseUnvalidatedCertificate(t *testing.T) {
+	issuance := func(issuer string, serial int64) *x509.Certificate {
+		name := pkix.Name{CommonName: issuer}
+		raw, err := asn1.Marshal(name.ToRDNSequence())
+		if err != nil {
+			t.Fatalf("Failed to marshal issuer: %v", err)
+		}
+		return &x509.Certificate{Issuer: name, RawIssuer: raw, SerialNumber: big.NewInt(serial)}
+	}
+
+	oldCert := issuance("VMware Certificate Authority", 1)
+	expected := issuance("R11", 2)
+
+	tests := []struct {
+		name     string
+		served   *x509.Certificate
+		contains string
+	}{
+		{"no certificate", nil, "presented no certificate"},
+		{"new certificate arrived late", issuance("R11", 2), "now serving the new certificate"},
+		{"old certificate still served", issuance("VMware Certificate Authority", 1), "still serving the OLD certificate"},
+		{"same serial from another issuer", issuance("R10", 1), "DIFFERENT unexpected certificate"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diagnosis := diagnoseUnvalidatedCertificate(tt.served, oldCert, expected)
+			if !strings.Contains(diagnosis, tt.contains) {
+				t.Errorf("Expected diagnosis to contain %q, got %q", tt.contains, diagnosis)
+			}
+		})
+	}
+}
+
 func TestGenerateCertificate_CacheHit(t *testing.T) {
 	// Test that generateCertificate returns cached certificate when cache is valid
 	hostname := "test.example.com"
@@ -653,3 +773,922 @@ func TestGenerateCertificate_Integration(t *testing.T) {
 	// 4. Verify the certificate was generated and cached
 	t.Skip("Full certificate generation test requires mocked ACME and Route53 services")
 }
+
+func TestKeyboardInteractiveChallenge_PasswordOnly(t *testing.T) {
+	challenge := keyboardInteractiveChallenge("secret-password", "")
+
+	answers, err := challenge("root", "", []string{"Password: ", "Verification code: "}, []bool{false, false})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	// Without a TOTP secret every prompt is answered with the password (legacy behavior)
+	for i, answer := range answers {
+		if answer != "secret-password" {
+			t.Errorf("Expected answer %d to be the password, got %s", i, answer)
+		}
+	}
+}
+
+func TestKeyboardInteractiveChallenge_WithTOTP(t *testing.T) {
+	secret := "JBSWY3DPEHPK3PXP"
+	challenge := keyboardInteractiveChallenge("secret-password", secret)
+
+	answers, err := challenge("root", "", []string{"Password: ", "Verification code: "}, []bool{false, false})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if len(answers) != 2 {
+		t.Fatalf("Expected 2 answers, got %d", len(answers))
+	}
+
+	if answers[0] != "secret-password" {
+		t.Errorf("Expected password prompt to be answered with the password, got %s", answers[0])
+	}
+	if !totp.Validate(answers[1], secret) {
+		t.Errorf("Expected verification code prompt to be answered with a valid TOTP code, got %s", answers[1])
+	}
+}
+
+func TestKeyboardInteractiveChallenge_InvalidSecret(t *testing.T) {
+	challenge := keyboardInteractiveChallenge("secret-password", "not-base32!")
+
+	_, err := challenge("root", "", []string{"Verification code: "}, []bool{false})
+	if err == nil {
+		t.Error("Expected error for invalid TOTP secret")
+	}
+}
+
+func TestIsVerificationCodePrompt(t *testing.T) {
+	tests := []struct {
+		question string
+		expected bool
+	}{
+		{"Password: ", false},
+		{"Verification code: ", true},
+		{"Enter one-time password: ", true},
+		{"Token: ", true},
+		{"Authenticator code: ", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.question, func(t *testing.T) {
+			if result := isVerificationCodePrompt(tt.question); result != tt.expected {
+				t.Errorf("isVerificationCodePrompt(%q) = %v, expected %v", tt.question, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestEsxiMajorVersion(t *testing.T) {
+	tests := []struct {
+		version  string
+		expected int
+	}{
+		{"6.7.0", 6},
+		{"7.0.3", 7},
+		{"8.0.2", 8},
+		{"", 0},
+		{"unknown", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			if result := esxiMajorVersion(tt.version); result != tt.expected {
+				t.Errorf("esxiMajorVersion(%q) = %d, expected %d", tt.version, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestParseVMwareVersionOutput(t *testing.T) {
+	tests := []struct {
+		output   string
+		expected string
+	}{
+		{"VMware ESXi 8.0.2 build-22380479\n", "8.0.2"},
+		{"VMware ESXi 7.0.3 build-21930508", "7.0.3"},
+		{"VMware ESXi", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.output, func(t *testing.T) {
+			if result := parseVMwareVersionOutput(tt.output); result != tt.expected {
+				t.Errorf("parseVMwareVersionOutput(%q) = %q, expected %q", tt.output, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestRestartCommandsForVersion(t *testing.T) {
+	t.Run("ESXi 6.7 restarts hostd only", func(t *testing.T) {
+		commands := restartCommandsForVersion("6.7.0")
+		if commands[0] != "/etc/init.d/hostd restart" {
+			t.Errorf("Expected hostd restart first, got %s", commands[0])
+		}
+		for _, cmd := range commands {
+			if strings.Contains(cmd, "rhttpproxy") {
+				t.Errorf("Did not expect rhttpproxy restart on ESXi 6.7, got %s", cmd)
+			}
+		}
+	})
+
+	t.Run("ESXi 8.0 restarts rhttpproxy then hostd", func(t *testing.T) {
+		commands := restartCommandsForVersion("8.0.2")
+		if len(commands) < 2 {
+			t.Fatalf("Expected at least 2 commands, got %d", len(commands))
+		}
+		if commands[0] != "/etc/init.d/rhttpproxy restart" {
+			t.Errorf("Expected rhttpproxy restart first, got %s", commands[0])
+		}
+		if commands[1] != "/etc/init.d/hostd restart" {
+			t.Errorf("Expected hostd restart second, got %s", commands[1])
+		}
+	})
+}
+
+// fakeServiceSystem simulates a host service system whose TSM-SSH stop settles asynchronously
+type fakeServiceSystem struct {
+	running       bool
+	pollsToSettle int
+	stopCalls     int
+	stopErr       error
+}
+
+func (f *fakeServiceSystem) Service(ctx context.Context) ([]types.HostService, error) {
+	if f.stopCalls > 0 && f.stopCalls >= f.pollsToSettle {
+		f.running = false
+	}
+	return []types.HostService{{Key: "TSM-SSH", Running: f.running}}, nil
+}
+
+func (f *fakeServiceSystem) Start(ctx context.Context, id string) error {
+	f.running = true
+	return nil
+}
+
+func (f *fakeServiceSystem) Stop(ctx context.Context, id string) error {
+	f.stopCalls++
+	return f.stopErr
+}
+
+func TestStopSSHService_StopsImmediately(t *testing.T) {
+	fake := &fakeServiceSystem{running: true, pollsToSettle: 1}
+
+	err := stopSSHServiceWithInterval(context.Background(), fake, time.Second, 10*time.Millisecond)
+	if err != nil {
+		t.Errorf("Expected service to stop, got error: %v", err)
+	}
+	if fake.stopCalls != 1 {
+		t.Errorf("Expected 1 stop call, got %d", fake.stopCalls)
+	}
+}
+
+func TestStopSSHService_AsyncStopIsRetried(t *testing.T) {
+	// Service only reports stopped after the third stop request
+	fake := &fakeServiceSystem{running: true, pollsToSettle: 3, stopErr: fmt.Errorf("operation in progress")}
+
+	err := stopSSHServiceWithInterval(context.Background(), fake, time.Second, 10*time.Millisecond)
+	if err != nil {
+		t.Errorf("Expected service to eventually stop, got error: %v", err)
+	}
+	if fake.stopCalls != 3 {
+		t.Errorf("Expected 3 stop calls, got %d", fake.stopCalls)
+	}
+}
+
+func TestStopSSHService_Timeout(t *testing.T) {
+	// Service never stops
+	fake := &fakeServiceSystem{running: true, pollsToSettle: 1000}
+
+	err := stopSSHServiceWithInterval(context.Background(), fake, 50*time.Millisecond, 10*time.Millisecond)
+	if err == nil {
+		t.Fatal("Expected timeout error when service never stops")
+	}
+	if !strings.Contains(err.Error(), "still running") {
+		t.Errorf("Expected 'still running' error, got: %v", err)
+	}
+}
+
+// unavailableServiceSystem fails every service query
+type unavailableServiceSystem struct{ fakeServiceSystem }
+
+func (u *unavailableServiceSystem) Service(ctx context.Context) ([]types.HostService, error) {
+	return nil, fmt.Errorf("hostd restarting")
+}
+
+func TestConfirmSSHServiceState(t *testing.T) {
+	tests := []struct {
+		name     string
+		system   HostServiceController
+		expected SSHServiceState
+	}{
+		{"stopped", &fakeServiceSystem{running: false}, SSHServiceStopped},
+		{"still running", &fakeServiceSystem{running: true, pollsToSettle: 1000}, SSHServiceRunning},
+		{"query fails", &unavailableServiceSystem{}, SSHServiceUnknown},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if state := confirmSSHServiceState(context.Background(), tt.system); state != tt.expected {
+				t.Errorf("Expected SSH state %s, got %s", tt.expected, state)
+			}
+		})
+	}
+}
+
+func TestEnsureCacheDir(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("relies on POSIX permissions")
+	}
+
+	dir := filepath.Join(t.TempDir(), "cache")
+	if err := ensureCacheDir(dir); err != nil {
+		t.Fatalf("Expected cache directory to be created, got: %v", err)
+	}
+	if info, _ := os.Stat(dir); info.Mode().Perm() != 0700 {
+		t.Errorf("Expected new cache directory mode 0700, got %o", info.Mode().Perm())
+	}
+
+	// A directory left world-readable by an older version is tightened
+	os.Chmod(dir, 0755)
+	if err := ensureCacheDir(dir); err != nil {
+		t.Fatalf("Expected existing cache directory to be accepted, got: %v", err)
+	}
+	if info, _ := os.Stat(dir); info.Mode().Perm() != 0700 {
+		t.Errorf("Expected cache directory to be tightened to 0700, got %o", info.Mode().Perm())
+	}
+
+	link := filepath.Join(t.TempDir(), "link")
+	if err := os.Symlink(dir, link); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+	if err := ensureCacheDir(link); err == nil || !strings.Contains(err.Error(), "not a directory") {
+		t.Errorf("Expected symlinked cache directory to be refused, got: %v", err)
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "host-key.pem")
+
+	if err := writeFileAtomic(path, []byte("first"), 0600); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := writeFileAtomic(path, []byte("second"), 0600); err != nil {
+		t.Fatalf("Failed to replace file: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil || string(data) != "second" {
+		t.Errorf("Expected replaced content, got %q (%v)", data, err)
+	}
+	if runtime.GOOS != "windows" {
+		if info, _ := os.Stat(path); info.Mode().Perm() != 0600 {
+			t.Errorf("Expected mode 0600, got %o", info.Mode().Perm())
+		}
+	}
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("Expected no temp files left behind, got %d entries", len(entries))
+	}
+}
+
+func TestLockCacheEntry_ExclusiveBlocksConcurrentWriter(t *testing.T) {
+	certPath := filepath.Join(t.TempDir(), "test.example.com-cert.pem")
+
+	lock, err := lockCacheEntry(certPath, time.Second, true)
+	if err != nil {
+		t.Fatalf("Expected to acquire exclusive lock, got error: %v", err)
+	}
+
+	// A second writer must time out while the first lock is held
+	if _, err := lockCacheEntry(certPath, 300*time.Millisecond, true); err == nil {
+		t.Error("Expected second exclusive lock to time out")
+	}
+
+	// A reader must also wait for the writer
+	if _, err := lockCacheEntry(certPath, 300*time.Millisecond, false); err == nil {
+		t.Error("Expected shared lock to time out while exclusive lock is held")
+	}
+
+	lock.Unlock()
+
+	// Once released the lock can be acquired again
+	lock, err = lockCacheEntry(certPath, time.Second, true)
+	if err != nil {
+		t.Fatalf("Expected to acquire lock after release, got error: %v", err)
+	}
+	lock.Unlock()
+}
+
+func TestLockCacheEntry_SharedReaders(t *testing.T) {
+	certPath := filepath.Join(t.TempDir(), "test.example.com-cert.pem")
+
+	first, err := lockCacheEntry(certPath, time.Second, false)
+	if err != nil {
+		t.Fatalf("Expected to acquire shared lock, got error: %v", err)
+	}
+	defer first.Unlock()
+
+	second, err := lockCacheEntry(certPath, time.Second, false)
+	if err != nil {
+		t.Fatalf("Expected concurrent shared locks to succeed, got error: %v", err)
+	}
+	defer second.Unlock()
+}
+
+func TestLoadCachedPrivateKeyWithDir(t *testing.T) {
+	cacheDir := t.TempDir()
+	config := Config{Hostname: "test.example.com", CacheLockTimeout: time.Second}
+
+	// No cached key yet
+	if _, err := loadCachedPrivateKeyWithDir(config, cacheDir); err == nil {
+		t.Error("Expected error when no key is cached")
+	}
+
+	_, keyPEM, err := testutil.GenerateValidCertificate(config.Hostname)
+	if err != nil {
+		t.Fatalf("Failed to generate test certificate: %v", err)
+	}
+	_, keyPath := cacheFilePaths(cacheDir, config.Hostname)
+	if err := os.WriteFile(keyPath, keyPEM, 0600); err != nil {
+		t.Fatalf("Failed to write cached key: %v", err)
+	}
+
+	key, err := loadCachedPrivateKeyWithDir(config, cacheDir)
+	if err != nil {
+		t.Fatalf("Expected cached key to load, got: %v", err)
+	}
+	if _, ok := key.(*rsa.PrivateKey); !ok {
+		t.Errorf("Expected RSA private key, got %T", key)
+	}
+
+	// Corrupt key file
+	if err := os.WriteFile(keyPath, []byte("not a key"), 0600); err != nil {
+		t.Fatalf("Failed to write cached key: %v", err)
+	}
+	if _, err := loadCachedPrivateKeyWithDir(config, cacheDir); err == nil {
+		t.Error("Expected error for unparseable cached key")
+	}
+}
+
+func TestACMEContactURIs(t *testing.T) {
+	config := Config{
+		Email:        "admin@example.com",
+		ACMEContacts: []string{"pki-team@example.com", "mailto:oncall@example.com"},
+	}
+
+	expected := []string{"mailto:admin@example.com", "mailto:pki-team@example.com", "mailto:oncall@example.com"}
+	if contacts := acmeContactURIs(config); !reflect.DeepEqual(contacts, expected) {
+		t.Errorf("Expected %v, got %v", expected, contacts)
+	}
+}
+
+func TestHasMustStaple(t *testing.T) {
+	mustStapleValue, err := asn1.Marshal([]int{tlsFeatureStatusRequest})
+	if err != nil {
+		t.Fatalf("Failed to marshal TLS feature: %v", err)
+	}
+	otherFeatureValue, err := asn1.Marshal([]int{17})
+	if err != nil {
+		t.Fatalf("Failed to marshal TLS feature: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		extensions []pkix.Extension
+		expected   bool
+	}{
+		{"no TLS feature extension", nil, false},
+		{"status_request feature", []pkix.Extension{{Id: oidTLSFeature, Value: mustStapleValue}}, true},
+		{"other TLS feature only", []pkix.Extension{{Id: oidTLSFeature, Value: otherFeatureValue}}, false},
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			template := &x509.Certificate{
+				SerialNumber:    big.NewInt(1),
+				Subject:         pkix.Name{CommonName: "test.example.com"},
+				NotBefore:       time.Now(),
+				NotAfter:        time.Now().Add(time.Hour),
+				ExtraExtensions: tt.extensions,
+			}
+			der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+			if err != nil {
+				t.Fatalf("Failed to create certificate: %v", err)
+			}
+			cert, err := x509.ParseCertificate(der)
+			if err != nil {
+				t.Fatalf("Failed to parse certificate: %v", err)
+			}
+
+			if got := hasMustStaple(cert); got != tt.expected {
+				t.Errorf("hasMustStaple() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestApplyChainMode(t *testing.T) {
+	leafPEM, _, err := testutil.GenerateValidCertificate("test.example.com")
+	if err != nil {
+		t.Fatalf("Failed to generate leaf certificate: %v", err)
+	}
+	intermediatePEM, _, err := testutil.GenerateValidCertificate("Test Intermediate CA")
+	if err != nil {
+		t.Fatalf("Failed to generate intermediate certificate: %v", err)
+	}
+	bundle := append(append([]byte{}, leafPEM...), intermediatePEM...)
+
+	t.Run("full keeps the bundle unchanged", func(t *testing.T) {
+		for _, mode := range []string{"", chainModeFull} {
+			got, err := applyChainMode(bundle, mode)
+			if err != nil {
+				t.Fatalf("applyChainMode(%q) error: %v", mode, err)
+			}
+			if string(got) != string(bundle) {
+				t.Errorf("applyChainMode(%q) modified the bundle", mode)
+			}
+		}
+	})
+
+	t.Run("leaf-only keeps the first certificate", func(t *testing.T) {
+		got, err := applyChainMode(bundle, chainModeLeafOnly)
+		if err != nil {
+			t.Fatalf("applyChainMode error: %v", err)
+		}
+		if string(got) != string(leafPEM) {
+			t.Errorf("Expected only the leaf certificate, got %d bytes (leaf is %d bytes)", len(got), len(leafPEM))
+		}
+	})
+
+	t.Run("leaf-only without certificates", func(t *testing.T) {
+		if _, err := applyChainMode([]byte("garbage"), chainModeLeafOnly); err == nil {
+			t.Error("Expected error for PEM without certificates")
+		}
+	})
+
+	t.Run("unknown mode", func(t *testing.T) {
+		if _, err := applyChainMode(bundle, "reversed"); err == nil {
+			t.Error("Expected error for unknown chain mode")
+		}
+	})
+}
+
+func TestInstalledCertificateMatches(t *testing.T) {
+	now := time.Now()
+	issued := func(issuer string, notBefore, notAfter time.Time, names ...string) *x509.Certificate {
+		return &x509.Certificate{
+			Issuer:    pkix.Name{CommonName: issuer, Organization: []string{"Let's Encrypt"}},
+			DNSNames:  names,
+			NotBefore: notBefore,
+			NotAfter:  notAfter,
+		}
+	}
+	fresh := issued("R11", now.Add(-10*24*time.Hour), now.Add(80*24*time.Hour), "esxi01.example.com")
+	cached := issued("R11", now, now.Add(90*24*time.Hour), "esxi01.example.com")
+	config := Config{Hostname: "ESXi01.example.com", Threshold: 0.33}
+	withExpected := config
+	withExpected.ExpectedIssuers = []string{"Let's Encrypt"}
+
+	tests := []struct {
+		name      string
+		config    Config
+		installed *x509.Certificate
+		cached    *x509.Certificate
+		expected  bool
+		reason    string
+	}{
+		{"same issuer as the cache and the ordered SANs", config, fresh, cached, true, ""},
+		{"different issuer from the cache", config, issued("E6", now, now.Add(90*24*time.Hour), "esxi01.example.com"), cached, false, "issuer"},
+		{"expected issuer without a cache entry", withExpected, fresh, nil, true, ""},
+		{"issuer not expected", withExpected, &x509.Certificate{Issuer: pkix.Name{CommonName: "Lab CA"}, DNSNames: []string{"esxi01.example.com"}}, nil, false, "expected issuers"},
+		{"nothing to compare the issuer with", config, fresh, nil, false, "expected-issuer"},
+		{"extra SAN", config, issued("R11", now, now.Add(90*24*time.Hour), "esxi01.example.com", "localhost"), cached, false, "SANs"},
+		{"too little validity remaining", config, issued("R11", now.Add(-80*24*time.Hour), now.Add(10*24*time.Hour), "esxi01.example.com"), cached, false, "threshold"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matches, reason := installedCertificateMatches(tt.config, tt.installed, tt.cached)
+			if matches != tt.expected {
+				t.Errorf("installedCertificateMatches() = %v (%s), expected %v", matches, reason, tt.expected)
+			}
+			if !strings.Contains(reason, tt.reason) {
+				t.Errorf("Expected reason containing %q, got %q", tt.reason, reason)
+			}
+		})
+	}
+}
+
+func TestCertificateSANs(t *testing.T) {
+	cert := &x509.Certificate{
+		DNSNames: []string{"ESXi01.Example.com.", "alias.example.com"},
+	}
+	got := certificateSANs(cert)
+	expected := []string{"alias.example.com", "esxi01.example.com"}
+	if strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Errorf("certificateSANs() = %v, expected %v", got, expected)
+	}
+}
+
+func TestPruneCache(t *testing.T) {
+	cacheDir := t.TempDir()
+	now := time.Now()
+
+	writeEntry := func(hostname string, certPEM, keyPEM []byte, modTime time.Time) {
+		certPath, keyPath := cacheFilePaths(cacheDir, hostname)
+		os.WriteFile(certPath, certPEM, 0600)
+		os.WriteFile(keyPath, keyPEM, 0600)
+		os.Chtimes(certPath, modTime, modTime)
+	}
+
+	validCert, validKey, _ := testutil.GenerateValidCertificate("valid.example.com")
+	writeEntry("valid.example.com", validCert, validKey, now)
+
+	staleCert, staleKey, _ := testutil.GenerateValidCertificate("stale.example.com")
+	writeEntry("stale.example.com", staleCert, staleKey, now.Add(-100*24*time.Hour))
+
+	expiredCert, expiredKey, _ := testutil.GenerateExpiredCertificate("expired.example.com")
+	writeEntry("expired.example.com", expiredCert, expiredKey, now)
+
+	writeEntry("corrupt.example.com", []byte("not a certificate"), []byte("key"), now)
+
+	t.Run("expired and unreadable only", func(t *testing.T) {
+		pruned, err := pruneCache(cacheDir, 0, time.Second, now)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		reasons := make(map[string]string)
+		for _, entry := range pruned {
+			reasons[entry.Hostname] = entry.Reason
+		}
+		if len(pruned) != 2 || !strings.HasPrefix(reasons["expired.example.com"], "expired") || reasons["corrupt.example.com"] != "unreadable certificate" {
+			t.Errorf("Expected expired and corrupt entries pruned, got %+v", pruned)
+		}
+
+		for _, hostname := range []string{"expired.example.com", "corrupt.example.com"} {
+			certPath, keyPath := cacheFilePaths(cacheDir, hostname)
+			for _, path := range []string{certPath, keyPath, certPath + ".lock"} {
+				if _, err := os.Stat(path); !os.IsNotExist(err) {
+					t.Errorf("Expected %s to be removed", path)
+				}
+			}
+		}
+	})
+
+	t.Run("older than", func(t *testing.T) {
+		pruned, err := pruneCache(cacheDir, 30*24*time.Hour, time.Second, now)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(pruned) != 1 || pruned[0].Hostname != "stale.example.com" {
+			t.Errorf("Expected only the stale entry pruned, got %+v", pruned)
+		}
+
+		if certPath, _ := cacheFilePaths(cacheDir, "valid.example.com"); !fileExists(certPath) {
+			t.Error("Expected valid entry to be kept")
+		}
+	})
+
+	t.Run("missing cache directory", func(t *testing.T) {
+		pruned, err := pruneCache(filepath.Join(cacheDir, "missing"), 0, time.Second, now)
+		if err != nil || len(pruned) != 0 {
+			t.Errorf("Expected nothing pruned from a missing directory, got %+v, %v", pruned, err)
+		}
+	})
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func TestCertificateKeyType(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   Config
+		expected certcrypto.KeyType
+	}{
+		{"default ignores key size", Config{KeySize: 4096}, certcrypto.RSA2048},
+		{"explicit EC key type", Config{KeySize: 4096, KeyType: "ec256"}, certcrypto.EC256},
+		{"explicit type is case-insensitive", Config{KeySize: 2048, KeyType: "RSA3072"}, certcrypto.RSA3072},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keyType, err := certificateKeyType(tt.config)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if keyType != tt.expected {
+				t.Errorf("Expected %s, got %s", tt.expected, keyType)
+			}
+		})
+	}
+
+	if _, err := certificateKeyType(Config{KeyType: "dsa1024"}); err == nil {
+		t.Error("Expected error for unsupported key type")
+	}
+}
+
+func TestLoadOrCreateAccountKey(t *testing.T) {
+	const caDirURL = "https://acme.example.com/directory"
+
+	for _, keySize := range []int{2048, 4096} {
+		t.Run(fmt.Sprintf("defaults to RSA of key size %d", keySize), func(t *testing.T) {
+			key, err := loadOrCreateAccountKey(Config{KeySize: keySize, Email: "a@example.com"}, t.TempDir(), caDirURL)
+			if rsaKey, ok := key.(*rsa.PrivateKey); err != nil || !ok || rsaKey.N.BitLen() != keySize {
+				t.Errorf("Expected %d-bit RSA account key, got %T (%v)", keySize, key, err)
+			}
+		})
+	}
+
+	t.Run("unsupported key size is an error", func(t *testing.T) {
+		if _, err := loadOrCreateAccountKey(Config{KeySize: 1024, Email: "a@example.com"}, t.TempDir(), caDirURL); err == nil {
+			t.Error("Expected an error for a 1024-bit RSA account key")
+		}
+	})
+
+	t.Run("EC account key independent of certificate key", func(t *testing.T) {
+		key, err := loadOrCreateAccountKey(Config{KeySize: 4096, KeyType: "rsa4096", AccountKeyType: "ec256", Email: "a@example.com"}, t.TempDir(), caDirURL)
+		if ecKey, ok := key.(*ecdsa.PrivateKey); err != nil || !ok || ecKey.Curve != elliptic.P256() {
+			t.Errorf("Expected P-256 EC account key, got %T (%v)", key, err)
+		}
+	})
+
+	t.Run("persisted per CA and email", func(t *testing.T) {
+		dir := t.TempDir()
+		config := Config{KeySize: 2048, AccountKeyType: "ec256", Email: "a@example.com"}
+		first, err := loadOrCreateAccountKey(config, dir, caDirURL)
+		if err != nil {
+			t.Fatalf("loadOrCreateAccountKey() error = %v", err)
+		}
+		again, _ := loadOrCreateAccountKey(config, dir, caDirURL)
+		if !first.(*ecdsa.PrivateKey).Equal(again) {
+			t.Error("Expected the saved account key to be reused")
+		}
+		other, _ := loadOrCreateAccountKey(config, dir, "https://acme-staging.example.com/directory")
+		if first.(*ecdsa.PrivateKey).Equal(other) {
+			t.Error("Expected a separate account key for another CA")
+		}
+
+		config.AccountKeyType = "ec384"
+		changed, err := loadOrCreateAccountKey(config, dir, caDirURL)
+		if err != nil || keyTypeOf(changed) != certcrypto.EC384 {
+			t.Errorf("Expected a new P-384 key after the type changed, got %s (%v)", keyTypeOf(changed), err)
+		}
+	})
+
+	t.Run("invalid type is an error", func(t *testing.T) {
+		if _, err := loadOrCreateAccountKey(Config{AccountKeyType: "dsa1024"}, t.TempDir(), caDirURL); err == nil {
+			t.Error("Expected an error for an unsupported account key type")
+		}
+	})
+}
+
+// recordingDialer records the network requested and fails the dial
+type recordingDialer struct {
+	network string
+}
+
+func (d *recordingDialer) Dial(network, addr string, config *tls.Config) (*tls.Conn, error) {
+	d.network = network
+	return nil, fmt.Errorf("dial disabled in test")
+}
+
+func TestNetworkForIPVersion(t *testing.T) {
+	tests := map[string]string{
+		"":            "tcp",
+		ipVersionAuto: "tcp",
+		ipVersion4:    "tcp4",
+		ipVersion6:    "tcp6",
+	}
+	for ipVersion, expected := range tests {
+		if network := networkForIPVersion(ipVersion); network != expected {
+			t.Errorf("networkForIPVersion(%q) = %s, expected %s", ipVersion, network, expected)
+		}
+	}
+}
+
+func TestCheckCertificateWithDialer_IPVersion(t *testing.T) {
+	original := dialNetwork
+	defer func() { dialNetwork = original }()
+
+	for _, ipVersion := range []string{ipVersion4, ipVersion6} {
+		dialNetwork = networkForIPVersion(ipVersion)
+		dialer := &recordingDialer{}
+		checkCertificateWithDialer("esxi01.lab.example.com", 0.33, dialer)
+		if dialer.network != dialNetwork {
+			t.Errorf("Expected certificate check to dial %s, got %s", dialNetwork, dialer.network)
+		}
+
+		dialer = &recordingDialer{}
+		checkCertificateChainWithDialer(Config{Hostname: "esxi01.lab.example.com"}, dialer)
+		if dialer.network != dialNetwork {
+			t.Errorf("Expected chain check to dial %s, got %s", dialNetwork, dialer.network)
+		}
+	}
+}
+
+func TestIssuerMatches(t *testing.T) {
+	letsEncrypt := &x509.Certificate{Issuer: pkix.Name{CommonName: "R11", Organization: []string{"Let's Encrypt"}}}
+	vmware := &x509.Certificate{Issuer: pkix.Name{CommonName: "CA", Organization: []string{"VMware Installer"}}}
+
+	tests := []struct {
+		name    string
+		cert    *x509.Certificate
+		pattern string
+		want    bool
+	}{
+		{"organization match", letsEncrypt, "Let's Encrypt", true},
+		{"case-insensitive", letsEncrypt, "let's encrypt", true},
+		{"common name match", letsEncrypt, "R1", true},
+		{"self-signed VMware", vmware, "Let's Encrypt", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := issuerMatches(tt.cert, tt.pattern); got != tt.want {
+				t.Errorf("issuerMatches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if foreignIssuer(Config{}, vmware) {
+		t.Error("Expected no foreign issuer without -renew-if-issuer-not")
+	}
+	if !foreignIssuer(Config{RenewIfIssuerNot: "Let's Encrypt"}, vmware) {
+		t.Error("Expected the VMware certificate to be foreign")
+	}
+}
+
+func TestCheckExpectedIssuer(t *testing.T) {
+	letsEncrypt := &x509.Certificate{Issuer: pkix.Name{CommonName: "R11", Organization: []string{"Let's Encrypt"}}}
+	other := &x509.Certificate{Issuer: pkix.Name{CommonName: "Rogue CA", Organization: []string{"Example Corp"}}}
+
+	if err := checkExpectedIssuer(Config{}, other); err != nil {
+		t.Errorf("Expected any issuer to pass without -expected-issuer, got: %v", err)
+	}
+
+	config := Config{ExpectedIssuers: []string{"ZeroSSL", "Let's Encrypt"}}
+	if err := checkExpectedIssuer(config, letsEncrypt); err != nil {
+		t.Errorf("Expected Let's Encrypt to be accepted, got: %v", err)
+	}
+	err := checkExpectedIssuer(config, other)
+	if err == nil || !strings.Contains(err.Error(), "Rogue CA") || !strings.Contains(err.Error(), "ZeroSSL, Let's Encrypt") {
+		t.Errorf("Expected the unexpected issuer to be rejected, got: %v", err)
+	}
+}
+
+func TestESXiAddresses(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    Config
+		wantHTTPS string
+		wantSSH   string
+	}{
+		{"defaults", Config{Hostname: "esxi01.example.com"}, "esxi01.example.com", "esxi01.example.com:22"},
+		{"explicit defaults", Config{Hostname: "esxi01.example.com", ESXiSSHPort: 22, ESXiHTTPSPort: 443}, "esxi01.example.com", "esxi01.example.com:22"},
+		{"relocated ports", Config{Hostname: "esxi01.example.com", ESXiSSHPort: 2222, ESXiHTTPSPort: 8443}, "esxi01.example.com:8443", "esxi01.example.com:2222"},
+		{"port in hostname wins", Config{Hostname: "esxi01.example.com:9443", ESXiSSHPort: 2222, ESXiHTTPSPort: 8443}, "esxi01.example.com:9443", "esxi01.example.com:2222"},
+		{"IPv6 literal", Config{Hostname: "2001:db8::10", ESXiSSHPort: 2222, ESXiHTTPSPort: 8443}, "[2001:db8::10]:8443", "[2001:db8::10]:2222"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := esxiHTTPSAddress(tt.config); got != tt.wantHTTPS {
+				t.Errorf("esxiHTTPSAddress() = %s, want %s", got, tt.wantHTTPS)
+			}
+			if got := esxiSSHAddress(tt.config); got != tt.wantSSH {
+				t.Errorf("esxiSSHAddress() = %s, want %s", got, tt.wantSSH)
+			}
+		})
+	}
+}
+
+func TestCertManagerLookupError(t *testing.T) {
+	if err := certManagerLookupError(object.ErrNotSupported); !errors.Is(err, errCertManagerUnsupported) {
+		t.Errorf("Expected a host without the manager to fall back to SSH, got %v", err)
+	}
+
+	noPermission := soap.WrapSoapFault(&soap.Fault{Detail: struct {
+		Fault types.AnyType `xml:",any,typeattr"`
+	}{Fault: types.NoPermission{}}})
+	for _, lookupErr := range []error{context.DeadlineExceeded, noPermission} {
+		err := certManagerLookupError(lookupErr)
+		if errors.Is(err, errCertManagerUnsupported) {
+			t.Errorf("Did not expect %v to fall back to SSH", lookupErr)
+		}
+		if err != lookupErr {
+			t.Errorf("Expected %v to be returned as is, got %v", lookupErr, err)
+		}
+	}
+}
+
+func TestRetrySOAPConnect(t *testing.T) {
+	transient := errors.New("connection refused")
+	invalidLogin := soap.WrapSoapFault(&soap.Fault{Detail: struct {
+		Fault types.AnyType `xml:",any,typeattr"`
+	}{Fault: types.InvalidLogin{}}})
+
+	tests := []struct {
+		name         string
+		retries      int
+		failures     []error
+		wantAttempts int
+		wantError    bool
+	}{
+		{"succeeds first time", 3, nil, 1, false},
+		{"recovers from transient failures", 3, []error{transient, transient}, 3, false},
+		{"gives up after retries", 2, []error{transient, transient, transient, transient}, 3, true},
+		{"does not retry rejected credentials", 3, []error{invalidLogin}, 1, true},
+		{"no retries configured", 0, []error{transient}, 1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			err := retrySOAPConnect(context.Background(), tt.retries, time.Millisecond, func() error {
+				attempts++
+				if attempts <= len(tt.failures) {
+					return tt.failures[attempts-1]
+				}
+				return nil
+			})
+			if tt.wantError != (err != nil) {
+				t.Errorf("retrySOAPConnect() error = %v, wantError %v", err, tt.wantError)
+			}
+			if attempts != tt.wantAttempts {
+				t.Errorf("Expected %d attempts, got %d", tt.wantAttempts, attempts)
+			}
+		})
+	}
+}
+
+func TestParseRemoteFileState(t *testing.T) {
+	tests := []struct {
+		name        string
+		output      string
+		expected    string
+		shouldError bool
+	}{
+		{"present", "1984\n", "present, 1984 bytes", false},
+		{"busybox padding", "     1704\n", "present, 1704 bytes", false},
+		{"empty file", "0\n", "present, 0 bytes", false},
+		{"absent", "absent\n", "absent", false},
+		{"unexpected output", "wc: permission denied\n", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state, err := parseRemoteFileState("/etc/vmware/ssl/rui.crt", tt.output)
+			if (err != nil) != tt.shouldError {
+				t.Fatalf("parseRemoteFileState() error = %v, shouldError %v", err, tt.shouldError)
+			}
+			if err == nil && state.String() != tt.expected {
+				t.Errorf("parseRemoteFileState() = %s, expected %s", state, tt.expected)
+			}
+		})
+	}
+}
+
+func TestNormalizeDomains(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    []string
+		expected []string
+	}{
+		{"already normal", []string{"esxi01.lab.example.com"}, []string{"esxi01.lab.example.com"}},
+		{"case and trailing dot", []string{"ESXi01.Lab.Example.com."}, []string{"esxi01.lab.example.com"}},
+		{"duplicates keep first order", []string{"esxi01.lab.example.com", "b.example.com", "ESXI01.lab.example.com.", "b.example.com"}, []string{"esxi01.lab.example.com", "b.example.com"}},
+		{"blank entries dropped", []string{" ", "esxi01.lab.example.com", "."}, []string{"esxi01.lab.example.com"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := normalizeDomains(tt.input); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("normalizeDomains(%q) = %q, expected %q", tt.input, got, tt.expected)
+			}
+		})
+	}
+
+	if got := certificateDomains(Config{Hostname: "ESXi01.lab.example.com:9443"}); !reflect.DeepEqual(got, []string{"esxi01.lab.example.com"}) {
+		t.Errorf("Expected the port dropped from the certificate names, got %q", got)
+	}
+
+	upper, _ := cacheFilePaths("/tmp/cache", "ESXi01.Lab.Example.com.")
+	lower, _ := cacheFilePaths("/tmp/cache", "esxi01.lab.example.com")
+	if upper != lower {
+		t.Errorf("Expected one cache entry regardless of case, got %s and %s", upper, lower)
+	}
+}
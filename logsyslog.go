@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strings"
+)
+
+// Syslog facilities accepted by -syslog-facility
+var syslogFacilities = []string{"user", "daemon", "local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7"}
+
+// validateSyslogFacility checks a facility name against the supported list
+func validateSyslogFacility(facility string) error {
+	for _, f := range syslogFacilities {
+		if facility == f {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid syslog facility %s, must be one of: %s", facility, strings.Join(syslogFacilities, ", "))
+}
+
+// splitLogLine finds the level tag in a formatted log line, returning the level and the
+// message from the tag onwards (syslog records its own timestamp)
+func splitLogLine(line string) (string, string) {
+	line = strings.TrimRight(line, "\n")
+	for _, level := range []string{"ERROR", "WARN", "INFO", "DEBUG", "TRACE"} {
+		if i := strings.Index(line, "["+level+"] "); i >= 0 {
+			return level, line[i:]
+		}
+	}
+	return "INFO", line
+}
+
+// syslogSink receives each log line at the severity matching its level
+type syslogSink interface {
+	Err(m string) error
+	Warning(m string) error
+	Info(m string) error
+	Debug(m string) error
+}
+
+// syslogWriter adapts a syslog connection to the standard logger's output
+type syslogWriter struct {
+	sink syslogSink
+}
+
+func (w syslogWriter) Write(p []byte) (int, error) {
+	level, message := splitLogLine(string(p))
+	var err error
+	switch level {
+	case "ERROR":
+		err = w.sink.Err(message)
+	case "WARN":
+		err = w.sink.Warning(message)
+	case "DEBUG", "TRACE":
+		err = w.sink.Debug(message)
+	default:
+		err = w.sink.Info(message)
+	}
+	return len(p), err
+}
+
+// setupSyslog adds syslog to the configured log outputs. Failing to reach syslog, or a
+// platform without it, only costs the extra output, so it is reported as a warning.
+func setupSyslog(config Config) {
+	if !config.LogSyslog {
+		return
+	}
+
+	sink, err := dialSyslog(config.SyslogFacility, config.SyslogTag)
+	if err != nil {
+		logWarn("Syslog output disabled: %v", err)
+		return
+	}
+
+	log.SetOutput(io.MultiWriter(log.Writer(), syslogWriter{sink: sink}))
+	logInfo("Logging to syslog (facility %s)", config.SyslogFacility)
+}
@@ -0,0 +1,13 @@
+//go:build windows || plan9
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// dialSyslog reports that syslog is unavailable; log/syslog does not support this platform
+func dialSyslog(facility, tag string) (syslogSink, error) {
+	return nil, fmt.Errorf("syslog is not supported on %s", runtime.GOOS)
+}
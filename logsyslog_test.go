@@ -0,0 +1,66 @@
+package main
+
+import (
+	"log"
+	"testing"
+)
+
+func TestSplitLogLine(t *testing.T) {
+	tests := []struct {
+		line    string
+		level   string
+		message string
+	}{
+		{"2026/01/01 03:00:00 main.go:12: [ERROR] [3f9a1c2e] upload failed\n", "ERROR", "[ERROR] [3f9a1c2e] upload failed"},
+		{"2026/01/01 03:00:00 main.go:12: [WARN] slow host\n", "WARN", "[WARN] slow host"},
+		{"2026/01/01 03:00:00 main.go:12: [DEBUG] detail\n", "DEBUG", "[DEBUG] detail"},
+		{"untagged output\n", "INFO", "untagged output"},
+	}
+
+	for _, tt := range tests {
+		level, message := splitLogLine(tt.line)
+		if level != tt.level || message != tt.message {
+			t.Errorf("splitLogLine(%q) = (%q, %q), want (%q, %q)", tt.line, level, message, tt.level, tt.message)
+		}
+	}
+}
+
+// recordingSyslog records the severity each message was sent at
+type recordingSyslog struct {
+	messages map[string]string
+}
+
+func (r *recordingSyslog) Err(m string) error     { r.messages[m] = "err"; return nil }
+func (r *recordingSyslog) Warning(m string) error { r.messages[m] = "warning"; return nil }
+func (r *recordingSyslog) Info(m string) error    { r.messages[m] = "info"; return nil }
+func (r *recordingSyslog) Debug(m string) error   { r.messages[m] = "debug"; return nil }
+
+func TestSyslogWriter(t *testing.T) {
+	sink := &recordingSyslog{messages: make(map[string]string)}
+	logger := log.New(syslogWriter{sink: sink}, "", log.Ldate|log.Ltime)
+
+	logger.Print("[ERROR] upload failed")
+	logger.Print("[WARN] slow host")
+	logger.Print("[INFO] renewed")
+
+	for message, severity := range map[string]string{
+		"[ERROR] upload failed": "err",
+		"[WARN] slow host":      "warning",
+		"[INFO] renewed":        "info",
+	} {
+		if got := sink.messages[message]; got != severity {
+			t.Errorf("Expected %q at severity %s, got %q", message, severity, got)
+		}
+	}
+}
+
+func TestValidateSyslogFacility(t *testing.T) {
+	for _, facility := range []string{"user", "daemon", "local7"} {
+		if err := validateSyslogFacility(facility); err != nil {
+			t.Errorf("Expected facility %s to be valid, got %v", facility, err)
+		}
+	}
+	if err := validateSyslogFacility("kern"); err == nil {
+		t.Error("Expected facility kern to be rejected")
+	}
+}
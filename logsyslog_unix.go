@@ -0,0 +1,24 @@
+//go:build !windows && !plan9
+
+package main
+
+import "log/syslog"
+
+// Priorities of the facilities in syslogFacilities
+var syslogFacilityPriorities = map[string]syslog.Priority{
+	"user":   syslog.LOG_USER,
+	"daemon": syslog.LOG_DAEMON,
+	"local0": syslog.LOG_LOCAL0,
+	"local1": syslog.LOG_LOCAL1,
+	"local2": syslog.LOG_LOCAL2,
+	"local3": syslog.LOG_LOCAL3,
+	"local4": syslog.LOG_LOCAL4,
+	"local5": syslog.LOG_LOCAL5,
+	"local6": syslog.LOG_LOCAL6,
+	"local7": syslog.LOG_LOCAL7,
+}
+
+// dialSyslog connects to the local syslog daemon (journald also listens on its socket)
+func dialSyslog(facility, tag string) (syslogSink, error) {
+	return syslog.New(syslogFacilityPriorities[facility]|syslog.LOG_INFO, tag)
+}
@@ -2,7 +2,10 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
 	"crypto/x509"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -11,6 +14,7 @@ import (
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	awsConfig "github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/sts"
@@ -20,10 +24,32 @@ import (
 
 // Constants
 const (
-	defaultThreshold     = 0.33
-	defaultCheckInterval = 30 * time.Second
-	maxCheckDuration     = 5 * time.Minute
-	acmeServerProduction = "https://acme-v02.api.letsencrypt.org/directory"
+	defaultThreshold           = 0.33
+	defaultCacheReuseThreshold = 0.5
+	defaultCheckInterval       = 30 * time.Second // Longest wait between validation attempts
+	defaultCheckFirstInterval  = 5 * time.Second
+	maxCheckDuration           = 5 * time.Minute
+	defaultSSHStopTimeout      = 30 * time.Second
+	defaultSSHStopPollInterval = 2 * time.Second
+	defaultCacheLockTimeout    = 30 * time.Second
+	defaultMaxRenewals         = 3
+	defaultESXiSSHPort         = 22
+	defaultSOAPConnectRetries  = 3
+	defaultRoute53MaxRetries   = 5
+	defaultDNSTTL              = 60
+	defaultMinRemainingDays    = 30
+	defaultESXiHTTPSPort       = 443
+	defaultRenewalWindow       = 24 * time.Hour
+	cacheLockRetryDelay        = 250 * time.Millisecond
+	acmeServerProduction       = "https://acme-v02.api.letsencrypt.org/directory"
+	acmeServerStaging          = "https://acme-staging-v02.api.letsencrypt.org/directory"
+	assumeRoleSessionName      = "lab-update-esxi-cert"
+	reachabilityTimeout        = 5 * time.Second
+	updateCheckWait            = 2 * time.Second
+	defaultHTTPChallengePort   = 80
+	exitCodeFailure            = 1
+	exitCodePartialFailure     = 50
+	hookTimeout                = 5 * time.Minute
 )
 
 // Log levels
@@ -34,15 +60,46 @@ const (
 	LOG_WARN
 	LOG_INFO
 	LOG_DEBUG
+	LOG_TRACE
+)
+
+// Log output destinations
+const (
+	logOutputBoth       = "both"
+	logOutputFileOnly   = "file"
+	logOutputStdoutOnly = "stdout"
+)
+
+// Base delay before retrying a failed SOAP connection; doubles on each retry
+var soapConnectRetryDelay = 5 * time.Second
+
+// Attempts allowed when validating the installed certificate (-validate-attempts); 0 leaves
+// only the time limit
+var validateAttempts int
+
+// Wait between validation attempts, doubling from the first to the longest
+// (-validate-initial-interval, -validate-max-interval)
+var (
+	validateInterval    = defaultCheckFirstInterval
+	validateMaxInterval = defaultCheckInterval
 )
 
 var (
+	// errorOutput additionally receives ERROR messages when the log output excludes stdout,
+	// so failures still reach the terminal (or cron mail)
+	errorOutput io.Writer
+
+	// correlationID tags every log line of the current run (and host, in a batch) so that
+	// lines from one invocation can be grouped in central logging
+	correlationID string
+
 	currentLogLevel LogLevel = LOG_INFO
 	logLevelNames            = map[LogLevel]string{
 		LOG_ERROR: "ERROR",
 		LOG_WARN:  "WARN",
 		LOG_INFO:  "INFO",
 		LOG_DEBUG: "DEBUG",
+		LOG_TRACE: "TRACE",
 	}
 )
 
@@ -51,7 +108,12 @@ type Config struct {
 	Hostname            string
 	Domain              string
 	Email               string
+	ACMEContacts        []string
+	ACMEUserAgent       string
+	ACMEProfile         string
+	PreferredChain      string
 	Threshold           float64
+	CacheReuseThreshold float64
 	LogFile             string
 	LogLevel            string
 	Route53KeyID        string
@@ -59,19 +121,155 @@ type Config struct {
 	Route53SessionToken string
 	Route53Region       string
 	DryRun              bool
+	PrintCommands       bool
 	Force               bool
 	KeySize             int
+	KeyType             string
+	AccountKeyType      string
 	ESXiUsername        string
 	ESXiPassword        string
+	ESXiTOTPSecret      string
+	SSHStopTimeout      time.Duration
+	InstallMethod       string
+	NoServiceManagement bool
+	TargetType          string
+	CacheLockTimeout    time.Duration
+	MaxRenewals         int
+	RenewalWindow       time.Duration
+	SMTPHost            string
+	SMTPPort            int
+	SMTPUsername        string
+	SMTPPassword        string
+	MailFrom            string
+	MailTo              string
+	Hosts               []HostConfig
+	Services            []ServiceTarget
+	TestIssuance        bool
+	FailFast            bool
+	ReuseKey            bool
+	MustStaple          bool
+	ExtKeyUsages        []string
+	AWSEndpoint         string
+	Route53ZoneID       string
+	Route53Profile      string // Shared AWS profile for the DNS provider only
+	Route53MaxRetries   int
+	DNSTTL              int
+	DNSPropagationWait  time.Duration
+	DNSSkipPropagation  bool
+	DNSPreflight        bool
+	ValidateInterval    time.Duration // First wait between validation attempts, doubling up to ValidateMaxInterval
+	ValidateMaxInterval time.Duration
+	ValidateAttempts    int
+	AWSTimeout          time.Duration
+	AWSAssumeRoleArn    string
+	AWSExternalID       string
+	ChainMode           string
+	ForceUpload         bool
+	CheckReachable      bool
+	IPVersion           string
+	Timing              bool
+	Explain             bool
+	PostRenewHook       string
+	PostFailHook        string
+	PreuploadCheckCmd   string
+	UploadLockWait      time.Duration
+	StrictHooks         bool
+	CheckChain          bool
+	Insecure            bool
+	VerifyTrust         bool
+	RequireValidation   bool
+	VerifyCertFile      bool
+	ProtectedPattern    string // Hosts matching this regex need ConfirmProtected to be modified
+	ConfirmProtected    bool
+	MinRemainingDays    int
+	MinIssuanceInterval time.Duration
+	ReloadMethod        string
+	RenewIfIssuerNot    string
+	ExpectedIssuers     []string
+	Labels              []string // key=value metadata attached to the run's reports
+	PFXOutput           string
+	PFXPassword         string
+	PFXFile             string
+	OutputDir           string
+	OutputLayout        string
+	S3UploadBucket      string
+	S3Prefix            string
+	S3Region            string
+	StrictDistribution  bool
+	Schedule            string
+	StatusFile          string
+	HealthzAddr         string
+	CABundle            string
+	Quiet               bool
+	StdoutOnly          bool
+	LogSyslog           bool
+	SyslogFacility      string
+	SyslogTag           string
+	CSRFile             string
+	KeyFile             string
+	ChallengeType       string
+	HTTPChallengePort   int
+	ESXiSSHPort         int
+	ESXiHTTPSPort       int
+	SOAPConnectRetries  int
+	SOAPKeepAlive       time.Duration
+	CheckUpdates        bool
+
+	// batchID is set on the per-host copies of a hosts-list run, so the whole batch
+	// counts as one order towards -min-issuance-interval
+	batchID string
+}
+
+// ForHost returns a copy of the configuration for a single host, with that
+// host's overrides applied on top of the global settings
+func (c Config) ForHost(host HostConfig) Config {
+	hostConfig := c
+	hostConfig.Hosts = nil
+	hostConfig.Hostname = host.Hostname
+
+	if host.Threshold != 0 {
+		hostConfig.Threshold = host.Threshold
+	}
+	if host.KeySize != 0 {
+		hostConfig.KeySize = host.KeySize
+	}
+	if host.ESXiSSHPort != 0 {
+		hostConfig.ESXiSSHPort = host.ESXiSSHPort
+	}
+	if host.ESXiHTTPSPort != 0 {
+		hostConfig.ESXiHTTPSPort = host.ESXiHTTPSPort
+	}
+	if host.ESXiUsername != "" {
+		hostConfig.ESXiUsername = host.ESXiUsername
+	}
+	if host.ESXiPassword != "" {
+		hostConfig.ESXiPassword = host.ESXiPassword
+	}
+	if host.ESXiTOTPSecret != "" {
+		hostConfig.ESXiTOTPSecret = host.ESXiTOTPSecret
+	}
+	if host.TargetType != "" {
+		hostConfig.TargetType = host.TargetType
+	}
+
+	return hostConfig
 }
 
 // Dependencies struct for dependency injection in main workflow
 type Dependencies struct {
 	AWSValidator  func(Config) error
+	RoleAssumer   func(Config) (Config, error)
 	CertChecker   func(string, float64) (bool, *x509.Certificate, error)
 	CertGenerator func(Config) (string, string, error)
-	CertUploader  func(Config, string, string) error
+	CertUploader  func(Config, string, string) (SSHServiceState, error)
 	CertValidator func(string, *x509.Certificate) (bool, error)
+	MailSender    func(Config, WorkflowResult, error) error
+	IssuanceTest  func(Config) error
+	ChainChecker  func(Config) (ChainReport, error)
+	HookRunner    func(string, []string) (string, error)
+	Distributor   func(Config, string, string) error
+	Renewals      *RenewalHistory
+	UploadLocks   *UploadLocks
 }
 
 // Parse log level from string
@@ -85,85 +283,258 @@ func parseLogLevel(level string) LogLevel {
 		return LOG_INFO
 	case "DEBUG":
 		return LOG_DEBUG
+	case "TRACE", "AUDIT":
+		return LOG_TRACE
 	default:
 		return LOG_INFO
 	}
 }
 
+// newRunID returns a short random identifier for this invocation
+func newRunID() string {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%08x", time.Now().UnixNano()&0xffffffff)
+	}
+	return hex.EncodeToString(b)
+}
+
+// hostCorrelationID combines the run ID with a host's 1-based position in a batch
+func hostCorrelationID(runID string, index int) string {
+	if runID == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s-%d", runID, index+1)
+}
+
+// logTag renders the level and, when set, the correlation ID that start each log line
+func logTag(level string) string {
+	if correlationID == "" {
+		return "[" + level + "] "
+	}
+	return "[" + level + "] [" + correlationID + "] "
+}
+
+// Format a log message with every registered secret scrubbed from it
+func redactedf(format string, args ...interface{}) string {
+	return logRedactor.redact(fmt.Sprintf(format, args...))
+}
+
 // Logging functions with level control
 func logError(format string, args ...interface{}) {
 	if currentLogLevel >= LOG_ERROR {
-		log.Printf("[ERROR] "+format, args...)
+		message := redactedf(format, args...)
+		log.Print(logTag("ERROR") + message)
+		if errorOutput != nil {
+			fmt.Fprint(errorOutput, logTag("ERROR")+strings.TrimSuffix(message, "\n")+"\n")
+		}
 	}
 }
 
 func logWarn(format string, args ...interface{}) {
 	if currentLogLevel >= LOG_WARN {
-		log.Printf("[WARN] "+format, args...)
+		log.Print(logTag("WARN") + redactedf(format, args...))
 	}
 }
 
 func logInfo(format string, args ...interface{}) {
 	if currentLogLevel >= LOG_INFO {
-		log.Printf("[INFO] "+format, args...)
+		log.Print(logTag("INFO") + redactedf(format, args...))
 	}
 }
 
 func logDebug(format string, args ...interface{}) {
 	if currentLogLevel >= LOG_DEBUG {
-		log.Printf("[DEBUG] "+format, args...)
+		log.Print(logTag("DEBUG") + redactedf(format, args...))
+	}
+}
+
+func logTrace(format string, args ...interface{}) {
+	if currentLogLevel >= LOG_TRACE {
+		log.Print(logTag("TRACE") + redactedf(format, args...))
 	}
 }
 
 // Set up logging to file with secure permissions
 func setupLogging(logFile, logLevel string) {
+	setupLoggingWithOutput(logFile, logLevel, logOutputBoth)
+}
+
+// Get the log output destination selected by -quiet or -stdout-only
+func logOutputMode(config Config) string {
+	switch {
+	case config.Quiet:
+		return logOutputFileOnly
+	case config.StdoutOnly:
+		return logOutputStdoutOnly
+	default:
+		return logOutputBoth
+	}
+}
+
+// Set up logging to the file, stdout, or both
+func setupLoggingWithOutput(logFile, logLevel, output string) {
 	// Set log level
 	currentLogLevel = parseLogLevel(logLevel)
+	configureSOAPTrace()
+	errorOutput = nil
+	log.SetFlags(log.Ldate | log.Ltime | log.Lshortfile)
+
+	if output == logOutputStdoutOnly {
+		log.SetOutput(os.Stdout)
+		logInfo("Logging to stdout with level %s", logLevelNames[currentLogLevel])
+		return
+	}
 
-	// Create log file with secure permissions (owner read/write only)
+	// Create log file with secure permissions (owner read/write only). When it can't be written,
+	// e.g. on a container's read-only filesystem, log to stdout instead (stderr in quiet mode).
 	file, err := os.OpenFile(logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
 	if err != nil {
-		fmt.Printf("Error opening log file: %v\n", err)
+		fallback, name := io.Writer(os.Stdout), "stdout"
+		if output == logOutputFileOnly {
+			fallback, name = os.Stderr, "stderr"
+		}
+		log.SetOutput(fallback)
+		logWarn("Cannot write log file %s (%v); logging to %s only with level %s", logFile, err, name, logLevelNames[currentLogLevel])
 		return
 	}
 
-	// Set up multi-writer to log to both file and stdout
-	multiWriter := io.MultiWriter(os.Stdout, file)
-	log.SetOutput(multiWriter)
-	log.SetFlags(log.Ldate | log.Ltime | log.Lshortfile)
+	if output == logOutputFileOnly {
+		// Quiet mode keeps stdout silent; errors still surface on stderr
+		log.SetOutput(file)
+		errorOutput = os.Stderr
+	} else {
+		// Set up multi-writer to log to both file and stdout
+		log.SetOutput(io.MultiWriter(os.Stdout, file))
+	}
 
 	logInfo("Logging to %s with level %s", logFile, logLevelNames[currentLogLevel])
 }
 
-// Validate AWS credentials by making a simple API call
-func validateAWSCredentials(config Config) error {
-	logDebug("Validating AWS credentials...")
-
+// Load the AWS SDK configuration using explicit credentials when provided,
+// otherwise the AWS default credential chain
+func loadAWSConfig(ctx context.Context, config Config, extra ...func(*awsConfig.LoadOptions) error) (aws.Config, error) {
 	var awsCfg aws.Config
 	var err error
 
+	opts := append([]func(*awsConfig.LoadOptions) error{
+		awsConfig.WithRegion(config.Route53Region),
+	}, extra...)
+
+	// Bound retries and per-request time so a degraded link fails predictably instead of hanging
+	opts = append(opts, awsConfig.WithRetryMaxAttempts(route53MaxRetries(config)))
+	if config.AWSTimeout > 0 {
+		opts = append(opts, awsConfig.WithHTTPClient(awshttp.NewBuildableClient().WithTimeout(config.AWSTimeout)))
+	}
+
+	// Send every service call to the custom endpoint (LocalStack, GovCloud, etc.)
+	if config.AWSEndpoint != "" {
+		logInfo("Using custom AWS endpoint: %s", config.AWSEndpoint)
+		opts = append(opts, awsConfig.WithEndpointResolverWithOptions(aws.EndpointResolverWithOptionsFunc(
+			func(service, region string, options ...interface{}) (aws.Endpoint, error) {
+				return aws.Endpoint{
+					URL:           config.AWSEndpoint,
+					SigningRegion: region,
+				}, nil
+			})))
+	}
+
 	// Check if explicit credentials are provided
 	if config.Route53KeyID != "" {
 		// Use explicit static credentials
 		logDebug("Using explicit AWS credentials (Access Key ID: %s)", config.Route53KeyID[:min(8, len(config.Route53KeyID))]+"...")
-		awsCfg, err = awsConfig.LoadDefaultConfig(context.TODO(),
-			awsConfig.WithRegion(config.Route53Region),
+		awsCfg, err = awsConfig.LoadDefaultConfig(ctx, append(opts,
 			awsConfig.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(
 				config.Route53KeyID,
 				config.Route53SecretKey,
 				config.Route53SessionToken,
 			)),
-		)
+		)...)
 	} else {
 		// Use AWS default credential chain (profiles, IAM roles, env vars, etc.)
 		logInfo("Using AWS default credential chain (checking ~/.aws/credentials, IAM roles, environment variables, etc.)")
-		awsCfg, err = awsConfig.LoadDefaultConfig(context.TODO(),
-			awsConfig.WithRegion(config.Route53Region),
-		)
+		awsCfg, err = awsConfig.LoadDefaultConfig(ctx, opts...)
+	}
+
+	if err != nil {
+		return aws.Config{}, fmt.Errorf("failed to create AWS config: %v", err)
+	}
+
+	return awsCfg, nil
+}
+
+// Get the attempts per AWS request, falling back to the default when unset
+func route53MaxRetries(config Config) int {
+	if config.Route53MaxRetries > 0 {
+		return config.Route53MaxRetries
+	}
+	return defaultRoute53MaxRetries
+}
+
+// Get the TTL of the challenge TXT record, falling back to the default when unset
+func dnsTTL(config Config) int {
+	if config.DNSTTL > 0 {
+		return config.DNSTTL
+	}
+	return defaultDNSTTL
+}
+
+// Get the lifetime fraction a cached certificate must keep to be reused, falling back to
+// the default when unset
+func cacheReuseThreshold(config Config) float64 {
+	if config.CacheReuseThreshold > 0 {
+		return config.CacheReuseThreshold
+	}
+	return defaultCacheReuseThreshold
+}
+
+// assumeAWSRole calls STS AssumeRole with the base credentials and returns a copy of the
+// configuration whose explicit Route53 credentials are the temporary role credentials
+func assumeAWSRole(config Config) (Config, error) {
+	ctx := context.TODO()
+
+	awsCfg, err := loadAWSConfig(ctx, config)
+	if err != nil {
+		return config, err
 	}
 
+	input := &sts.AssumeRoleInput{
+		RoleArn:         aws.String(config.AWSAssumeRoleArn),
+		RoleSessionName: aws.String(assumeRoleSessionName),
+	}
+	if config.AWSExternalID != "" {
+		input.ExternalId = aws.String(config.AWSExternalID)
+	}
+
+	logInfo("Assuming AWS role %s", config.AWSAssumeRoleArn)
+	result, err := sts.NewFromConfig(awsCfg).AssumeRole(ctx, input)
+	if err != nil {
+		return config, fmt.Errorf("AssumeRole %s failed: %v", config.AWSAssumeRoleArn, err)
+	}
+	if result.Credentials == nil {
+		return config, fmt.Errorf("AssumeRole %s returned no credentials", config.AWSAssumeRoleArn)
+	}
+
+	assumed := config
+	assumed.Route53KeyID = aws.ToString(result.Credentials.AccessKeyId)
+	assumed.Route53SecretKey = aws.ToString(result.Credentials.SecretAccessKey)
+	assumed.Route53SessionToken = aws.ToString(result.Credentials.SessionToken)
+	assumed.AWSAssumeRoleArn = ""
+	assumed.AWSExternalID = ""
+
+	if result.Credentials.Expiration != nil {
+		logDebug("Assumed role credentials expire at %s", result.Credentials.Expiration.Format(time.RFC3339))
+	}
+	return assumed, nil
+}
+
+// Validate AWS credentials by making a simple API call
+func validateAWSCredentials(config Config) error {
+	logDebug("Validating AWS credentials...")
+
+	awsCfg, err := loadAWSConfig(context.TODO(), config)
 	if err != nil {
-		return fmt.Errorf("failed to create AWS config: %v", err)
+		return err
 	}
 
 	// Create STS client to test credentials
@@ -199,91 +570,575 @@ func min(a, b int) int {
 func GetDefaultDependencies() Dependencies {
 	return Dependencies{
 		AWSValidator: validateAWSCredentials,
+		RoleAssumer:  assumeAWSRole,
 		CertChecker: func(hostname string, threshold float64) (bool, *x509.Certificate, error) {
 			return checkCertificateWithDialer(hostname, threshold, &DefaultTLSDialer{})
 		},
 		CertGenerator: generateCertificate,
 		CertUploader:  uploadCertificate,
 		CertValidator: func(hostname string, oldCert *x509.Certificate) (bool, error) {
-			return validateCertificateWithDialer(hostname, oldCert, &DefaultTLSDialer{}, maxCheckDuration, defaultCheckInterval)
+			return validateCertificateWithDialer(hostname, oldCert, &DefaultTLSDialer{}, maxCheckDuration, validateInterval, validateMaxInterval, validateAttempts)
+		},
+		MailSender:   sendMailReport,
+		IssuanceTest: testCertificateIssuance,
+		ChainChecker: func(config Config) (ChainReport, error) {
+			return checkCertificateChainWithDialer(config, &DefaultTLSDialer{})
 		},
+		HookRunner:  runHookCommand,
+		Distributor: distributeCertificateToS3,
+		Renewals:    NewRenewalHistory(defaultRenewalHistoryPath(), defaultCacheLockTimeout),
+		UploadLocks: NewUploadLocks(defaultCacheDir()),
 	}
 }
 
 // runWorkflow executes the main certificate renewal workflow with dependency injection
-func runWorkflow(config Config, deps Dependencies) error {
+// and emails a report of the outcome when SMTP notifications are configured
+func runWorkflow(config Config, deps Dependencies) (WorkflowResult, error) {
+	if len(config.Hosts) > 0 {
+		return runHostsWorkflow(config, deps)
+	}
+
+	start := time.Now()
+	result, err := executeWorkflow(config, deps)
+	result.Duration = time.Since(start)
+	result.RunID = correlationID
+
+	// Hooks only fail the workflow when strict hooks are requested
+	if hookErr := runHooks(config, result, err, deps.HookRunner); hookErr != nil && err == nil {
+		err = hookErr
+	}
+
+	// Email failures must never fail the core workflow
+	if config.SMTPHost != "" && deps.MailSender != nil {
+		if mailErr := deps.MailSender(config, result, err); mailErr != nil {
+			logWarn("Failed to send email report: %v", mailErr)
+		} else {
+			logInfo("Email report sent to %s", config.MailTo)
+		}
+	}
+
+	return result, err
+}
+
+// WorkflowAction describes what the workflow did for a host
+type WorkflowAction string
+
+const (
+	ActionNone          WorkflowAction = "none"           // Failed before completing any action
+	ActionChecked       WorkflowAction = "checked"        // Dry run: certificate checked only
+	ActionTestIssued    WorkflowAction = "test-issued"    // Staging certificate ordered, nothing installed
+	ActionUpToDate      WorkflowAction = "up-to-date"     // Certificate still within threshold
+	ActionUploadSkipped WorkflowAction = "upload-skipped" // Installed certificate already matches, so nothing was ordered or uploaded
+	ActionRenewed       WorkflowAction = "renewed"        // New certificate uploaded to the host
+)
+
+// WorkflowResult describes the outcome of a workflow run for embedding callers,
+// reports, and notifications
+type WorkflowResult struct {
+	Hostname        string
+	Action          WorkflowAction
+	OldExpiry       time.Time
+	OldThumbprint   string
+	NewExpiry       time.Time
+	NewThumbprint   string
+	CertPath        string
+	KeyPath         string
+	Validated       bool
+	Timings         []PhaseTiming
+	Duration        time.Duration
+	Hosts           []HostResult    // Per-host outcomes of a batch run
+	RunID           string          // Correlation ID of the run (run-host in a batch)
+	Decision        string          // Why the certificate was or wasn't renewed
+	SSHServiceState SSHServiceState // TSM-SSH state after an SSH install, empty if SSH was not used or not managed
+
+	// Expiry of the certificate the host serves after a validated renewal, zero if not measured
+	InstalledExpiry        time.Time
+	InstalledDaysRemaining int
+}
+
+// setOldCertificate records the details of the certificate found on the host
+func (r *WorkflowResult) setOldCertificate(cert *x509.Certificate) {
+	if cert == nil {
+		return
+	}
+	r.OldExpiry = cert.NotAfter
+	r.OldThumbprint = certificateThumbprint(cert)
+}
+
+// setInstalledCertificate records how long the certificate served after the renewal has left
+func (r *WorkflowResult) setInstalledCertificate(cert *x509.Certificate, now time.Time) {
+	if cert == nil {
+		return
+	}
+	r.InstalledExpiry = cert.NotAfter
+	r.InstalledDaysRemaining = int(cert.NotAfter.Sub(now).Hours() / 24)
+}
+
+// Renewed reports whether a new certificate was installed (on any host, for a batch run)
+func (r WorkflowResult) Renewed() bool {
+	if r.Action == ActionRenewed {
+		return true
+	}
+	for _, host := range r.Hosts {
+		if host.Result.Action == ActionRenewed {
+			return true
+		}
+	}
+	return false
+}
+
+// ExitCode maps the workflow outcome to the process exit status
+func (r WorkflowResult) ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+
+	// Batch runs report partial failures with a distinct exit code for CI gating
+	var batchErr *BatchError
+	if errors.As(err, &batchErr) {
+		return batchErr.ExitCode()
+	}
+	return exitCodeFailure
+}
+
+// HostResult records the outcome of the workflow for a single host in a batch run
+type HostResult struct {
+	Hostname string
+	Err      error
+	Skipped  bool
+	Result   WorkflowResult
+}
+
+// BatchError is returned when one or more hosts in a batch run fail
+type BatchError struct {
+	Results []HostResult
+}
+
+// Unwrap returns the error of every failed host, so errors.As finds e.g. a *RateLimitError
+// raised by any one of them
+func (e *BatchError) Unwrap() []error {
+	var errs []error
+	for _, result := range e.Results {
+		if result.Err != nil {
+			errs = append(errs, result.Err)
+		}
+	}
+	return errs
+}
+
+// Failed returns the hostnames whose workflow returned an error
+func (e *BatchError) Failed() []string {
+	var failed []string
+	for _, result := range e.Results {
+		if result.Err != nil {
+			failed = append(failed, result.Hostname)
+		}
+	}
+	return failed
+}
+
+// Succeeded returns the number of hosts that completed without error
+func (e *BatchError) Succeeded() int {
+	succeeded := 0
+	for _, result := range e.Results {
+		if result.Err == nil && !result.Skipped {
+			succeeded++
+		}
+	}
+	return succeeded
+}
+
+func (e *BatchError) Error() string {
+	failed := e.Failed()
+	return fmt.Sprintf("workflow failed for %d of %d hosts: %s", len(failed), len(e.Results), strings.Join(failed, ", "))
+}
+
+// ExitCode distinguishes a partial failure (some hosts renewed) from a total failure
+func (e *BatchError) ExitCode() int {
+	if e.Succeeded() > 0 {
+		return exitCodePartialFailure
+	}
+	return exitCodeFailure
+}
+
+// runHostsWorkflow runs the workflow for every configured host, continuing past
+// failures (unless fail-fast is set) so that one unreachable host doesn't block
+// renewal of the others
+func runHostsWorkflow(config Config, deps Dependencies) (WorkflowResult, error) {
+	start := time.Now()
+
+	if config.Hostname != "" {
+		logWarn("Ignoring hostname %s because a hosts list is configured", config.Hostname)
+	}
+
+	runID := correlationID
+	defer func() { correlationID = runID }()
+	batchID := newRunID()
+
+	results := make([]HostResult, 0, len(config.Hosts))
+	stopped := false
+	for i, host := range config.Hosts {
+		if stopped {
+			results = append(results, HostResult{Hostname: host.Hostname, Skipped: true})
+			continue
+		}
+
+		logInfo("Processing host %s (%d/%d)", host.Hostname, i+1, len(config.Hosts))
+		correlationID = hostCorrelationID(runID, i)
+		hostConfig := config.ForHost(host)
+		hostConfig.batchID = batchID
+		result, err := runWorkflow(hostConfig, deps)
+		correlationID = runID
+		if err != nil {
+			logError("Workflow failed for host %s: %v", host.Hostname, err)
+			if config.FailFast {
+				logWarn("Fail-fast enabled - skipping remaining hosts")
+				stopped = true
+			}
+		}
+		results = append(results, HostResult{Hostname: host.Hostname, Err: err, Result: result})
+	}
+
+	logBatchSummary(results)
+
+	batchResult := WorkflowResult{Hosts: results, Duration: time.Since(start), RunID: runID}
+	batchErr := &BatchError{Results: results}
+	if len(batchErr.Failed()) > 0 {
+		return batchResult, batchErr
+	}
+	return batchResult, nil
+}
+
+// logBatchSummary logs each host's outcome followed by the aggregate counts
+func logBatchSummary(results []HostResult) {
+	succeeded, failed, skipped := 0, 0, 0
+
+	logInfo("Batch summary:")
+	for _, result := range results {
+		switch {
+		case result.Skipped:
+			skipped++
+			logInfo("  %s: SKIPPED", result.Hostname)
+		case result.Err != nil:
+			failed++
+			logInfo("  %s: FAILED (%v)", result.Hostname, result.Err)
+		default:
+			succeeded++
+			logInfo("  %s: OK", result.Hostname)
+		}
+	}
+	logInfo("Batch complete: %d succeeded, %d failed, %d skipped (of %d hosts)", succeeded, failed, skipped, len(results))
+}
+
+// executeWorkflow performs the certificate check, renewal, upload, and validation steps
+func executeWorkflow(config Config, deps Dependencies) (result WorkflowResult, err error) {
+	result = WorkflowResult{Hostname: config.Hostname, Action: ActionNone}
+
 	// Log version information
 	v := version.Get()
 	logInfo("Starting %s", v.String())
 
-	// Check for updates and display notification
-	if updateMsg := version.GetUpdateNotification(); updateMsg != "" {
-		logInfo(updateMsg)
-		fmt.Println(updateMsg)
+	// Time each phase so slow runs can be traced to DNS propagation, ACME, or SSH
+	timer := NewPhaseTimer()
+	defer func() {
+		result.Timings = timer.Phases()
+		if len(timer.Phases()) == 0 {
+			return
+		}
+		if config.Timing {
+			logInfo("Timing for %s: %s", config.Hostname, timer.Summary())
+		} else {
+			logDebug("Timing for %s: %s", config.Hostname, timer.Summary())
+		}
+	}()
+
+	// Guard hosts the operator marked as protected before anything can modify them
+	if err := checkProtectedHost(config); err != nil {
+		return result, err
 	}
 
-	// Validate AWS credentials (required for both dry-run and normal execution)
-	err := deps.AWSValidator(config)
-	if err != nil {
-		return fmt.Errorf("AWS credential validation failed: %v", err)
+	// HTTP-01 challenges and imported certificates never talk to AWS
+	if config.ChallengeType == challengeTypeHTTP01 {
+		logInfo("Using HTTP-01 challenge - skipping AWS credential validation")
+	} else if config.PFXFile != "" {
+		logInfo("Installing certificate from PFX file %s - skipping AWS credential validation", config.PFXFile)
+	} else {
+		done := timer.Start("aws validation")
+
+		// Swap in temporary role credentials before anything talks to AWS
+		if config.AWSAssumeRoleArn != "" {
+			assumed, err := deps.RoleAssumer(config)
+			if err != nil {
+				done()
+				return result, fmt.Errorf("failed to assume AWS role: %v", err)
+			}
+			config = assumed
+
+			// The temporary credentials belong to this run alone: keep them out of the logs
+			// while it lasts, and stop holding them in the redactor once it ends
+			logRedactor.register(assumed.Route53SecretKey, assumed.Route53SessionToken)
+			defer logRedactor.unregister(assumed.Route53SecretKey, assumed.Route53SessionToken)
+		}
+
+		// Validate AWS credentials (required for both dry-run and normal execution)
+		err := deps.AWSValidator(config)
+		done()
+		if err != nil {
+			return result, fmt.Errorf("AWS credential validation failed: %v", err)
+		}
+	}
+
+	// Test issuance orders a staging certificate and stops before touching the ESXi host
+	if config.TestIssuance {
+		logInfo("Running in test-issuance mode. Will order a staging certificate without uploading to ESXi.")
+		done := timer.Start("test issuance")
+		err := deps.IssuanceTest(config)
+		done()
+		if err != nil {
+			return result, fmt.Errorf("test issuance failed: %v", err)
+		}
+		logInfo("Test issuance succeeded for %s: ACME challenge and certificate order completed against Let's Encrypt staging.", config.Hostname)
+		result.Action = ActionTestIssued
+		return result, nil
 	}
 
 	// If dry run, just check the certificate
 	if config.DryRun {
 		logInfo("Running in dry-run mode. Will only check certificate expiration.")
-		_, _, err := deps.CertChecker(config.Hostname, config.Threshold)
+		if config.PrintCommands {
+			printInstallCommands(os.Stdout, config)
+		}
+		done := timer.Start("check")
+		needsRenewal, certInfo, err := deps.CertChecker(esxiHTTPSAddress(config), config.Threshold)
+		done()
 		if err != nil {
-			return fmt.Errorf("certificate check failed: %v", err)
+			return result, fmt.Errorf("certificate check failed: %v", err)
 		}
-		return nil
+		result.setOldCertificate(certInfo)
+		result.Decision = explainRenewalDecision(config, certInfo, needsRenewal, false)
+		reportDecision(config, result.Decision)
+
+		if foreignIssuer(config, certInfo) {
+			logWarn("Installed certificate was issued by %q, not %q; it would be renewed", certInfo.Issuer.String(), config.RenewIfIssuerNot)
+		}
+
+		// A broken chain fails the dry run so monitoring catches installs a leaf-only check misses
+		if chainCheckEnabled(config) && deps.ChainChecker != nil {
+			report, err := deps.ChainChecker(config)
+			if err != nil {
+				return result, fmt.Errorf("certificate chain check failed: %v", err)
+			}
+			if problem := chainProblem(report); problem != "" {
+				return result, fmt.Errorf("certificate chain check failed: %s", problem)
+			}
+		}
+		result.Action = ActionChecked
+		return result, nil
 	}
 
 	// Check if the certificate needs renewal (or if force is enabled)
-	needsRenewal, certInfo, err := deps.CertChecker(config.Hostname, config.Threshold)
+	done := timer.Start("check")
+	needsRenewal, certInfo, err := deps.CertChecker(esxiHTTPSAddress(config), config.Threshold)
+	done()
 	if err != nil {
-		return fmt.Errorf("certificate check failed: %v", err)
+		return result, fmt.Errorf("certificate check failed: %v", err)
+	}
+	result.setOldCertificate(certInfo)
+
+	// A broken chain is repaired by reinstalling the full chain, even if the leaf is still fresh
+	chainBroken := false
+	if chainCheckEnabled(config) && deps.ChainChecker != nil {
+		report, err := deps.ChainChecker(config)
+		if err != nil {
+			logWarn("Certificate chain check failed: %v", err)
+		} else if problem := chainProblem(report); problem != "" {
+			chainBroken = true
+		}
 	}
 
+	result.Decision = explainRenewalDecision(config, certInfo, needsRenewal, chainBroken)
+	reportDecision(config, result.Decision)
+
 	if config.Force {
 		logInfo("Force renewal enabled - bypassing expiration threshold check")
+	} else if chainBroken {
+		logWarn("Installed certificate chain is broken - renewing to reinstall a complete chain")
+	} else if foreignIssuer(config, certInfo) {
+		logInfo("Installed certificate was issued by %q, not %q - renewing regardless of expiry", certInfo.Issuer.String(), config.RenewIfIssuerNot)
 	} else if !needsRenewal {
 		logInfo("Certificate for %s is still valid (expires on %s) and doesn't need renewal yet.",
 			config.Hostname, certInfo.NotAfter.Format(time.RFC3339))
-		return nil
+		result.Action = ActionUpToDate
+		return result, nil
+	}
+
+	// Avoid ordering, exporting and a disruptive upload when the installed certificate is already equivalent
+	if !config.ForceUpload && !chainBroken && certInfo != nil {
+		if matches, reason := installedCertificateMatches(config, certInfo, cachedCertificate(config)); matches {
+			logInfo("Installed certificate already matches (same issuer and SANs, %.1f%% lifetime remaining); skipping renewal and upload. Use --force-upload to renew anyway.",
+				lifetimeRemaining(certInfo)*100)
+			result.Action = ActionUploadSkipped
+			return result, nil
+		} else {
+			logDebug("Installed certificate differs from a new one: %s", reason)
+		}
+	}
+
+	// Refuse to reissue a certificate that keeps being renewed, which points at a renewal loop
+	if deps.Renewals != nil {
+		var loopErr *RenewalLoopError
+		if err := deps.Renewals.Check(config.Hostname, config.MaxRenewals, config.RenewalWindow); errors.As(err, &loopErr) {
+			logError("Renewal loop detected: %v", loopErr)
+			return result, fmt.Errorf("renewal refused: %v", loopErr)
+		} else if err != nil {
+			logWarn("Could not check renewal history: %v", err)
+		}
 	}
 
 	// Generate a new certificate
 	logInfo("Generating new certificate...")
+	done = timer.Start("generation")
 	certPath, keyPath, err := deps.CertGenerator(config)
+	done()
 	if err != nil {
-		return fmt.Errorf("failed to generate certificate: %v", err)
+		return result, fmt.Errorf("failed to generate certificate: %w", err)
 	}
 	logInfo("Certificate generated successfully: %s", certPath)
+	result.CertPath, result.KeyPath = certPath, keyPath
+	exportPFX(config, certPath, keyPath)
+	exportOutputDir(config, certPath, keyPath)
+	if config.S3UploadBucket != "" && deps.Distributor != nil {
+		done = timer.Start("distribution")
+		err := deps.Distributor(config, certPath, keyPath)
+		done()
+		if err != nil && config.StrictDistribution {
+			return result, fmt.Errorf("certificate distribution failed: %v", err)
+		} else if err != nil {
+			logWarn("Certificate distribution failed: %v", err)
+		}
+	}
+
+	newCert, readErr := readCertificateFile(certPath)
+	if readErr == nil {
+		result.NewExpiry = newCert.NotAfter
+		result.NewThumbprint = certificateThumbprint(newCert)
+	}
+
+	// Only one run at a time may install on a host; the lock is held through validation
+	if deps.UploadLocks != nil {
+		lock, err := deps.UploadLocks.Acquire(config.Hostname, config.UploadLockWait)
+		if err != nil {
+			return result, err
+		}
+		defer lock.Unlock()
+	}
 
 	// Upload the certificate to ESXi
 	logInfo("Uploading certificate to ESXi server...")
-	err = deps.CertUploader(config, certPath, keyPath)
+	done = timer.Start("upload")
+	result.SSHServiceState, err = deps.CertUploader(config, certPath, keyPath)
+	done()
 	if err != nil {
-		return fmt.Errorf("failed to upload certificate: %v", err)
+		return result, fmt.Errorf("failed to upload certificate: %v", err)
 	}
 	logInfo("Certificate uploaded successfully.")
+	result.Action = ActionRenewed
+	if deps.Renewals != nil {
+		if err := deps.Renewals.Record(config.Hostname, config.RenewalWindow); err != nil {
+			logWarn("Could not record renewal: %v", err)
+		}
+	}
 
 	// Validate the certificate installation
 	logInfo("Validating new certificate installation...")
-	validated, err := deps.CertValidator(config.Hostname, certInfo)
+	done = timer.Start("validation")
+	validated, err := deps.CertValidator(esxiHTTPSAddress(config), certInfo)
+	done()
 	if err != nil {
 		logWarn("Certificate validation error: %v", err)
+		if config.RequireValidation {
+			return result, fmt.Errorf("could not confirm the new certificate on the host: %v", err)
+		}
 	} else if validated {
+		result.Validated = true
 		logInfo("New certificate successfully validated!")
+
+		// Measure freshness from what the host now serves, not the file that was uploaded
+		if _, installed, err := deps.CertChecker(esxiHTTPSAddress(config), config.Threshold); err != nil {
+			logWarn("Could not re-fetch the installed certificate: %v", err)
+		} else if installed != nil {
+			result.setInstalledCertificate(installed, time.Now())
+			logInfo("Installed certificate expires in %d days (%s)", result.InstalledDaysRemaining, result.InstalledExpiry.Format(time.RFC3339))
+		}
 	} else {
 		logWarn("Could not validate new certificate within the timeout period.")
+
+		// Re-fetch once more so the warning says which certificate the host is actually serving
+		if _, served, err := deps.CertChecker(esxiHTTPSAddress(config), config.Threshold); err != nil {
+			logWarn("Could not re-fetch the served certificate for diagnosis: %v", err)
+		} else {
+			logWarn("%s", diagnoseUnvalidatedCertificate(served, certInfo, newCert))
+		}
+		if config.RequireValidation {
+			return result, fmt.Errorf("could not confirm the new certificate on the host within the timeout period")
+		}
 	}
 
+	// A fresh certificate that would soon need renewing again points at a misconfigured CA
+	if err := checkRemainingAfterRenew(config, result, newCert, time.Now()); err != nil {
+		return result, err
+	}
+
+	// Confirm the installed certificate is trusted, not just different
+	if config.VerifyTrust && result.Validated {
+		if deps.ChainChecker == nil {
+			result.Validated = false
+			return result, fmt.Errorf("failed to verify trust of installed certificate: no chain checker configured")
+		}
+		done = timer.Start("trust verification")
+		report, err := deps.ChainChecker(config)
+		done()
+		if err != nil {
+			result.Validated = false
+			return result, fmt.Errorf("failed to verify trust of installed certificate: %v", err)
+		}
+		if problem := trustProblem(config.Hostname, report); problem != "" {
+			result.Validated = false
+			return result, fmt.Errorf("installed certificate is not trusted: %s", problem)
+		}
+		logInfo("Installed certificate is trusted for %s", config.Hostname)
+	}
+
+	return result, nil
+}
+
+// checkRemainingAfterRenew fails when the new certificate has fewer days left than
+// -min-remaining-after-renew-days: as served by the host when validated, else as generated
+func checkRemainingAfterRenew(config Config, result WorkflowResult, newCert *x509.Certificate, now time.Time) error {
+	if config.MinRemainingDays <= 0 {
+		return nil
+	}
+	expiry := result.InstalledExpiry
+	if expiry.IsZero() && newCert != nil {
+		expiry = newCert.NotAfter
+	}
+	if expiry.IsZero() {
+		return nil
+	}
+
+	days := int(expiry.Sub(now).Hours() / 24)
+	if days < config.MinRemainingDays {
+		return fmt.Errorf("new certificate has only %d days of validity left (expires %s), fewer than the required %d; check the CA and profile configuration",
+			days, expiry.Format(time.RFC3339), config.MinRemainingDays)
+	}
+	logDebug("New certificate has %d days of validity left (at least %d required)", days, config.MinRemainingDays)
 	return nil
 }
 
 // Main function
 func main() {
+	correlationID = newRunID()
+
 	// Parse the command-line arguments
 	config, err := parseArgs()
 	if err != nil {
@@ -291,14 +1146,43 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Scrub credentials from every log line before anything is logged
+	registerConfigSecrets(config)
+
 	// Set up logging
-	setupLogging(config.LogFile, config.LogLevel)
+	setupLoggingWithOutput(config.LogFile, config.LogLevel, logOutputMode(config))
+	setupSyslog(config)
+
+	// Check for updates in the background so an unreachable GitHub never delays the workflow
+	var updateCheck *version.UpdateCheck
+	if config.CheckUpdates {
+		updateCheck = version.StartUpdateCheck()
+	}
 
 	// Run the main workflow with default dependencies
 	deps := GetDefaultDependencies()
-	err = runWorkflow(config, deps)
+
+	// A schedule keeps running the workflow until the process is stopped
+	if config.Schedule != "" {
+		if err := runScheduleUntilSignal(config, deps); err != nil {
+			logError("Schedule failed: %v", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	result, err := runWorkflow(config, deps)
+
+	// Only report an update if the check has already finished (or finishes within a short grace period)
+	if updateMsg := updateCheck.Notification(updateCheckWait); updateMsg != "" {
+		logInfo("%s", updateMsg)
+		if !config.Quiet {
+			fmt.Println(updateMsg)
+		}
+	}
+
 	if err != nil {
 		logError("Workflow failed: %v", err)
-		os.Exit(1)
+		os.Exit(result.ExitCode(err))
 	}
 }
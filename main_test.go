@@ -3,14 +3,20 @@ package main
 import (
 	"bytes"
 	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
 	"time"
+
+	"lab-update-esxi-cert/testutil"
 )
 
 func TestParseLogLevel(t *testing.T) {
@@ -27,6 +33,8 @@ func TestParseLogLevel(t *testing.T) {
 		{"info", LOG_INFO},
 		{"DEBUG", LOG_DEBUG},
 		{"debug", LOG_DEBUG},
+		{"TRACE", LOG_TRACE},
+		{"audit", LOG_TRACE},
 		{"INVALID", LOG_INFO}, // Default fallback
 		{"", LOG_INFO},        // Default fallback
 	}
@@ -62,6 +70,7 @@ func TestLoggingFunctions(t *testing.T) {
 		{LOG_WARN, logWarn, "[WARN]", "test warning message"},
 		{LOG_INFO, logInfo, "[INFO]", "test info message"},
 		{LOG_DEBUG, logDebug, "[DEBUG]", "test debug message"},
+		{LOG_TRACE, logTrace, "[TRACE]", "test trace message"},
 	}
 
 	for _, tt := range tests {
@@ -176,30 +185,116 @@ func TestSetupLogging(t *testing.T) {
 }
 
 func TestSetupLogging_InvalidFile(t *testing.T) {
-	// Capture stdout to check error message
+	// Capture stdout to check the warning and the fallback
+	originalOutput := log.Writer()
 	originalStdout := os.Stdout
 	r, w, _ := os.Pipe()
 	os.Stdout = w
 	defer func() {
 		os.Stdout = originalStdout
+		log.SetOutput(originalOutput)
 		w.Close()
 	}()
 
-	// Try to create log file in non-existent directory
+	// Try to create log file in non-existent directory, as on a read-only container filesystem
 	invalidPath := "/nonexistent/directory/test.log"
 
-	// This should handle the error gracefully
+	// This should fall back to logging on stdout
 	setupLogging(invalidPath, "INFO")
+	logInfo("after fallback")
 
 	// Restore stdout and read captured output
 	w.Close()
 	var output bytes.Buffer
 	io.Copy(&output, r)
 
-	// The function should have printed an error message
 	outputStr := output.String()
-	if !strings.Contains(outputStr, "Error opening log file") {
-		t.Error("Expected error message about log file creation")
+	if !strings.Contains(outputStr, "Cannot write log file "+invalidPath) {
+		t.Errorf("Expected a warning about the log file, got: %s", outputStr)
+	}
+	if !strings.Contains(outputStr, "after fallback") {
+		t.Errorf("Expected later messages on stdout, got: %s", outputStr)
+	}
+}
+
+func TestSetupLoggingWithOutput_Quiet(t *testing.T) {
+	tempDir := t.TempDir()
+	logFile := filepath.Join(tempDir, "quiet.log")
+
+	originalOutput := log.Writer()
+	originalStdout, originalStderr := os.Stdout, os.Stderr
+	outR, outW, _ := os.Pipe()
+	errR, errW, _ := os.Pipe()
+	os.Stdout, os.Stderr = outW, errW
+	defer func() {
+		os.Stdout, os.Stderr = originalStdout, originalStderr
+		log.SetOutput(originalOutput)
+		log.SetFlags(log.LstdFlags)
+		errorOutput = nil
+	}()
+
+	setupLoggingWithOutput(logFile, "INFO", logOutputFileOnly)
+	logInfo("quiet info message")
+	logError("quiet error message")
+
+	os.Stdout, os.Stderr = originalStdout, originalStderr
+	outW.Close()
+	errW.Close()
+	var stdout, stderr bytes.Buffer
+	io.Copy(&stdout, outR)
+	io.Copy(&stderr, errR)
+
+	if stdout.Len() != 0 {
+		t.Errorf("Expected no stdout output in quiet mode, got: %s", stdout.String())
+	}
+	if !strings.Contains(stderr.String(), "[ERROR] quiet error message") || strings.Contains(stderr.String(), "quiet info message") {
+		t.Errorf("Expected only the error on stderr, got: %s", stderr.String())
+	}
+
+	content, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatalf("Failed to read log file: %v", err)
+	}
+	if !strings.Contains(string(content), "quiet info message") || !strings.Contains(string(content), "quiet error message") {
+		t.Errorf("Expected log file to contain all messages, got: %s", string(content))
+	}
+}
+
+func TestSetupLoggingWithOutput_StdoutOnly(t *testing.T) {
+	tempDir := t.TempDir()
+	logFile := filepath.Join(tempDir, "stdout-only.log")
+
+	originalOutput := log.Writer()
+	defer func() {
+		log.SetOutput(originalOutput)
+		log.SetFlags(log.LstdFlags)
+	}()
+
+	setupLoggingWithOutput(logFile, "INFO", logOutputStdoutOnly)
+	log.SetOutput(originalOutput)
+
+	if _, err := os.Stat(logFile); !os.IsNotExist(err) {
+		t.Errorf("Expected no log file in stdout-only mode, got: %v", err)
+	}
+	if errorOutput != nil {
+		t.Error("Expected no separate error output in stdout-only mode")
+	}
+}
+
+func TestLogOutputMode(t *testing.T) {
+	tests := []struct {
+		config   Config
+		expected string
+	}{
+		{Config{}, logOutputBoth},
+		{Config{Quiet: true}, logOutputFileOnly},
+		{Config{StdoutOnly: true}, logOutputStdoutOnly},
+	}
+
+	for _, tt := range tests {
+		if result := logOutputMode(tt.config); result != tt.expected {
+			t.Errorf("logOutputMode(%+v) = %s, expected %s", tt.config, result, tt.expected)
+		}
 	}
 }
 
@@ -258,6 +353,138 @@ func TestValidateAWSCredentials_SessionToken(t *testing.T) {
 	}
 }
 
+func TestValidateAWSCredentials_CustomEndpoint(t *testing.T) {
+	requests := 0
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		response := `<?xml version="1.0" encoding="UTF-8"?>
+<GetCallerIdentityResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+    <GetCallerIdentityResult>
+        <Arn>arn:aws:iam::000000000000:root</Arn>
+        <UserId>000000000000</UserId>
+        <Account>000000000000</Account>
+    </GetCallerIdentityResult>
+</GetCallerIdentityResponse>`
+		w.Header().Set("Content-Type", "text/xml")
+		w.Write([]byte(response))
+	}))
+	defer mockServer.Close()
+
+	config := Config{
+		Route53KeyID:     "test",
+		Route53SecretKey: "test",
+		Route53Region:    "us-east-1",
+		AWSEndpoint:      mockServer.URL,
+	}
+
+	if err := validateAWSCredentials(config); err != nil {
+		t.Fatalf("Expected validation against custom endpoint to succeed, got: %v", err)
+	}
+	if requests == 0 {
+		t.Error("Expected STS request to be sent to the custom endpoint")
+	}
+}
+
+func TestAssumeAWSRole(t *testing.T) {
+	var form map[string][]string
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		form = r.PostForm
+		response := `<?xml version="1.0" encoding="UTF-8"?>
+<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+    <AssumeRoleResult>
+        <Credentials>
+            <AccessKeyId>ASIAASSUMED</AccessKeyId>
+            <SecretAccessKey>assumed-secret</SecretAccessKey>
+            <SessionToken>assumed-token</SessionToken>
+            <Expiration>2030-01-01T00:00:00Z</Expiration>
+        </Credentials>
+    </AssumeRoleResult>
+</AssumeRoleResponse>`
+		w.Header().Set("Content-Type", "text/xml")
+		w.Write([]byte(response))
+	}))
+	defer mockServer.Close()
+
+	config := Config{
+		Route53KeyID:     "AKIABASE",
+		Route53SecretKey: "base-secret",
+		Route53Region:    "us-east-1",
+		AWSEndpoint:      mockServer.URL,
+		AWSAssumeRoleArn: "arn:aws:iam::123456789012:role/dns-manager",
+		AWSExternalID:    "lab-external-id",
+	}
+
+	assumed, err := assumeAWSRole(config)
+	if err != nil {
+		t.Fatalf("Expected AssumeRole to succeed, got: %v", err)
+	}
+
+	if got := form["RoleArn"]; len(got) != 1 || got[0] != config.AWSAssumeRoleArn {
+		t.Errorf("Expected RoleArn %s to be sent, got %v", config.AWSAssumeRoleArn, got)
+	}
+	if got := form["ExternalId"]; len(got) != 1 || got[0] != "lab-external-id" {
+		t.Errorf("Expected ExternalId to be sent, got %v", got)
+	}
+
+	if assumed.Route53KeyID != "ASIAASSUMED" || assumed.Route53SecretKey != "assumed-secret" || assumed.Route53SessionToken != "assumed-token" {
+		t.Errorf("Expected temporary credentials in config, got key=%s token=%s", assumed.Route53KeyID, assumed.Route53SessionToken)
+	}
+	if assumed.AWSAssumeRoleArn != "" {
+		t.Error("Expected role ARN to be cleared after assumption")
+	}
+}
+
+func TestRunWorkflow_AssumeRole(t *testing.T) {
+	config := Config{
+		Hostname:         "test.example.com",
+		DryRun:           true,
+		AWSAssumeRoleArn: "arn:aws:iam::123456789012:role/dns-manager",
+	}
+
+	originalRedactor := logRedactor
+	logRedactor = &secretRedactor{}
+	defer func() { logRedactor = originalRedactor }()
+
+	var validatedToken, redactedDuringRun string
+	mockDeps := Dependencies{
+		RoleAssumer: func(c Config) (Config, error) {
+			c.Route53SessionToken = "assumed-token"
+			c.AWSAssumeRoleArn = ""
+			return c, nil
+		},
+		AWSValidator: func(c Config) error {
+			validatedToken = c.Route53SessionToken
+			redactedDuringRun = logRedactor.redact(c.Route53SessionToken)
+			return nil
+		},
+		CertChecker: func(string, float64) (bool, *x509.Certificate, error) {
+			return false, nil, nil
+		},
+	}
+
+	if _, err := runWorkflow(config, mockDeps); err != nil {
+		t.Fatalf("Expected workflow to succeed, got: %v", err)
+	}
+	if validatedToken != "assumed-token" {
+		t.Errorf("Expected assumed role credentials to reach the validator, got token %q", validatedToken)
+	}
+	if redactedDuringRun != redactedValue {
+		t.Errorf("Expected the temporary token to be redacted during the run, got %q", redactedDuringRun)
+	}
+	if len(logRedactor.secrets) != 0 {
+		t.Errorf("Expected the temporary credentials to be released after the run, got %v", logRedactor.secrets)
+	}
+
+	mockDeps.RoleAssumer = func(c Config) (Config, error) {
+		return c, fmt.Errorf("AccessDenied")
+	}
+	_, err := runWorkflow(config, mockDeps)
+	if err == nil || !strings.Contains(err.Error(), "failed to assume AWS role") {
+		t.Errorf("Expected role assumption failure, got: %v", err)
+	}
+}
+
 func TestRunWorkflow_DryRun(t *testing.T) {
 	// Create a dry-run configuration
 	config := Config{
@@ -286,9 +513,9 @@ func TestRunWorkflow_DryRun(t *testing.T) {
 			t.Error("CertGenerator should not be called in dry-run mode")
 			return "", "", nil
 		},
-		CertUploader: func(Config, string, string) error {
+		CertUploader: func(Config, string, string) (SSHServiceState, error) {
 			t.Error("CertUploader should not be called in dry-run mode")
-			return nil
+			return "", nil
 		},
 		CertValidator: func(string, *x509.Certificate) (bool, error) {
 			t.Error("CertValidator should not be called in dry-run mode")
@@ -297,12 +524,64 @@ func TestRunWorkflow_DryRun(t *testing.T) {
 	}
 
 	// Test the workflow
-	err := runWorkflow(config, mockDeps)
+	_, err := runWorkflow(config, mockDeps)
 	if err != nil {
 		t.Errorf("Dry run workflow should succeed, got error: %v", err)
 	}
 }
 
+func TestRunWorkflow_ForceKeepsMatchingCertificate(t *testing.T) {
+	config := Config{
+		Hostname:        "force-match.example.com",
+		Force:           true,
+		Threshold:       0.33,
+		ExpectedIssuers: []string{"Let's Encrypt"},
+	}
+	installed := &x509.Certificate{
+		Issuer:    pkix.Name{CommonName: "R11", Organization: []string{"Let's Encrypt"}},
+		DNSNames:  []string{"force-match.example.com"},
+		NotBefore: time.Now().Add(-10 * 24 * time.Hour),
+		NotAfter:  time.Now().Add(80 * 24 * time.Hour),
+	}
+
+	generated, uploaded := false, false
+	mockDeps := Dependencies{
+		AWSValidator: func(Config) error { return nil },
+		CertChecker: func(string, float64) (bool, *x509.Certificate, error) {
+			return false, installed, nil
+		},
+		CertGenerator: func(Config) (string, string, error) {
+			generated = true
+			return "cert.pem", "key.pem", nil
+		},
+		CertUploader: func(Config, string, string) (SSHServiceState, error) {
+			uploaded = true
+			return "", nil
+		},
+		CertValidator: func(string, *x509.Certificate) (bool, error) { return true, nil },
+	}
+
+	result, err := runWorkflow(config, mockDeps)
+	if err != nil {
+		t.Fatalf("Expected the run to succeed, got %v", err)
+	}
+	if result.Action != ActionUploadSkipped {
+		t.Errorf("Expected action %s, got %s", ActionUploadSkipped, result.Action)
+	}
+	if generated || uploaded {
+		t.Errorf("Expected no order or upload for a matching certificate, got generated=%t uploaded=%t", generated, uploaded)
+	}
+
+	// -force-upload still renews
+	config.ForceUpload = true
+	if _, err := runWorkflow(config, mockDeps); err != nil {
+		t.Fatalf("Expected the forced upload to succeed, got %v", err)
+	}
+	if !generated || !uploaded {
+		t.Errorf("Expected -force-upload to order and upload, got generated=%t uploaded=%t", generated, uploaded)
+	}
+}
+
 func TestRunWorkflow_ForceRenewal(t *testing.T) {
 	config := Config{
 		Hostname:         "test.example.com",
@@ -340,9 +619,9 @@ func TestRunWorkflow_ForceRenewal(t *testing.T) {
 			certGeneratorCalled = true
 			return "cert.pem", "key.pem", nil
 		},
-		CertUploader: func(Config, string, string) error {
+		CertUploader: func(Config, string, string) (SSHServiceState, error) {
 			certUploaderCalled = true
-			return nil
+			return SSHServiceStopped, nil
 		},
 		CertValidator: func(string, *x509.Certificate) (bool, error) {
 			certValidatorCalled = true
@@ -351,10 +630,13 @@ func TestRunWorkflow_ForceRenewal(t *testing.T) {
 	}
 
 	// Test the workflow
-	err := runWorkflow(config, mockDeps)
+	result, err := runWorkflow(config, mockDeps)
 	if err != nil {
 		t.Errorf("Force renewal workflow should succeed, got error: %v", err)
 	}
+	if result.SSHServiceState != SSHServiceStopped {
+		t.Errorf("Expected SSH service state to be reported, got %q", result.SSHServiceState)
+	}
 
 	// Verify all expected functions were called for force renewal
 	if !awsValidatorCalled {
@@ -396,9 +678,9 @@ func TestRunWorkflow_AWSValidationFailure(t *testing.T) {
 			t.Error("CertGenerator should not be called when AWS validation fails")
 			return "", "", nil
 		},
-		CertUploader: func(Config, string, string) error {
+		CertUploader: func(Config, string, string) (SSHServiceState, error) {
 			t.Error("CertUploader should not be called when AWS validation fails")
-			return nil
+			return "", nil
 		},
 		CertValidator: func(string, *x509.Certificate) (bool, error) {
 			t.Error("CertValidator should not be called when AWS validation fails")
@@ -407,7 +689,7 @@ func TestRunWorkflow_AWSValidationFailure(t *testing.T) {
 	}
 
 	// Test the workflow
-	err := runWorkflow(config, mockDeps)
+	_, err := runWorkflow(config, mockDeps)
 	if err == nil {
 		t.Error("Expected workflow to fail with AWS validation error")
 	}
@@ -437,9 +719,9 @@ func TestRunWorkflow_CertificateCheckFailure(t *testing.T) {
 			t.Error("CertGenerator should not be called when cert check fails")
 			return "", "", nil
 		},
-		CertUploader: func(Config, string, string) error {
+		CertUploader: func(Config, string, string) (SSHServiceState, error) {
 			t.Error("CertUploader should not be called when cert check fails")
-			return nil
+			return "", nil
 		},
 		CertValidator: func(string, *x509.Certificate) (bool, error) {
 			t.Error("CertValidator should not be called when cert check fails")
@@ -448,7 +730,7 @@ func TestRunWorkflow_CertificateCheckFailure(t *testing.T) {
 	}
 
 	// Test the workflow
-	err := runWorkflow(config, mockDeps)
+	_, err := runWorkflow(config, mockDeps)
 	if err == nil {
 		t.Error("Expected workflow to fail with certificate check error")
 	}
@@ -483,9 +765,9 @@ func TestRunWorkflow_CertificateUpToDate(t *testing.T) {
 			t.Error("CertGenerator should not be called when cert is up to date")
 			return "", "", nil
 		},
-		CertUploader: func(Config, string, string) error {
+		CertUploader: func(Config, string, string) (SSHServiceState, error) {
 			t.Error("CertUploader should not be called when cert is up to date")
-			return nil
+			return "", nil
 		},
 		CertValidator: func(string, *x509.Certificate) (bool, error) {
 			t.Error("CertValidator should not be called when cert is up to date")
@@ -494,7 +776,7 @@ func TestRunWorkflow_CertificateUpToDate(t *testing.T) {
 	}
 
 	// Test the workflow
-	err := runWorkflow(config, mockDeps)
+	_, err := runWorkflow(config, mockDeps)
 	if err != nil {
 		t.Errorf("Workflow with up-to-date certificate should succeed, got error: %v", err)
 	}
@@ -666,9 +948,9 @@ func TestRunWorkflow_CertGenerationFailure(t *testing.T) {
 		CertGenerator: func(Config) (string, string, error) {
 			return "", "", fmt.Errorf("ACME server unreachable")
 		},
-		CertUploader: func(Config, string, string) error {
+		CertUploader: func(Config, string, string) (SSHServiceState, error) {
 			t.Error("CertUploader should not be called when generation fails")
-			return nil
+			return "", nil
 		},
 		CertValidator: func(string, *x509.Certificate) (bool, error) {
 			t.Error("CertValidator should not be called when generation fails")
@@ -676,7 +958,7 @@ func TestRunWorkflow_CertGenerationFailure(t *testing.T) {
 		},
 	}
 
-	err := runWorkflow(config, mockDeps)
+	_, err := runWorkflow(config, mockDeps)
 	if err == nil {
 		t.Error("Expected workflow to fail with certificate generation error")
 	}
@@ -714,8 +996,8 @@ func TestRunWorkflow_CertUploadFailure(t *testing.T) {
 		CertGenerator: func(Config) (string, string, error) {
 			return "cert.pem", "key.pem", nil
 		},
-		CertUploader: func(Config, string, string) error {
-			return fmt.Errorf("SSH authentication failed")
+		CertUploader: func(Config, string, string) (SSHServiceState, error) {
+			return "", fmt.Errorf("SSH authentication failed")
 		},
 		CertValidator: func(string, *x509.Certificate) (bool, error) {
 			t.Error("CertValidator should not be called when upload fails")
@@ -723,7 +1005,7 @@ func TestRunWorkflow_CertUploadFailure(t *testing.T) {
 		},
 	}
 
-	err := runWorkflow(config, mockDeps)
+	_, err := runWorkflow(config, mockDeps)
 	if err == nil {
 		t.Error("Expected workflow to fail with certificate upload error")
 	}
@@ -761,8 +1043,8 @@ func TestRunWorkflow_ValidationWarning(t *testing.T) {
 		CertGenerator: func(Config) (string, string, error) {
 			return "cert.pem", "key.pem", nil
 		},
-		CertUploader: func(Config, string, string) error {
-			return nil
+		CertUploader: func(Config, string, string) (SSHServiceState, error) {
+			return "", nil
 		},
 		CertValidator: func(string, *x509.Certificate) (bool, error) {
 			// Return validation error (not failure, just warning)
@@ -771,8 +1053,724 @@ func TestRunWorkflow_ValidationWarning(t *testing.T) {
 	}
 
 	// Should succeed even if validation has errors (it's just a warning)
-	err := runWorkflow(config, mockDeps)
+	_, err := runWorkflow(config, mockDeps)
 	if err != nil {
 		t.Errorf("Workflow should succeed even with validation warnings, got error: %v", err)
 	}
+
+	// With -require-validation, an unconfirmed certificate fails the run, whether the validator
+	// errored or timed out
+	config.RequireValidation = true
+	if _, err := runWorkflow(config, mockDeps); err == nil || !strings.Contains(err.Error(), "connection timeout") {
+		t.Errorf("Expected the validation error to fail the run, got: %v", err)
+	}
+	mockDeps.CertValidator = func(string, *x509.Certificate) (bool, error) {
+		return false, nil
+	}
+	result, err := runWorkflow(config, mockDeps)
+	if err == nil || !strings.Contains(err.Error(), "could not confirm the new certificate") {
+		t.Errorf("Expected the unconfirmed certificate to fail the run, got: %v", err)
+	}
+	if result.ExitCode(err) == 0 {
+		t.Error("Expected a non-zero exit code")
+	}
+}
+
+func TestCheckRemainingAfterRenew(t *testing.T) {
+	now := time.Now()
+	newCert := &x509.Certificate{NotAfter: now.Add(10*24*time.Hour + time.Hour)}
+	config := Config{MinRemainingDays: 30}
+
+	err := checkRemainingAfterRenew(config, WorkflowResult{}, newCert, now)
+	if err == nil || !strings.Contains(err.Error(), "only 10 days of validity left") {
+		t.Errorf("Expected a short-dated certificate to fail the check, got: %v", err)
+	}
+
+	// The expiry the host serves takes precedence over the generated certificate
+	result := WorkflowResult{InstalledExpiry: now.Add(90 * 24 * time.Hour)}
+	if err := checkRemainingAfterRenew(config, result, newCert, now); err != nil {
+		t.Errorf("Expected the installed certificate to clear the check, got: %v", err)
+	}
+
+	config.MinRemainingDays = 0
+	if err := checkRemainingAfterRenew(config, WorkflowResult{}, newCert, now); err != nil {
+		t.Errorf("Expected 0 to disable the check, got: %v", err)
+	}
+}
+
+func TestRunWorkflow_MailReport(t *testing.T) {
+	config := Config{
+		Hostname: "test.example.com",
+		DryRun:   true,
+		SMTPHost: "smtp.example.com",
+		SMTPPort: 587,
+		MailFrom: "esxi-cert@example.com",
+		MailTo:   "ops@example.com",
+	}
+
+	checkErr := fmt.Errorf("connection refused")
+	var mailedErr error
+	mailCalls := 0
+
+	mockDeps := Dependencies{
+		AWSValidator: func(Config) error {
+			return nil
+		},
+		CertChecker: func(string, float64) (bool, *x509.Certificate, error) {
+			return false, nil, checkErr
+		},
+		MailSender: func(c Config, _ WorkflowResult, workflowErr error) error {
+			mailCalls++
+			mailedErr = workflowErr
+			// Mail failures must not change the workflow outcome
+			return fmt.Errorf("SMTP server unavailable")
+		},
+	}
+
+	_, err := runWorkflow(config, mockDeps)
+	if err == nil || !strings.Contains(err.Error(), "certificate check failed") {
+		t.Errorf("Expected certificate check error to be returned, got: %v", err)
+	}
+	if mailCalls != 1 {
+		t.Errorf("Expected mail sender to be called once, got %d", mailCalls)
+	}
+	if mailedErr == nil {
+		t.Error("Expected workflow error to be passed to the mail sender")
+	}
+}
+
+func TestRunWorkflow_MailReportNotConfigured(t *testing.T) {
+	config := Config{Hostname: "test.example.com", DryRun: true}
+
+	mockDeps := Dependencies{
+		AWSValidator: func(Config) error {
+			return nil
+		},
+		CertChecker: func(string, float64) (bool, *x509.Certificate, error) {
+			return false, &x509.Certificate{NotAfter: time.Now().Add(60 * 24 * time.Hour)}, nil
+		},
+		MailSender: func(Config, WorkflowResult, error) error {
+			t.Error("MailSender should not be called when SMTP is not configured")
+			return nil
+		},
+	}
+
+	if _, err := runWorkflow(config, mockDeps); err != nil {
+		t.Errorf("Expected dry run to succeed, got: %v", err)
+	}
+}
+
+func TestRunWorkflow_Hosts(t *testing.T) {
+	config := Config{
+		DryRun:    true,
+		Threshold: 0.33,
+		Hosts: []HostConfig{
+			{Hostname: "esxi01.example.com"},
+			{Hostname: "esxi02.example.com", Threshold: 0.5},
+			{Hostname: "esxi03.example.com"},
+		},
+	}
+
+	thresholds := make(map[string]float64)
+	mockDeps := Dependencies{
+		AWSValidator: func(Config) error {
+			return nil
+		},
+		CertChecker: func(hostname string, threshold float64) (bool, *x509.Certificate, error) {
+			thresholds[hostname] = threshold
+			if hostname == "esxi01.example.com" {
+				return false, nil, fmt.Errorf("connection refused")
+			}
+			return false, nil, nil
+		},
+	}
+
+	_, err := runWorkflow(config, mockDeps)
+	if err == nil || !strings.Contains(err.Error(), "workflow failed for 1 of 3 hosts: esxi01.example.com") {
+		t.Errorf("Expected aggregated host failure, got: %v", err)
+	}
+
+	// A failure on the first host must not stop the remaining hosts
+	expected := map[string]float64{
+		"esxi01.example.com": 0.33,
+		"esxi02.example.com": 0.5,
+		"esxi03.example.com": 0.33,
+	}
+	for hostname, threshold := range expected {
+		if got, ok := thresholds[hostname]; !ok || got != threshold {
+			t.Errorf("Expected %s to be checked with threshold %.2f, got %.2f (checked: %v)", hostname, threshold, got, ok)
+		}
+	}
+}
+
+func TestRunWorkflow_TestIssuance(t *testing.T) {
+	config := Config{
+		Hostname:     "test.example.com",
+		Domain:       "example.com",
+		Email:        "admin@example.com",
+		TestIssuance: true,
+	}
+
+	issuanceCalls := 0
+	mockDeps := Dependencies{
+		AWSValidator: func(Config) error {
+			return nil
+		},
+		CertChecker: func(string, float64) (bool, *x509.Certificate, error) {
+			t.Error("Certificate checker should not be called in test-issuance mode")
+			return false, nil, nil
+		},
+		CertGenerator: func(Config) (string, string, error) {
+			t.Error("Certificate generator should not be called in test-issuance mode")
+			return "", "", nil
+		},
+		CertUploader: func(Config, string, string) (SSHServiceState, error) {
+			t.Error("Certificate uploader should not be called in test-issuance mode")
+			return "", nil
+		},
+		IssuanceTest: func(c Config) error {
+			issuanceCalls++
+			return nil
+		},
+	}
+
+	if _, err := runWorkflow(config, mockDeps); err != nil {
+		t.Errorf("Expected test issuance to succeed, got: %v", err)
+	}
+	if issuanceCalls != 1 {
+		t.Errorf("Expected issuance test to run once, got %d", issuanceCalls)
+	}
+
+	// Failures are reported clearly
+	mockDeps.IssuanceTest = func(Config) error {
+		return fmt.Errorf("DNS challenge timed out")
+	}
+	_, err := runWorkflow(config, mockDeps)
+	if err == nil || !strings.Contains(err.Error(), "test issuance failed: DNS challenge timed out") {
+		t.Errorf("Expected test issuance failure, got: %v", err)
+	}
+}
+
+func TestRunWorkflow_HostsFailFast(t *testing.T) {
+	config := Config{
+		DryRun:    true,
+		Threshold: 0.33,
+		FailFast:  true,
+		Hosts: []HostConfig{
+			{Hostname: "esxi01.example.com"},
+			{Hostname: "esxi02.example.com"},
+			{Hostname: "esxi03.example.com"},
+		},
+	}
+
+	var checked []string
+	mockDeps := Dependencies{
+		AWSValidator: func(Config) error {
+			return nil
+		},
+		CertChecker: func(hostname string, threshold float64) (bool, *x509.Certificate, error) {
+			checked = append(checked, hostname)
+			if hostname == "esxi02.example.com" {
+				return false, nil, fmt.Errorf("connection refused")
+			}
+			return false, nil, nil
+		},
+	}
+
+	_, err := runWorkflow(config, mockDeps)
+	var batchErr *BatchError
+	if !errors.As(err, &batchErr) {
+		t.Fatalf("Expected BatchError, got: %v", err)
+	}
+
+	if len(checked) != 2 {
+		t.Errorf("Expected fail-fast to stop after the second host, checked: %v", checked)
+	}
+	if !batchErr.Results[2].Skipped {
+		t.Error("Expected the third host to be marked as skipped")
+	}
+	if batchErr.ExitCode() != exitCodePartialFailure {
+		t.Errorf("Expected partial failure exit code %d, got %d", exitCodePartialFailure, batchErr.ExitCode())
+	}
+}
+
+func TestBatchError_ExitCode(t *testing.T) {
+	tests := []struct {
+		name     string
+		results  []HostResult
+		expected int
+	}{
+		{
+			name: "partial failure",
+			results: []HostResult{
+				{Hostname: "esxi01.example.com"},
+				{Hostname: "esxi02.example.com", Err: fmt.Errorf("timeout")},
+			},
+			expected: exitCodePartialFailure,
+		},
+		{
+			name: "all hosts failed",
+			results: []HostResult{
+				{Hostname: "esxi01.example.com", Err: fmt.Errorf("timeout")},
+				{Hostname: "esxi02.example.com", Err: fmt.Errorf("timeout")},
+			},
+			expected: exitCodeFailure,
+		},
+		{
+			name: "failure followed by skipped hosts",
+			results: []HostResult{
+				{Hostname: "esxi01.example.com", Err: fmt.Errorf("timeout")},
+				{Hostname: "esxi02.example.com", Skipped: true},
+			},
+			expected: exitCodeFailure,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			batchErr := &BatchError{Results: tt.results}
+			if code := batchErr.ExitCode(); code != tt.expected {
+				t.Errorf("ExitCode() = %d, expected %d", code, tt.expected)
+			}
+		})
+	}
+}
+
+func TestBatchError_Unwrap(t *testing.T) {
+	rateErr := &RateLimitError{Hostname: "esxi02.example.com", RetryAfter: time.Now().Add(time.Hour)}
+	var err error = &BatchError{Results: []HostResult{
+		{Hostname: "esxi01.example.com", Err: fmt.Errorf("timeout")},
+		{Hostname: "esxi02.example.com", Err: fmt.Errorf("failed to generate certificate: %w", rateErr)},
+		{Hostname: "esxi03.example.com"},
+	}}
+
+	var found *RateLimitError
+	if !errors.As(err, &found) || found != rateErr {
+		t.Errorf("Expected errors.As to find the rate limit of one host, got %v", found)
+	}
+	if errs := err.(*BatchError).Unwrap(); len(errs) != 2 {
+		t.Errorf("Expected the errors of the 2 failed hosts, got %v", errs)
+	}
+}
+
+func TestRunWorkflow_HTTP01SkipsAWSValidation(t *testing.T) {
+	config := Config{
+		Hostname:      "test.example.com",
+		DryRun:        true,
+		ChallengeType: challengeTypeHTTP01,
+	}
+
+	mockDeps := Dependencies{
+		AWSValidator: func(Config) error {
+			t.Error("AWS validator should not be called for HTTP-01 challenges")
+			return nil
+		},
+		CertChecker: func(string, float64) (bool, *x509.Certificate, error) {
+			return false, nil, nil
+		},
+	}
+
+	if _, err := runWorkflow(config, mockDeps); err != nil {
+		t.Errorf("Expected workflow to succeed, got: %v", err)
+	}
+}
+
+func TestRunWorkflow_Timing(t *testing.T) {
+	var buf bytes.Buffer
+	originalOutput := log.Writer()
+	log.SetOutput(&buf)
+	originalLevel := currentLogLevel
+	currentLogLevel = LOG_INFO
+	defer func() {
+		log.SetOutput(originalOutput)
+		currentLogLevel = originalLevel
+	}()
+
+	config := Config{
+		Hostname:  "test.example.com",
+		Domain:    "example.com",
+		Force:     true,
+		Threshold: 0.33,
+		Timing:    true,
+	}
+
+	mockDeps := Dependencies{
+		AWSValidator: func(Config) error { return nil },
+		CertChecker: func(string, float64) (bool, *x509.Certificate, error) {
+			return false, &x509.Certificate{NotAfter: time.Now().Add(60 * 24 * time.Hour)}, nil
+		},
+		CertGenerator: func(Config) (string, string, error) { return "cert.pem", "key.pem", nil },
+		CertUploader:  func(Config, string, string) (SSHServiceState, error) { return "", nil },
+		CertValidator: func(string, *x509.Certificate) (bool, error) { return true, nil },
+	}
+
+	if _, err := runWorkflow(config, mockDeps); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	output := buf.String()
+	if !strings.Contains(output, "Timing for test.example.com:") {
+		t.Fatalf("Expected timing breakdown in output, got: %s", output)
+	}
+	for _, phase := range []string{"aws validation:", "check:", "generation:", "upload:", "validation:"} {
+		if !strings.Contains(output, phase) {
+			t.Errorf("Expected timing breakdown to include %q, got: %s", phase, output)
+		}
+	}
+
+	// Without -timing the breakdown is only logged at DEBUG
+	buf.Reset()
+	config.Timing = false
+	if _, err := runWorkflow(config, mockDeps); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if strings.Contains(buf.String(), "Timing for") {
+		t.Errorf("Did not expect timing breakdown at INFO without -timing, got: %s", buf.String())
+	}
+}
+
+func TestRunWorkflow_Result(t *testing.T) {
+	certPEM, _, err := testutil.GenerateValidCertificate("test.example.com")
+	if err != nil {
+		t.Fatalf("Failed to generate certificate: %v", err)
+	}
+	certPath := filepath.Join(t.TempDir(), "cert.pem")
+	os.WriteFile(certPath, certPEM, 0600)
+	newCert, _ := testutil.ParseCertificatePEM(certPEM)
+
+	oldCert := &x509.Certificate{Raw: []byte("old"), NotAfter: time.Now().Add(10 * 24 * time.Hour)}
+
+	baseConfig := Config{Hostname: "test.example.com", Domain: "example.com", Threshold: 0.33, ForceUpload: true}
+	newDeps := func(needsRenewal bool) Dependencies {
+		return Dependencies{
+			AWSValidator: func(Config) error { return nil },
+			CertChecker: func(string, float64) (bool, *x509.Certificate, error) {
+				return needsRenewal, oldCert, nil
+			},
+			CertGenerator: func(Config) (string, string, error) { return certPath, "key.pem", nil },
+			CertUploader:  func(Config, string, string) (SSHServiceState, error) { return "", nil },
+			CertValidator: func(string, *x509.Certificate) (bool, error) { return true, nil },
+		}
+	}
+
+	t.Run("renewed", func(t *testing.T) {
+		result, err := runWorkflow(baseConfig, newDeps(true))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if result.Action != ActionRenewed || !result.Renewed() || !result.Validated {
+			t.Errorf("Expected validated renewal, got %+v", result)
+		}
+		if !result.OldExpiry.Equal(oldCert.NotAfter) || result.OldThumbprint != certificateThumbprint(oldCert) {
+			t.Errorf("Unexpected old certificate details: %s %s", result.OldExpiry, result.OldThumbprint)
+		}
+		if !result.NewExpiry.Equal(newCert.NotAfter) || result.NewThumbprint != certificateThumbprint(newCert) {
+			t.Errorf("Unexpected new certificate details: %s %s", result.NewExpiry, result.NewThumbprint)
+		}
+		if len(result.Timings) == 0 {
+			t.Error("Expected phase timings in result")
+		}
+		if code := result.ExitCode(err); code != 0 {
+			t.Errorf("Expected exit code 0, got %d", code)
+		}
+	})
+
+	t.Run("up to date", func(t *testing.T) {
+		result, err := runWorkflow(baseConfig, newDeps(false))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if result.Action != ActionUpToDate || result.Renewed() || !result.NewExpiry.IsZero() {
+			t.Errorf("Expected up-to-date result without a new certificate, got %+v", result)
+		}
+	})
+
+	t.Run("dry run", func(t *testing.T) {
+		config := baseConfig
+		config.DryRun = true
+		result, _ := runWorkflow(config, newDeps(true))
+		if result.Action != ActionChecked {
+			t.Errorf("Expected checked action, got %s", result.Action)
+		}
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		deps := newDeps(true)
+		deps.AWSValidator = func(Config) error { return errors.New("no credentials") }
+		result, err := runWorkflow(baseConfig, deps)
+		if err == nil {
+			t.Fatal("Expected error")
+		}
+		if result.Action != ActionNone {
+			t.Errorf("Expected no action on failure, got %s", result.Action)
+		}
+		if code := result.ExitCode(err); code != exitCodeFailure {
+			t.Errorf("Expected exit code %d, got %d", exitCodeFailure, code)
+		}
+	})
+
+	t.Run("batch", func(t *testing.T) {
+		config := baseConfig
+		config.Hostname = ""
+		config.Hosts = []HostConfig{{Hostname: "esxi01.example.com"}, {Hostname: "esxi02.example.com"}}
+		deps := newDeps(true)
+		deps.CertUploader = func(c Config, _, _ string) (SSHServiceState, error) {
+			if c.Hostname == "esxi02.example.com" {
+				return "", errors.New("ssh refused")
+			}
+			return "", nil
+		}
+
+		result, err := runWorkflow(config, deps)
+		if len(result.Hosts) != 2 {
+			t.Fatalf("Expected 2 host results, got %d", len(result.Hosts))
+		}
+		if result.Hosts[0].Result.Action != ActionRenewed || !result.Renewed() {
+			t.Errorf("Expected first host renewed, got %+v", result.Hosts[0].Result)
+		}
+		if code := result.ExitCode(err); code != exitCodePartialFailure {
+			t.Errorf("Expected exit code %d, got %d", exitCodePartialFailure, code)
+		}
+	})
+}
+
+func TestRunWorkflow_ChainCheck(t *testing.T) {
+	brokenChain := func(Config) (ChainReport, error) {
+		return ChainReport{Length: 1, VerifyErr: errors.New("unknown authority")}, nil
+	}
+	newDeps := func(uploaded *bool) Dependencies {
+		return Dependencies{
+			AWSValidator: func(Config) error { return nil },
+			CertChecker: func(string, float64) (bool, *x509.Certificate, error) {
+				return false, &x509.Certificate{NotAfter: time.Now().Add(60 * 24 * time.Hour)}, nil
+			},
+			CertGenerator: func(Config) (string, string, error) { return "cert.pem", "key.pem", nil },
+			CertUploader: func(Config, string, string) (SSHServiceState, error) {
+				*uploaded = true
+				return "", nil
+			},
+			CertValidator: func(string, *x509.Certificate) (bool, error) { return true, nil },
+			ChainChecker:  brokenChain,
+		}
+	}
+	config := Config{Hostname: "test.example.com", Domain: "example.com", Threshold: 0.33, CheckChain: true}
+
+	t.Run("dry run fails on broken chain", func(t *testing.T) {
+		var uploaded bool
+		dryRun := config
+		dryRun.DryRun = true
+		_, err := runWorkflow(dryRun, newDeps(&uploaded))
+		if err == nil || !strings.Contains(err.Error(), "certificate chain check failed") {
+			t.Errorf("Expected chain check failure, got %v", err)
+		}
+	})
+
+	t.Run("broken chain triggers reinstall", func(t *testing.T) {
+		var uploaded bool
+		result, err := runWorkflow(config, newDeps(&uploaded))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if !uploaded || result.Action != ActionRenewed {
+			t.Errorf("Expected a broken chain to be reinstalled, got action %s", result.Action)
+		}
+	})
+
+	t.Run("chain check disabled", func(t *testing.T) {
+		var uploaded bool
+		disabled := config
+		disabled.CheckChain = false
+		result, err := runWorkflow(disabled, newDeps(&uploaded))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if uploaded || result.Action != ActionUpToDate {
+			t.Errorf("Expected no renewal without -check-chain, got action %s", result.Action)
+		}
+	})
+}
+
+func TestRunWorkflow_VerifyTrust(t *testing.T) {
+	newDeps := func(report ChainReport) Dependencies {
+		return Dependencies{
+			AWSValidator: func(Config) error { return nil },
+			CertChecker: func(string, float64) (bool, *x509.Certificate, error) {
+				return true, &x509.Certificate{NotAfter: time.Now().Add(10 * 24 * time.Hour)}, nil
+			},
+			CertGenerator: func(Config) (string, string, error) { return "cert.pem", "key.pem", nil },
+			CertUploader:  func(Config, string, string) (SSHServiceState, error) { return "", nil },
+			CertValidator: func(string, *x509.Certificate) (bool, error) { return true, nil },
+			ChainChecker:  func(Config) (ChainReport, error) { return report, nil },
+		}
+	}
+	config := Config{Hostname: "test.example.com", Domain: "example.com", Threshold: 0.33, VerifyTrust: true}
+
+	t.Run("untrusted certificate fails", func(t *testing.T) {
+		result, err := runWorkflow(config, newDeps(ChainReport{Length: 1, VerifyErr: errors.New("unknown authority")}))
+		if err == nil || !strings.Contains(err.Error(), "installed certificate is not trusted") {
+			t.Errorf("Expected trust failure, got %v", err)
+		}
+		if result.Validated {
+			t.Error("Expected an untrusted certificate not to count as validated")
+		}
+	})
+
+	t.Run("trusted certificate passes", func(t *testing.T) {
+		leaf := &x509.Certificate{DNSNames: []string{"test.example.com"}}
+		result, err := runWorkflow(config, newDeps(ChainReport{Length: 1, Leaf: leaf, Verified: true}))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if !result.Validated {
+			t.Error("Expected a trusted certificate to be validated")
+		}
+	})
+
+	t.Run("missing chain checker fails instead of panicking", func(t *testing.T) {
+		deps := newDeps(ChainReport{})
+		deps.ChainChecker = nil
+		result, err := runWorkflow(config, deps)
+		if err == nil || !strings.Contains(err.Error(), "no chain checker configured") {
+			t.Errorf("Expected an error without a chain checker, got %v", err)
+		}
+		if result.Validated {
+			t.Error("Expected an unverified certificate not to count as validated")
+		}
+	})
+}
+
+func TestRunWorkflow_RenewIfIssuerNot(t *testing.T) {
+	var uploaded bool
+	mockDeps := Dependencies{
+		AWSValidator: func(Config) error { return nil },
+		CertChecker: func(string, float64) (bool, *x509.Certificate, error) {
+			return false, &x509.Certificate{
+				Issuer:   pkix.Name{CommonName: "CA", Organization: []string{"VMware Installer"}},
+				NotAfter: time.Now().Add(5 * 365 * 24 * time.Hour),
+			}, nil
+		},
+		CertGenerator: func(Config) (string, string, error) { return "cert.pem", "key.pem", nil },
+		CertUploader: func(Config, string, string) (SSHServiceState, error) {
+			uploaded = true
+			return "", nil
+		},
+		CertValidator: func(string, *x509.Certificate) (bool, error) { return true, nil },
+	}
+	config := Config{Hostname: "test.example.com", Domain: "example.com", Threshold: 0.33, RenewIfIssuerNot: "Let's Encrypt"}
+
+	result, err := runWorkflow(config, mockDeps)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !uploaded || result.Action != ActionRenewed {
+		t.Errorf("Expected a foreign-issuer certificate to be renewed, got action %s", result.Action)
+	}
+}
+
+func TestCorrelationID(t *testing.T) {
+	var buf bytes.Buffer
+	originalOutput := log.Writer()
+	log.SetOutput(&buf)
+	originalLevel := currentLogLevel
+	currentLogLevel = LOG_INFO
+	defer func() {
+		log.SetOutput(originalOutput)
+		currentLogLevel = originalLevel
+		correlationID = ""
+	}()
+
+	runID := newRunID()
+	if len(runID) != 8 {
+		t.Errorf("Expected an 8 character run ID, got %q", runID)
+	}
+	if newRunID() == runID {
+		t.Error("Expected run IDs to differ between invocations")
+	}
+
+	correlationID = "abcd1234"
+	config := Config{
+		DryRun:    true,
+		Threshold: 0.33,
+		Hosts:     []HostConfig{{Hostname: "esxi01.example.com"}, {Hostname: "esxi02.example.com"}},
+	}
+	mockDeps := Dependencies{
+		AWSValidator: func(Config) error { return nil },
+		CertChecker: func(hostname string, threshold float64) (bool, *x509.Certificate, error) {
+			logInfo("checking %s", hostname)
+			return false, nil, nil
+		},
+	}
+
+	result, err := runWorkflow(config, mockDeps)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	output := buf.String()
+	for _, want := range []string{
+		"[INFO] [abcd1234-1] checking esxi01.example.com",
+		"[INFO] [abcd1234-2] checking esxi02.example.com",
+		"[INFO] [abcd1234] Batch summary:",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("Expected log to contain %q, got:\n%s", want, output)
+		}
+	}
+	if result.RunID != "abcd1234" || result.Hosts[1].Result.RunID != "abcd1234-2" {
+		t.Errorf("Unexpected run IDs: batch %q, host %q", result.RunID, result.Hosts[1].Result.RunID)
+	}
+	if correlationID != "abcd1234" {
+		t.Errorf("Expected the run ID to be restored after the batch, got %q", correlationID)
+	}
+}
+
+func TestRunWorkflow_Distribution(t *testing.T) {
+	config := Config{
+		Hostname:       "test.example.com",
+		Domain:         "example.com",
+		Email:          "test@example.com",
+		ESXiUsername:   "root",
+		ESXiPassword:   "password",
+		Force:          true,
+		Threshold:      0.33,
+		S3UploadBucket: "pki-archive",
+	}
+
+	uploaded := false
+	mockDeps := Dependencies{
+		AWSValidator: func(Config) error { return nil },
+		CertChecker: func(string, float64) (bool, *x509.Certificate, error) {
+			return false, &x509.Certificate{NotAfter: time.Now().Add(60 * 24 * time.Hour)}, nil
+		},
+		CertGenerator: func(Config) (string, string, error) { return "cert.pem", "key.pem", nil },
+		CertUploader: func(Config, string, string) (SSHServiceState, error) {
+			uploaded = true
+			return "", nil
+		},
+		CertValidator: func(string, *x509.Certificate) (bool, error) { return true, nil },
+		Distributor: func(c Config, certPath, keyPath string) error {
+			if certPath != "cert.pem" || keyPath != "key.pem" {
+				t.Errorf("Unexpected files distributed: %s, %s", certPath, keyPath)
+			}
+			return fmt.Errorf("AccessDenied")
+		},
+	}
+
+	// A failed upload is only a warning by default
+	if _, err := runWorkflow(config, mockDeps); err != nil {
+		t.Errorf("Expected the workflow to succeed despite the failed upload, got: %v", err)
+	}
+	if !uploaded {
+		t.Error("Expected the certificate to be installed")
+	}
+
+	// With -strict-distribution it fails the run before anything is installed
+	uploaded = false
+	config.StrictDistribution = true
+	if _, err := runWorkflow(config, mockDeps); err == nil || !strings.Contains(err.Error(), "certificate distribution failed") {
+		t.Errorf("Expected the distribution failure to fail the run, got: %v", err)
+	}
+	if uploaded {
+		t.Error("Expected no install after a strict distribution failure")
+	}
 }
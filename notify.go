@@ -0,0 +1,137 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"lab-update-esxi-cert/internal/version"
+)
+
+// Split a comma-separated recipient list into trimmed, non-empty addresses
+func parseMailRecipients(mailTo string) []string {
+	var recipients []string
+	for _, addr := range strings.Split(mailTo, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			recipients = append(recipients, addr)
+		}
+	}
+	return recipients
+}
+
+// Build the subject and body of the renewal report email
+func buildMailReport(config Config, result WorkflowResult, workflowErr error) (string, string) {
+	status := "SUCCESS"
+	if workflowErr != nil {
+		status = "FAILURE"
+	}
+
+	subject := fmt.Sprintf("[ESXi Certificate Manager] %s: certificate renewal for %s", status, config.Hostname)
+
+	var body strings.Builder
+	fmt.Fprintf(&body, "ESXi certificate renewal report\n\n")
+	fmt.Fprintf(&body, "Host:    %s\n", config.Hostname)
+	fmt.Fprintf(&body, "Status:  %s\n", status)
+	fmt.Fprintf(&body, "Time:    %s\n", time.Now().Format(time.RFC3339))
+	fmt.Fprintf(&body, "Version: %s\n", version.Get().String())
+	if result.RunID != "" {
+		fmt.Fprintf(&body, "Run ID:  %s\n", result.RunID)
+	}
+	if len(config.Labels) > 0 {
+		fmt.Fprintf(&body, "Labels:  %s\n", strings.Join(config.Labels, ", "))
+	}
+	if result.Action != "" {
+		fmt.Fprintf(&body, "Action:  %s\n", result.Action)
+	}
+	if result.Decision != "" {
+		fmt.Fprintf(&body, "Reason:  %s\n", result.Decision)
+	}
+	if !result.OldExpiry.IsZero() {
+		fmt.Fprintf(&body, "\nPrevious certificate expires: %s\n", result.OldExpiry.Format(time.RFC3339))
+		fmt.Fprintf(&body, "Previous thumbprint (SHA-256): %s\n", result.OldThumbprint)
+	}
+	if !result.NewExpiry.IsZero() {
+		fmt.Fprintf(&body, "\nNew certificate expires: %s\n", result.NewExpiry.Format(time.RFC3339))
+		fmt.Fprintf(&body, "New thumbprint (SHA-256): %s\n", result.NewThumbprint)
+	}
+	if !result.InstalledExpiry.IsZero() {
+		fmt.Fprintf(&body, "Installed certificate expires in: %d days\n", result.InstalledDaysRemaining)
+	}
+	if result.SSHServiceState != "" {
+		fmt.Fprintf(&body, "\nSSH service after install: %s\n", result.SSHServiceState)
+	}
+	if len(result.Timings) > 0 {
+		timer := PhaseTimer{phases: result.Timings}
+		fmt.Fprintf(&body, "\nTiming: %s\n", timer.Summary())
+	}
+	if workflowErr != nil {
+		fmt.Fprintf(&body, "\nError:\n%v\n", workflowErr)
+	}
+	if config.LogFile != "" {
+		fmt.Fprintf(&body, "\nSee %s for the full log.\n", config.LogFile)
+	}
+
+	return subject, body.String()
+}
+
+// Send the renewal report via SMTP, upgrading the connection with STARTTLS when offered
+func sendMailReport(config Config, result WorkflowResult, workflowErr error) error {
+	recipients := parseMailRecipients(config.MailTo)
+	if len(recipients) == 0 {
+		return fmt.Errorf("no mail recipients configured")
+	}
+
+	subject, body := buildMailReport(config, result, workflowErr)
+	message := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\nMIME-Version: 1.0\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n%s",
+		config.MailFrom, strings.Join(recipients, ", "), subject, time.Now().Format(time.RFC1123Z),
+		strings.ReplaceAll(body, "\n", "\r\n"))
+
+	addr := net.JoinHostPort(config.SMTPHost, strconv.Itoa(config.SMTPPort))
+	logDebug("Sending renewal report to %s via %s", strings.Join(recipients, ", "), addr)
+
+	client, err := smtp.Dial(addr)
+	if err != nil {
+		return fmt.Errorf("failed to connect to SMTP server %s: %v", addr, err)
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: config.SMTPHost}); err != nil {
+			return fmt.Errorf("failed to start TLS with SMTP server: %v", err)
+		}
+		logDebug("SMTP connection upgraded with STARTTLS")
+	}
+
+	if config.SMTPUsername != "" {
+		auth := smtp.PlainAuth("", config.SMTPUsername, config.SMTPPassword, config.SMTPHost)
+		if err := client.Auth(auth); err != nil {
+			return fmt.Errorf("SMTP authentication failed: %v", err)
+		}
+	}
+
+	if err := client.Mail(config.MailFrom); err != nil {
+		return fmt.Errorf("SMTP MAIL FROM failed: %v", err)
+	}
+	for _, rcpt := range recipients {
+		if err := client.Rcpt(rcpt); err != nil {
+			return fmt.Errorf("SMTP RCPT TO %s failed: %v", rcpt, err)
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("SMTP DATA failed: %v", err)
+	}
+	if _, err := w.Write([]byte(message)); err != nil {
+		return fmt.Errorf("failed to write email message: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to send email message: %v", err)
+	}
+
+	return client.Quit()
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bufio"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startFakeSMTPServer starts a minimal SMTP server that records the DATA payload
+func startFakeSMTPServer(t *testing.T) (string, int, <-chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to start fake SMTP server: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	messages := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		reader := bufio.NewReader(conn)
+		fmt.Fprintf(conn, "220 localhost ESMTP fake\r\n")
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			cmd := strings.ToUpper(strings.TrimSpace(line))
+			switch {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				fmt.Fprintf(conn, "250 localhost\r\n")
+			case strings.HasPrefix(cmd, "MAIL"), strings.HasPrefix(cmd, "RCPT"):
+				fmt.Fprintf(conn, "250 OK\r\n")
+			case cmd == "DATA":
+				fmt.Fprintf(conn, "354 End data with <CR><LF>.<CR><LF>\r\n")
+				var data strings.Builder
+				for {
+					dataLine, err := reader.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if dataLine == ".\r\n" {
+						break
+					}
+					data.WriteString(dataLine)
+				}
+				messages <- data.String()
+				fmt.Fprintf(conn, "250 OK\r\n")
+			case cmd == "QUIT":
+				fmt.Fprintf(conn, "221 Bye\r\n")
+				return
+			default:
+				fmt.Fprintf(conn, "250 OK\r\n")
+			}
+		}
+	}()
+
+	host, portStr, _ := net.SplitHostPort(listener.Addr().String())
+	port, _ := strconv.Atoi(portStr)
+	return host, port, messages
+}
+
+func TestParseMailRecipients(t *testing.T) {
+	recipients := parseMailRecipients(" ops@example.com, ,admin@example.com ")
+	if len(recipients) != 2 {
+		t.Fatalf("Expected 2 recipients, got %d: %v", len(recipients), recipients)
+	}
+	if recipients[0] != "ops@example.com" || recipients[1] != "admin@example.com" {
+		t.Errorf("Unexpected recipients: %v", recipients)
+	}
+
+	if recipients := parseMailRecipients(""); len(recipients) != 0 {
+		t.Errorf("Expected no recipients for empty string, got %v", recipients)
+	}
+}
+
+func TestBuildMailReport(t *testing.T) {
+	config := Config{Hostname: "esxi01.example.com", LogFile: "test.log"}
+
+	t.Run("success", func(t *testing.T) {
+		subject, body := buildMailReport(config, WorkflowResult{}, nil)
+		if !strings.Contains(subject, "SUCCESS") || !strings.Contains(subject, "esxi01.example.com") {
+			t.Errorf("Unexpected subject: %s", subject)
+		}
+		if strings.Contains(body, "Error:") {
+			t.Errorf("Did not expect error section in success report: %s", body)
+		}
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		subject, body := buildMailReport(config, WorkflowResult{}, fmt.Errorf("SSH authentication failed"))
+		if !strings.Contains(subject, "FAILURE") {
+			t.Errorf("Expected FAILURE in subject, got: %s", subject)
+		}
+		if !strings.Contains(body, "SSH authentication failed") {
+			t.Errorf("Expected error in body, got: %s", body)
+		}
+	})
+}
+
+func TestBuildMailReport_WorkflowResult(t *testing.T) {
+	config := Config{Hostname: "esxi01.example.com"}
+	result := WorkflowResult{
+		Hostname:        "esxi01.example.com",
+		Action:          ActionRenewed,
+		OldExpiry:       time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC),
+		OldThumbprint:   "AA:BB",
+		NewExpiry:       time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC),
+		NewThumbprint:   "CC:DD",
+		Timings:         []PhaseTiming{{Name: "generation", Duration: 47 * time.Second}},
+		SSHServiceState: SSHServiceRunning,
+	}
+	result.setInstalledCertificate(&x509.Certificate{NotAfter: result.NewExpiry}, result.NewExpiry.Add(-90*24*time.Hour))
+
+	_, body := buildMailReport(config, result, nil)
+	for _, expected := range []string{
+		"Action:  renewed",
+		"Previous certificate expires: 2025-03-01T00:00:00Z",
+		"Previous thumbprint (SHA-256): AA:BB",
+		"New certificate expires: 2025-06-01T00:00:00Z",
+		"New thumbprint (SHA-256): CC:DD",
+		"Installed certificate expires in: 90 days",
+		"Timing: generation: 47s",
+		"SSH service after install: running",
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("Expected report to contain %q, got:\n%s", expected, body)
+		}
+	}
+}
+
+func TestSendMailReport(t *testing.T) {
+	host, port, messages := startFakeSMTPServer(t)
+
+	config := Config{
+		Hostname: "esxi01.example.com",
+		SMTPHost: host,
+		SMTPPort: port,
+		MailFrom: "esxi-cert@example.com",
+		MailTo:   "ops@example.com",
+	}
+
+	if err := sendMailReport(config, WorkflowResult{}, nil); err != nil {
+		t.Fatalf("Expected email to be sent, got error: %v", err)
+	}
+
+	message := <-messages
+	if !strings.Contains(message, "To: ops@example.com") {
+		t.Errorf("Expected To header in message, got: %s", message)
+	}
+	if !strings.Contains(message, "SUCCESS") {
+		t.Errorf("Expected SUCCESS status in message, got: %s", message)
+	}
+}
+
+func TestSendMailReport_NoRecipients(t *testing.T) {
+	config := Config{SMTPHost: "127.0.0.1", SMTPPort: 25, MailFrom: "esxi-cert@example.com"}
+	if err := sendMailReport(config, WorkflowResult{}, nil); err == nil {
+		t.Error("Expected error when no recipients are configured")
+	}
+}
+
+func TestSendMailReport_ConnectionFailure(t *testing.T) {
+	config := Config{SMTPHost: "127.0.0.1", SMTPPort: 1, MailFrom: "a@example.com", MailTo: "b@example.com"}
+	if err := sendMailReport(config, WorkflowResult{}, nil); err == nil {
+		t.Error("Expected error when SMTP server is unreachable")
+	}
+}
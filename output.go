@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+)
+
+// Layouts of the -output-dir copy of each generated certificate
+const (
+	outputLayoutFlat    = "flat"
+	outputLayoutCertbot = "certbot"
+)
+
+// certbotArchiveVersion matches a numbered file in a certbot archive directory, e.g. cert3.pem
+var certbotArchiveVersion = regexp.MustCompile(`^(?:cert|chain|fullchain|privkey)(\d+)\.pem$`)
+
+// outputFiles holds the PEM material written to the output directory
+type outputFiles struct {
+	cert, chain, fullchain, privkey []byte
+}
+
+// readOutputFiles splits the generated bundle into the leaf, its chain and the full chain
+func readOutputFiles(certPath, keyPath string) (outputFiles, error) {
+	certs, err := readCertificateBundle(certPath)
+	if err != nil {
+		return outputFiles{}, err
+	}
+	key, err := os.ReadFile(keyPath)
+	if err != nil {
+		return outputFiles{}, fmt.Errorf("failed to read key file: %v", err)
+	}
+
+	files := outputFiles{privkey: key}
+	for i, cert := range certs {
+		block := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+		if i == 0 {
+			files.cert = block
+		} else {
+			files.chain = append(files.chain, block...)
+		}
+		files.fullchain = append(files.fullchain, block...)
+	}
+	return files, nil
+}
+
+// writeFlatOutput writes <host>-cert.pem (full chain) and <host>-key.pem, as in the cache
+func writeFlatOutput(dir, hostname string, files outputFiles) ([]string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create output directory %s: %v", dir, err)
+	}
+	certPath, keyPath := cacheFilePaths(dir, hostname)
+	if err := writeFileAtomic(certPath, files.fullchain, 0644); err != nil {
+		return nil, fmt.Errorf("failed to write %s: %v", certPath, err)
+	}
+	if err := writeFileAtomic(keyPath, files.privkey, 0600); err != nil {
+		return nil, fmt.Errorf("failed to write %s: %v", keyPath, err)
+	}
+	return []string{certPath, keyPath}, nil
+}
+
+// writeCertbotOutput writes the material like certbot: numbered files under
+// archive/<host>/ and live/<host>/{cert,chain,fullchain,privkey}.pem linking to the newest.
+// Windows gets plain copies in live/, as symlinks there need extra privileges.
+func writeCertbotOutput(dir, hostname string, files outputFiles) ([]string, error) {
+	name := normalizeDomain(esxiHostOnly(hostname))
+	archiveDir := filepath.Join(dir, "archive", name)
+	liveDir := filepath.Join(dir, "live", name)
+	for _, d := range []string{archiveDir, liveDir} {
+		if err := os.MkdirAll(d, 0700); err != nil {
+			return nil, fmt.Errorf("failed to create output directory %s: %v", d, err)
+		}
+	}
+
+	version, err := nextCertbotVersion(archiveDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var written []string
+	for _, file := range []struct {
+		name string
+		data []byte
+		perm os.FileMode
+	}{
+		{"cert", files.cert, 0644},
+		{"chain", files.chain, 0644},
+		{"fullchain", files.fullchain, 0644},
+		{"privkey", files.privkey, 0600},
+	} {
+		archiveName := file.name + strconv.Itoa(version) + ".pem"
+		livePath := filepath.Join(liveDir, file.name+".pem")
+		if err := writeFileAtomic(filepath.Join(archiveDir, archiveName), file.data, file.perm); err != nil {
+			return nil, fmt.Errorf("failed to write %s: %v", archiveName, err)
+		}
+
+		if runtime.GOOS == "windows" {
+			err = writeFileAtomic(livePath, file.data, file.perm)
+		} else {
+			err = replaceSymlink(filepath.Join("..", "..", "archive", name, archiveName), livePath)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to update %s: %v", livePath, err)
+		}
+		written = append(written, livePath)
+	}
+	return written, nil
+}
+
+// nextCertbotVersion returns one more than the highest version in a certbot archive directory
+func nextCertbotVersion(archiveDir string) (int, error) {
+	entries, err := os.ReadDir(archiveDir)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read archive directory %s: %v", archiveDir, err)
+	}
+	latest := 0
+	for _, entry := range entries {
+		if match := certbotArchiveVersion.FindStringSubmatch(entry.Name()); match != nil {
+			if n, err := strconv.Atoi(match[1]); err == nil && n > latest {
+				latest = n
+			}
+		}
+	}
+	return latest + 1, nil
+}
+
+// replaceSymlink points path at target by renaming a new link over it, so a reader always
+// finds either the old or the new file
+func replaceSymlink(target, path string) error {
+	tmp := path + ".tmp"
+	os.Remove(tmp)
+	if err := os.Symlink(target, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// exportOutputDir copies the generated certificate to -output-dir in the configured layout.
+// Like the PFX export, a failure is only logged so it never blocks the install.
+func exportOutputDir(config Config, certPath, keyPath string) {
+	if config.OutputDir == "" {
+		return
+	}
+
+	files, err := readOutputFiles(certPath, keyPath)
+	var written []string
+	if err == nil {
+		if config.OutputLayout == outputLayoutCertbot {
+			written, err = writeCertbotOutput(config.OutputDir, config.Hostname, files)
+		} else {
+			written, err = writeFlatOutput(config.OutputDir, config.Hostname, files)
+		}
+	}
+	if err != nil {
+		logWarn("Output directory export failed: %v", err)
+		return
+	}
+	for _, path := range written {
+		logInfo("Certificate material written: %s", path)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+)
+
+// writeTestBundle writes a leaf + intermediate bundle and its key, as the cache holds them
+func writeTestBundle(t *testing.T, dir string) (string, string, *testIssuer, *testIssuer) {
+	t.Helper()
+	now := time.Now()
+	root := issueTestCertificate(t, "Test Root", true, now.Add(10*365*24*time.Hour), nil)
+	intermediate := issueTestCertificate(t, "Test Intermediate", true, now.Add(365*24*time.Hour), root)
+	leaf := issueTestCertificate(t, "esxi01.lab.example.com", false, now.Add(90*24*time.Hour), intermediate)
+
+	var bundle []byte
+	for _, cert := range []*x509.Certificate{leaf.cert, intermediate.cert} {
+		bundle = append(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+	}
+	certPath := filepath.Join(dir, "cert.pem")
+	keyPath := filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certPath, bundle, 0600); err != nil {
+		t.Fatalf("Failed to write certificate: %v", err)
+	}
+	if err := os.WriteFile(keyPath, certcrypto.PEMEncode(leaf.key), 0600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+	return certPath, keyPath, leaf, intermediate
+}
+
+func TestReadOutputFiles(t *testing.T) {
+	certPath, keyPath, leaf, intermediate := writeTestBundle(t, t.TempDir())
+
+	files, err := readOutputFiles(certPath, keyPath)
+	if err != nil {
+		t.Fatalf("readOutputFiles() error = %v", err)
+	}
+	leafPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leaf.cert.Raw})
+	chainPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: intermediate.cert.Raw})
+	if !bytes.Equal(files.cert, leafPEM) || !bytes.Equal(files.chain, chainPEM) || !bytes.Equal(files.fullchain, append(leafPEM, chainPEM...)) {
+		t.Error("Expected the bundle split into leaf, chain and full chain")
+	}
+	if len(files.privkey) == 0 {
+		t.Error("Expected the private key")
+	}
+}
+
+func TestExportOutputDir(t *testing.T) {
+	certPath, keyPath, leaf, _ := writeTestBundle(t, t.TempDir())
+
+	t.Run("flat", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "out")
+		exportOutputDir(Config{Hostname: "ESXi01.lab.example.com", OutputDir: dir, OutputLayout: outputLayoutFlat}, certPath, keyPath)
+
+		certs, err := readCertificateBundle(filepath.Join(dir, "esxi01.lab.example.com-cert.pem"))
+		if err != nil || len(certs) != 2 || !certs[0].Equal(leaf.cert) {
+			t.Fatalf("Expected the full chain in the flat output, got %d certificates (%v)", len(certs), err)
+		}
+		info, err := os.Stat(filepath.Join(dir, "esxi01.lab.example.com-key.pem"))
+		if err != nil {
+			t.Fatalf("Expected the key in the flat output: %v", err)
+		}
+		if runtime.GOOS != "windows" && info.Mode().Perm() != 0600 {
+			t.Errorf("Expected the key to be owner-only, got %v", info.Mode().Perm())
+		}
+	})
+
+	t.Run("certbot", func(t *testing.T) {
+		dir := t.TempDir()
+		config := Config{Hostname: "esxi01.lab.example.com:443", OutputDir: dir, OutputLayout: outputLayoutCertbot}
+		exportOutputDir(config, certPath, keyPath)
+		exportOutputDir(config, certPath, keyPath)
+
+		live := filepath.Join(dir, "live", "esxi01.lab.example.com")
+		for _, name := range []string{"cert.pem", "chain.pem", "fullchain.pem", "privkey.pem"} {
+			if _, err := os.Stat(filepath.Join(live, name)); err != nil {
+				t.Errorf("Expected live/%s: %v", name, err)
+			}
+		}
+		cert, err := readCertificateFile(filepath.Join(live, "cert.pem"))
+		if err != nil || !cert.Equal(leaf.cert) {
+			t.Errorf("Expected live/cert.pem to hold the leaf (%v)", err)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "archive", "esxi01.lab.example.com", "fullchain2.pem")); err != nil {
+			t.Errorf("Expected a second archive version after a second export: %v", err)
+		}
+
+		if runtime.GOOS == "windows" {
+			return
+		}
+		target, err := os.Readlink(filepath.Join(live, "fullchain.pem"))
+		if err != nil {
+			t.Fatalf("Expected live/fullchain.pem to be a symlink: %v", err)
+		}
+		if want := filepath.Join("..", "..", "archive", "esxi01.lab.example.com", "fullchain2.pem"); target != want {
+			t.Errorf("Expected live/fullchain.pem -> %s, got %s", want, target)
+		}
+	})
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// readCertificateBundle reads every certificate in a PEM file, leaf first
+func readCertificateBundle(path string) ([]*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read certificate file: %v", err)
+	}
+
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse certificate in %s: %v", path, err)
+		}
+		certs = append(certs, cert)
+	}
+
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+	return certs, nil
+}
+
+// writePFXFile bundles the leaf, chain and private key into a PKCS#12 file for consumers
+// such as Windows agents that expect a .pfx. The file is only readable by the owner.
+func writePFXFile(certPath, keyPath, pfxPath, password string) error {
+	certs, err := readCertificateBundle(certPath)
+	if err != nil {
+		return err
+	}
+
+	keyData, err := os.ReadFile(keyPath)
+	if err != nil {
+		return fmt.Errorf("failed to read key file: %v", err)
+	}
+	key, err := certcrypto.ParsePEMPrivateKey(keyData)
+	if err != nil {
+		return fmt.Errorf("failed to parse key file %s: %v", keyPath, err)
+	}
+
+	pfxData, err := pkcs12.Modern.Encode(key, certs[0], certs[1:], password)
+	if err != nil {
+		return fmt.Errorf("failed to encode PKCS#12 bundle: %v", err)
+	}
+
+	if err := os.WriteFile(pfxPath, pfxData, 0600); err != nil {
+		return fmt.Errorf("failed to write PFX file: %v", err)
+	}
+	return nil
+}
+
+// exportPFX writes the PFX output when configured. Failures are logged rather than returned
+// so the export never blocks installing the certificate on the host.
+func exportPFX(config Config, certPath, keyPath string) {
+	if config.PFXOutput == "" {
+		return
+	}
+
+	if config.PFXPassword == "" {
+		logWarn("Writing %s without a password; the private key in it is unprotected", config.PFXOutput)
+	}
+	if err := writePFXFile(certPath, keyPath, config.PFXOutput, config.PFXPassword); err != nil {
+		logWarn("PFX export failed: %v", err)
+		return
+	}
+	logInfo("Certificate exported in PKCS#12 format: %s", config.PFXOutput)
+}
+
+// loadPFXFile decodes a PKCS#12 bundle into its private key, leaf and chain, and checks
+// that the key belongs to the leaf
+func loadPFXFile(path, password string) (crypto.PrivateKey, *x509.Certificate, []*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to read PFX file: %v", err)
+	}
+
+	key, leaf, chain, err := pkcs12.DecodeChain(data, password)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to decode PFX file %s (wrong password?): %v", path, err)
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, nil, nil, fmt.Errorf("PFX file %s does not contain a signing key", path)
+	}
+	pub, ok := signer.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !pub.Equal(leaf.PublicKey) {
+		return nil, nil, nil, fmt.Errorf("private key in PFX file %s does not match its certificate", path)
+	}
+
+	return key, leaf, chain, nil
+}
+
+// importPFXCertificate takes the certificate to install from -pfx-file instead of ACME. The
+// bundle is checked against the hostname and written as PEM files the upload can read,
+// kept apart from the ACME cache entry so a later ACME run never picks them up.
+func importPFXCertificate(config Config, cacheDir string) (string, string, error) {
+	key, leaf, chain, err := loadPFXFile(config.PFXFile, config.PFXPassword)
+	if err != nil {
+		return "", "", err
+	}
+
+	if err := leaf.VerifyHostname(esxiHostOnly(config.Hostname)); err != nil {
+		return "", "", fmt.Errorf("certificate in PFX file %s does not cover %s: %v", config.PFXFile, config.Hostname, err)
+	}
+	if time.Now().After(leaf.NotAfter) {
+		return "", "", fmt.Errorf("certificate in PFX file %s expired on %s", config.PFXFile, leaf.NotAfter.Format(time.RFC3339))
+	}
+	if err := checkExpectedIssuer(config, leaf); err != nil {
+		return "", "", fmt.Errorf("refusing to install certificate: %v", err)
+	}
+	logInfo("Importing certificate from %s: issued by %q, valid until %s, %d chain certificates",
+		config.PFXFile, leaf.Issuer.CommonName, leaf.NotAfter.Format(time.RFC3339), len(chain))
+
+	var bundle []byte
+	for _, cert := range append([]*x509.Certificate{leaf}, chain...) {
+		bundle = append(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+	}
+
+	if err := ensureCacheDir(cacheDir); err != nil {
+		return "", "", err
+	}
+	certPath, keyPath := cacheFilePaths(cacheDir, config.Hostname+"-pfx")
+	if err := writeFileAtomic(certPath, bundle, 0600); err != nil {
+		return "", "", fmt.Errorf("failed to write cert file: %v", err)
+	}
+	if err := writeFileAtomic(keyPath, certcrypto.PEMEncode(key), 0600); err != nil {
+		return "", "", fmt.Errorf("failed to write key file: %v", err)
+	}
+	return certPath, keyPath, nil
+}
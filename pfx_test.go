@@ -0,0 +1,121 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+func TestWritePFXFile(t *testing.T) {
+	now := time.Now()
+	root := issueTestCertificate(t, "Test Root", true, now.Add(10*365*24*time.Hour), nil)
+	intermediate := issueTestCertificate(t, "Test Intermediate", true, now.Add(365*24*time.Hour), root)
+	leaf := issueTestCertificate(t, "esxi01.lab.example.com", false, now.Add(90*24*time.Hour), intermediate)
+
+	dir := t.TempDir()
+	certPath := filepath.Join(dir, "cert.pem")
+	keyPath := filepath.Join(dir, "key.pem")
+	pfxPath := filepath.Join(dir, "cert.pfx")
+
+	var bundle []byte
+	for _, cert := range []*x509.Certificate{leaf.cert, intermediate.cert} {
+		bundle = append(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+	}
+	if err := os.WriteFile(certPath, bundle, 0600); err != nil {
+		t.Fatalf("Failed to write certificate: %v", err)
+	}
+	if err := os.WriteFile(keyPath, certcrypto.PEMEncode(leaf.key), 0600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+
+	if err := writePFXFile(certPath, keyPath, pfxPath, "s3cret"); err != nil {
+		t.Fatalf("writePFXFile() error = %v", err)
+	}
+
+	info, err := os.Stat(pfxPath)
+	if err != nil {
+		t.Fatalf("PFX file not written: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("Expected PFX permissions 0600, got %o", perm)
+	}
+
+	data, _ := os.ReadFile(pfxPath)
+	key, cert, caCerts, err := pkcs12.DecodeChain(data, "s3cret")
+	if err != nil {
+		t.Fatalf("Failed to decode PFX: %v", err)
+	}
+	if !cert.Equal(leaf.cert) {
+		t.Error("Expected the leaf certificate in the PFX")
+	}
+	if len(caCerts) != 1 || !caCerts[0].Equal(intermediate.cert) {
+		t.Errorf("Expected the intermediate in the PFX chain, got %d certificates", len(caCerts))
+	}
+	if key == nil {
+		t.Error("Expected the private key in the PFX")
+	}
+
+	if _, _, _, err := pkcs12.DecodeChain(data, "wrong"); err == nil {
+		t.Error("Expected decoding with the wrong password to fail")
+	}
+
+	if err := writePFXFile(filepath.Join(dir, "missing.pem"), keyPath, pfxPath, ""); err == nil {
+		t.Error("Expected an error for a missing certificate file")
+	}
+}
+
+func TestImportPFXCertificate(t *testing.T) {
+	now := time.Now()
+	root := issueTestCertificate(t, "Corp Root CA", true, now.Add(10*365*24*time.Hour), nil)
+	leaf := issueTestCertificate(t, "esxi01.lab.example.com", false, now.Add(365*24*time.Hour), root)
+	other := issueTestCertificate(t, "esxi02.lab.example.com", false, now.Add(365*24*time.Hour), root)
+
+	dir := t.TempDir()
+	writePFX := func(name string, key interface{}, cert *x509.Certificate) string {
+		data, err := pkcs12.Modern.Encode(key, cert, []*x509.Certificate{root.cert}, "s3cret")
+		if err != nil {
+			t.Fatalf("Failed to encode PFX: %v", err)
+		}
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, data, 0600)
+		return path
+	}
+	pfxPath := writePFX("esxi01.pfx", leaf.key, leaf.cert)
+
+	cacheDir := filepath.Join(dir, "cache")
+	config := Config{Hostname: "esxi01.lab.example.com", PFXFile: pfxPath, PFXPassword: "s3cret"}
+	certPath, keyPath, err := importPFXCertificate(config, cacheDir)
+	if err != nil {
+		t.Fatalf("importPFXCertificate() error = %v", err)
+	}
+	if filepath.Base(certPath) != "esxi01.lab.example.com-pfx-cert.pem" {
+		t.Errorf("Expected the import kept apart from the ACME cache entry, got %s", certPath)
+	}
+
+	certs, err := readCertificateBundle(certPath)
+	if err != nil || len(certs) != 2 || !certs[0].Equal(leaf.cert) || !certs[1].Equal(root.cert) {
+		t.Errorf("Expected leaf then chain in %s, got %d certificates (%v)", certPath, len(certs), err)
+	}
+	keyData, _ := os.ReadFile(keyPath)
+	if key, err := certcrypto.ParsePEMPrivateKey(keyData); err != nil || !leaf.key.Equal(key) {
+		t.Errorf("Expected the PFX private key in %s (%v)", keyPath, err)
+	}
+
+	// Hostname coverage, password and key match are all checked
+	if _, _, err := importPFXCertificate(Config{Hostname: "esxi09.lab.example.com", PFXFile: pfxPath, PFXPassword: "s3cret"}, cacheDir); err == nil {
+		t.Error("Expected a certificate for another host to be rejected")
+	}
+	if _, _, _, err := loadPFXFile(pfxPath, "wrong"); err == nil {
+		t.Error("Expected a wrong password to be rejected")
+	}
+	mismatched := writePFX("mismatched.pfx", other.key, leaf.cert)
+	if _, _, _, err := loadPFXFile(mismatched, "s3cret"); err == nil {
+		t.Error("Expected a key that does not match the certificate to be rejected")
+	}
+}
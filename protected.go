@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// checkProtectedHost refuses a run that could modify a host whose name matches
+// -protected-pattern unless -i-know-what-im-doing was given. Dry runs and test issuance
+// never touch the host, so they are always allowed.
+func checkProtectedHost(config Config) error {
+	if config.ProtectedPattern == "" {
+		return nil
+	}
+	pattern, err := regexp.Compile(config.ProtectedPattern)
+	if err != nil {
+		return fmt.Errorf("invalid protected pattern %q: %v", config.ProtectedPattern, err)
+	}
+
+	host := esxiHostOnly(config.Hostname)
+	switch {
+	case !pattern.MatchString(host):
+		logDebug("Host %s does not match protected pattern %q", host, config.ProtectedPattern)
+		return nil
+	case config.DryRun || config.TestIssuance:
+		logInfo("Host %s matches protected pattern %q; allowed because this run does not modify it", host, config.ProtectedPattern)
+		return nil
+	case config.ConfirmProtected:
+		logWarn("Host %s matches protected pattern %q; proceeding because --i-know-what-im-doing is set", host, config.ProtectedPattern)
+		return nil
+	}
+
+	logError("Host %s matches protected pattern %q; refusing to modify it", host, config.ProtectedPattern)
+	return fmt.Errorf("%s is a protected host (matches --protected-pattern %q); rerun with --i-know-what-im-doing to modify it", host, config.ProtectedPattern)
+}
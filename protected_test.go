@@ -0,0 +1,55 @@
+package main
+
+import (
+	"crypto/x509"
+	"strings"
+	"testing"
+)
+
+func TestCheckProtectedHost(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    Config
+		errorPart string
+	}{
+		{"no pattern", Config{Hostname: "esxi-prod01.example.com"}, ""},
+		{"unprotected host", Config{Hostname: "esxi-lab01.example.com", ProtectedPattern: "prod"}, ""},
+		{"protected host", Config{Hostname: "esxi-prod01.example.com:8443", ProtectedPattern: `prod\d+\.`}, "protected host"},
+		{"protected host confirmed", Config{Hostname: "esxi-prod01.example.com", ProtectedPattern: "prod", ConfirmProtected: true}, ""},
+		{"protected host in a dry run", Config{Hostname: "esxi-prod01.example.com", ProtectedPattern: "prod", DryRun: true}, ""},
+		{"invalid pattern", Config{Hostname: "esxi-prod01.example.com", ProtectedPattern: "("}, "invalid protected pattern"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkProtectedHost(tt.config)
+			if tt.errorPart == "" && err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+			if tt.errorPart != "" && (err == nil || !strings.Contains(err.Error(), tt.errorPart)) {
+				t.Errorf("Expected an error containing %q, got %v", tt.errorPart, err)
+			}
+		})
+	}
+}
+
+func TestRunWorkflow_ProtectedHost(t *testing.T) {
+	config := Config{Hostname: "esxi-prod01.example.com", ProtectedPattern: "prod", Force: true, ChallengeType: challengeTypeHTTP01}
+	generated := false
+	deps := Dependencies{
+		CertChecker: func(string, float64) (bool, *x509.Certificate, error) {
+			return true, &x509.Certificate{}, nil
+		},
+		CertGenerator: func(Config) (string, string, error) {
+			generated = true
+			return "", "", nil
+		},
+	}
+
+	if _, err := runWorkflow(config, deps); err == nil || !strings.Contains(err.Error(), "--i-know-what-im-doing") {
+		t.Fatalf("Expected the protected host to be refused, got %v", err)
+	}
+	if generated {
+		t.Error("Did not expect a certificate to be generated for a refused host")
+	}
+}
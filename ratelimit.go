@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-acme/lego/v4/acme"
+	"golang.org/x/net/publicsuffix"
+)
+
+// ACME problem type returned when the CA refuses a request under its rate limits
+const acmeRateLimitedProblem = "urn:ietf:params:acme:error:rateLimited"
+
+// Let's Encrypt states when the limit lifts in the problem detail, e.g. "retry after
+// 2026-01-02 03:04:05 UTC"; lego does not expose the Retry-After header of error responses
+var rateLimitRetryAfterPattern = regexp.MustCompile(`retry after (\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}) UTC`)
+
+// RateLimitError reports that the CA refused issuance for a host under its rate limits.
+// RetryAfter is zero when the CA did not say when issuance is allowed again.
+type RateLimitError struct {
+	Hostname   string
+	RetryAfter time.Time
+	Detail     string
+}
+
+func (e *RateLimitError) Error() string {
+	if e.RetryAfter.IsZero() {
+		return fmt.Sprintf("CA rate limit reached for %s; retrying now would only extend it: %s", e.Hostname, e.Detail)
+	}
+	return fmt.Sprintf("CA rate limit reached for %s; issuance is allowed again after %s (in %s): %s",
+		e.Hostname, e.RetryAfter.Format(time.RFC3339), time.Until(e.RetryAfter).Round(time.Minute), e.Detail)
+}
+
+// rateLimitScopes are the keys rate-limit lockouts are recorded under for a host: the host
+// itself, its registered domain and its ACME account
+type rateLimitScopes struct {
+	host, domain, account string
+}
+
+// rateLimitScopesFor returns the lockout scopes of the configured host. The registered
+// domain comes from the public suffix list, so esxi01.lab.example.co.uk maps to example.co.uk.
+func rateLimitScopesFor(config Config) rateLimitScopes {
+	scopes := rateLimitScopes{host: config.Hostname}
+	if domain, err := publicsuffix.EffectiveTLDPlusOne(normalizeDomain(esxiHostOnly(config.Hostname))); err == nil {
+		scopes.domain = "domain:" + domain
+	}
+	if config.Email != "" {
+		scopes.account = "account:" + strings.ToLower(config.Email)
+	}
+	return scopes
+}
+
+// all returns every scope that can hold a lockout affecting the host
+func (s rateLimitScopes) all() []string {
+	var keys []string
+	for _, key := range []string{s.host, s.domain, s.account} {
+		if key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// forDetail picks the scope a refusal applies to from its problem detail. Let's Encrypt
+// counts new orders per account, and duplicate certificates and failed validations per
+// host. Certificates per registered domain, and any limit not recognized, lock the whole
+// registered domain so sibling hosts do not keep extending it.
+func (s rateLimitScopes) forDetail(detail string) string {
+	detail = strings.ToLower(detail)
+	switch {
+	case strings.Contains(detail, "exact set") || strings.Contains(detail, "failed authorization"):
+		return s.host
+	case strings.Contains(detail, "new orders") && s.account != "":
+		return s.account
+	case s.domain != "":
+		return s.domain
+	default:
+		return s.host
+	}
+}
+
+// asRateLimitError converts an ACME rate-limit problem into a *RateLimitError, returning nil
+// for any other error
+func asRateLimitError(hostname string, err error) *RateLimitError {
+	var problem *acme.ProblemDetails
+	if !errors.As(err, &problem) {
+		return nil
+	}
+	if problem.Type != acmeRateLimitedProblem && problem.HTTPStatus != http.StatusTooManyRequests {
+		return nil
+	}
+	return &RateLimitError{Hostname: hostname, RetryAfter: parseRateLimitRetryAfter(problem.Detail), Detail: problem.Detail}
+}
+
+// parseRateLimitRetryAfter extracts when a rate limit lifts from the problem detail
+func parseRateLimitRetryAfter(detail string) time.Time {
+	match := rateLimitRetryAfterPattern.FindStringSubmatch(detail)
+	if match == nil {
+		return time.Time{}
+	}
+	retryAfter, err := time.ParseInLocation("2006-01-02 15:04:05", match[1], time.UTC)
+	if err != nil {
+		return time.Time{}
+	}
+	return retryAfter
+}
+
+// acmeRequestError describes a failed ACME request, keeping a rate limit recognizable to
+// the workflow and the schedule
+func acmeRequestError(hostname, action string, err error) error {
+	if rateErr := asRateLimitError(hostname, err); rateErr != nil {
+		return rateErr
+	}
+	return fmt.Errorf("failed to %s: %v", action, err)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-acme/lego/v4/acme"
+)
+
+func TestAsRateLimitError(t *testing.T) {
+	detail := "too many certificates (5) already issued for this exact set of identifiers in the last 168h0m0s, retry after 2026-03-08 22:17:01 UTC: see https://letsencrypt.org/docs/rate-limits/"
+	problem := &acme.ProblemDetails{Type: acmeRateLimitedProblem, HTTPStatus: 429, Detail: detail}
+
+	rateErr := asRateLimitError("esxi01", fmt.Errorf("order: %w", problem))
+	if rateErr == nil {
+		t.Fatal("Expected a wrapped rate-limit problem to be recognized")
+	}
+	if !rateErr.RetryAfter.Equal(time.Date(2026, 3, 8, 22, 17, 1, 0, time.UTC)) {
+		t.Errorf("Unexpected retry time %s", rateErr.RetryAfter)
+	}
+	if !strings.Contains(rateErr.Error(), "issuance is allowed again after 2026-03-08T22:17:01Z") {
+		t.Errorf("Expected the error to say when issuance is allowed again, got %v", rateErr)
+	}
+
+	// A 429 without the problem type, and without a retry time
+	rateErr = asRateLimitError("esxi01", &acme.ProblemDetails{HTTPStatus: 429, Detail: "slow down"})
+	if rateErr == nil || !rateErr.RetryAfter.IsZero() || !strings.Contains(rateErr.Error(), "retrying now would only extend it") {
+		t.Errorf("Expected a rate limit without a retry time, got %v", rateErr)
+	}
+
+	if asRateLimitError("esxi01", &acme.ProblemDetails{Type: "urn:ietf:params:acme:error:unauthorized", HTTPStatus: 403}) != nil {
+		t.Error("Expected other ACME problems not to be treated as rate limits")
+	}
+	if asRateLimitError("esxi01", errors.New("connection refused")) != nil {
+		t.Error("Expected non-ACME errors not to be treated as rate limits")
+	}
+}
+
+func TestRateLimitScopes(t *testing.T) {
+	scopes := rateLimitScopesFor(Config{Hostname: "ESXi01.lab.example.co.uk:443", Email: "Ops@Example.com"})
+	if scopes.domain != "domain:example.co.uk" || scopes.account != "account:ops@example.com" {
+		t.Errorf("Unexpected scopes %+v", scopes)
+	}
+	if all := scopes.all(); len(all) != 3 {
+		t.Errorf("Expected host, domain and account scopes, got %v", all)
+	}
+
+	tests := map[string]string{
+		"too many certificates (50) already issued for \"example.co.uk\" in the last 168h0m0s":            scopes.domain,
+		"too many new orders (300) recently":                                                              scopes.account,
+		"too many certificates (5) already issued for this exact set of identifiers in the last 168h0m0s": scopes.host,
+		"too many failed authorizations (5) for \"esxi01.lab.example.co.uk\" in the last 1h0m0s":          scopes.host,
+		"slow down": scopes.domain,
+	}
+	for detail, expected := range tests {
+		if got := scopes.forDetail(detail); got != expected {
+			t.Errorf("forDetail(%q) = %q, expected %q", detail, got, expected)
+		}
+	}
+
+	// Without an email there is no account scope to lock
+	if got := rateLimitScopesFor(Config{Hostname: "esxi01.example.com"}).forDetail("too many new orders (300) recently"); got != "domain:example.com" {
+		t.Errorf("Expected the registered domain without an account, got %q", got)
+	}
+}
+
+func TestACMERequestError(t *testing.T) {
+	var rateErr *RateLimitError
+	err := acmeRequestError("esxi01", "obtain certificate", &acme.ProblemDetails{Type: acmeRateLimitedProblem, HTTPStatus: 429})
+	if !errors.As(fmt.Errorf("failed to generate certificate: %w", err), &rateErr) {
+		t.Errorf("Expected the rate limit to survive wrapping, got %v", err)
+	}
+
+	err = acmeRequestError("esxi01", "obtain certificate", errors.New("connection refused"))
+	if err.Error() != "failed to obtain certificate: connection refused" {
+		t.Errorf("Unexpected error %v", err)
+	}
+}
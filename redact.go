@@ -0,0 +1,76 @@
+package main
+
+import (
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Replacement for a secret value found in a log message
+const redactedValue = "[REDACTED]"
+
+// Secrets shorter than this are not redacted, as replacing them would mangle ordinary words
+const minRedactedSecretLength = 4
+
+// secretRedactor scrubs registered secret values from log messages
+type secretRedactor struct {
+	mu      sync.RWMutex
+	secrets []string
+}
+
+// Process-wide redactor applied by the log functions
+var logRedactor = &secretRedactor{}
+
+// register adds secret values to scrub, longest first so a secret containing another is
+// replaced whole
+func (r *secretRedactor) register(values ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, value := range values {
+		if len(value) < minRedactedSecretLength || slices.Contains(r.secrets, value) {
+			continue
+		}
+		r.secrets = append(r.secrets, value)
+	}
+	sort.SliceStable(r.secrets, func(i, j int) bool { return len(r.secrets[i]) > len(r.secrets[j]) })
+}
+
+// unregister stops scrubbing values that are no longer in use, such as the temporary
+// credentials of a finished run
+func (r *secretRedactor) unregister(values ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.secrets = slices.DeleteFunc(r.secrets, func(secret string) bool {
+		return slices.Contains(values, secret)
+	})
+}
+
+// redact replaces every registered secret in the message
+func (r *secretRedactor) redact(message string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, secret := range r.secrets {
+		message = strings.ReplaceAll(message, secret, redactedValue)
+	}
+	return message
+}
+
+// registerConfigSecrets registers every credential in the configuration, including
+// per-host overrides, so no log line can carry them
+func registerConfigSecrets(config Config) {
+	logRedactor.register(
+		config.ESXiPassword,
+		config.ESXiTOTPSecret,
+		config.Route53SecretKey,
+		config.Route53SessionToken,
+		config.SMTPPassword,
+		config.PFXPassword,
+	)
+	for _, host := range config.Hosts {
+		logRedactor.register(host.ESXiPassword, host.ESXiTOTPSecret)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestSecretRedactor(t *testing.T) {
+	r := &secretRedactor{}
+	r.register("hunter2pass", "hunter2", "", "abc", "hunter2")
+
+	tests := []struct {
+		message  string
+		expected string
+	}{
+		{"password is hunter2pass", "password is [REDACTED]"},
+		{"token hunter2 and hunter2", "token [REDACTED] and [REDACTED]"},
+		{"abc is too short to redact", "abc is too short to redact"},
+		{"nothing secret here", "nothing secret here"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.message, func(t *testing.T) {
+			if got := r.redact(tt.message); got != tt.expected {
+				t.Errorf("redact(%q) = %q, expected %q", tt.message, got, tt.expected)
+			}
+		})
+	}
+
+	if len(r.secrets) != 2 {
+		t.Errorf("Expected duplicates and short values to be skipped, got %v", r.secrets)
+	}
+
+	r.unregister("hunter2pass")
+	if got := r.redact("hunter2pass"); got != "[REDACTED]pass" {
+		t.Errorf("Expected only the remaining secret to be redacted after unregister, got %q", got)
+	}
+}
+
+func TestLogFunctionsRedactConfigSecrets(t *testing.T) {
+	originalRedactor := logRedactor
+	logRedactor = &secretRedactor{}
+	defer func() { logRedactor = originalRedactor }()
+
+	originalLevel := currentLogLevel
+	currentLogLevel = LOG_DEBUG
+	defer func() { currentLogLevel = originalLevel }()
+
+	var buf bytes.Buffer
+	originalOutput := log.Writer()
+	log.SetOutput(&buf)
+	defer log.SetOutput(originalOutput)
+
+	registerConfigSecrets(Config{
+		ESXiPassword:     "esxi-secret-pass",
+		Route53SecretKey: "aws-secret-key",
+		Hosts:            []HostConfig{{Hostname: "esxi02", ESXiPassword: "host-secret-pass"}},
+	})
+
+	logDebug("SSH password: %s", "esxi-secret-pass")
+	logInfo("Using key %s", "aws-secret-key")
+	logWarn("Host password %s rejected", "host-secret-pass")
+	logError("Login failed for root:%s", "esxi-secret-pass")
+
+	output := buf.String()
+	for _, secret := range []string{"esxi-secret-pass", "aws-secret-key", "host-secret-pass"} {
+		if strings.Contains(output, secret) {
+			t.Errorf("Expected %q to be redacted, got:\n%s", secret, output)
+		}
+	}
+	if strings.Count(output, redactedValue) != 4 {
+		t.Errorf("Expected 4 redactions, got:\n%s", output)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// How the built-in ESXi sequence makes the host pick up the new certificate
+const (
+	reloadMethodRestart = "restart"
+	reloadMethodReload  = "reload"
+)
+
+// After a soft reload, how long to wait for the host to serve the new certificate before
+// falling back to a full restart, and how often to check
+var (
+	reloadSettleTime     = 30 * time.Second
+	reloadSettleInterval = 3 * time.Second
+)
+
+// reloadCommandsForVersion returns the lighter-weight reload for each service that serves
+// the certificate: the init script's refresh where it has one, otherwise a SIGHUP. vpxa is
+// left alone as it doesn't serve the certificate.
+func reloadCommandsForVersion(esxiVersion string) []string {
+	services := []string{"hostd"}
+	if esxiMajorVersion(esxiVersion) >= 8 {
+		services = []string{"rhttpproxy", "hostd"}
+	}
+
+	var commands []string
+	for _, service := range services {
+		commands = append(commands, fmt.Sprintf("/etc/init.d/%[1]s refresh || kill -HUP $(pidof %[1]s)", service))
+	}
+	return commands
+}
+
+// reloadESXiServicesViaSSH tries the soft reload and, when servesNewCert does not confirm
+// the host picked up the certificate within reloadSettleTime, falls back to the full restart
+func reloadESXiServicesViaSSH(client *ssh.Client, esxiVersion string, servesNewCert func() bool) error {
+	logInfo("Reloading ESXi services (reload method %s)...", reloadMethodReload)
+
+	reloaded := true
+	for _, cmd := range reloadCommandsForVersion(esxiVersion) {
+		if err := runSSHCommand(client, cmd); err != nil {
+			logWarn("Reload command '%s' failed: %v", cmd, err)
+			reloaded = false
+			break
+		}
+	}
+
+	if reloaded {
+		deadline := time.Now().Add(reloadSettleTime)
+		for {
+			if servesNewCert() {
+				logInfo("Reload was sufficient: the host serves the new certificate without a restart")
+				return nil
+			}
+			if !time.Now().Add(reloadSettleInterval).Before(deadline) {
+				break
+			}
+			time.Sleep(reloadSettleInterval)
+		}
+		logWarn("Host still not serving the new certificate %s after the reload", reloadSettleTime)
+	}
+
+	logWarn("Reload was not sufficient; falling back to reload method %s", reloadMethodRestart)
+	return restartESXiServicesViaSSH(client, esxiVersion)
+}
+
+// servesCertificateCheck returns a check of whether the host's HTTPS endpoint presents the
+// leaf certificate of certData, for deciding whether a reload was enough
+func servesCertificateCheck(config Config, certData []byte, dialer TLSDialer) func() bool {
+	block, _ := pem.Decode(certData)
+	if block == nil {
+		return func() bool { return false }
+	}
+	want, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return func() bool { return false }
+	}
+
+	address := esxiHTTPSAddress(config)
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, "443")
+	}
+	host := esxiHostOnly(config.Hostname)
+
+	return func() bool {
+		conn, err := dialer.Dial(dialNetwork, address, hostTLSConfig(host))
+		if err != nil {
+			logDebug("Reload check could not connect to %s: %v", address, err)
+			return false
+		}
+		defer conn.Close()
+		certs := conn.ConnectionState().PeerCertificates
+		return len(certs) > 0 && sameCertificate(certs[0], want)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestReloadCommandsForVersion(t *testing.T) {
+	if commands := reloadCommandsForVersion("7.0.3"); !reflect.DeepEqual(commands, []string{"/etc/init.d/hostd refresh || kill -HUP $(pidof hostd)"}) {
+		t.Errorf("Unexpected ESXi 7 reload: %v", commands)
+	}
+	commands := reloadCommandsForVersion("8.0.2")
+	if len(commands) != 2 || !strings.Contains(commands[0], "rhttpproxy") || !strings.Contains(commands[1], "hostd") {
+		t.Errorf("Expected rhttpproxy reloaded before hostd on ESXi 8, got %v", commands)
+	}
+
+	for _, cmd := range sshInstallCommands(Config{ReloadMethod: reloadMethodReload}, "7.0.3") {
+		if strings.Contains(cmd, "restart") {
+			t.Errorf("Did not expect a restart in the reload plan, got %s", cmd)
+		}
+	}
+}
+
+func TestReloadESXiServicesViaSSH(t *testing.T) {
+	oldTime, oldInterval := reloadSettleTime, reloadSettleInterval
+	reloadSettleTime, reloadSettleInterval = 50*time.Millisecond, 10*time.Millisecond
+	defer func() { reloadSettleTime, reloadSettleInterval = oldTime, oldInterval }()
+
+	connect := func(t *testing.T) (*ssh.Client, func() []string) {
+		port, commands := startTestSSHServer(t)
+		config := Config{Hostname: "127.0.0.1", ESXiSSHPort: port, ESXiUsername: "root", ESXiPassword: "password"}
+		client, err := dialSSH(esxiSSHAddress(config), newSSHClientConfig(config, nil))
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		t.Cleanup(func() { client.Close() })
+		return client, commands
+	}
+
+	t.Run("reload sufficient", func(t *testing.T) {
+		client, commands := connect(t)
+		if err := reloadESXiServicesViaSSH(client, "7.0.3", func() bool { return true }); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if executed := commands(); !reflect.DeepEqual(executed, reloadCommandsForVersion("7.0.3")) {
+			t.Errorf("Expected only the reload, got %v", executed)
+		}
+	})
+
+	t.Run("falls back to restart", func(t *testing.T) {
+		client, commands := connect(t)
+		if err := reloadESXiServicesViaSSH(client, "7.0.3", func() bool { return false }); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		expected := append(reloadCommandsForVersion("7.0.3"), restartCommandsForVersion("7.0.3")...)
+		if executed := commands(); !reflect.DeepEqual(executed, expected) {
+			t.Errorf("Expected the reload then the full restart, got %v", executed)
+		}
+	})
+}
+
+func TestServesCertificateCheck(t *testing.T) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+	config := Config{Hostname: server.Listener.Addr().String()}
+
+	served := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if !servesCertificateCheck(config, served, &DefaultTLSDialer{})() {
+		t.Error("Expected the served certificate to be recognised")
+	}
+
+	other := issueTestCertificate(t, "esxi01.lab.example.com", false, time.Now().Add(24*time.Hour), nil)
+	if servesCertificateCheck(config, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: other.cert.Raw}), &DefaultTLSDialer{})() {
+		t.Error("Did not expect a different certificate to match")
+	}
+}
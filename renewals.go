@@ -0,0 +1,287 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// RenewalHistory records when each host was last renewed so that a misbehaving validation
+// step combined with -force and cron cannot reissue certificates in a loop and exhaust
+// the CA's rate limits
+type RenewalHistory struct {
+	Path        string
+	LockTimeout time.Duration
+	now         func() time.Time
+}
+
+// NewRenewalHistory returns a history stored in the state file at path
+func NewRenewalHistory(path string, lockTimeout time.Duration) *RenewalHistory {
+	return &RenewalHistory{Path: path, LockTimeout: lockTimeout, now: time.Now}
+}
+
+// defaultRenewalHistoryPath keeps the history next to the certificate cache
+func defaultRenewalHistoryPath() string {
+	return filepath.Join(defaultCacheDir(), "renewal-history.json")
+}
+
+// load reads the per-host renewal times; a missing file is an empty history
+func (h *RenewalHistory) load() (map[string][]time.Time, error) {
+	history := make(map[string][]time.Time)
+	data, err := os.ReadFile(h.Path)
+	if os.IsNotExist(err) {
+		return history, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read renewal history: %v", err)
+	}
+	if err := json.Unmarshal(data, &history); err != nil {
+		return nil, fmt.Errorf("failed to parse renewal history %s: %v", h.Path, err)
+	}
+	return history, nil
+}
+
+// recentRenewals returns the renewals of hostname within window of now
+func recentRenewals(times []time.Time, window time.Duration, now time.Time) []time.Time {
+	var recent []time.Time
+	for _, t := range times {
+		if now.Sub(t) < window {
+			recent = append(recent, t)
+		}
+	}
+	return recent
+}
+
+// RenewalLoopError reports a host that has been renewed too often within the window
+type RenewalLoopError struct {
+	Hostname string
+	Count    int
+	Window   time.Duration
+	Last     time.Time
+}
+
+func (e *RenewalLoopError) Error() string {
+	return fmt.Sprintf("%s was already renewed %d times in the last %s (most recently %s); refusing to reissue. "+
+		"This usually means validation cannot see the new certificate (e.g. a load-balanced pair) while --force or cron keeps renewing. "+
+		"Fix the cause, or raise --max-renewals / shorten --renewal-window",
+		e.Hostname, e.Count, e.Window, e.Last.Format(time.RFC3339))
+}
+
+// Check refuses a renewal with a *RenewalLoopError when the host has already been renewed
+// maxRenewals times within window. Other errors mean the history could not be read.
+func (h *RenewalHistory) Check(hostname string, maxRenewals int, window time.Duration) error {
+	if maxRenewals <= 0 {
+		return nil
+	}
+	if _, err := os.Stat(h.Path); os.IsNotExist(err) {
+		return nil
+	}
+
+	lock, err := lockCacheEntry(h.Path, h.LockTimeout, false)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	history, err := h.load()
+	if err != nil {
+		return err
+	}
+
+	recent := recentRenewals(history[hostname], window, h.now())
+	if len(recent) >= maxRenewals {
+		return &RenewalLoopError{Hostname: hostname, Count: len(recent), Window: window, Last: recent[len(recent)-1]}
+	}
+	return nil
+}
+
+// Record adds a renewal of hostname, dropping entries older than window
+func (h *RenewalHistory) Record(hostname string, window time.Duration) error {
+	if err := ensureCacheDir(filepath.Dir(h.Path)); err != nil {
+		return err
+	}
+
+	lock, err := lockCacheEntry(h.Path, h.LockTimeout, true)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	history, err := h.load()
+	if err != nil {
+		return err
+	}
+
+	now := h.now()
+	history[hostname] = append(recentRenewals(history[hostname], window, now), now)
+
+	data, err := json.MarshalIndent(history, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode renewal history: %v", err)
+	}
+	if err := writeFileAtomic(h.Path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write renewal history: %v", err)
+	}
+	return nil
+}
+
+// issuancePath is the state file recording the last ACME order placed from this machine,
+// kept beside the renewal history
+func (h *RenewalHistory) issuancePath() string {
+	return filepath.Join(filepath.Dir(h.Path), "last-issuance.json")
+}
+
+// issuanceState is the content of the last-issuance state file
+type issuanceState struct {
+	Hostname  string    `json:"hostname"`
+	OrderedAt time.Time `json:"ordered_at"`
+	BatchID   string    `json:"batch_id,omitempty"`
+}
+
+// IssuanceIntervalError reports an ACME order refused because the previous one, for any
+// host, was placed less than -min-issuance-interval ago
+type IssuanceIntervalError struct {
+	Interval     time.Duration
+	Last         issuanceState
+	AllowedAfter time.Time
+}
+
+func (e *IssuanceIntervalError) Error() string {
+	return fmt.Sprintf("the last certificate order (for %s) was placed at %s, less than --min-issuance-interval %s ago; "+
+		"not ordering again before %s", e.Last.Hostname, e.Last.OrderedAt.Format(time.RFC3339), e.Interval, e.AllowedAfter.Format(time.RFC3339))
+}
+
+// ReserveIssuance records an ACME order for hostname, refusing it with an
+// *IssuanceIntervalError when the previous order was placed less than interval ago. The
+// check and the record happen under one lock, so concurrent runs cannot both order. Orders
+// sharing a non-empty batchID (the hosts of one hosts-list run) count once: later hosts in
+// the batch are allowed, and the interval runs from the batch's first order.
+func (h *RenewalHistory) ReserveIssuance(hostname, batchID string, interval time.Duration) error {
+	if interval <= 0 {
+		return nil
+	}
+	if err := ensureCacheDir(filepath.Dir(h.Path)); err != nil {
+		return err
+	}
+
+	lock, err := lockCacheEntry(h.issuancePath(), h.LockTimeout, true)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	var last issuanceState
+	data, err := os.ReadFile(h.issuancePath())
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read issuance state: %v", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &last); err != nil {
+			return fmt.Errorf("failed to parse issuance state %s: %v", h.issuancePath(), err)
+		}
+	}
+
+	if batchID != "" && last.BatchID == batchID {
+		return nil
+	}
+
+	now := h.now()
+	if allowed := last.OrderedAt.Add(interval); !last.OrderedAt.IsZero() && now.Before(allowed) {
+		return &IssuanceIntervalError{Interval: interval, Last: last, AllowedAfter: allowed}
+	}
+
+	data, err = json.MarshalIndent(issuanceState{Hostname: hostname, OrderedAt: now, BatchID: batchID}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode issuance state: %v", err)
+	}
+	if err := writeFileAtomic(h.issuancePath(), data, 0600); err != nil {
+		return fmt.Errorf("failed to write issuance state: %v", err)
+	}
+	return nil
+}
+
+// rateLimitPath is the state file recording CA rate-limit lockouts by scope (host, registered
+// domain or account), kept beside the renewal history
+func (h *RenewalHistory) rateLimitPath() string {
+	return filepath.Join(filepath.Dir(h.Path), "rate-limits.json")
+}
+
+// loadRateLimits reads the lockouts by scope; a missing file means none
+func (h *RenewalHistory) loadRateLimits() (map[string]time.Time, error) {
+	lockouts := make(map[string]time.Time)
+	data, err := os.ReadFile(h.rateLimitPath())
+	if os.IsNotExist(err) {
+		return lockouts, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rate-limit state: %v", err)
+	}
+	if err := json.Unmarshal(data, &lockouts); err != nil {
+		return nil, fmt.Errorf("failed to parse rate-limit state %s: %v", h.rateLimitPath(), err)
+	}
+	return lockouts, nil
+}
+
+// RateLimitedUntil returns when the last recorded rate-limit lockout of any of the scopes
+// lifts, or the zero time when there is none in force
+func (h *RenewalHistory) RateLimitedUntil(scopes ...string) (time.Time, error) {
+	if _, err := os.Stat(h.rateLimitPath()); os.IsNotExist(err) {
+		return time.Time{}, nil
+	}
+
+	lock, err := lockCacheEntry(h.rateLimitPath(), h.LockTimeout, false)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer lock.Unlock()
+
+	lockouts, err := h.loadRateLimits()
+	if err != nil {
+		return time.Time{}, err
+	}
+	var latest time.Time
+	for _, scope := range scopes {
+		if until := lockouts[scope]; until.After(h.now()) && until.After(latest) {
+			latest = until
+		}
+	}
+	return latest, nil
+}
+
+// RecordRateLimit stores a lockout of scope until the given time, dropping lockouts that
+// have already lifted
+func (h *RenewalHistory) RecordRateLimit(scope string, until time.Time) error {
+	if err := ensureCacheDir(filepath.Dir(h.Path)); err != nil {
+		return err
+	}
+
+	lock, err := lockCacheEntry(h.rateLimitPath(), h.LockTimeout, true)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	lockouts, err := h.loadRateLimits()
+	if err != nil {
+		return err
+	}
+
+	now := h.now()
+	for key, lifted := range lockouts {
+		if !lifted.After(now) {
+			delete(lockouts, key)
+		}
+	}
+	lockouts[scope] = until
+
+	data, err := json.MarshalIndent(lockouts, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode rate-limit state: %v", err)
+	}
+	if err := writeFileAtomic(h.rateLimitPath(), data, 0600); err != nil {
+		return fmt.Errorf("failed to write rate-limit state: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"crypto/x509"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRenewalHistory(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	history := NewRenewalHistory(filepath.Join(t.TempDir(), "state", "renewal-history.json"), time.Second)
+	history.now = func() time.Time { return now }
+
+	if err := history.Check("esxi01", 2, time.Hour); err != nil {
+		t.Fatalf("Expected an empty history to allow renewal, got %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := history.Record("esxi01", time.Hour); err != nil {
+			t.Fatalf("Record() error = %v", err)
+		}
+		now = now.Add(10 * time.Minute)
+	}
+
+	var loopErr *RenewalLoopError
+	err := history.Check("esxi01", 2, time.Hour)
+	if !errors.As(err, &loopErr) || loopErr.Count != 2 {
+		t.Fatalf("Expected a renewal loop error after 2 renewals, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "refusing to reissue") {
+		t.Errorf("Expected the error to explain the refusal, got %v", err)
+	}
+
+	if err := history.Check("esxi02", 2, time.Hour); err != nil {
+		t.Errorf("Expected other hosts to be unaffected, got %v", err)
+	}
+	if err := history.Check("esxi01", 0, time.Hour); err != nil {
+		t.Errorf("Expected max renewals of 0 to disable the check, got %v", err)
+	}
+
+	// Renewals age out of the window
+	now = now.Add(time.Hour)
+	if err := history.Check("esxi01", 2, time.Hour); err != nil {
+		t.Errorf("Expected renewals outside the window to be ignored, got %v", err)
+	}
+}
+
+func TestRenewalHistoryRateLimits(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	dir := filepath.Join(t.TempDir(), "state")
+	history := NewRenewalHistory(filepath.Join(dir, "renewal-history.json"), time.Second)
+	history.now = func() time.Time { return now }
+
+	if until, err := history.RateLimitedUntil("esxi01"); err != nil || !until.IsZero() {
+		t.Fatalf("Expected no lockout without a state file, got %s (%v)", until, err)
+	}
+
+	lifts := now.Add(3 * time.Hour)
+	if err := history.RecordRateLimit("esxi01", lifts); err != nil {
+		t.Fatalf("RecordRateLimit() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "rate-limits.json")); err != nil {
+		t.Errorf("Expected the lockout beside the renewal history: %v", err)
+	}
+
+	if until, err := history.RateLimitedUntil("esxi01"); err != nil || !until.Equal(lifts) {
+		t.Errorf("Expected a lockout until %s, got %s (%v)", lifts, until, err)
+	}
+	if until, _ := history.RateLimitedUntil("esxi02"); !until.IsZero() {
+		t.Errorf("Expected other hosts to be unaffected, got %s", until)
+	}
+
+	// A registered-domain lockout covers every host checking that scope
+	domainLifts := now.Add(5 * time.Hour)
+	if err := history.RecordRateLimit("domain:example.com", domainLifts); err != nil {
+		t.Fatalf("RecordRateLimit() error = %v", err)
+	}
+	if until, _ := history.RateLimitedUntil("esxi02", "domain:example.com"); !until.Equal(domainLifts) {
+		t.Errorf("Expected the sibling host to be locked out until %s, got %s", domainLifts, until)
+	}
+	if until, _ := history.RateLimitedUntil("esxi01", "domain:example.com"); !until.Equal(domainLifts) {
+		t.Errorf("Expected the latest of several lockouts, got %s", until)
+	}
+
+	// A lockout that has lifted no longer applies
+	now = lifts.Add(time.Second)
+	if until, _ := history.RateLimitedUntil("esxi01"); !until.IsZero() {
+		t.Errorf("Expected the lockout to have lifted, got %s", until)
+	}
+}
+
+func TestRenewalHistoryReserveIssuance(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	dir := filepath.Join(t.TempDir(), "state")
+	history := NewRenewalHistory(filepath.Join(dir, "renewal-history.json"), time.Second)
+	history.now = func() time.Time { return now }
+
+	if err := history.ReserveIssuance("esxi01", "", 0); err != nil {
+		t.Fatalf("Expected an interval of 0 to disable the check, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "last-issuance.json")); !os.IsNotExist(err) {
+		t.Errorf("Did not expect a state file while the check is disabled: %v", err)
+	}
+
+	if err := history.ReserveIssuance("esxi01", "", time.Hour); err != nil {
+		t.Fatalf("Expected the first order to be allowed, got %v", err)
+	}
+
+	// The interval applies across hosts
+	now = now.Add(20 * time.Minute)
+	var intervalErr *IssuanceIntervalError
+	err := history.ReserveIssuance("esxi02", "", time.Hour)
+	if !errors.As(err, &intervalErr) || intervalErr.Last.Hostname != "esxi01" || !intervalErr.AllowedAfter.Equal(now.Add(40*time.Minute)) {
+		t.Fatalf("Expected the order for another host to be refused, got %v", err)
+	}
+
+	// A refused order does not push the next allowed order back
+	now = now.Add(40 * time.Minute)
+	if err := history.ReserveIssuance("esxi02", "", time.Hour); err != nil {
+		t.Errorf("Expected an order once the interval has passed, got %v", err)
+	}
+
+	// The hosts of one batch count as a single order, timed from the first
+	now = now.Add(2 * time.Hour)
+	if err := history.ReserveIssuance("esxi01", "batch1", time.Hour); err != nil {
+		t.Fatalf("Expected the batch's first order to be allowed, got %v", err)
+	}
+	now = now.Add(10 * time.Minute)
+	if err := history.ReserveIssuance("esxi02", "batch1", time.Hour); err != nil {
+		t.Errorf("Expected a second host in the batch to be allowed, got %v", err)
+	}
+	err = history.ReserveIssuance("esxi03", "batch2", time.Hour)
+	if !errors.As(err, &intervalErr) || !intervalErr.AllowedAfter.Equal(now.Add(50*time.Minute)) {
+		t.Errorf("Expected another batch to be refused until an hour after the first batch order, got %v", err)
+	}
+}
+
+func TestRunWorkflow_RenewalLoop(t *testing.T) {
+	history := NewRenewalHistory(filepath.Join(t.TempDir(), "renewal-history.json"), time.Second)
+	generated := 0
+	mockDeps := Dependencies{
+		AWSValidator: func(Config) error { return nil },
+		CertChecker: func(string, float64) (bool, *x509.Certificate, error) {
+			return true, nil, nil
+		},
+		CertGenerator: func(Config) (string, string, error) {
+			generated++
+			return "cert.pem", "key.pem", nil
+		},
+		CertUploader:  func(Config, string, string) (SSHServiceState, error) { return "", nil },
+		CertValidator: func(string, *x509.Certificate) (bool, error) { return false, nil },
+		Renewals:      history,
+	}
+	config := Config{Hostname: "test.example.com", Domain: "example.com", Threshold: 0.33, Force: true, MaxRenewals: 2, RenewalWindow: time.Hour}
+
+	for i := 0; i < 2; i++ {
+		if _, err := runWorkflow(config, mockDeps); err != nil {
+			t.Fatalf("Run %d: unexpected error: %v", i+1, err)
+		}
+	}
+
+	_, err := runWorkflow(config, mockDeps)
+	if err == nil || !strings.Contains(err.Error(), "renewal refused") {
+		t.Errorf("Expected the third forced renewal to be refused, got %v", err)
+	}
+	if generated != 2 {
+		t.Errorf("Expected 2 certificates to be generated, got %d", generated)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsConfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+	route53types "github.com/aws/aws-sdk-go-v2/service/route53/types"
+)
+
+// Normalize a DNS name for comparison (lowercase, no trailing dot)
+func normalizeDNSName(name string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(name)), ".")
+}
+
+// Report whether the hosted zone is a private (VPC-only) zone
+func isPrivateHostedZone(zone route53types.HostedZone) bool {
+	return zone.Config != nil && zone.Config.PrivateZone
+}
+
+// Find the most specific hosted zone covering the domain (longest suffix match). When a
+// public and a private zone share the same name, the public zone wins because ACME
+// validates the challenge record against public DNS.
+func findHostedZoneForDomain(zones []route53types.HostedZone, domain string) (route53types.HostedZone, bool) {
+	domain = normalizeDNSName(domain)
+
+	var best route53types.HostedZone
+	found := false
+	for _, zone := range zones {
+		zoneName := normalizeDNSName(aws.ToString(zone.Name))
+		if zoneName == "" {
+			continue
+		}
+		if domain != zoneName && !strings.HasSuffix(domain, "."+zoneName) {
+			continue
+		}
+		if !found {
+			best, found = zone, true
+			continue
+		}
+		bestName := normalizeDNSName(aws.ToString(best.Name))
+		if len(zoneName) > len(bestName) || (len(zoneName) == len(bestName) && isPrivateHostedZone(best) && !isPrivateHostedZone(zone)) {
+			best = zone
+		}
+	}
+
+	return best, found
+}
+
+// List all hosted zones accessible with the configured credentials
+func listHostedZones(ctx context.Context, client route53.ListHostedZonesAPIClient) ([]route53types.HostedZone, error) {
+	var zones []route53types.HostedZone
+
+	paginator := route53.NewListHostedZonesPaginator(client, &route53.ListHostedZonesInput{})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list Route53 hosted zones: %v", err)
+		}
+		zones = append(zones, page.HostedZones...)
+	}
+
+	return zones, nil
+}
+
+// Confirm a Route53 hosted zone covering the domain exists before starting an ACME order
+func checkRoute53HostedZone(ctx context.Context, client route53.ListHostedZonesAPIClient, domain string) (string, error) {
+	logDebug("Checking for a Route53 hosted zone covering %s...", domain)
+
+	zones, err := listHostedZones(ctx, client)
+	if err != nil {
+		return "", err
+	}
+
+	zone, found := findHostedZoneForDomain(zones, domain)
+	if !found {
+		return "", fmt.Errorf("no Route53 hosted zone found for domain %s (checked %d accessible zones)", domain, len(zones))
+	}
+
+	zoneID := hostedZoneID(zone)
+	if isPrivateHostedZone(zone) {
+		logWarn("Route53 hosted zone %s (%s) is private; the ACME server cannot see records in it, use --route53-zone-id to select the public zone", normalizeDNSName(aws.ToString(zone.Name)), zoneID)
+	}
+	logInfo("Found Route53 hosted zone %s (%s) for domain %s", normalizeDNSName(aws.ToString(zone.Name)), zoneID, domain)
+	return zoneID, nil
+}
+
+// Confirm a pinned hosted zone ID is accessible, warning if it does not cover the domain
+func checkPinnedRoute53HostedZone(ctx context.Context, client route53.ListHostedZonesAPIClient, zoneID, domain string) (string, error) {
+	logDebug("Checking pinned Route53 hosted zone %s...", zoneID)
+
+	zones, err := listHostedZones(ctx, client)
+	if err != nil {
+		return "", err
+	}
+
+	for _, zone := range zones {
+		if hostedZoneID(zone) != zoneID {
+			continue
+		}
+		zoneName := normalizeDNSName(aws.ToString(zone.Name))
+		name := normalizeDNSName(domain)
+		if name != zoneName && !strings.HasSuffix(name, "."+zoneName) {
+			logWarn("Pinned Route53 hosted zone %s (%s) does not cover %s", zoneName, zoneID, domain)
+		}
+		logInfo("Using pinned Route53 hosted zone %s (%s) for domain %s", zoneName, zoneID, domain)
+		return zoneID, nil
+	}
+
+	return "", fmt.Errorf("route53 hosted zone %s not found (checked %d accessible zones)", zoneID, len(zones))
+}
+
+// Strip the /hostedzone/ prefix from a hosted zone ID
+func hostedZoneID(zone route53types.HostedZone) string {
+	return strings.TrimPrefix(aws.ToString(zone.Id), "/hostedzone/")
+}
+
+// Name the challenge record is created under: the hostname when it sits inside the
+// domain, so split delegations (lab.example.com inside example.com) resolve to the
+// delegated zone
+func route53LookupName(config Config) string {
+	hostname := normalizeDNSName(esxiHostOnly(config.Hostname))
+	domain := normalizeDNSName(config.Domain)
+	if hostname != "" && strings.HasSuffix(hostname, "."+domain) {
+		return hostname
+	}
+	return config.Domain
+}
+
+// Run the hosted zone pre-flight using the configured AWS credentials, returning the zone ID to use
+func preflightRoute53HostedZone(config Config) (string, error) {
+	ctx := context.TODO()
+
+	client, err := newRoute53Client(ctx, config)
+	if err != nil {
+		return "", err
+	}
+
+	if config.Route53ZoneID != "" {
+		return checkPinnedRoute53HostedZone(ctx, client, config.Route53ZoneID, route53LookupName(config))
+	}
+	return checkRoute53HostedZone(ctx, client, route53LookupName(config))
+}
+
+// loadRoute53AWSConfig loads the AWS configuration for Route53 calls. With -route53-profile
+// the named shared profile supplies the credentials, in place of the explicit or assumed-role
+// keys that STS validation keeps using.
+func loadRoute53AWSConfig(ctx context.Context, config Config) (aws.Config, error) {
+	if config.Route53Profile == "" {
+		return loadAWSConfig(ctx, config)
+	}
+
+	logInfo("Using AWS profile %s for Route53", config.Route53Profile)
+	dnsConfig := config
+	dnsConfig.Route53KeyID, dnsConfig.Route53SecretKey, dnsConfig.Route53SessionToken = "", "", ""
+	return loadAWSConfig(ctx, dnsConfig, awsConfig.WithSharedConfigProfile(config.Route53Profile))
+}
+
+// Create a Route53 client honoring the configured credentials, region, and custom endpoint
+func newRoute53Client(ctx context.Context, config Config) (*route53.Client, error) {
+	awsCfg, err := loadRoute53AWSConfig(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+
+	return route53.NewFromConfig(awsCfg), nil
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+	route53types "github.com/aws/aws-sdk-go-v2/service/route53/types"
+)
+
+// fakeRoute53Client returns canned hosted zones, split across pages
+type fakeRoute53Client struct {
+	pages [][]route53types.HostedZone
+	err   error
+	calls int
+}
+
+func (f *fakeRoute53Client) ListHostedZones(ctx context.Context, params *route53.ListHostedZonesInput, optFns ...func(*route53.Options)) (*route53.ListHostedZonesOutput, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	page := f.pages[f.calls]
+	f.calls++
+
+	output := &route53.ListHostedZonesOutput{HostedZones: page}
+	if f.calls < len(f.pages) {
+		output.IsTruncated = true
+		output.NextMarker = aws.String(fmt.Sprintf("page-%d", f.calls))
+	}
+	return output, nil
+}
+
+func hostedZone(id, name string) route53types.HostedZone {
+	return route53types.HostedZone{Id: aws.String("/hostedzone/" + id), Name: aws.String(name)}
+}
+
+func privateHostedZone(id, name string) route53types.HostedZone {
+	zone := hostedZone(id, name)
+	zone.Config = &route53types.HostedZoneConfig{PrivateZone: true}
+	return zone
+}
+
+func TestFindHostedZoneForDomain(t *testing.T) {
+	zones := []route53types.HostedZone{
+		hostedZone("Z1", "example.com."),
+		hostedZone("Z2", "corp.example.com."),
+		hostedZone("Z3", "other.org."),
+		privateHostedZone("Z4", "lab.example.com."),
+		privateHostedZone("Z5", "split.example.com."),
+		hostedZone("Z6", "split.example.com."),
+	}
+
+	tests := []struct {
+		domain     string
+		expectedID string
+		found      bool
+	}{
+		{"example.com", "/hostedzone/Z1", true},
+		{"lab.example.com", "/hostedzone/Z4", true},
+		{"esxi.split.example.com", "/hostedzone/Z6", true},
+		{"www.example.com", "/hostedzone/Z1", true},
+		{"corp.example.com", "/hostedzone/Z2", true},
+		{"lab.corp.example.com.", "/hostedzone/Z2", true},
+		{"LAB.CORP.EXAMPLE.COM", "/hostedzone/Z2", true},
+		{"notexample.com", "", false},
+		{"example.net", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.domain, func(t *testing.T) {
+			zone, found := findHostedZoneForDomain(zones, tt.domain)
+			if found != tt.found {
+				t.Fatalf("findHostedZoneForDomain(%s) found = %v, expected %v", tt.domain, found, tt.found)
+			}
+			if found && aws.ToString(zone.Id) != tt.expectedID {
+				t.Errorf("findHostedZoneForDomain(%s) = %s, expected %s", tt.domain, aws.ToString(zone.Id), tt.expectedID)
+			}
+		})
+	}
+}
+
+func TestCheckRoute53HostedZone(t *testing.T) {
+	t.Run("zone found across pages", func(t *testing.T) {
+		client := &fakeRoute53Client{pages: [][]route53types.HostedZone{
+			{hostedZone("Z1", "other.org.")},
+			{hostedZone("Z2", "example.com.")},
+		}}
+
+		zoneID, err := checkRoute53HostedZone(context.Background(), client, "lab.example.com")
+		if err != nil {
+			t.Fatalf("Expected hosted zone to be found, got error: %v", err)
+		}
+		if zoneID != "Z2" {
+			t.Errorf("Expected zone ID Z2, got %s", zoneID)
+		}
+		if client.calls != 2 {
+			t.Errorf("Expected 2 ListHostedZones calls, got %d", client.calls)
+		}
+	})
+
+	t.Run("no matching zone", func(t *testing.T) {
+		client := &fakeRoute53Client{pages: [][]route53types.HostedZone{{hostedZone("Z1", "other.org.")}}}
+
+		_, err := checkRoute53HostedZone(context.Background(), client, "example.com")
+		if err == nil {
+			t.Fatal("Expected error when no hosted zone matches")
+		}
+		if !strings.Contains(err.Error(), "no Route53 hosted zone found for domain example.com") {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	})
+
+	t.Run("API failure", func(t *testing.T) {
+		client := &fakeRoute53Client{err: fmt.Errorf("AccessDenied")}
+
+		_, err := checkRoute53HostedZone(context.Background(), client, "example.com")
+		if err == nil || !strings.Contains(err.Error(), "AccessDenied") {
+			t.Errorf("Expected API error to be returned, got: %v", err)
+		}
+	})
+}
+
+func TestCheckPinnedRoute53HostedZone(t *testing.T) {
+	client := &fakeRoute53Client{pages: [][]route53types.HostedZone{
+		{hostedZone("Z1", "example.com."), privateHostedZone("Z2", "example.com.")},
+	}}
+
+	zoneID, err := checkPinnedRoute53HostedZone(context.Background(), client, "Z2", "esxi.example.com")
+	if err != nil {
+		t.Fatalf("Expected pinned zone to be accepted, got error: %v", err)
+	}
+	if zoneID != "Z2" {
+		t.Errorf("Expected zone ID Z2, got %s", zoneID)
+	}
+
+	client = &fakeRoute53Client{pages: [][]route53types.HostedZone{{hostedZone("Z1", "example.com.")}}}
+	_, err = checkPinnedRoute53HostedZone(context.Background(), client, "Z9", "esxi.example.com")
+	if err == nil || !strings.Contains(err.Error(), "route53 hosted zone Z9 not found") {
+		t.Errorf("Expected missing pinned zone error, got: %v", err)
+	}
+}
+
+func TestRoute53LookupName(t *testing.T) {
+	tests := []struct {
+		hostname string
+		domain   string
+		expected string
+	}{
+		{"esxi.lab.example.com", "example.com", "esxi.lab.example.com"},
+		{"esxi.lab.example.com:8443", "example.com", "esxi.lab.example.com"},
+		{"esxi.other.org", "example.com", "example.com"},
+		{"", "example.com", "example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.hostname, func(t *testing.T) {
+			if got := route53LookupName(Config{Hostname: tt.hostname, Domain: tt.domain}); got != tt.expected {
+				t.Errorf("route53LookupName(%s, %s) = %s, expected %s", tt.hostname, tt.domain, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestNewRoute53ProviderConfig(t *testing.T) {
+	tests := []struct {
+		name          string
+		config        Config
+		expectKeyID   string
+		expectSecret  string
+		expectSession string
+	}{
+		{
+			name:   "default chain when no keys are configured",
+			config: Config{Route53Region: "us-east-1"},
+		},
+		{
+			name:   "default chain ignores a lone session token",
+			config: Config{Route53Region: "us-east-1", Route53SessionToken: "token"},
+		},
+		{
+			name:          "explicit credentials",
+			config:        Config{Route53Region: "us-east-1", Route53KeyID: "AKIATEST123", Route53SecretKey: "secret", Route53SessionToken: "token"},
+			expectKeyID:   "AKIATEST123",
+			expectSecret:  "secret",
+			expectSession: "token",
+		},
+		{
+			name:   "pinned hosted zone",
+			config: Config{Route53Region: "us-east-1", Route53ZoneID: "Z123"},
+		},
+		{
+			name:   "profile takes the place of explicit credentials",
+			config: Config{Route53Region: "us-east-1", Route53Profile: "dns-only", Route53KeyID: "AKIATEST123", Route53SecretKey: "secret"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newRoute53ProviderConfig(tt.config)
+			if cfg.AccessKeyID != tt.expectKeyID || cfg.SecretAccessKey != tt.expectSecret || cfg.SessionToken != tt.expectSession {
+				t.Errorf("Unexpected credentials: key=%q secret=%q session=%q", cfg.AccessKeyID, cfg.SecretAccessKey, cfg.SessionToken)
+			}
+			if cfg.HostedZoneID != tt.config.Route53ZoneID {
+				t.Errorf("Expected hosted zone ID %q, got %q", tt.config.Route53ZoneID, cfg.HostedZoneID)
+			}
+			if cfg.Region != tt.config.Route53Region {
+				t.Errorf("Expected region %s, got %s", tt.config.Route53Region, cfg.Region)
+			}
+		})
+	}
+}
+
+func TestLoadAWSConfig_RetriesAndTimeout(t *testing.T) {
+	base := Config{Route53Region: "us-east-1", Route53KeyID: "AKIATEST123", Route53SecretKey: "secret"}
+
+	awsCfg, err := loadAWSConfig(context.Background(), base)
+	if err != nil {
+		t.Fatalf("Failed to load AWS config: %v", err)
+	}
+	if awsCfg.RetryMaxAttempts != defaultRoute53MaxRetries {
+		t.Errorf("Expected default %d attempts, got %d", defaultRoute53MaxRetries, awsCfg.RetryMaxAttempts)
+	}
+
+	tuned := base
+	tuned.Route53MaxRetries = 2
+	tuned.AWSTimeout = 15 * time.Second
+	awsCfg, err = loadAWSConfig(context.Background(), tuned)
+	if err != nil {
+		t.Fatalf("Failed to load AWS config: %v", err)
+	}
+	if awsCfg.RetryMaxAttempts != 2 {
+		t.Errorf("Expected 2 attempts, got %d", awsCfg.RetryMaxAttempts)
+	}
+	client, ok := awsCfg.HTTPClient.(*awshttp.BuildableClient)
+	if !ok {
+		t.Fatalf("Expected a buildable HTTP client, got %T", awsCfg.HTTPClient)
+	}
+	if client.GetTimeout() != 15*time.Second {
+		t.Errorf("Expected 15s HTTP timeout, got %s", client.GetTimeout())
+	}
+
+	if cfg := newRoute53ProviderConfig(tuned); cfg.MaxRetries != 2 {
+		t.Errorf("Expected provider max retries 2, got %d", cfg.MaxRetries)
+	}
+}
+
+func TestLoadRoute53AWSConfig_Profile(t *testing.T) {
+	dir := t.TempDir()
+	credentialsFile := filepath.Join(dir, "credentials")
+	content := "[dns-only]\naws_access_key_id = AKIADNSONLY\naws_secret_access_key = dns-secret\n"
+	if err := os.WriteFile(credentialsFile, []byte(content), 0600); err != nil {
+		t.Fatalf("Failed to write credentials file: %v", err)
+	}
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", credentialsFile)
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(dir, "config"))
+
+	config := Config{Route53Region: "us-east-1", Route53KeyID: "AKIAVALIDATION", Route53SecretKey: "validation-secret"}
+
+	for _, tt := range []struct {
+		name    string
+		profile string
+		keyID   string
+	}{
+		{"explicit keys without a profile", "", "AKIAVALIDATION"},
+		{"profile overrides explicit keys", "dns-only", "AKIADNSONLY"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			config.Route53Profile = tt.profile
+			awsCfg, err := loadRoute53AWSConfig(context.Background(), config)
+			if err != nil {
+				t.Fatalf("Failed to load AWS config: %v", err)
+			}
+			creds, err := awsCfg.Credentials.Retrieve(context.Background())
+			if err != nil {
+				t.Fatalf("Failed to retrieve credentials: %v", err)
+			}
+			if creds.AccessKeyID != tt.keyID {
+				t.Errorf("Expected access key %s, got %s", tt.keyID, creds.AccessKeyID)
+			}
+		})
+	}
+
+	// STS validation keeps the explicit keys regardless of the profile
+	awsCfg, err := loadAWSConfig(context.Background(), config)
+	if err != nil {
+		t.Fatalf("Failed to load AWS config: %v", err)
+	}
+	if creds, err := awsCfg.Credentials.Retrieve(context.Background()); err != nil || creds.AccessKeyID != "AKIAVALIDATION" {
+		t.Errorf("Expected validation to use the explicit keys, got %s (%v)", creds.AccessKeyID, err)
+	}
+}
+
+func TestRoute53ProviderDNSSettings(t *testing.T) {
+	base := Config{Route53Region: "us-east-1"}
+	if cfg := newRoute53ProviderConfig(base); cfg.TTL != defaultDNSTTL {
+		t.Errorf("Expected default TTL %d, got %d", defaultDNSTTL, cfg.TTL)
+	}
+
+	tuned := base
+	tuned.DNSTTL = 10
+	if cfg := newRoute53ProviderConfig(tuned); cfg.TTL != 10 {
+		t.Errorf("Expected TTL 10, got %d", cfg.TTL)
+	}
+
+	if opts := dns01ChallengeOptions(base); len(opts) != 1 {
+		t.Errorf("Expected only the resolver option by default, got %d options", len(opts))
+	}
+	tuned.DNSSkipPropagation = true
+	if opts := dns01ChallengeOptions(tuned); len(opts) != 2 {
+		t.Errorf("Expected a propagation option when skipping the check, got %d options", len(opts))
+	}
+}
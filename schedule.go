@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// parseSchedule parses a standard five-field cron expression or descriptor such as @daily
+func parseSchedule(expr string) (cron.Schedule, error) {
+	schedule, err := cron.ParseStandard(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schedule %q: %v", expr, err)
+	}
+	return schedule, nil
+}
+
+// configReloader swaps in a freshly loaded configuration and schedule whenever a signal
+// arrives, between runs
+type configReloader struct {
+	signals <-chan os.Signal
+	load    func() (Config, cron.Schedule, error)
+}
+
+// runScheduled runs the workflow at every time matched by the schedule until ctx is done.
+// Each run gets a fresh run ID, and a failed run is logged without stopping later ones;
+// the renewal threshold still decides whether a run actually renews. With -status-file the
+// status is written at startup and after every run. A non-nil reloader replaces the
+// configuration for the following runs, keeping the current one when loading fails.
+func runScheduled(ctx context.Context, config Config, deps Dependencies, schedule cron.Schedule, reloader *configReloader) error {
+	now := time.Now()
+	next := schedule.Next(now)
+	recordScheduleStatus(config, scheduleStatus{Schedule: config.Schedule, UpdatedAt: now, NextRun: next})
+
+	var reloads <-chan os.Signal
+	if reloader != nil {
+		reloads = reloader.signals
+	}
+
+	for {
+		logInfo("Next scheduled run at %s", next.Format(time.RFC3339))
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			logInfo("Schedule stopped")
+			return nil
+		case <-reloads:
+			timer.Stop()
+			reloaded, reloadedSchedule, err := reloader.load()
+			if err != nil {
+				logWarn("Config reload rejected, keeping the previous configuration: %v", err)
+				continue
+			}
+			config, schedule = reloaded, reloadedSchedule
+			next = schedule.Next(time.Now())
+
+			status := scheduleHealth.snapshot()
+			status.Schedule, status.UpdatedAt, status.NextRun = config.Schedule, time.Now(), next
+			recordScheduleStatus(config, status)
+			logInfo("Config reloaded")
+			continue
+		case <-timer.C:
+		}
+
+		correlationID = newRunID()
+		result, err := runWorkflow(config, deps)
+		if err != nil {
+			logError("Scheduled run failed: %v", err)
+		}
+
+		finished := time.Now()
+		next = schedule.Next(finished)
+
+		// Runs before a rate limit lifts would be refused, so resume at the first slot after it
+		var rateErr *RateLimitError
+		if errors.As(err, &rateErr) && rateErr.RetryAfter.After(next) {
+			next = schedule.Next(rateErr.RetryAfter)
+			logInfo("Rate limited until %s; skipping scheduled runs before then", rateErr.RetryAfter.Format(time.RFC3339))
+		}
+		recordScheduleStatus(config, buildScheduleStatus(config, result, err, finished, next))
+	}
+}
+
+// reloadScheduleConfig loads the configuration again for SIGHUP, from the same command line
+// with the config file and environment read afresh. The package settings parseArgs applies
+// are put back when the new configuration is rejected, so the running one stays intact.
+func reloadScheduleConfig() (Config, cron.Schedule, error) {
+	previousNetwork, previousAttempts := dialNetwork, validateAttempts
+	previousInterval, previousMaxInterval := validateInterval, validateMaxInterval
+	previousRoots := hostTrustRoots
+	restore := func() {
+		dialNetwork, validateAttempts = previousNetwork, previousAttempts
+		validateInterval, validateMaxInterval = previousInterval, previousMaxInterval
+		hostTrustRoots = previousRoots
+	}
+
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	config, err := parseArgs()
+	if err == nil && config.Schedule == "" {
+		err = errors.New("the reloaded configuration has no schedule")
+	}
+	var schedule cron.Schedule
+	if err == nil {
+		schedule, err = parseSchedule(config.Schedule)
+	}
+	if err != nil {
+		restore()
+		return Config{}, nil, err
+	}
+
+	// Log file, syslog and healthz settings are only applied at startup
+	registerConfigSecrets(config)
+	currentLogLevel = parseLogLevel(config.LogLevel)
+	return config, schedule, nil
+}
+
+// runScheduleUntilSignal runs the schedule until the process is interrupted or terminated,
+// reloading the configuration on SIGHUP
+func runScheduleUntilSignal(config Config, deps Dependencies) error {
+	schedule, err := parseSchedule(config.Schedule)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	defer signal.Stop(hangups)
+	defer soapSessions.closeAll(context.Background())
+
+	if config.HealthzAddr != "" {
+		shutdown, err := startHealthzServer(config.HealthzAddr, &scheduleHealth)
+		if err != nil {
+			return err
+		}
+		defer shutdown()
+	}
+
+	logInfo("Running on schedule %q", config.Schedule)
+	return runScheduled(ctx, config, deps, schedule, &configReloader{signals: hangups, load: reloadScheduleConfig})
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+func TestParseSchedule(t *testing.T) {
+	tests := []struct {
+		expr        string
+		shouldError bool
+	}{
+		{"0 3 * * *", false},
+		{"@daily", false},
+		{"*/15 * * * *", false},
+		{"0 3 * *", true},
+		{"every day", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			_, err := parseSchedule(tt.expr)
+			if (err != nil) != tt.shouldError {
+				t.Errorf("parseSchedule(%q) error = %v, shouldError %v", tt.expr, err, tt.shouldError)
+			}
+		})
+	}
+
+	schedule, _ := parseSchedule("0 3 * * *")
+	from := time.Date(2026, 1, 1, 12, 0, 0, 0, time.Local)
+	if next := schedule.Next(from); !next.Equal(time.Date(2026, 1, 2, 3, 0, 0, 0, time.Local)) {
+		t.Errorf("Expected next run at 3am the following day, got %s", next)
+	}
+}
+
+// immediateSchedule fires shortly after every call, to drive the loop in tests
+type immediateSchedule struct{}
+
+func (immediateSchedule) Next(t time.Time) time.Time {
+	return t.Add(time.Millisecond)
+}
+
+// hourSchedule fires an hour after every call, so no run is due during a test
+type hourSchedule struct{}
+
+func (hourSchedule) Next(t time.Time) time.Time {
+	return t.Add(time.Hour)
+}
+
+func TestRunScheduled(t *testing.T) {
+	statusPath := filepath.Join(t.TempDir(), "status.json")
+	config := Config{Hostname: "test.example.com", DryRun: true, StatusFile: statusPath}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	runs := 0
+	runIDs := make(map[string]bool)
+	deps := Dependencies{
+		AWSValidator: func(Config) error { return nil },
+		CertChecker: func(string, float64) (bool, *x509.Certificate, error) {
+			runs++
+			runIDs[correlationID] = true
+			if runs == 3 {
+				cancel()
+			}
+			// A failed run must not stop the schedule
+			return false, nil, fmt.Errorf("host unreachable")
+		},
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- runScheduled(ctx, config, deps, immediateSchedule{}, nil) }()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Expected schedule to stop cleanly, got: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Schedule did not stop after cancellation")
+	}
+
+	if runs != 3 {
+		t.Errorf("Expected 3 scheduled runs, got %d", runs)
+	}
+	if len(runIDs) != 3 {
+		t.Errorf("Expected a fresh run ID per scheduled run, got %d distinct IDs", len(runIDs))
+	}
+
+	// The status file reflects the last run
+	data, err := os.ReadFile(statusPath)
+	if err != nil {
+		t.Fatalf("Expected a status file: %v", err)
+	}
+	var status scheduleStatus
+	if err := json.Unmarshal(data, &status); err != nil {
+		t.Fatalf("Status file is not valid JSON: %v", err)
+	}
+	if status.LastRun == nil || status.Success == nil || *status.Success || len(status.Hosts) != 1 || status.Hosts[0].Status != hostStatusFailed {
+		t.Errorf("Expected the failed last run in the status file, got:\n%s", data)
+	}
+}
+
+func TestRunScheduledReload(t *testing.T) {
+	tests := []struct {
+		name         string
+		loadErr      error
+		expectedHost string
+	}{
+		{"valid configuration is swapped in", nil, "reloaded.example.com"},
+		{"rejected configuration keeps the previous one", fmt.Errorf("invalid threshold"), "test.example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			var checked []string
+			deps := Dependencies{
+				AWSValidator: func(Config) error { return nil },
+				CertChecker: func(hostname string, _ float64) (bool, *x509.Certificate, error) {
+					checked = append(checked, hostname)
+					cancel()
+					return false, nil, nil
+				},
+			}
+
+			// The signal is pending before the first run is due, so it is handled first
+			signals := make(chan os.Signal, 1)
+			signals <- syscall.SIGHUP
+			reloader := &configReloader{signals: signals, load: func() (Config, cron.Schedule, error) {
+				if tt.loadErr != nil {
+					cancel()
+					return Config{}, nil, tt.loadErr
+				}
+				return Config{Hostname: "reloaded.example.com", DryRun: true, Schedule: "@daily"}, immediateSchedule{}, nil
+			}}
+
+			config := Config{Hostname: "test.example.com", DryRun: true, Schedule: "@hourly"}
+			if err := runScheduled(ctx, config, deps, hourSchedule{}, reloader); err != nil {
+				t.Fatalf("Expected schedule to stop cleanly, got: %v", err)
+			}
+			if tt.loadErr != nil {
+				// A rejected reload leaves the hourly schedule, so no run happens in the test
+				if len(checked) != 0 {
+					t.Errorf("Expected no run on the previous hourly schedule, got %v", checked)
+				}
+				if status := scheduleHealth.snapshot(); status.Schedule != "@hourly" {
+					t.Errorf("Expected the previous schedule to stay active, got %q", status.Schedule)
+				}
+				return
+			}
+			if len(checked) != 1 || checked[0] != tt.expectedHost {
+				t.Errorf("Expected one run for %s, got %v", tt.expectedHost, checked)
+			}
+		})
+	}
+}
+
+func TestRunScheduledWaitsOutRateLimit(t *testing.T) {
+	statusPath := filepath.Join(t.TempDir(), "status.json")
+	config := Config{Hostname: "test.example.com", StatusFile: statusPath}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	retryAfter := time.Now().Add(3 * time.Hour).Truncate(time.Second)
+	deps := Dependencies{
+		AWSValidator: func(Config) error { return nil },
+		CertChecker: func(string, float64) (bool, *x509.Certificate, error) {
+			return true, nil, nil
+		},
+		CertGenerator: func(Config) (string, string, error) {
+			cancel()
+			return "", "", &RateLimitError{Hostname: "test.example.com", RetryAfter: retryAfter, Detail: "too many certificates"}
+		},
+	}
+
+	if err := runScheduled(ctx, config, deps, immediateSchedule{}, nil); err != nil {
+		t.Fatalf("Expected schedule to stop cleanly, got: %v", err)
+	}
+
+	data, err := os.ReadFile(statusPath)
+	if err != nil {
+		t.Fatalf("Expected a status file: %v", err)
+	}
+	var status scheduleStatus
+	if err := json.Unmarshal(data, &status); err != nil {
+		t.Fatalf("Status file is not valid JSON: %v", err)
+	}
+	if status.NextRun.Before(retryAfter) {
+		t.Errorf("Expected the next run after the rate limit lifts at %s, got %s", retryAfter, status.NextRun)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Constraints and descriptions for config file fields, keyed by JSON name. The field list
+// and types come from the ConfigFile struct itself, so new options appear automatically.
+var configSchemaConstraints = map[string]map[string]interface{}{
+	"threshold":            {"exclusiveMinimum": 0, "exclusiveMaximum": 1, "description": "Renew when this fraction of the certificate lifetime remains"},
+	"log_level":            {"enum": []string{"ERROR", "WARN", "INFO", "DEBUG", "TRACE", "AUDIT"}},
+	"syslog_facility":      {"enum": syslogFacilities},
+	"ip_version":           {"enum": []string{ipVersionAuto, ipVersion4, ipVersion6}},
+	"key_size":             {"enum": caSupportedRSAKeySizes},
+	"key_type":             {"enum": keyTypeNames()},
+	"account_key_type":     {"enum": keyTypeNames()},
+	"install_method":       {"enum": []string{installMethodSSH, installMethodSOAPCertMgr}},
+	"target_type":          {"enum": []string{targetTypeESXi, targetTypeVCSA}},
+	"chain_mode":           {"enum": []string{chainModeFull, chainModeLeafOnly}},
+	"output_layout":        {"enum": []string{outputLayoutFlat, outputLayoutCertbot}},
+	"ext_key_usages":       {"items": map[string]interface{}{"type": "string", "enum": extKeyUsageNames}},
+	"challenge_type":       {"enum": []string{challengeTypeDNS01, challengeTypeHTTP01}},
+	"reload_method":        {"enum": []string{reloadMethodRestart, reloadMethodReload}},
+	"http_challenge_port":  {"minimum": 1, "maximum": 65535},
+	"smtp_port":            {"minimum": 1, "maximum": 65535},
+	"esxi_ssh_port":        {"minimum": 1, "maximum": 65535},
+	"esxi_https_port":      {"minimum": 1, "maximum": 65535},
+	"min_remaining_days":   {"minimum": 0, "description": "0 disables the check"},
+	"max_renewals":         {"minimum": 0, "description": "0 disables the renewal loop guard"},
+	"soap_connect_retries": {"minimum": 0},
+	"route53_max_retries":  {"minimum": 0, "description": "Attempts per AWS request, including the first; 0 uses the default of 5"},
+	"dns_ttl":              {"minimum": 0, "description": "Seconds; 0 uses the default of 60"},
+	"dns_propagation_wait": {"description": "Go duration, e.g. 30s"},
+	"aws_timeout":          {"description": "Go duration, e.g. 30s; 0 uses the AWS SDK default"},
+	"ssh_stop_timeout":     {"description": "Go duration, e.g. 30s"},
+	"cache_lock_timeout":   {"description": "Go duration, e.g. 30s"},
+	"renewal_window":       {"description": "Go duration, e.g. 24h"},
+	"mail_to":              {"description": "Comma-separated recipients"},
+	"labels":               {"propertyNames": map[string]interface{}{"pattern": labelKeyPattern.String()}},
+}
+
+// keyTypeNames returns the accepted key type names in order
+func keyTypeNames() []string {
+	names := make([]string, 0, len(keyTypes))
+	for name := range keyTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// jsonFieldName returns the JSON name of a struct field, or "" when it isn't serialized
+func jsonFieldName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "-" || !field.IsExported() {
+		return ""
+	}
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
+
+// schemaForType describes a Go type as a JSON Schema fragment
+func schemaForType(t reflect.Type) map[string]interface{} {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": schemaForType(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaForType(t.Elem())}
+	case reflect.Struct:
+		return schemaForStruct(t)
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+// schemaForStruct describes a struct's JSON fields, marking fields without omitempty as required
+func schemaForStruct(t reflect.Type) map[string]interface{} {
+	properties := make(map[string]interface{})
+	var required []string
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := jsonFieldName(field)
+		if name == "" {
+			continue
+		}
+
+		property := schemaForType(field.Type)
+		for key, value := range configSchemaConstraints[name] {
+			property[key] = value
+		}
+		properties[name] = property
+
+		if !strings.Contains(field.Tag.Get("json"), "omitempty") {
+			required = append(required, name)
+		}
+	}
+
+	schema := map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// configFileSchema returns a JSON Schema for the config file, for editor validation and completion
+func configFileSchema() ([]byte, error) {
+	schema := schemaForStruct(reflect.TypeOf(ConfigFile{}))
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["title"] = "lab-update-esxi-cert configuration file"
+	return json.MarshalIndent(schema, "", "  ")
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestConfigFileSchema(t *testing.T) {
+	data, err := configFileSchema()
+	if err != nil {
+		t.Fatalf("configFileSchema() error = %v", err)
+	}
+
+	var schema struct {
+		Type       string                            `json:"type"`
+		Properties map[string]map[string]interface{} `json:"properties"`
+	}
+	if err := json.Unmarshal(data, &schema); err != nil {
+		t.Fatalf("Schema is not valid JSON: %v", err)
+	}
+	if schema.Type != "object" {
+		t.Errorf("Expected an object schema, got %q", schema.Type)
+	}
+
+	// Every config file field is described
+	configType := reflect.TypeOf(ConfigFile{})
+	for i := 0; i < configType.NumField(); i++ {
+		name := jsonFieldName(configType.Field(i))
+		if _, ok := schema.Properties[name]; !ok {
+			t.Errorf("Schema is missing config file field %s", name)
+		}
+	}
+
+	tests := []struct {
+		field    string
+		key      string
+		expected interface{}
+	}{
+		{"threshold", "type", "number"},
+		{"threshold", "exclusiveMaximum", float64(1)},
+		{"key_size", "enum", []interface{}{float64(2048), float64(3072), float64(4096)}},
+		{"dry_run", "type", "boolean"},
+		{"hosts", "type", "array"},
+		{"smtp_port", "maximum", float64(65535)},
+	}
+	for _, tt := range tests {
+		if got := schema.Properties[tt.field][tt.key]; !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("%s.%s = %v, want %v", tt.field, tt.key, got, tt.expected)
+		}
+	}
+
+	hostItems := schema.Properties["hosts"]["items"].(map[string]interface{})
+	if required := hostItems["required"].([]interface{}); len(required) != 1 || required[0] != "hostname" {
+		t.Errorf("Expected hosts entries to require hostname, got %v", required)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+const (
+	defaultServiceCertPath = "/etc/vmware/ssl/rui.crt"
+	defaultServiceKeyPath  = "/etc/vmware/ssl/rui.key"
+
+	// The vCenter appliance serves its certificate from vmware-vpx and is restarted with service-control
+	vcsaServiceCertPath = "/etc/vmware-vpx/ssl/rui.crt"
+	vcsaServiceKeyPath  = "/etc/vmware-vpx/ssl/rui.key"
+	vcsaRestartCommand  = "service-control --stop vmware-rhttpproxy vmware-vpxd && service-control --start vmware-vpxd vmware-rhttpproxy"
+)
+
+// ServiceTarget is a destination on the ESXi host that receives the certificate,
+// together with the command that makes the owning service pick it up
+type ServiceTarget struct {
+	CertPath       string `json:"cert_path"`
+	KeyPath        string `json:"key_path,omitempty"`
+	RestartCommand string `json:"restart_command,omitempty"`
+}
+
+// ResolvedKeyPath returns the key destination, defaulting to the certificate path
+// with its extension replaced by .key
+func (t ServiceTarget) ResolvedKeyPath() string {
+	if t.KeyPath != "" {
+		return t.KeyPath
+	}
+	return strings.TrimSuffix(t.CertPath, path.Ext(t.CertPath)) + ".key"
+}
+
+// serviceTargets returns the configured destinations, or the host's rui.crt/rui.key
+// (restarted with the built-in ESXi sequence, or service-control on a vCenter
+// appliance) when none are configured
+func serviceTargets(config Config) []ServiceTarget {
+	if len(config.Services) > 0 {
+		return config.Services
+	}
+	if config.TargetType == targetTypeVCSA {
+		return []ServiceTarget{{CertPath: vcsaServiceCertPath, KeyPath: vcsaServiceKeyPath, RestartCommand: vcsaRestartCommand}}
+	}
+	return []ServiceTarget{{CertPath: defaultServiceCertPath, KeyPath: defaultServiceKeyPath}}
+}
+
+// parseServicesSpec parses the -services flag value: entries of the form
+// cert_path[,key_path]=restart_command separated by semicolons. The restart
+// command may be empty to use the built-in ESXi restart sequence.
+func parseServicesSpec(spec string) ([]ServiceTarget, error) {
+	var targets []ServiceTarget
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		paths, command, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid services entry %q, expected cert_path[,key_path]=restart_command", entry)
+		}
+
+		certPath, keyPath, _ := strings.Cut(paths, ",")
+		targets = append(targets, ServiceTarget{
+			CertPath:       strings.TrimSpace(certPath),
+			KeyPath:        strings.TrimSpace(keyPath),
+			RestartCommand: strings.TrimSpace(command),
+		})
+	}
+
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("services value %q contains no entries", spec)
+	}
+	return targets, nil
+}
+
+// validateServiceTargets checks that every destination has absolute, distinct paths
+func validateServiceTargets(targets []ServiceTarget) error {
+	seen := make(map[string]bool)
+	for i, target := range targets {
+		if target.CertPath == "" {
+			return fmt.Errorf("services[%d]: cert_path is required", i)
+		}
+
+		for _, p := range []string{target.CertPath, target.ResolvedKeyPath()} {
+			if !path.IsAbs(p) {
+				return fmt.Errorf("services[%d]: path %s must be absolute", i, p)
+			}
+			if seen[p] {
+				return fmt.Errorf("services[%d]: duplicate destination %s", i, p)
+			}
+			seen[p] = true
+		}
+	}
+	return nil
+}
+
+// serviceRestartCommands returns the distinct custom restart commands in order, and
+// whether any destination relies on the built-in ESXi restart sequence
+func serviceRestartCommands(targets []ServiceTarget) ([]string, bool) {
+	var commands []string
+	useBuiltin := false
+	seen := make(map[string]bool)
+	for _, target := range targets {
+		if target.RestartCommand == "" {
+			useBuiltin = true
+			continue
+		}
+		if !seen[target.RestartCommand] {
+			seen[target.RestartCommand] = true
+			commands = append(commands, target.RestartCommand)
+		}
+	}
+	return commands, useBuiltin
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestServiceTarget_ResolvedKeyPath(t *testing.T) {
+	tests := []struct {
+		target   ServiceTarget
+		expected string
+	}{
+		{ServiceTarget{CertPath: "/etc/vmware/ssl/rui.crt"}, "/etc/vmware/ssl/rui.key"},
+		{ServiceTarget{CertPath: "/etc/vmware/ssl/vasa.pem"}, "/etc/vmware/ssl/vasa.key"},
+		{ServiceTarget{CertPath: "/etc/vmware/ssl/cert"}, "/etc/vmware/ssl/cert.key"},
+		{ServiceTarget{CertPath: "/etc/vmware/ssl/rui.crt", KeyPath: "/etc/vmware/ssl/private.key"}, "/etc/vmware/ssl/private.key"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.target.CertPath, func(t *testing.T) {
+			if result := tt.target.ResolvedKeyPath(); result != tt.expected {
+				t.Errorf("ResolvedKeyPath() = %s, expected %s", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestServiceTargets_Default(t *testing.T) {
+	targets := serviceTargets(Config{})
+	expected := []ServiceTarget{{CertPath: defaultServiceCertPath, KeyPath: defaultServiceKeyPath}}
+	if !reflect.DeepEqual(targets, expected) {
+		t.Errorf("Expected default rui.crt target, got %+v", targets)
+	}
+
+	configured := []ServiceTarget{{CertPath: "/etc/vmware/ssl/vasa.crt", RestartCommand: "/etc/init.d/vvold restart"}}
+	if targets := serviceTargets(Config{Services: configured}); !reflect.DeepEqual(targets, configured) {
+		t.Errorf("Expected configured targets, got %+v", targets)
+	}
+
+	vcsa := serviceTargets(Config{TargetType: targetTypeVCSA})
+	expected = []ServiceTarget{{CertPath: vcsaServiceCertPath, KeyPath: vcsaServiceKeyPath, RestartCommand: vcsaRestartCommand}}
+	if !reflect.DeepEqual(vcsa, expected) {
+		t.Errorf("Expected vCenter appliance target, got %+v", vcsa)
+	}
+	if commands, useBuiltin := serviceRestartCommands(vcsa); useBuiltin || len(commands) != 1 {
+		t.Errorf("Expected vCenter appliance to use service-control only, got %v (builtin %v)", commands, useBuiltin)
+	}
+}
+
+func TestParseServicesSpec(t *testing.T) {
+	tests := []struct {
+		name        string
+		spec        string
+		expected    []ServiceTarget
+		expectError string
+	}{
+		{
+			name:     "single entry with restart command",
+			spec:     "/etc/vmware/ssl/rui.crt=/etc/init.d/hostd restart",
+			expected: []ServiceTarget{{CertPath: "/etc/vmware/ssl/rui.crt", RestartCommand: "/etc/init.d/hostd restart"}},
+		},
+		{
+			name: "multiple entries with explicit key and built-in restart",
+			spec: "/etc/vmware/ssl/rui.crt=; /etc/vmware/ssl/vasa.crt,/etc/vmware/ssl/vasa-private.key=/etc/init.d/vvold restart;",
+			expected: []ServiceTarget{
+				{CertPath: "/etc/vmware/ssl/rui.crt"},
+				{CertPath: "/etc/vmware/ssl/vasa.crt", KeyPath: "/etc/vmware/ssl/vasa-private.key", RestartCommand: "/etc/init.d/vvold restart"},
+			},
+		},
+		{
+			name:        "missing separator",
+			spec:        "/etc/vmware/ssl/rui.crt",
+			expectError: "expected cert_path[,key_path]=restart_command",
+		},
+		{
+			name:        "no entries",
+			spec:        " ; ",
+			expectError: "contains no entries",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			targets, err := parseServicesSpec(tt.spec)
+			if tt.expectError != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.expectError) {
+					t.Fatalf("Expected error containing %q, got %v", tt.expectError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(targets, tt.expected) {
+				t.Errorf("Expected %+v, got %+v", tt.expected, targets)
+			}
+		})
+	}
+}
+
+func TestValidateServiceTargets(t *testing.T) {
+	tests := []struct {
+		name        string
+		targets     []ServiceTarget
+		expectError string
+	}{
+		{
+			name: "valid destinations",
+			targets: []ServiceTarget{
+				{CertPath: "/etc/vmware/ssl/rui.crt"},
+				{CertPath: "/etc/vmware/ssl/vasa.crt", RestartCommand: "/etc/init.d/vvold restart"},
+			},
+		},
+		{
+			name:        "missing cert path",
+			targets:     []ServiceTarget{{KeyPath: "/etc/vmware/ssl/rui.key"}},
+			expectError: "services[0]: cert_path is required",
+		},
+		{
+			name:        "relative path",
+			targets:     []ServiceTarget{{CertPath: "ssl/rui.crt"}},
+			expectError: "must be absolute",
+		},
+		{
+			name: "duplicate destination",
+			targets: []ServiceTarget{
+				{CertPath: "/etc/vmware/ssl/rui.crt"},
+				{CertPath: "/etc/vmware/ssl/other.crt", KeyPath: "/etc/vmware/ssl/rui.key"},
+			},
+			expectError: "services[1]: duplicate destination /etc/vmware/ssl/rui.key",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateServiceTargets(tt.targets)
+			if tt.expectError == "" {
+				if err != nil {
+					t.Errorf("Unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.expectError) {
+				t.Errorf("Expected error containing %q, got %v", tt.expectError, err)
+			}
+		})
+	}
+}
+
+func TestServiceRestartCommands(t *testing.T) {
+	commands, useBuiltin := serviceRestartCommands([]ServiceTarget{
+		{CertPath: "/etc/vmware/ssl/rui.crt"},
+		{CertPath: "/etc/vmware/ssl/vasa.crt", RestartCommand: "/etc/init.d/vvold restart"},
+		{CertPath: "/etc/vmware/ssl/sps.crt", RestartCommand: "/etc/init.d/vvold restart"},
+	})
+
+	if !useBuiltin {
+		t.Error("Expected built-in restart for the destination without a command")
+	}
+	if !reflect.DeepEqual(commands, []string{"/etc/init.d/vvold restart"}) {
+		t.Errorf("Expected deduplicated restart commands, got %v", commands)
+	}
+
+	if _, useBuiltin := serviceRestartCommands([]ServiceTarget{{CertPath: "/a.crt", RestartCommand: "x"}}); useBuiltin {
+		t.Error("Did not expect built-in restart when every destination has a command")
+	}
+}
+
+func TestServiceTargetCommands(t *testing.T) {
+	target := ServiceTarget{CertPath: "/etc/vmware/ssl/vasa.crt"}
+
+	backup := backupCommands(target)
+	if backup[0] != "cp -f /etc/vmware/ssl/vasa.crt /etc/vmware/ssl/vasa.crt.backup 2>/dev/null || true" {
+		t.Errorf("Unexpected certificate backup command: %s", backup[0])
+	}
+	if backup[1] != "cp -f /etc/vmware/ssl/vasa.key /etc/vmware/ssl/vasa.key.backup 2>/dev/null || true" {
+		t.Errorf("Unexpected key backup command: %s", backup[1])
+	}
+
+	perms := permissionCommands(target)
+	expected := []string{
+		"chmod 644 /etc/vmware/ssl/vasa.crt",
+		"chmod 600 /etc/vmware/ssl/vasa.key",
+		"chown root:root /etc/vmware/ssl/vasa.crt /etc/vmware/ssl/vasa.key",
+	}
+	if !reflect.DeepEqual(perms, expected) {
+		t.Errorf("Expected %v, got %v", expected, perms)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"sync"
+
+	"github.com/vmware/govmomi"
+)
+
+// soapSessionCache keeps logged-in SOAP clients between scheduled runs when -soap-keepalive
+// is set, so each cycle reuses the host session instead of logging in and out again
+type soapSessionCache struct {
+	mu      sync.Mutex
+	clients map[string]*govmomi.Client
+}
+
+// Cached SOAP sessions for the life of the process
+var soapSessions = &soapSessionCache{clients: make(map[string]*govmomi.Client)}
+
+// soapSessionKey identifies a session by user and host endpoint
+func soapSessionKey(u *url.URL) string {
+	return u.User.Username() + "@" + u.Host
+}
+
+// get returns the cached client for key if its session is still authenticated, dropping
+// it otherwise so the caller logs in again
+func (c *soapSessionCache) get(ctx context.Context, key string) *govmomi.Client {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	client := c.clients[key]
+	if client == nil {
+		return nil
+	}
+
+	userSession, err := client.SessionManager.UserSession(ctx)
+	if err != nil || userSession == nil {
+		logInfo("Cached SOAP session for %s has expired, logging in again", key)
+		delete(c.clients, key)
+		return nil
+	}
+
+	logDebug("Reusing SOAP session for %s", key)
+	return client
+}
+
+// put caches a logged-in client for later runs
+func (c *soapSessionCache) put(key string, client *govmomi.Client) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.clients[key] = client
+}
+
+// remove drops a cached client, e.g. after it turned out to be unusable
+func (c *soapSessionCache) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.clients, key)
+}
+
+// closeAll logs out every cached session, which also stops their keepalives
+func (c *soapSessionCache) closeAll(ctx context.Context) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, client := range c.clients {
+		if err := client.Logout(ctx); err != nil {
+			logWarn("Failed to log out cached SOAP session for %s: %v", key, err)
+		}
+		delete(c.clients, key)
+	}
+}
+
+// releaseESXiClient ends a run's use of a SOAP client, logging out unless the session is
+// being kept alive for the next scheduled run
+func releaseESXiClient(ctx context.Context, config Config, client *govmomi.Client) {
+	if config.SOAPKeepAlive > 0 {
+		return
+	}
+	client.Logout(ctx)
+}
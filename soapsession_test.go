@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/vmware/govmomi"
+	"github.com/vmware/govmomi/simulator"
+)
+
+func TestSOAPSessionKey(t *testing.T) {
+	u, _ := url.Parse("https://esxi01.lab.example.com:8443/sdk")
+	u.User = url.UserPassword("root", "secret")
+
+	if key := soapSessionKey(u); key != "root@esxi01.lab.example.com:8443" {
+		t.Errorf("Expected session key without the password, got %q", key)
+	}
+}
+
+func TestSOAPSessionCache(t *testing.T) {
+	model := simulator.ESX()
+	defer model.Remove()
+	if err := model.Create(); err != nil {
+		t.Fatal(err)
+	}
+	server := model.Service.NewServer()
+	defer server.Close()
+
+	ctx := context.Background()
+	client, err := newGovmomiClient(ctx, server.URL, time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to connect to simulator: %v", err)
+	}
+
+	cache := &soapSessionCache{clients: make(map[string]*govmomi.Client)}
+	key := soapSessionKey(server.URL)
+	if cache.get(ctx, key) != nil {
+		t.Fatal("Expected empty cache to return no client")
+	}
+
+	cache.put(key, client)
+	if cache.get(ctx, key) != client {
+		t.Fatal("Expected cached client while its session is active")
+	}
+
+	// An expired session is dropped so the next run logs in again
+	if err := client.Logout(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if cache.get(ctx, key) != nil {
+		t.Error("Expected expired session to be dropped")
+	}
+	if len(cache.clients) != 0 {
+		t.Error("Expected expired session to be removed from the cache")
+	}
+
+	// Stopping the schedule logs out every cached session
+	client, err = newGovmomiClient(ctx, server.URL, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.put(key, client)
+	cache.closeAll(ctx)
+	if len(cache.clients) != 0 {
+		t.Error("Expected closeAll to empty the cache")
+	}
+	if userSession, _ := client.SessionManager.UserSession(ctx); userSession != nil {
+		t.Error("Expected closeAll to log out the session")
+	}
+}
+
+func TestReleaseESXiClient(t *testing.T) {
+	model := simulator.ESX()
+	defer model.Remove()
+	if err := model.Create(); err != nil {
+		t.Fatal(err)
+	}
+	server := model.Service.NewServer()
+	defer server.Close()
+
+	ctx := context.Background()
+	for _, keepAlive := range []time.Duration{0, time.Minute} {
+		client, err := newGovmomiClient(ctx, server.URL, keepAlive)
+		if err != nil {
+			t.Fatal(err)
+		}
+		releaseESXiClient(ctx, Config{SOAPKeepAlive: keepAlive}, client)
+
+		userSession, _ := client.SessionManager.UserSession(ctx)
+		if loggedIn := userSession != nil; loggedIn != (keepAlive > 0) {
+			t.Errorf("keepalive %s: expected logged in %v after release, got %v", keepAlive, keepAlive > 0, loggedIn)
+		}
+		client.Logout(ctx)
+	}
+}
+
+func TestNewGovmomiClientThroughProxy(t *testing.T) {
+	model := simulator.ESX()
+	defer model.Remove()
+	if err := model.Create(); err != nil {
+		t.Fatal(err)
+	}
+	model.Service.TLS = new(tls.Config)
+	server := model.Service.NewServer()
+	defer server.Close()
+
+	// A minimal HTTPS proxy: accept CONNECT and splice the connection to the target
+	var tunnels atomic.Int32
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			http.Error(w, "CONNECT only", http.StatusMethodNotAllowed)
+			return
+		}
+		target, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		tunnels.Add(1)
+		w.WriteHeader(http.StatusOK)
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			target.Close()
+			return
+		}
+		go func() {
+			io.Copy(target, conn)
+			target.Close()
+		}()
+		io.Copy(conn, target)
+		conn.Close()
+	}))
+	defer proxy.Close()
+
+	proxyURL, _ := url.Parse(proxy.URL)
+	soapProxy = http.ProxyURL(proxyURL)
+	defer func() { soapProxy = http.ProxyFromEnvironment }()
+
+	ctx := context.Background()
+	client, err := newGovmomiClient(ctx, server.URL, 0)
+	if err != nil {
+		t.Fatalf("Failed to connect to simulator through the proxy: %v", err)
+	}
+	defer client.Logout(ctx)
+
+	if tunnels.Load() == 0 {
+		t.Error("Expected the SOAP connection to be tunneled through the proxy")
+	}
+}
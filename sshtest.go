@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// sshTestDirectories returns the distinct directories holding the configured certificate destinations
+func sshTestDirectories(config Config) []string {
+	var dirs []string
+	seen := make(map[string]bool)
+	for _, target := range serviceTargets(config) {
+		dir := path.Dir(target.CertPath)
+		if !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// testSSHConnection connects with the configured credentials, reports what was negotiated
+// and which authentication method succeeded, and lists the certificate directories. It
+// never touches services or uploads anything.
+func testSSHConnection(w io.Writer, config Config) error {
+	address := esxiSSHAddress(config)
+
+	// The last method tried before the handshake completes is the one that succeeded
+	var authMethod string
+	var hostKey ssh.PublicKey
+	sshConfig := newSSHClientConfig(config, func(method string) { authMethod = method })
+	sshConfig.HostKeyCallback = func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		hostKey = key
+		return nil
+	}
+
+	fmt.Fprintf(w, "Connecting to %s as %s...\n", address, config.ESXiUsername)
+	client, err := dialSSH(address, sshConfig)
+	if err != nil {
+		return fmt.Errorf("SSH connection to %s failed: %v", address, err)
+	}
+	defer client.Close()
+
+	fmt.Fprintf(w, "Server version:  %s\n", client.ServerVersion())
+	if hostKey != nil {
+		fmt.Fprintf(w, "Host key:        %s %s\n", hostKey.Type(), ssh.FingerprintSHA256(hostKey))
+	}
+	if meta, ok := client.Conn.(ssh.AlgorithmsConnMetadata); ok {
+		algs := meta.Algorithms()
+		fmt.Fprintf(w, "Key exchange:    %s\n", algs.KeyExchange)
+		fmt.Fprintf(w, "Host key type:   %s\n", algs.HostKey)
+		fmt.Fprintf(w, "Cipher:          %s (to server), %s (from server)\n", algs.Write.Cipher, algs.Read.Cipher)
+		fmt.Fprintf(w, "MAC:             %s (to server), %s (from server)\n", macName(algs.Write), macName(algs.Read))
+	}
+	fmt.Fprintf(w, "Authenticated:   %s\n", authMethod)
+
+	for _, dir := range sshTestDirectories(config) {
+		session, err := client.NewSession()
+		if err != nil {
+			return fmt.Errorf("failed to create SSH session: %v", err)
+		}
+		output, err := session.CombinedOutput(fmt.Sprintf("ls -la %s", dir))
+		session.Close()
+
+		fmt.Fprintf(w, "\nls -la %s\n%s", dir, output)
+		if err != nil {
+			return fmt.Errorf("listing %s failed: %v", dir, err)
+		}
+	}
+	return nil
+}
+
+// Name the MAC of one direction; AEAD ciphers carry their own integrity check
+func macName(algs ssh.DirectionAlgorithms) string {
+	if algs.MAC == "" {
+		return "implicit (AEAD cipher)"
+	}
+	return algs.MAC
+}
+
+// Run the SSH diagnostics for -test-ssh and exit
+func runTestSSH(config Config) {
+	var err error
+	if config.Hostname == "" {
+		err = fmt.Errorf("test-ssh requires hostname")
+	} else {
+		err = validateHostname(config.Hostname)
+	}
+	if err == nil && (config.ESXiUsername == "" || config.ESXiPassword == "") {
+		err = fmt.Errorf("test-ssh requires ESXi username and password")
+	}
+	if err == nil {
+		err = testSSHConnection(os.Stdout, config)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitCodeFailure)
+	}
+	fmt.Println("\nSSH test succeeded; no services were changed and nothing was uploaded.")
+	os.Exit(0)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// startTestSSHServer accepts password logins for root/password and answers every exec
+// request with a fixed directory listing, recording the commands it was asked to run
+func startTestSSHServer(t *testing.T) (int, func() []string) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate host key: %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+
+	serverConfig := &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if conn.User() == "root" && string(password) == "password" {
+				return nil, nil
+			}
+			return nil, ssh.ErrNoAuth
+		},
+	}
+	serverConfig.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	var mu sync.Mutex
+	var commands []string
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, chans, reqs, err := ssh.NewServerConn(conn, serverConfig)
+				if err != nil {
+					return
+				}
+				go ssh.DiscardRequests(reqs)
+				for newChannel := range chans {
+					channel, requests, err := newChannel.Accept()
+					if err != nil {
+						continue
+					}
+					for req := range requests {
+						if req.Type != "exec" {
+							req.Reply(false, nil)
+							continue
+						}
+						command := string(req.Payload[4:])
+						mu.Lock()
+						commands = append(commands, command)
+						mu.Unlock()
+						req.Reply(true, nil)
+						channel.Write([]byte("-rw-r--r--    1 root     root          1984 rui.crt\n"))
+						status := make([]byte, 4)
+						binary.BigEndian.PutUint32(status, 0)
+						channel.SendRequest("exit-status", false, status)
+						channel.Close()
+					}
+				}
+			}()
+		}
+	}()
+
+	return listener.Addr().(*net.TCPAddr).Port, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), commands...)
+	}
+}
+
+func TestTestSSHConnection(t *testing.T) {
+	port, commands := startTestSSHServer(t)
+	config := Config{Hostname: "127.0.0.1", ESXiSSHPort: port, ESXiUsername: "root", ESXiPassword: "password"}
+
+	var buf bytes.Buffer
+	if err := testSSHConnection(&buf, config); err != nil {
+		t.Fatalf("Expected SSH test to succeed, got: %v\n%s", err, buf.String())
+	}
+
+	output := buf.String()
+	for _, want := range []string{"127.0.0.1:" + strconv.Itoa(port), "Host key:        ssh-ed25519 SHA256:", "Key exchange:", "Cipher:", "Authenticated:   password", "ls -la /etc/vmware/ssl", "rui.crt"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("Expected output to contain %q, got:\n%s", want, output)
+		}
+	}
+	if executed := commands(); len(executed) != 1 || executed[0] != "ls -la /etc/vmware/ssl" {
+		t.Errorf("Expected only the directory listing to run, got %v", executed)
+	}
+
+	config.ESXiPassword = "wrong"
+	if err := testSSHConnection(&bytes.Buffer{}, config); err == nil || !strings.Contains(err.Error(), "SSH connection") {
+		t.Errorf("Expected authentication failure, got: %v", err)
+	}
+}
+
+func TestSSHTestDirectories(t *testing.T) {
+	config := Config{Services: []ServiceTarget{
+		{CertPath: "/etc/vmware/ssl/rui.crt"},
+		{CertPath: "/etc/vmware/ssl/vasa.crt"},
+		{CertPath: "/opt/app/ssl/app.crt"},
+	}}
+	dirs := sshTestDirectories(config)
+	if strings.Join(dirs, ",") != "/etc/vmware/ssl,/opt/app/ssl" {
+		t.Errorf("Expected distinct directories in order, got %v", dirs)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Host outcomes recorded in the status file
+const (
+	hostStatusOK      = "ok"
+	hostStatusFailed  = "failed"
+	hostStatusSkipped = "skipped"
+)
+
+// scheduleStatus is the JSON written to -status-file by a scheduled run, so a monitoring
+// check can tell from its contents (and mtime) that the daemon is alive and healthy
+type scheduleStatus struct {
+	PID             int          `json:"pid"`
+	Schedule        string       `json:"schedule"`
+	UpdatedAt       time.Time    `json:"updated_at"`
+	LastRun         *time.Time   `json:"last_run,omitempty"`
+	RunID           string       `json:"run_id,omitempty"`
+	DurationSeconds float64      `json:"duration_seconds,omitempty"`
+	Success         *bool        `json:"success,omitempty"`
+	Error           string       `json:"error,omitempty"`
+	NextRun         time.Time    `json:"next_run"`
+	Labels          labelSet     `json:"labels,omitempty"`
+	Hosts           []hostStatus `json:"hosts,omitempty"`
+}
+
+// hostStatus is one host's outcome of the last run
+type hostStatus struct {
+	Hostname      string     `json:"hostname"`
+	Status        string     `json:"status"`
+	Action        string     `json:"action,omitempty"`
+	Error         string     `json:"error,omitempty"`
+	Expiry        *time.Time `json:"expiry,omitempty"`
+	DaysRemaining *int       `json:"days_remaining,omitempty"`
+}
+
+// newHostStatus summarises a single host's result; error text is redacted like log lines
+func newHostStatus(hostname string, result WorkflowResult, err error, skipped bool) hostStatus {
+	status := hostStatus{Hostname: hostname, Status: hostStatusOK, Action: string(result.Action)}
+	switch {
+	case skipped:
+		status.Status = hostStatusSkipped
+		status.Action = ""
+	case err != nil:
+		status.Status = hostStatusFailed
+		status.Error = logRedactor.redact(err.Error())
+	}
+
+	if !result.InstalledExpiry.IsZero() {
+		expiry, days := result.InstalledExpiry, result.InstalledDaysRemaining
+		status.Expiry, status.DaysRemaining = &expiry, &days
+	} else if !result.OldExpiry.IsZero() {
+		expiry := result.OldExpiry
+		status.Expiry = &expiry
+	}
+	return status
+}
+
+// buildScheduleStatus records a finished run and when the next one is due
+func buildScheduleStatus(config Config, result WorkflowResult, err error, finished, next time.Time) scheduleStatus {
+	success := err == nil
+	status := scheduleStatus{
+		Schedule:        config.Schedule,
+		UpdatedAt:       finished,
+		LastRun:         &finished,
+		RunID:           result.RunID,
+		DurationSeconds: result.Duration.Seconds(),
+		Success:         &success,
+		NextRun:         next,
+		Labels:          labelMap(config.Labels),
+	}
+	if err != nil {
+		status.Error = logRedactor.redact(err.Error())
+	}
+
+	if len(result.Hosts) == 0 {
+		status.Hosts = []hostStatus{newHostStatus(config.Hostname, result, err, false)}
+		return status
+	}
+	for _, host := range result.Hosts {
+		status.Hosts = append(status.Hosts, newHostStatus(host.Hostname, host.Result, host.Err, host.Skipped))
+	}
+	return status
+}
+
+// writeStatusFile atomically replaces the status file, so a monitor never reads a partial one
+func writeStatusFile(path string, status scheduleStatus) error {
+	data, err := json.MarshalIndent(status, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write status file %s: %v", path, err)
+	}
+	return nil
+}
+
+// recordScheduleStatus publishes the status to the healthz endpoint and writes the status file
+// when -status-file is set. A failed write is only a warning: the monitor will see a stale
+// file, but renewals must keep running.
+func recordScheduleStatus(config Config, status scheduleStatus) {
+	status.PID = os.Getpid()
+	scheduleHealth.update(status)
+	if config.StatusFile == "" {
+		return
+	}
+	if err := writeStatusFile(config.StatusFile, status); err != nil {
+		logWarn("%v", err)
+		return
+	}
+	logDebug("Status written to %s", config.StatusFile)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBuildScheduleStatus(t *testing.T) {
+	finished := time.Date(2026, 3, 1, 3, 0, 5, 0, time.UTC)
+	next := time.Date(2026, 3, 2, 3, 0, 0, 0, time.UTC)
+	expiry := time.Date(2026, 5, 30, 0, 0, 0, 0, time.UTC)
+	config := Config{Hostname: "esxi01.example.com", Schedule: "0 3 * * *"}
+
+	result := WorkflowResult{Action: ActionUpToDate, OldExpiry: expiry, RunID: "abcd1234", Duration: 5 * time.Second}
+	status := buildScheduleStatus(config, result, nil, finished, next)
+	if !*status.Success || status.RunID != "abcd1234" || status.DurationSeconds != 5 || !status.NextRun.Equal(next) {
+		t.Errorf("Unexpected run status: %+v", status)
+	}
+	if len(status.Hosts) != 1 || status.Hosts[0].Hostname != "esxi01.example.com" || status.Hosts[0].Status != hostStatusOK ||
+		status.Hosts[0].Action != "up-to-date" || !status.Hosts[0].Expiry.Equal(expiry) {
+		t.Errorf("Unexpected host status: %+v", status.Hosts)
+	}
+
+	batch := WorkflowResult{Hosts: []HostResult{
+		{Hostname: "esxi01", Result: WorkflowResult{Action: ActionRenewed, InstalledExpiry: expiry, InstalledDaysRemaining: 89}},
+		{Hostname: "esxi02", Err: fmt.Errorf("host unreachable")},
+		{Hostname: "esxi03", Skipped: true},
+	}}
+	status = buildScheduleStatus(config, batch, &BatchError{Results: batch.Hosts}, finished, next)
+	if *status.Success || status.Error == "" {
+		t.Errorf("Expected the batch failure to be recorded, got %+v", status)
+	}
+	if len(status.Hosts) != 3 {
+		t.Fatalf("Expected 3 host statuses, got %+v", status.Hosts)
+	}
+	if h := status.Hosts[0]; h.Status != hostStatusOK || h.Action != "renewed" || *h.DaysRemaining != 89 {
+		t.Errorf("Unexpected renewed host status: %+v", h)
+	}
+	if h := status.Hosts[1]; h.Status != hostStatusFailed || h.Error != "host unreachable" {
+		t.Errorf("Unexpected failed host status: %+v", h)
+	}
+	if h := status.Hosts[2]; h.Status != hostStatusSkipped || h.Action != "" {
+		t.Errorf("Unexpected skipped host status: %+v", h)
+	}
+}
+
+func TestRecordScheduleStatus(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "status.json")
+	next := time.Date(2026, 3, 2, 3, 0, 0, 0, time.UTC)
+
+	recordScheduleStatus(Config{Schedule: "@daily"}, scheduleStatus{NextRun: next})
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatal("Expected no status file without -status-file")
+	}
+
+	recordScheduleStatus(Config{Schedule: "@daily", StatusFile: path}, scheduleStatus{Schedule: "@daily", NextRun: next})
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Expected status file to be written: %v", err)
+	}
+
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Status file is not valid JSON: %v\n%s", err, data)
+	}
+	if decoded["pid"] != float64(os.Getpid()) || decoded["next_run"] != "2026-03-02T03:00:00Z" {
+		t.Errorf("Unexpected status file contents:\n%s", data)
+	}
+	if _, ok := decoded["last_run"]; ok {
+		t.Errorf("Expected no last run before the first run:\n%s", data)
+	}
+}
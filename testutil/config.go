@@ -28,6 +28,7 @@ func NewConfigBuilder() *ConfigBuilder {
 			"aws_secret_key": "test-secret-key-123",
 			"esxi_username":  "root",
 			"esxi_password":  "test-password",
+			"insecure":       true,
 		},
 	}
 }
@@ -143,6 +144,7 @@ func (cb *ConfigBuilder) SetEnv() func() {
 		"key_size":          "CERT_KEY_SIZE",
 		"esxi_username":     "ESXI_USERNAME",
 		"esxi_password":     "ESXI_PASSWORD",
+		"insecure":          "INSECURE",
 	}
 
 	for configKey, envVar := range envMappings {
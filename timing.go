@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// PhaseTiming records how long one workflow phase took
+type PhaseTiming struct {
+	Name     string
+	Duration time.Duration
+}
+
+// PhaseTimer collects the durations of the workflow phases in the order they ran
+type PhaseTimer struct {
+	phases []PhaseTiming
+	now    func() time.Time
+}
+
+// NewPhaseTimer creates a timer using the wall clock
+func NewPhaseTimer() *PhaseTimer {
+	return &PhaseTimer{now: time.Now}
+}
+
+// Start begins timing a phase; calling the returned function records its duration
+func (t *PhaseTimer) Start(name string) func() {
+	start := t.now()
+	return func() {
+		d := t.now().Sub(start)
+		t.phases = append(t.phases, PhaseTiming{Name: name, Duration: d})
+		logDebug("Phase %s took %s", name, formatPhaseDuration(d))
+	}
+}
+
+// Phases returns the recorded phase durations
+func (t *PhaseTimer) Phases() []PhaseTiming {
+	return t.phases
+}
+
+// Summary renders the breakdown, e.g. "generation: 47s, upload: 8s"
+func (t *PhaseTimer) Summary() string {
+	parts := make([]string, 0, len(t.phases))
+	for _, phase := range t.phases {
+		parts = append(parts, fmt.Sprintf("%s: %s", phase.Name, formatPhaseDuration(phase.Duration)))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// formatPhaseDuration rounds to a readable precision: whole milliseconds below
+// a second, tenths of a second above
+func formatPhaseDuration(d time.Duration) string {
+	if d < time.Second {
+		return d.Round(time.Millisecond).String()
+	}
+	return d.Round(100 * time.Millisecond).String()
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPhaseTimer(t *testing.T) {
+	clock := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	timer := &PhaseTimer{now: func() time.Time { return clock }}
+
+	done := timer.Start("generation")
+	clock = clock.Add(47 * time.Second)
+	done()
+
+	done = timer.Start("upload")
+	clock = clock.Add(8*time.Second + 120*time.Millisecond)
+	done()
+
+	done = timer.Start("check")
+	clock = clock.Add(312*time.Millisecond + 400*time.Microsecond)
+	done()
+
+	phases := timer.Phases()
+	if len(phases) != 3 {
+		t.Fatalf("Expected 3 phases, got %d", len(phases))
+	}
+	if phases[0].Name != "generation" || phases[0].Duration != 47*time.Second {
+		t.Errorf("Unexpected first phase: %+v", phases[0])
+	}
+
+	expected := "generation: 47s, upload: 8.1s, check: 312ms"
+	if summary := timer.Summary(); summary != expected {
+		t.Errorf("Summary() = %q, expected %q", summary, expected)
+	}
+}
+
+func TestPhaseTimer_Empty(t *testing.T) {
+	timer := NewPhaseTimer()
+	if summary := timer.Summary(); summary != "" {
+		t.Errorf("Expected empty summary, got %q", summary)
+	}
+}
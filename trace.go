@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"regexp"
+	"strings"
+	"sync/atomic"
+
+	"github.com/vmware/govmomi/vim25/debug"
+	"golang.org/x/crypto/ssh"
+)
+
+// soapTraceProvider sends govmomi's debug capture of each SOAP round trip to the log at TRACE
+type soapTraceProvider struct{}
+
+// NewFile returns a buffer for one piece of a round trip (e.g. "1-0003.req.xml"), logged when
+// govmomi closes it
+func (soapTraceProvider) NewFile(name string) io.WriteCloser {
+	return &soapTraceFile{name: name}
+}
+
+func (soapTraceProvider) Flush() {}
+
+// soapTraceFile collects one captured request or response part
+type soapTraceFile struct {
+	name string
+	buf  bytes.Buffer
+}
+
+func (f *soapTraceFile) Write(p []byte) (int, error) {
+	return f.buf.Write(p)
+}
+
+func (f *soapTraceFile) Close() error {
+	if f.buf.Len() > 0 {
+		logTrace("SOAP %s:\n%s", f.name, strings.TrimRight(string(scrubSOAPTrace(f.buf.Bytes())), "\n"))
+	}
+	return nil
+}
+
+// cookieHeader matches the value of a captured Cookie or Set-Cookie header
+var cookieHeader = regexp.MustCompile(`(?im)^((?:set-)?cookie:[ \t]*)[^\r\n]*`)
+
+// scrubSOAPTrace masks passwords and session cookies in a captured round trip. The
+// vmware_soap_session cookie is a live host credential, and with -soap-keepalive it outlives the run.
+func scrubSOAPTrace(b []byte) []byte {
+	return cookieHeader.ReplaceAll(debug.Scrub(b), []byte("${1}****"))
+}
+
+// configureSOAPTrace turns govmomi's SOAP tracing on at TRACE and off otherwise. Clients pick
+// this up when they are created, so it must run before any connection is made.
+func configureSOAPTrace() {
+	if currentLogLevel >= LOG_TRACE {
+		debug.SetProvider(soapTraceProvider{})
+	} else if debug.Enabled() {
+		debug.SetProvider(nil)
+	}
+}
+
+// sshTraceConn counts the bytes exchanged on an SSH connection for the TRACE summary
+type sshTraceConn struct {
+	net.Conn
+	read, written atomic.Int64
+}
+
+func (c *sshTraceConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.read.Add(int64(n))
+	return n, err
+}
+
+func (c *sshTraceConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.written.Add(int64(n))
+	return n, err
+}
+
+func (c *sshTraceConn) Close() error {
+	logTrace("SSH connection to %s closed: %d bytes sent, %d bytes received", c.RemoteAddr(), c.written.Load(), c.read.Load())
+	return c.Conn.Close()
+}
+
+// dialSSH connects like ssh.Dial, additionally logging the handshake at TRACE: the server
+// version, negotiated algorithms, banner and each authentication method tried
+func dialSSH(address string, sshConfig *ssh.ClientConfig) (*ssh.Client, error) {
+	if currentLogLevel < LOG_TRACE {
+		return ssh.Dial(dialNetwork, address, sshConfig)
+	}
+
+	logTrace("SSH dialing %s over %s as %s (client version %s)", address, dialNetwork, sshConfig.User, sshConfig.ClientVersion)
+	rawConn, err := net.DialTimeout(dialNetwork, address, sshConfig.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	conn := &sshTraceConn{Conn: rawConn}
+
+	traced := *sshConfig
+	bannerCallback := traced.BannerCallback
+	traced.BannerCallback = func(message string) error {
+		logTrace("SSH banner: %s", strings.TrimSpace(message))
+		if bannerCallback != nil {
+			return bannerCallback(message)
+		}
+		return nil
+	}
+	hostKeyCallback := traced.HostKeyCallback
+	traced.HostKeyCallback = func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		logTrace("SSH host key: %s %s", key.Type(), ssh.FingerprintSHA256(key))
+		return hostKeyCallback(hostname, remote, key)
+	}
+
+	c, chans, reqs, err := ssh.NewClientConn(conn, address, &traced)
+	if err != nil {
+		conn.Conn.Close()
+		logTrace("SSH handshake with %s failed after %d bytes sent, %d bytes received", address, conn.written.Load(), conn.read.Load())
+		return nil, err
+	}
+
+	logTrace("SSH server version: %s", c.ServerVersion())
+	if meta, ok := c.(ssh.AlgorithmsConnMetadata); ok {
+		algs := meta.Algorithms()
+		logTrace("SSH negotiated kex %s, host key %s, cipher %s/%s, MAC %s/%s", algs.KeyExchange, algs.HostKey,
+			algs.Write.Cipher, algs.Read.Cipher, macName(algs.Write), macName(algs.Read))
+	}
+	return ssh.NewClient(c, chans, reqs), nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/vmware/govmomi/vim25/debug"
+)
+
+func TestSOAPTraceProvider(t *testing.T) {
+	originalLevel := currentLogLevel
+	defer func() {
+		currentLogLevel = originalLevel
+		configureSOAPTrace()
+	}()
+
+	var buf bytes.Buffer
+	originalOutput := log.Writer()
+	log.SetOutput(&buf)
+	defer log.SetOutput(originalOutput)
+
+	currentLogLevel = LOG_DEBUG
+	configureSOAPTrace()
+	if debug.Enabled() {
+		t.Fatal("Expected SOAP tracing to stay off at DEBUG")
+	}
+
+	currentLogLevel = LOG_TRACE
+	configureSOAPTrace()
+	if !debug.Enabled() {
+		t.Fatal("Expected SOAP tracing to be enabled at TRACE")
+	}
+
+	f := debug.NewFile("1-0001.req.xml")
+	f.Write([]byte("<Login><userName>root</userName><password>hunter22</password></Login>\n"))
+	f.Close()
+
+	output := buf.String()
+	if !strings.Contains(output, "[TRACE]") || !strings.Contains(output, "SOAP 1-0001.req.xml:") || !strings.Contains(output, "<userName>root</userName>") {
+		t.Errorf("Expected the request body at TRACE, got:\n%s", output)
+	}
+	if strings.Contains(output, "hunter22") {
+		t.Errorf("Expected the SOAP password to be scrubbed, got:\n%s", output)
+	}
+
+	buf.Reset()
+	f = debug.NewFile("1-0001.res.headers")
+	f.Write([]byte("HTTP/1.1 200 OK\r\nContent-Type: text/xml\r\nSet-Cookie: vmware_soap_session=\"52a1b2c3\"; Path=/; HttpOnly\r\n"))
+	f.Close()
+	f = debug.NewFile("1-0002.req.headers")
+	f.Write([]byte("POST /sdk HTTP/1.1\r\ncookie: vmware_soap_session=\"52a1b2c3\"\r\n"))
+	f.Close()
+
+	output = buf.String()
+	if strings.Contains(output, "52a1b2c3") {
+		t.Errorf("Expected the session cookie to be scrubbed, got:\n%s", output)
+	}
+	if !strings.Contains(output, "Set-Cookie: ****") || !strings.Contains(output, "Content-Type: text/xml") {
+		t.Errorf("Expected only the cookie values to be masked, got:\n%s", output)
+	}
+}
+
+func TestDialSSHTrace(t *testing.T) {
+	port, _ := startTestSSHServer(t)
+	config := Config{Hostname: "127.0.0.1", ESXiSSHPort: port, ESXiUsername: "root", ESXiPassword: "password"}
+
+	originalLevel := currentLogLevel
+	currentLogLevel = LOG_TRACE
+	defer func() { currentLogLevel = originalLevel }()
+
+	var buf bytes.Buffer
+	originalOutput := log.Writer()
+	log.SetOutput(&buf)
+	defer log.SetOutput(originalOutput)
+
+	client, err := dialSSH(esxiSSHAddress(config), newSSHClientConfig(config, nil))
+	if err != nil {
+		t.Fatalf("Expected traced SSH dial to succeed, got: %v", err)
+	}
+	client.Close()
+
+	output := buf.String()
+	for _, want := range []string{"SSH dialing 127.0.0.1:" + strconv.Itoa(port), "SSH host key: ssh-ed25519", "SSH trying password authentication", "SSH negotiated kex", "closed:"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("Expected trace output to contain %q, got:\n%s", want, output)
+		}
+	}
+}
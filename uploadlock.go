@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gofrs/flock"
+)
+
+// UploadLocks serializes certificate installs on the same host across concurrent runs, so
+// overlapping cron invocations cannot both toggle TSM-SSH and restart hostd. The locks are
+// advisory file locks, released by the OS if a run dies, so a crash leaves no stale lock.
+type UploadLocks struct {
+	Dir string
+	now func() time.Time
+}
+
+// NewUploadLocks returns upload locks kept in dir
+func NewUploadLocks(dir string) *UploadLocks {
+	return &UploadLocks{Dir: dir, now: time.Now}
+}
+
+// path returns the lock file for a host
+func (u *UploadLocks) path(hostname string) string {
+	return filepath.Join(u.Dir, fmt.Sprintf("%s-upload.lock", hostname))
+}
+
+// UploadInProgressError reports a host whose upload lock is held by another run
+type UploadInProgressError struct {
+	Hostname string
+	Holder   string // PID and start time of the run holding the lock, if known
+}
+
+func (e *UploadInProgressError) Error() string {
+	msg := fmt.Sprintf("another run is already installing a certificate on %s", e.Hostname)
+	if e.Holder != "" {
+		msg += " (" + e.Holder + ")"
+	}
+	return msg + "; not installing concurrently, try again once it has finished"
+}
+
+// Acquire takes the host's upload lock, waiting up to wait for another run to release it
+// (zero fails at once). The holder is recorded next to the lock for the error message.
+func (u *UploadLocks) Acquire(hostname string, wait time.Duration) (*flock.Flock, error) {
+	if err := ensureCacheDir(u.Dir); err != nil {
+		return nil, err
+	}
+
+	lock := flock.New(u.path(hostname))
+	var locked bool
+	var err error
+	if wait > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), wait)
+		defer cancel()
+		logInfo("Waiting up to %s for the upload lock on %s...", wait, hostname)
+		locked, err = lock.TryLockContext(ctx, cacheLockRetryDelay)
+	} else {
+		locked, err = lock.TryLock()
+	}
+	if !locked {
+		if err != nil && !errors.Is(err, context.DeadlineExceeded) {
+			return nil, fmt.Errorf("failed to acquire upload lock %s: %v", lock.Path(), err)
+		}
+		holder, _ := os.ReadFile(lock.Path() + ".owner")
+		return nil, &UploadInProgressError{Hostname: hostname, Holder: strings.TrimSpace(string(holder))}
+	}
+
+	owner := fmt.Sprintf("pid %d since %s", os.Getpid(), u.now().Format(time.RFC3339))
+	if err := os.WriteFile(lock.Path()+".owner", []byte(owner+"\n"), 0644); err != nil {
+		logDebug("Could not record upload lock owner: %v", err)
+	}
+	logDebug("Acquired upload lock %s", lock.Path())
+	return lock, nil
+}
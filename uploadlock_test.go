@@ -0,0 +1,85 @@
+package main
+
+import (
+	"crypto/x509"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUploadLocks_Acquire(t *testing.T) {
+	locks := NewUploadLocks(t.TempDir())
+	locks.now = func() time.Time { return time.Date(2026, 1, 1, 3, 0, 0, 0, time.UTC) }
+
+	lock, err := locks.Acquire("esxi01", 0)
+	if err != nil {
+		t.Fatalf("Expected to acquire upload lock, got %v", err)
+	}
+
+	// A concurrent run on the same host is refused, naming the holder
+	_, err = locks.Acquire("esxi01", 0)
+	var inProgress *UploadInProgressError
+	if !errors.As(err, &inProgress) {
+		t.Fatalf("Expected UploadInProgressError, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "since 2026-01-01T03:00:00Z") {
+		t.Errorf("Expected error to name the lock holder, got %v", err)
+	}
+
+	// Waiting gives up once the wait elapses
+	start := time.Now()
+	if _, err := locks.Acquire("esxi01", 200*time.Millisecond); !errors.As(err, &inProgress) {
+		t.Errorf("Expected UploadInProgressError after waiting, got %v", err)
+	}
+	if time.Since(start) < 200*time.Millisecond {
+		t.Error("Expected Acquire to wait before giving up")
+	}
+
+	// Other hosts are independent
+	other, err := locks.Acquire("esxi02", 0)
+	if err != nil {
+		t.Fatalf("Expected lock on another host, got %v", err)
+	}
+	other.Unlock()
+
+	lock.Unlock()
+	lock, err = locks.Acquire("esxi01", 0)
+	if err != nil {
+		t.Fatalf("Expected to acquire lock after release, got %v", err)
+	}
+	lock.Unlock()
+}
+
+func TestRunWorkflow_UploadInProgress(t *testing.T) {
+	locks := NewUploadLocks(t.TempDir())
+	held, err := locks.Acquire("test.example.com", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer held.Unlock()
+
+	uploaded := false
+	mockDeps := Dependencies{
+		AWSValidator: func(Config) error { return nil },
+		CertChecker: func(string, float64) (bool, *x509.Certificate, error) {
+			return true, nil, nil
+		},
+		CertGenerator: func(Config) (string, string, error) { return "cert.pem", "key.pem", nil },
+		CertUploader: func(Config, string, string) (SSHServiceState, error) {
+			uploaded = true
+			return "", nil
+		},
+		CertValidator: func(string, *x509.Certificate) (bool, error) { return true, nil },
+		UploadLocks:   locks,
+	}
+	config := Config{Hostname: "test.example.com", Domain: "example.com", Threshold: 0.33}
+
+	_, err = runWorkflow(config, mockDeps)
+	if err == nil || !strings.Contains(err.Error(), "already installing") {
+		t.Errorf("Expected concurrent upload to be refused, got %v", err)
+	}
+	if uploaded {
+		t.Error("Expected no upload while another run holds the lock")
+	}
+}